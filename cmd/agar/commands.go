@@ -0,0 +1,345 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+var (
+	errNoMatch        = errors.New("no fixture matches")
+	errNoFixture      = errors.New("no fixture found")
+	errGenerate       = errors.New("fixture generation failed")
+	errVerify         = errors.New("fixture verification failed")
+	errBadPattern     = errors.New("invalid fixture pattern")
+	errUnknownFixture = errors.New("unknown fixture")
+)
+
+// newFlagSet returns the flag set of command, printing its usage line and flags to stderr.
+func newFlagSet(command, arguments string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: agar %s %s\n", command, arguments)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// parse parses args with flags, mapping parse errors and a wrong number of positional arguments to
+// errUsage. It returns flag.ErrHelp when help was requested. maxArgs < 0 means no maximum.
+func parse(flags *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+
+		return errUsage
+	}
+
+	if flags.NArg() < minArgs || (maxArgs >= 0 && flags.NArg() > maxArgs) {
+		flags.Usage()
+
+		return errUsage
+	}
+
+	return nil
+}
+
+// selectFixtures returns the catalog fixtures matching any of the names or patterns, in catalog order.
+func selectFixtures(patterns []string) (agar.Catalog, error) {
+	predicates := make([]agar.FixturePredicate, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: %q", errBadPattern, pattern)
+		}
+
+		named := agar.Named(pattern)
+		if len(agar.Fixtures().Where(named)) == 0 {
+			return nil, fmt.Errorf("%w: %q", errNoMatch, pattern)
+		}
+
+		predicates = append(predicates, named)
+	}
+
+	return agar.Fixtures().Where(func(fixture agar.Fixture) bool {
+		for _, predicate := range predicates {
+			if predicate(fixture) {
+				return true
+			}
+		}
+
+		return len(predicates) == 0
+	}), nil
+}
+
+// list prints a table of the fixtures.
+func list(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("list", "[pattern...]", stderr)
+	if err := parse(flags, args, 0, -1); err != nil {
+		return err
+	}
+
+	fixtures, err := selectFixtures(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0) //nolint:mnd // two spaces between columns.
+	fmt.Fprintln(table, "NAME\tFILE\tCODEC\tRATE\tDEPTH\tCH\tDURATION\tTRAITS")
+
+	for _, fixture := range fixtures {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			fixture.Name, fixture.File, fixture.Codec, orDash(fixture.SampleRate), orDash(fixture.BitDepth),
+			orDash(fixture.Channels), durationOrDash(fixture), orDashString(strings.Join(traits(fixture), ",")))
+	}
+
+	return table.Flush()
+}
+
+// generate writes the selected fixtures to the output directory.
+func generate(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("generate", "[flags] <name|pattern>...", stderr)
+	output := flags.String("o", ".", "output `dir`ectory, created if needed")
+	timeout := flags.Duration("timeout", agar.DefaultToolTimeout, "bound on the generation of each fixture")
+	cache := flags.Bool("cache", false, "reuse fixtures from the fixture cache, populating it")
+	cacheDir := flags.String("cache-dir", "", "fixture cache `dir`ectory (default agar/fixtures in the user cache dir)")
+
+	if err := parse(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	fixtures, err := selectFixtures(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	if *cache || *cacheDir != "" {
+		if _, err = agar.EnableFixtureCache(agar.CacheOptions{Dir: *cacheDir}); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+
+	for _, fixture := range fixtures {
+		if err = generateOne(ctx, fixture, *output, *timeout, stdout); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", fixture.Name, err)
+
+			failed++
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", errGenerate, failed, len(fixtures))
+	}
+
+	return nil
+}
+
+// generateOne generates fixture in dir within timeout and prints its path.
+func generateOne(
+	ctx context.Context, fixture agar.Fixture, dir string, timeout time.Duration, stdout io.Writer,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	path, err := fixture.Generate(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, path)
+
+	return nil
+}
+
+// describe prints the format, traits and documented properties of a fixture.
+func describe(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("describe", "<name>", stderr)
+	if err := parse(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	fixture, ok := agar.Fixtures().Lookup(flags.Arg(0))
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownFixture, flags.Arg(0))
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 1, ' ', 0)
+	row := func(key string, value any) {
+		fmt.Fprintf(table, "%s:\t%v\n", key, value)
+	}
+
+	row("Name", fixture.Name)
+	row("File", fixture.File)
+	row("Container", fixture.Container)
+	row("Codec", fixture.Codec)
+	row("Audio streams", fixture.Streams)
+
+	if fixture.Streams > 0 {
+		row("Sample rate", orDash(fixture.SampleRate))
+		row("Bit depth", orDash(fixture.BitDepth))
+		row("Channels", orDash(fixture.Channels))
+		row("Duration", durationOrDash(fixture))
+	}
+
+	if traits := traits(fixture); len(traits) > 0 {
+		row("Traits", strings.Join(traits, ", "))
+	}
+
+	for _, property := range agar.FixtureProperties() {
+		if property.Fixture == fixture.Name {
+			row("Property", property.Description)
+		}
+	}
+
+	return table.Flush()
+}
+
+// verify checks the catalog fixtures found in a directory.
+func verify(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("verify", "[flags] <dir>", stderr)
+	timeout := flags.Duration("timeout", agar.DefaultToolTimeout, "bound on the verification of each fixture")
+
+	if err := parse(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	dir := flags.Arg(0)
+	found, failed := 0, 0
+
+	for _, fixture := range agar.Fixtures() {
+		path := filepath.Join(dir, fixture.File)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		found++
+
+		if err := verifyOne(ctx, fixture, path, *timeout); err != nil {
+			fmt.Fprintf(stdout, "FAIL %s\n", fixture.Name)
+
+			for line := range strings.SplitSeq(err.Error(), "\n") {
+				fmt.Fprintf(stdout, "     %s\n", line)
+			}
+
+			failed++
+		} else {
+			fmt.Fprintf(stdout, "ok   %s\n", fixture.Name)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	switch {
+	case found == 0:
+		return fmt.Errorf("%w in %s", errNoFixture, dir)
+	case failed > 0:
+		return fmt.Errorf("%w: %d of %d", errVerify, failed, found)
+	default:
+		return nil
+	}
+}
+
+// verifyOne verifies the fixture at path within timeout.
+func verifyOne(ctx context.Context, fixture agar.Fixture, path string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return fixture.Verify(ctx, path)
+}
+
+// traits lists the notable traits of fixture.
+func traits(fixture agar.Fixture) []string {
+	var traits []string
+
+	for _, trait := range []struct {
+		set  bool
+		name string
+	}{
+		{fixture.Tagged, "tagged"},
+		{fixture.Clipped, "clipped"},
+		{fixture.Upsampled, "upsampled"},
+		{fixture.Padded, "padded"},
+		{fixture.FakeStereo, "fake-stereo"},
+		{fixture.Oversized, "oversized"},
+		{fixture.Malformed, "malformed"},
+	} {
+		if trait.set {
+			traits = append(traits, trait.name)
+		}
+	}
+
+	if fixture.DR > 0 {
+		traits = append(traits, "dr"+strconv.Itoa(fixture.DR))
+	}
+
+	if fixture.Cutoff > 0 {
+		traits = append(traits, "cutoff-"+strconv.FormatFloat(fixture.Cutoff, 'f', -1, 64)+"hz")
+	}
+
+	return traits
+}
+
+// orDash formats value, or a dash when it is unknown.
+func orDash(value int) string {
+	if value == 0 {
+		return "-"
+	}
+
+	return strconv.Itoa(value)
+}
+
+// durationOrDash formats the duration of fixture, or a dash when it has no audio.
+func durationOrDash(fixture agar.Fixture) string {
+	if fixture.Duration == 0 {
+		return "-"
+	}
+
+	return fixture.Duration.String()
+}
+
+// orDashString returns value, or a dash when it is empty.
+func orDashString(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
@@ -0,0 +1,104 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Command agar generates the agar fixture corpus outside of Go tests, so that other tools and people get
+// the exact files tests use.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mycophonic/agar/version"
+)
+
+// Exit codes.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// errUsage is returned for invalid command lines, after printing the usage.
+var errUsage = errors.New("invalid usage")
+
+const usage = `Usage: agar <command> [arguments]
+
+Commands:
+  list [pattern...]                      list fixtures, optionally only those matching name patterns
+  generate [flags] <name|pattern>...     generate fixtures into a directory
+  describe <name>                        show the format and documented properties of a fixture
+  verify [flags] <dir>                   check the fixtures found in a directory
+  version                                print the version
+
+Patterns are shell patterns on fixture names, such as 'Tagged*'.
+Run 'agar <command> -h' for the flags of a command.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command line args and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+
+		return exitUsage
+	}
+
+	var err error
+
+	switch command, rest := args[0], args[1:]; command {
+	case "list":
+		err = list(rest, stdout, stderr)
+	case "generate":
+		err = generate(ctx, rest, stdout, stderr)
+	case "describe":
+		err = describe(rest, stdout, stderr)
+	case "verify":
+		err = verify(ctx, rest, stdout, stderr)
+	case "version", "--version", "-version":
+		fmt.Fprintln(stdout, version.String())
+	case "help", "--help", "-help", "-h":
+		fmt.Fprint(stdout, usage)
+	default:
+		fmt.Fprintf(stderr, "agar: unknown command %q\n\n%s", command, usage)
+
+		return exitUsage
+	}
+
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	default:
+		fmt.Fprintln(stderr, "agar: "+err.Error())
+
+		return exitFailure
+	}
+}
@@ -0,0 +1,146 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stdout []string
+		stderr []string
+	}{
+		{name: "no command", code: exitUsage, stderr: []string{"Usage: agar <command>"}},
+		{name: "unknown command", args: []string{"frobnicate"}, code: exitUsage, stderr: []string{`"frobnicate"`}},
+		{name: "help", args: []string{"help"}, code: exitOK, stdout: []string{"Commands:"}},
+		{name: "version", args: []string{"version"}, code: exitOK, stdout: []string{"agar "}},
+		{
+			name: "list", args: []string{"list"}, code: exitOK,
+			stdout: []string{"NAME", "Genuine16bit44k", "FLACBadHeaderCRC", "malformed"},
+		},
+		{
+			name: "list pattern", args: []string{"list", "Tagged*", "Untagged*"}, code: exitOK,
+			stdout: []string{"TaggedMP3ID3v24", "UntaggedFLAC"},
+		},
+		{name: "list flag help", args: []string{"list", "-h"}, code: exitOK, stderr: []string{"Usage: agar list"}},
+		{name: "list bad flag", args: []string{"list", "-x"}, code: exitUsage},
+		{
+			name: "list bad pattern", args: []string{"list", "["}, code: exitFailure,
+			stderr: []string{errBadPattern.Error()},
+		},
+		{
+			name: "list no match", args: []string{"list", "Nope*"}, code: exitFailure,
+			stderr: []string{errNoMatch.Error()},
+		},
+		{
+			name: "describe", args: []string{"describe", "Genuine24bit96k"}, code: exitOK,
+			stdout: []string{"Name:", "Genuine24bit96k", "Sample rate:", "96000", "Bit depth:", "24"},
+		},
+		{
+			name: "describe no name", args: []string{"describe"}, code: exitUsage,
+			stderr: []string{"Usage: agar describe"},
+		},
+		{name: "describe two names", args: []string{"describe", "A", "B"}, code: exitUsage},
+		{
+			name: "describe unknown", args: []string{"describe", "Nope"}, code: exitFailure,
+			stderr: []string{errUnknownFixture.Error()},
+		},
+		{name: "generate no name", args: []string{"generate"}, code: exitUsage},
+		{name: "generate bad pattern", args: []string{"generate", "["}, code: exitFailure},
+		{name: "verify no dir", args: []string{"verify"}, code: exitUsage},
+		{
+			name: "verify empty dir", args: []string{"verify", "."}, code: exitFailure,
+			stderr: []string{errNoFixture.Error()},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			stdout, stderr := assertRun(t, tc.args, tc.code)
+
+			for _, expected := range tc.stdout {
+				if !strings.Contains(stdout, expected) {
+					t.Errorf("stdout does not contain %q:\n%s", expected, stdout)
+				}
+			}
+
+			for _, expected := range tc.stderr {
+				if !strings.Contains(stderr, expected) {
+					t.Errorf("stderr does not contain %q:\n%s", expected, stderr)
+				}
+			}
+		})
+	}
+}
+
+// TestRunListPattern checks that patterns select fixtures by name only.
+func TestRunListPattern(t *testing.T) {
+	t.Parallel()
+
+	stdout, _ := assertRun(t, []string{"list", "FLAC*"}, exitOK)
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, "FLAC") {
+			t.Errorf("unexpected fixture: %s", line)
+		}
+	}
+
+	if len(lines) != 1+9 {
+		t.Errorf("got %d fixtures, expected the 9 malformed FLAC fixtures:\n%s", len(lines)-1, stdout)
+	}
+}
+
+// TestRunGenerateVerify generates a fixture written without external tools, then verifies it.
+func TestRunGenerateVerify(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	stdout, _ := assertRun(t, []string{"generate", "-o", dir, "FLACLostSync"}, exitOK)
+	if expected := filepath.Join(dir, "malformed-lost-sync.flac"); strings.TrimSpace(stdout) != expected {
+		t.Errorf("generate printed %q, expected %q", stdout, expected)
+	}
+
+	stdout, _ = assertRun(t, []string{"verify", dir}, exitOK)
+	if !strings.Contains(stdout, "ok   FLACLostSync") {
+		t.Errorf("verify printed %q", stdout)
+	}
+}
+
+// assertRun runs the command line args, checks its exit code and returns its output.
+func assertRun(t *testing.T, args []string, code int) (string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+
+	if got := run(t.Context(), args, &stdout, &stderr); got != code {
+		t.Fatalf("agar %s: exit code %d, expected %d\nstdout:\n%s\nstderr:\n%s",
+			strings.Join(args, " "), got, code, stdout.String(), stderr.String())
+	}
+
+	return stdout.String(), stderr.String()
+}
@@ -0,0 +1,336 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+
+	"github.com/mycophonic/primordium/filesystem"
+)
+
+// AIFFCompression is the four-character compression type of an AIFF-C file.
+type AIFFCompression string
+
+// AIFF-C compression types.
+const (
+	// AIFFCompressionNone is big-endian integer PCM.
+	AIFFCompressionNone AIFFCompression = "NONE"
+	// AIFFCompressionSowt is little-endian integer PCM ("twos" byte-swapped), as written by macOS.
+	AIFFCompressionSowt AIFFCompression = "sowt"
+	// AIFFCompressionFL32 is big-endian 32-bit IEEE float.
+	AIFFCompressionFL32 AIFFCompression = "fl32"
+	// AIFFCompressionFL64 is big-endian 64-bit IEEE float.
+	AIFFCompressionFL64 AIFFCompression = "fl64"
+)
+
+const (
+	// aifcVersion1 is the only defined AIFF-C format version timestamp.
+	aifcVersion1 = 0xA2805140
+
+	// extendedExponentBias is the exponent bias of the 80-bit IEEE 754 extended format.
+	extendedExponentBias = 16383
+
+	aiffCommSize  = 18
+	aiffSSNDExtra = 8
+	extendedSize  = 10
+)
+
+// AIFFOptions controls the layout written by WriteAIFFWithOptions.
+type AIFFOptions struct {
+	// Compression selects the AIFF-C compression type. Empty writes plain AIFF for integer formats
+	// and picks fl32/fl64 for float formats. Any non-empty value produces an AIFF-C file.
+	Compression AIFFCompression
+	// Name, Author and Annotation are written as NAME, AUTH and ANNO chunks when non-empty.
+	Name       string
+	Author     string
+	Annotation string
+	// ID3 is a raw ID3v2 tag written verbatim as an "ID3 " chunk when non-empty.
+	ID3 []byte
+}
+
+// WriteAIFF writes pcm to path as a plain AIFF file (or AIFF-C fl32/fl64 for float formats).
+func WriteAIFF(path string, pcm []byte, format Format) error {
+	return WriteAIFFWithOptions(path, pcm, format, AIFFOptions{})
+}
+
+// WriteAIFFWithOptions writes pcm to path as AIFF or AIFF-C using the given options.
+func WriteAIFFWithOptions(path string, pcm []byte, format Format, opts AIFFOptions) error {
+	encoded, err := EncodeAIFF(pcm, format, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, encoded, filesystem.FilePermissionsPrivate); err != nil {
+		return fmt.Errorf("writing AIFF file: %w", err)
+	}
+
+	return nil
+}
+
+// EncodeAIFF returns a complete AIFF or AIFF-C file for pcm.
+// Input is little-endian as described by Format; samples are converted to the byte order
+// and justification required by the selected compression type.
+func EncodeAIFF(pcm []byte, format Format, opts AIFFOptions) ([]byte, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(pcm)%format.FrameSize() != 0 {
+		return nil, fmt.Errorf("%w: %d bytes is not a multiple of frame size %d",
+			ErrUnsupportedFormat, len(pcm), format.FrameSize())
+	}
+
+	compression := opts.Compression
+	if compression == "" && format.Float {
+		compression = AIFFCompressionFL32
+		if format.BitDepth == FloatBits64 {
+			compression = AIFFCompressionFL64
+		}
+	}
+
+	data, err := aiffSampleData(pcm, format, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	aifc := compression != ""
+
+	var buf bytes.Buffer
+
+	buf.WriteString("FORM")
+	writeBE32(&buf, 0) // patched below
+
+	if aifc {
+		buf.WriteString("AIFC")
+
+		fver := make([]byte, 4)
+		binary.BigEndian.PutUint32(fver, aifcVersion1)
+		writeIFFChunk(&buf, "FVER", fver)
+	} else {
+		buf.WriteString("AIFF")
+	}
+
+	writeIFFChunk(&buf, "COMM", aiffCommChunk(format, compression, len(pcm)/format.FrameSize()))
+
+	for _, text := range []struct{ id, value string }{
+		{"NAME", opts.Name},
+		{"AUTH", opts.Author},
+		{"ANNO", opts.Annotation},
+	} {
+		if text.value != "" {
+			writeIFFChunk(&buf, text.id, []byte(text.value))
+		}
+	}
+
+	ssnd := make([]byte, aiffSSNDExtra+len(data)) // offset and blockSize are zero
+	copy(ssnd[aiffSSNDExtra:], data)
+	writeIFFChunk(&buf, "SSND", ssnd)
+
+	if len(opts.ID3) > 0 {
+		writeIFFChunk(&buf, "ID3 ", opts.ID3)
+	}
+
+	out := buf.Bytes()
+	binary.BigEndian.PutUint32(out[4:], uint32(len(out)-chunkHeaderSize)) //nolint:gosec // G115: IFF is 32-bit.
+
+	return out, nil
+}
+
+// WhiteNoiseAIFF returns path to a deterministic white noise AIFF/AIFF-C in the given format,
+// written without ffmpeg.
+func WhiteNoiseAIFF(data test.Data, helpers test.Helpers, format Format, opts AIFFOptions, durationSec int) string {
+	helpers.T().Helper()
+
+	return WhiteNoiseAIFFGenerator(format, opts, durationSec).Path(data, helpers)
+}
+
+// WhiteNoiseAIFFGenerator returns the generator of WhiteNoiseAIFF.
+func WhiteNoiseAIFFGenerator(format Format, opts AIFFOptions, durationSec int) Generator {
+	ext := ".aiff"
+	if opts.Compression != "" || format.Float {
+		ext = ".aifc"
+	}
+
+	name := whiteNoiseName(format)
+	if opts.Compression != "" {
+		name += "-" + string(opts.Compression)
+	}
+
+	return nativeGenerator("WhiteNoiseAIFF", name+ext, func(path string) error {
+		return WriteAIFFWithOptions(path, whiteNoisePCM(format, durationSec), format, opts)
+	}, strconv.Itoa(durationSec), fmt.Sprintf("%+v", opts))
+}
+
+// Float64ToExtended encodes val as an 80-bit IEEE 754 extended precision number (big-endian),
+// as used by the AIFF COMM chunk sample rate field.
+func Float64ToExtended(val float64) [extendedSize]byte {
+	var out [extendedSize]byte
+
+	if val == 0 || math.IsNaN(val) {
+		return out
+	}
+
+	var sign uint16
+
+	if val < 0 {
+		sign = 0x8000
+		val = -val
+	}
+
+	if math.IsInf(val, 0) {
+		binary.BigEndian.PutUint16(out[0:], sign|0x7FFF)
+		binary.BigEndian.PutUint64(out[2:], 1<<63)
+
+		return out
+	}
+
+	// val = frac * 2^exp with frac in [0.5, 1); the extended mantissa has an explicit integer bit.
+	frac, exp := math.Frexp(val)
+	biased := uint16(exp - 1 + extendedExponentBias) //nolint:gosec // G115: float64 exponents fit the extended range.
+
+	binary.BigEndian.PutUint16(out[0:], sign|biased)
+	binary.BigEndian.PutUint64(out[2:], uint64(math.Ldexp(frac, 64)))
+
+	return out
+}
+
+// aiffCommChunk builds the body of a COMM chunk. AIFF-C adds the compression type and name.
+func aiffCommChunk(format Format, compression AIFFCompression, frames int) []byte {
+	var buf bytes.Buffer
+
+	writeBE16(&buf, uint16(format.Channels)) //nolint:gosec // G115: validated.
+	writeBE32(&buf, uint32(frames))          //nolint:gosec // G115: IFF is 32-bit.
+	writeBE16(&buf, uint16(format.BitDepth)) //nolint:gosec // G115: validated.
+
+	rate := Float64ToExtended(float64(format.SampleRate))
+	buf.Write(rate[:])
+
+	if compression == "" {
+		return buf.Bytes()
+	}
+
+	buf.WriteString(string(compression))
+
+	name := aiffCompressionName(compression)
+	buf.WriteByte(byte(len(name)))
+	buf.WriteString(name)
+
+	// Pascal strings are padded to an even total length (count byte included).
+	if (len(name)+1)%2 == 1 {
+		buf.WriteByte(0)
+	}
+
+	return buf.Bytes()
+}
+
+func aiffCompressionName(compression AIFFCompression) string {
+	switch compression {
+	case AIFFCompressionNone:
+		return "not compressed"
+	case AIFFCompressionFL32:
+		return "32-bit floating point"
+	case AIFFCompressionFL64:
+		return "64-bit floating point"
+	case AIFFCompressionSowt:
+		return ""
+	default:
+		return string(compression)
+	}
+}
+
+// aiffSampleData converts little-endian input samples to the layout of the compression type.
+// Integer samples are left-justified in their container as AIFF requires.
+func aiffSampleData(pcm []byte, format Format, compression AIFFCompression) ([]byte, error) {
+	floatCompression := compression == AIFFCompressionFL32 || compression == AIFFCompressionFL64
+
+	if format.Float != floatCompression {
+		return nil, fmt.Errorf("%w: compression %q with float=%t", ErrUnsupportedFormat, compression, format.Float)
+	}
+
+	if floatCompression {
+		wantBits := FloatBits32
+		if compression == AIFFCompressionFL64 {
+			wantBits = FloatBits64
+		}
+
+		if format.BitDepth != wantBits {
+			return nil, fmt.Errorf("%w: compression %q with %d-bit samples",
+				ErrUnsupportedFormat, compression, format.BitDepth)
+		}
+
+		return swapSampleBytes(pcm, format.BytesPerSample()), nil
+	}
+
+	bps := format.BytesPerSample()
+	shift := bps*bitsPerByte - format.BitDepth
+	out := make([]byte, len(pcm))
+
+	for offset := 0; offset < len(pcm); offset += bps {
+		putPCMSample(out[offset:], bps, pcmSampleAt(pcm[offset:], bps)<<shift)
+	}
+
+	if compression == AIFFCompressionSowt {
+		return out, nil
+	}
+
+	return swapSampleBytes(out, bps), nil
+}
+
+// swapSampleBytes reverses the byte order of every sample in pcm.
+func swapSampleBytes(pcm []byte, bytesPerSample int) []byte {
+	out := make([]byte, len(pcm))
+
+	for offset := 0; offset+bytesPerSample <= len(pcm); offset += bytesPerSample {
+		for idx := range bytesPerSample {
+			out[offset+idx] = pcm[offset+bytesPerSample-1-idx]
+		}
+	}
+
+	return out
+}
+
+// writeIFFChunk appends a chunk with a big-endian size and a pad byte for odd lengths.
+func writeIFFChunk(buf *bytes.Buffer, id string, body []byte) {
+	buf.WriteString(id)
+	writeBE32(buf, uint32(len(body))) //nolint:gosec // G115: IFF chunk sizes are 32-bit.
+	buf.Write(body)
+
+	if len(body)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func writeBE16(buf *bytes.Buffer, val uint16) {
+	var tmp [2]byte
+
+	binary.BigEndian.PutUint16(tmp[:], val)
+	buf.Write(tmp[:])
+}
+
+func writeBE32(buf *bytes.Buffer, val uint32) {
+	var tmp [4]byte
+
+	binary.BigEndian.PutUint32(tmp[:], val)
+	buf.Write(tmp[:])
+}
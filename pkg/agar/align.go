@@ -0,0 +1,338 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// Alignment defaults.
+const (
+	// DefaultMaxLag is the lag searched on either side of zero when AlignOptions.MaxLag is zero, in frames.
+	// It covers the encoder delay and priming of MP3, AAC, HE-AAC, Opus and Vorbis.
+	DefaultMaxLag = 4096
+	// DefaultAlignWindow is the number of frames correlated when AlignOptions.Window is zero.
+	DefaultAlignWindow = 1 << 18
+
+	// correlationTolerance is the correlation difference below which two lags tie. It absorbs the float
+	// noise of the FFT, so that periodic material does not align on a far period by rounding.
+	correlationTolerance = 1e-6
+)
+
+// ErrAlignment is returned when PCM buffers cannot be aligned.
+var ErrAlignment = errors.New("cannot align PCM")
+
+// AlignOptions controls AlignPCM.
+type AlignOptions struct {
+	// MaxLag bounds the lag searched on either side of zero, in frames. Zero selects DefaultMaxLag.
+	MaxLag int
+	// Window is the number of leading frames of each buffer correlated, which bounds the cost of long
+	// buffers. Zero selects DefaultAlignWindow.
+	Window int
+}
+
+// ChannelAlignment is the best lag of one channel.
+type ChannelAlignment struct {
+	// Lag is the number of frames actual is delayed by relative to expected: actual[n+Lag] matches
+	// expected[n]. It is negative when actual is ahead (e.g. one decoder trimmed priming samples).
+	Lag int
+	// Correlation is the normalized cross-correlation at Lag, from -1 to 1.
+	Correlation float64
+}
+
+// Alignment is the lag found between two PCM buffers.
+type Alignment struct {
+	Format Format
+	// ChannelAlignment holds the lag maximizing the correlation of all channels together, which is
+	// the lag Trim applies.
+	ChannelAlignment
+	// Channels holds the best lag of each channel. A channel disagreeing with the overall lag points
+	// to a channel-specific delay.
+	Channels []ChannelAlignment
+	// Overlap is the number of frames the buffers share once aligned.
+	Overlap int
+}
+
+// AlignPCM finds the lag between expected and actual interleaved PCM in format by normalized
+// cross-correlation of the leading frames of each channel, within +/-opts.MaxLag frames. Only lags
+// overlapping at least half of the shorter correlated buffer are considered, since a few overlapping
+// frames correlate perfectly by chance. Correlations within a float tolerance tie, and ties favor the
+// lag closest to zero: silent buffers align at zero, while periodic material (e.g. a pure sine) aligns
+// on the period closest to zero and is better compared unaligned.
+func AlignPCM(expected, actual []byte, format Format, opts AlignOptions) (*Alignment, error) {
+	expectedChannels, err := pcmChannels(expected, format)
+	if err != nil {
+		return nil, fmt.Errorf("expected: %w", err)
+	}
+
+	actualChannels, err := pcmChannels(actual, format)
+	if err != nil {
+		return nil, fmt.Errorf("actual: %w", err)
+	}
+
+	return alignChannels(expectedChannels, actualChannels, format, opts)
+}
+
+// alignChannels finds the lag between de-interleaved channels.
+func alignChannels(expected, actual [][]float64, format Format, opts AlignOptions) (*Alignment, error) {
+	maxLag := opts.MaxLag
+	if maxLag == 0 {
+		maxLag = DefaultMaxLag
+	}
+
+	window := opts.Window
+	if window == 0 {
+		window = DefaultAlignWindow
+	}
+
+	if maxLag < 0 || window < 0 {
+		return nil, fmt.Errorf("%w: negative lag %d or window %d", ErrAlignment, maxLag, window)
+	}
+
+	expectedFrames, actualFrames := len(expected[0]), len(actual[0])
+	if expectedFrames == 0 || actualFrames == 0 {
+		return nil, fmt.Errorf("%w: empty buffer (%d and %d frames)", ErrAlignment, expectedFrames, actualFrames)
+	}
+
+	// Lags beyond either length leave no overlap.
+	maxLag = min(maxLag, expectedFrames-1, actualFrames-1)
+	window = min(window+maxLag, max(expectedFrames, actualFrames))
+
+	alignment := &Alignment{
+		Format:   format,
+		Channels: make([]ChannelAlignment, len(expected)),
+	}
+
+	expectedWindow, actualWindow := min(window, expectedFrames), min(window, actualFrames)
+	minOverlap := (min(expectedWindow, actualWindow) + 1) / 2
+	total := newCorrelation(maxLag)
+
+	for ch := range expected {
+		channel := crossCorrelate(expected[ch][:expectedWindow], actual[ch][:actualWindow], maxLag)
+		alignment.Channels[ch] = channel.best(minOverlap)
+		total.add(channel)
+	}
+
+	alignment.ChannelAlignment = total.best(minOverlap)
+
+	expectedStart, actualStart := alignmentStarts(alignment.Lag)
+	alignment.Overlap = max(0, min(expectedFrames-expectedStart, actualFrames-actualStart))
+
+	return alignment, nil
+}
+
+// Trim returns the aligned overlap of expected and actual, which must be the buffers the alignment was
+// computed from, ready for any comparator.
+func (a *Alignment) Trim(expected, actual []byte) ([]byte, []byte) {
+	frameSize := a.Format.FrameSize()
+	expectedStart, actualStart := alignmentStarts(a.Lag)
+
+	return expected[expectedStart*frameSize : (expectedStart+a.Overlap)*frameSize],
+		actual[actualStart*frameSize : (actualStart+a.Overlap)*frameSize]
+}
+
+// Duration returns the lag as a duration, negative when actual is ahead.
+func (a *Alignment) Duration() time.Duration {
+	return framesDuration(a.Lag, a.Format.SampleRate)
+}
+
+// String summarizes the alignment: overall lag, overlap and per-channel lags.
+func (a *Alignment) String() string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "lag %+d frames (%v), correlation %.4f, %d frames overlap",
+		a.Lag, a.Duration(), a.Correlation, a.Overlap)
+
+	for ch, channel := range a.Channels {
+		fmt.Fprintf(&builder, "\n  channel %d: lag %+d frames, correlation %.4f", ch, channel.Lag, channel.Correlation)
+	}
+
+	return builder.String()
+}
+
+// CompareAlignedPCM aligns expected and actual with AlignPCM, then compares their aligned overlap with
+// ComparePCM.
+func CompareAlignedPCM(
+	expected, actual []byte, format Format, opts AlignOptions,
+) (*Alignment, *PCMComparison, error) {
+	alignment, err := AlignPCM(expected, actual, format, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	alignedExpected, alignedActual := alignment.Trim(expected, actual)
+
+	comparison, err := ComparePCM(alignedExpected, alignedActual, format)
+	if err != nil {
+		return alignment, nil, err
+	}
+
+	return alignment, comparison, nil
+}
+
+// AssertAlignedPCMWithin aligns expected and actual PCM, logs the lag found, and fails the test,
+// logging the comparison, when their aligned overlap differs beyond tolerance. Use Alignment.Trim to
+// run another comparator (e.g. CompareLossySamples) on the overlap instead.
+func AssertAlignedPCMWithin(
+	t tig.T, label string, expected, actual []byte, format Format, opts AlignOptions, tolerance PCMTolerance,
+) {
+	t.Helper()
+
+	alignment, comparison, err := CompareAlignedPCM(expected, actual, format, opts)
+	if alignment != nil {
+		t.Log(label + ": " + alignment.String())
+	}
+
+	if err == nil {
+		err = comparison.Check(tolerance)
+	}
+
+	if err != nil {
+		t.Log(label + ": " + err.Error())
+
+		if comparison != nil {
+			t.Log(comparison.String())
+		}
+
+		t.Fail()
+	}
+}
+
+// alignmentStarts returns the first aligned frame of expected and actual for a lag.
+func alignmentStarts(lag int) (int, int) {
+	if lag < 0 {
+		return -lag, 0
+	}
+
+	return 0, lag
+}
+
+// correlation holds, for lags -maxLag to maxLag, the cross-correlation of two signals over their
+// overlap, the energy of each signal over that overlap, and the overlap in frames.
+type correlation struct {
+	maxLag         int
+	products       []float64
+	expectedEnergy []float64
+	actualEnergy   []float64
+	overlaps       []int
+}
+
+func newCorrelation(maxLag int) *correlation {
+	return &correlation{
+		maxLag:         maxLag,
+		products:       make([]float64, 2*maxLag+1),
+		expectedEnergy: make([]float64, 2*maxLag+1),
+		actualEnergy:   make([]float64, 2*maxLag+1),
+		overlaps:       make([]int, 2*maxLag+1),
+	}
+}
+
+// add accumulates the correlation of another channel, whose buffers have the same lengths.
+func (c *correlation) add(other *correlation) {
+	for idx := range c.products {
+		c.products[idx] += other.products[idx]
+		c.expectedEnergy[idx] += other.expectedEnergy[idx]
+		c.actualEnergy[idx] += other.actualEnergy[idx]
+		c.overlaps[idx] = other.overlaps[idx]
+	}
+}
+
+// best returns the lag of highest normalized correlation among those overlapping at least minOverlap
+// frames, the closest to zero on ties within correlationTolerance.
+func (c *correlation) best(minOverlap int) ChannelAlignment {
+	var best ChannelAlignment
+
+	for offset := range c.maxLag + 1 {
+		for _, lag := range []int{offset, -offset} {
+			idx := lag + c.maxLag
+
+			energy := math.Sqrt(c.expectedEnergy[idx] * c.actualEnergy[idx])
+			if energy == 0 || c.overlaps[idx] < minOverlap {
+				continue
+			}
+
+			if value := c.products[idx] / energy; value > best.Correlation+correlationTolerance {
+				best = ChannelAlignment{Lag: lag, Correlation: value}
+			}
+		}
+	}
+
+	return best
+}
+
+// crossCorrelate computes the correlation of expected and actual for lags -maxLag to maxLag with an
+// FFT, and their energies over each overlap from cumulative sums.
+func crossCorrelate(expected, actual []float64, maxLag int) *correlation {
+	size := 1 << bits.Len(uint(len(expected)+len(actual)))
+
+	expectedReals, expectedImags := make([]float64, size), make([]float64, size)
+	actualReals, actualImags := make([]float64, size), make([]float64, size)
+
+	copy(expectedReals, expected)
+	copy(actualReals, actual)
+
+	fft(expectedReals, expectedImags)
+	fft(actualReals, actualImags)
+
+	// The inverse transform of conj(E) * A is the correlation, computed as the conjugate of the forward
+	// transform of its conjugate.
+	for idx := range size {
+		re := expectedReals[idx]*actualReals[idx] + expectedImags[idx]*actualImags[idx]
+		im := expectedReals[idx]*actualImags[idx] - expectedImags[idx]*actualReals[idx]
+		expectedReals[idx], expectedImags[idx] = re, -im
+	}
+
+	fft(expectedReals, expectedImags)
+
+	expectedCumulative := cumulativeEnergy(expected)
+	actualCumulative := cumulativeEnergy(actual)
+	result := newCorrelation(maxLag)
+
+	for lag := -maxLag; lag <= maxLag; lag++ {
+		idx := lag + maxLag
+		expectedStart, actualStart := alignmentStarts(lag)
+
+		overlap := min(len(expected)-expectedStart, len(actual)-actualStart)
+		if overlap <= 0 {
+			continue
+		}
+
+		result.products[idx] = expectedReals[(lag+size)%size] / float64(size)
+		result.expectedEnergy[idx] = expectedCumulative[expectedStart+overlap] - expectedCumulative[expectedStart]
+		result.actualEnergy[idx] = actualCumulative[actualStart+overlap] - actualCumulative[actualStart]
+		result.overlaps[idx] = overlap
+	}
+
+	return result
+}
+
+// cumulativeEnergy returns the sums of squares of samples [0, n) for every n.
+func cumulativeEnergy(samples []float64) []float64 {
+	out := make([]float64, len(samples)+1)
+	for idx, val := range samples {
+		out[idx+1] = out[idx] + val*val
+	}
+
+	return out
+}
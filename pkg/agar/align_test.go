@@ -0,0 +1,82 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// delayed returns pcm delayed by frames of silence, keeping its length.
+func delayed(pcm []byte, format agar.Format, frames int) []byte {
+	shift := frames * format.FrameSize()
+
+	return slices.Concat(make([]byte, shift), pcm[:len(pcm)-shift])
+}
+
+func TestAlignPCM(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 48000, BitDepth: 16, Channels: 2}
+
+	for _, tt := range []struct {
+		name     string
+		duration time.Duration
+		signal   agar.Signal
+		// noise is added to the delayed copy, as a lossy decoder would.
+		noise agar.Signal
+		lag   int
+	}{
+		{"noise", time.Second, agar.WhiteNoise{Level: -6}, agar.WhiteNoise{Seed: 2, Level: -30}, 37},
+		// A 1 kHz sine repeats every 48 frames: every lag 37+48k correlates as well within float noise.
+		{"periodic", time.Second, agar.Sine{Frequency: 1000, Level: -6}, agar.Silence{}, 37},
+		// 64 frames: lags near the ends overlap by a few frames, which correlate perfectly by chance.
+		{"short", 64 * time.Second / 48000, agar.WhiteNoise{Level: -6}, agar.WhiteNoise{Seed: 2, Level: -30}, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			expected, err := agar.RenderPCM(format, tt.duration, tt.signal)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := agar.RenderPCM(format, tt.duration, agar.Mix{tt.signal, tt.noise})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			alignment, err := agar.AlignPCM(expected, delayed(actual, format, tt.lag), format, agar.AlignOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if alignment.Lag != tt.lag {
+				t.Errorf("lag %+d, expected %+d\n%s", alignment.Lag, tt.lag, alignment)
+			}
+
+			for ch, channel := range alignment.Channels {
+				if channel.Lag != tt.lag {
+					t.Errorf("channel %d: lag %+d, expected %+d", ch, channel.Lag, tt.lag)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// Analysis parameters.
+const (
+	// ClipRunMinSamples is the number of consecutive full-scale samples counted as one clipping run.
+	// Isolated full-scale samples are common in legitimate masters and are not reported as runs.
+	ClipRunMinSamples = 3
+
+	// drBlockDuration is the block length of the DR14 measurement.
+	drBlockDuration = 3 * time.Second
+	// drLoudestFraction is the fraction of the loudest blocks whose RMS enters the DR14 score.
+	drLoudestFraction = 5
+)
+
+// ChannelAnalysis holds the measurements of a single channel. Levels are linear, full scale being 1.0.
+type ChannelAnalysis struct {
+	// Peak is the largest absolute sample value.
+	Peak float64
+	// RMS is the root mean square over the whole channel.
+	RMS float64
+	// CrestFactor is Peak / RMS (sqrt(2) for a sine). Zero for digital silence.
+	CrestFactor float64
+	// DCOffset is the mean sample value.
+	DCOffset float64
+	// ClippedSamples counts samples sitting on either rail of the format.
+	ClippedSamples int
+	// ClipRuns counts runs of at least ClipRunMinSamples consecutive samples on the same rail.
+	ClipRuns int
+	// LongestClipRun is the length in samples of the longest run on a rail.
+	LongestClipRun int
+	// DR is the DR14-style dynamic range in dB: the second highest block peak over the RMS of the
+	// loudest 20% of 3 second blocks. RMS is not scaled by sqrt(2) as in the original meter, so that
+	// a pure sine scores DR3, as documented on the fixtures.
+	DR float64
+}
+
+// PeakDB returns the sample peak in dBFS.
+func (c ChannelAnalysis) PeakDB() float64 {
+	return AmplitudeToDB(c.Peak)
+}
+
+// RMSDB returns the RMS level in dBFS.
+func (c ChannelAnalysis) RMSDB() float64 {
+	return AmplitudeToDB(c.RMS)
+}
+
+// CrestFactorDB returns the crest factor in dB.
+func (c ChannelAnalysis) CrestFactorDB() float64 {
+	return AmplitudeToDB(c.CrestFactor)
+}
+
+// Analysis holds the measurements of interleaved PCM, per channel.
+type Analysis struct {
+	Format Format
+	// Frames is the number of samples per channel.
+	Frames int
+	// Channels holds one entry per channel, in interleaving order.
+	Channels []ChannelAnalysis
+	// DR is the overall DR score: the channel DR values averaged and rounded, as DR meters report it.
+	DR int
+}
+
+// Duration returns the length of the analyzed audio.
+func (a *Analysis) Duration() time.Duration {
+	return time.Duration(int64(a.Frames) * int64(time.Second) / int64(a.Format.SampleRate))
+}
+
+// AnalyzePCM measures interleaved PCM in the given format, integer or float.
+func AnalyzePCM(pcm []byte, format Format) (*Analysis, error) {
+	channels, err := pcmChannels(pcm, format)
+	if err != nil {
+		return nil, err
+	}
+
+	positiveRail, negativeRail := pcmRails(format)
+	blockSize := max(1, SamplesFor(drBlockDuration, format.SampleRate))
+
+	analysis := &Analysis{
+		Format:   format,
+		Frames:   len(channels[0]),
+		Channels: make([]ChannelAnalysis, format.Channels),
+	}
+
+	var drSum float64
+
+	for idx, samples := range channels {
+		stats := analyzeChannel(samples, positiveRail, negativeRail)
+		stats.DR = dynamicRange(samples, blockSize)
+		analysis.Channels[idx] = stats
+		drSum += stats.DR
+	}
+
+	analysis.DR = int(math.Round(drSum / float64(format.Channels)))
+
+	return analysis, nil
+}
+
+// AnalyzeFile decodes an audio file with ffmpeg, at the bit depth and channel count reported by
+// ffprobe, and analyzes it with AnalyzePCM.
+func AnalyzeFile(path string) (*Analysis, error) {
+	pcm, format, err := DecodeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return AnalyzePCM(pcm, format)
+}
+
+// DecodeFile decodes the first audio stream of a file to interleaved little-endian integer PCM.
+// The bit depth is the one reported by ffprobe rounded up to 8, 16, 24 or 32 bits, so that
+// full-scale samples stay on the rails of the returned format. It runs within ToolContext(nil).
+func DecodeFile(path string) ([]byte, Format, error) {
+	ctx, cancel := ToolContext(nil)
+	defer cancel()
+
+	return DecodeFileContext(ctx, path)
+}
+
+// DecodeFileContext is DecodeFile bounded by ctx.
+func DecodeFileContext(ctx context.Context, path string) ([]byte, Format, error) {
+	probe, err := FFProbeContext(ctx, path)
+	if err != nil {
+		return nil, Format{}, err
+	}
+
+	stream, err := probe.AudioStream()
+	if err != nil {
+		return nil, Format{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	format := Format{
+		SampleRate: stream.SampleRateInt(),
+		BitDepth:   decodeBitDepth(stream.BitDepth()),
+		Channels:   stream.Channels,
+	}
+
+	if err = format.Validate(); err != nil {
+		return nil, Format{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	ffmpegPath, err := LookFor(ffmpegBinary)
+	if err != nil {
+		return nil, Format{}, err
+	}
+
+	cmd := newToolCommand(ctx, ffmpegPath,
+		"-v", "error",
+		"-i", path,
+		"-map", "0:a:0",
+		"-f", RawPCMFormat(format.BitDepth),
+		"-acodec", RawPCMCodec(format.BitDepth),
+		"-",
+	)
+
+	var pcm bytes.Buffer
+
+	cmd.Stdout = &pcm
+
+	if err = runTool(ctx, path, cmd); err != nil {
+		return nil, Format{}, err
+	}
+
+	return pcm.Bytes(), format, nil
+}
+
+// decodeBitDepth rounds a source bit depth up to a raw PCM depth ffmpeg can output.
+func decodeBitDepth(bitDepth int) int {
+	switch {
+	case bitDepth <= BitDepth8:
+		return BitDepth8
+	case bitDepth <= BitDepth16:
+		return BitDepth16
+	case bitDepth <= BitDepth24:
+		return BitDepth24
+	default:
+		return BitDepth32
+	}
+}
+
+// pcmChannels de-interleaves PCM into per-channel float samples, integer full scale mapping to [-1, 1).
+func pcmChannels(pcm []byte, format Format) ([][]float64, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+
+	frameSize := format.FrameSize()
+	if len(pcm)%frameSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes is not a whole number of %d-byte frames",
+			ErrUnsupportedFormat, len(pcm), frameSize)
+	}
+
+	bps := format.BytesPerSample()
+	frames := len(pcm) / frameSize
+	scale := math.Ldexp(1, format.BitDepth-1)
+
+	channels := make([][]float64, format.Channels)
+	for ch := range channels {
+		channels[ch] = make([]float64, frames)
+	}
+
+	for frame := range frames {
+		for ch := range format.Channels {
+			offset := frame*frameSize + ch*bps
+
+			var val float64
+
+			switch {
+			case format.Float && format.BitDepth == FloatBits64:
+				val = math.Float64frombits(binary.LittleEndian.Uint64(pcm[offset:]))
+			case format.Float:
+				val = float64(math.Float32frombits(binary.LittleEndian.Uint32(pcm[offset:])))
+			default:
+				val = float64(pcmSampleAt(pcm[offset:], bps)) / scale
+			}
+
+			channels[ch][frame] = val
+		}
+	}
+
+	return channels, nil
+}
+
+// pcmRails returns the positive and negative full-scale values of a format, as produced by pcmChannels.
+func pcmRails(format Format) (float64, float64) {
+	if format.Float {
+		return 1, -1
+	}
+
+	scale := math.Ldexp(1, format.BitDepth-1)
+
+	return (scale - 1) / scale, -1
+}
+
+// analyzeChannel measures everything but DR over one channel.
+func analyzeChannel(samples []float64, positiveRail, negativeRail float64) ChannelAnalysis {
+	var (
+		stats      ChannelAnalysis
+		sum, power float64
+		run        int
+		runSign    int
+	)
+
+	endRun := func() {
+		if run >= ClipRunMinSamples {
+			stats.ClipRuns++
+		}
+
+		stats.LongestClipRun = max(stats.LongestClipRun, run)
+		run, runSign = 0, 0
+	}
+
+	for _, val := range samples {
+		sum += val
+		power += val * val
+		stats.Peak = max(stats.Peak, math.Abs(val))
+
+		sign := 0
+		if val >= positiveRail {
+			sign = 1
+		} else if val <= negativeRail {
+			sign = -1
+		}
+
+		if sign == 0 || sign != runSign {
+			endRun()
+		}
+
+		if sign != 0 {
+			stats.ClippedSamples++
+			run++
+			runSign = sign
+		}
+	}
+
+	endRun()
+
+	if len(samples) > 0 {
+		stats.DCOffset = sum / float64(len(samples))
+		stats.RMS = math.Sqrt(power / float64(len(samples)))
+	}
+
+	if stats.RMS > 0 {
+		stats.CrestFactor = stats.Peak / stats.RMS
+	}
+
+	return stats
+}
+
+// dynamicRange computes the DR14 score of one channel over blocks of blockSize samples. A trailing
+// partial block is measured as well. Digital silence scores zero.
+func dynamicRange(samples []float64, blockSize int) float64 {
+	var rms, peaks []float64
+
+	for block := range slices.Chunk(samples, blockSize) {
+		var power, peak float64
+
+		for _, val := range block {
+			power += val * val
+			peak = max(peak, math.Abs(val))
+		}
+
+		rms = append(rms, math.Sqrt(power/float64(len(block))))
+		peaks = append(peaks, peak)
+	}
+
+	if len(rms) == 0 {
+		return 0
+	}
+
+	slices.Sort(rms)
+	slices.Sort(peaks)
+
+	loudest := rms[len(rms)-max(1, len(rms)/drLoudestFraction):]
+
+	var power float64
+	for _, val := range loudest {
+		power += val * val
+	}
+
+	topRMS := math.Sqrt(power / float64(len(loudest)))
+
+	// The highest peak is discarded when there is another one, making the score robust to a single spike.
+	peak := peaks[len(peaks)-1]
+	if len(peaks) > 1 {
+		peak = peaks[len(peaks)-2]
+	}
+
+	if topRMS == 0 || peak == 0 {
+		return 0
+	}
+
+	return AmplitudeToDB(peak / topRMS)
+}
@@ -0,0 +1,153 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// analyzeSignal renders signals in format and analyzes them.
+func analyzeSignal(t *testing.T, format agar.Format, duration time.Duration, signals ...agar.Signal) *agar.Analysis {
+	t.Helper()
+
+	pcm, err := agar.RenderPCM(format, duration, signals...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analysis, err := agar.AnalyzePCM(pcm, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return analysis
+}
+
+func TestAnalyzePCMSine(t *testing.T) {
+	t.Parallel()
+
+	// 441 Hz fits exactly 100 samples per period at 44.1 kHz.
+	sine := agar.Sine{Frequency: 441, Level: -6}
+
+	for _, format := range []agar.Format{
+		{SampleRate: 44100, BitDepth: agar.BitDepth16, Channels: 2},
+		{SampleRate: 44100, BitDepth: agar.BitDepth24, Channels: 1},
+		{SampleRate: 44100, BitDepth: 32, Channels: 2, Float: true},
+	} {
+		analysis := analyzeSignal(t, format, 10*time.Second, sine)
+
+		if analysis.Frames != 441000 || analysis.Duration() != 10*time.Second || analysis.DR != 3 {
+			t.Errorf("%+v: %d frames over %v, DR%d, expected 441000 frames over 10s, DR3",
+				format, analysis.Frames, analysis.Duration(), analysis.DR)
+		}
+
+		for idx, channel := range analysis.Channels {
+			if math.Abs(channel.CrestFactor-math.Sqrt2) > 1e-3 || math.Abs(channel.CrestFactorDB()-3.01) > 0.01 {
+				t.Errorf("%+v channel %d: crest factor %f, expected sqrt(2)", format, idx, channel.CrestFactor)
+			}
+
+			if math.Abs(channel.PeakDB()+6) > 0.01 || math.Abs(channel.RMSDB()+9.01) > 0.01 {
+				t.Errorf("%+v channel %d: peak %.2f dBFS, RMS %.2f dBFS, expected -6 and -9.01",
+					format, idx, channel.PeakDB(), channel.RMSDB())
+			}
+
+			if math.Abs(channel.DR-3.01) > 0.01 || math.Abs(channel.DCOffset) > 1e-6 || channel.ClippedSamples != 0 {
+				t.Errorf("%+v channel %d: %+v", format, idx, channel)
+			}
+		}
+	}
+}
+
+func TestAnalyzePCMDynamicRange(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 8000, BitDepth: agar.BitDepth16, Channels: 1}
+
+	// The highest block peak is discarded, so a single full-scale spike leaves a sine at DR3.
+	spiked := analyzeSignal(t, format, 9*time.Second,
+		agar.Mix{agar.Sine{Frequency: 400, Level: -20}, agar.Impulse{Position: 100, Level: 0}})
+	if spiked.DR != 3 || spiked.Channels[0].PeakDB() < -0.01 {
+		t.Errorf("spiked sine: DR%d with a %.2f dBFS peak, expected DR3 and a full-scale peak",
+			spiked.DR, spiked.Channels[0].PeakDB())
+	}
+
+	// Only the loudest 20% of blocks enter the RMS, so a long quiet passage leaves the score alone.
+	quiet := analyzeSignal(t, format, 30*time.Second, agar.Sequence{
+		{Signal: agar.Sine{Frequency: 400, Level: -6}, Duration: 6 * time.Second},
+		{Signal: agar.Sine{Frequency: 400, Level: -26}, Duration: 24 * time.Second},
+	})
+	if math.Abs(quiet.Channels[0].DR-3.01) > 0.01 {
+		t.Errorf("quiet passage: DR %.2f, expected 3.01", quiet.Channels[0].DR)
+	}
+
+	silence := analyzeSignal(t, format, time.Second, agar.Silence{})
+	if channel := silence.Channels[0]; silence.DR != 0 || channel.CrestFactor != 0 || channel.Peak != 0 {
+		t.Errorf("silence: DR%d, %+v", silence.DR, channel)
+	}
+}
+
+func TestAnalyzePCMDCOffset(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 44100, BitDepth: agar.BitDepth24, Channels: 2}
+	analysis := analyzeSignal(t, format, time.Second,
+		agar.Mix{agar.Sine{Frequency: 441, Level: -12}, agar.Step{Level: -20}},
+		agar.Mix{agar.Sine{Frequency: 441, Level: -12}, agar.Step{Level: -20, Negative: true}},
+	)
+
+	for idx, expected := range []float64{0.1, -0.1} {
+		if offset := analysis.Channels[idx].DCOffset; math.Abs(offset-expected) > 1e-6 {
+			t.Errorf("channel %d: DC offset %f, expected %f", idx, offset, expected)
+		}
+	}
+}
+
+func TestAnalyzePCMClipRuns(t *testing.T) {
+	t.Parallel()
+
+	const sampleRate = 8000
+
+	segment := func(signal agar.Signal, samples int) agar.Segment {
+		return agar.Segment{Signal: signal, Duration: time.Duration(samples) * time.Second / sampleRate}
+	}
+
+	positive, negative, silence := agar.Step{}, agar.Step{Negative: true}, agar.Silence{}
+
+	// Runs of 3, 4 and 3 samples reach ClipRunMinSamples. A run ends when the signal jumps to the
+	// other rail, and isolated or paired full-scale samples are clipped without forming a run.
+	sequence := agar.Sequence{
+		segment(positive, 3), segment(silence, 1), segment(negative, 2), segment(silence, 1),
+		segment(positive, 1), segment(silence, 1), segment(negative, 4), segment(positive, 2),
+		segment(negative, 3), segment(silence, 2),
+	}
+
+	for _, format := range []agar.Format{
+		{SampleRate: sampleRate, BitDepth: agar.BitDepth16, Channels: 1},
+		{SampleRate: sampleRate, BitDepth: 32, Channels: 1, Float: true},
+	} {
+		channel := analyzeSignal(t, format, 20*time.Second/sampleRate, sequence).Channels[0]
+
+		if channel.ClippedSamples != 15 || channel.ClipRuns != 3 || channel.LongestClipRun != 4 {
+			t.Errorf("%+v: %d clipped samples in %d runs, longest %d, expected 15 in 3 runs, longest 4",
+				format, channel.ClippedSamples, channel.ClipRuns, channel.LongestClipRun)
+		}
+	}
+}
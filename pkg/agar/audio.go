@@ -17,8 +17,6 @@
 package agar
 
 import (
-	"path/filepath"
-
 	"github.com/containerd/nerdctl/mod/tigron/test"
 )
 
@@ -30,7 +28,6 @@ const (
 	soxBinary           = "sox"
 	metaflacBinary      = "metaflac"
 	atomicParsleyBinary = "atomicparsley"
-	id3v2Binary         = "id3v2"
 	vorbiscommentBinary = "vorbiscomment"
 	opustagsBinary      = "opustags"
 
@@ -45,7 +42,11 @@ const (
 func Genuine16bit44k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "genuine-16bit-44k.flac"), []string{
+	return genuine16bit44k().Path(data, helpers)
+}
+
+func genuine16bit44k() Generator {
+	return ffmpegGenerator("Genuine16bit44k", "genuine-16bit-44k.flac", []string{
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.5",
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -56,7 +57,11 @@ func Genuine16bit44k(data test.Data, helpers test.Helpers) string {
 func Genuine24bit96k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "genuine-24bit-96k.flac"), []string{
+	return genuine24bit96k().Path(data, helpers)
+}
+
+func genuine24bit96k() Generator {
+	return ffmpegGenerator("Genuine24bit96k", "genuine-24bit-96k.flac", []string{
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.3",
 		"-f", "lavfi", "-i", "sine=frequency=25000:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=30000:duration=" + defaultDuration,
@@ -71,7 +76,11 @@ func Genuine24bit96k(data test.Data, helpers test.Helpers) string {
 func Genuine24bit48k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "genuine-24bit-48k.flac"), []string{
+	return genuine24bit48k().Path(data, helpers)
+}
+
+func genuine24bit48k() Generator {
+	return ffmpegGenerator("Genuine24bit48k", "genuine-24bit-48k.flac", []string{
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.5",
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
 		"-ar", "48000", "-sample_fmt", "s32",
@@ -82,7 +91,11 @@ func Genuine24bit48k(data test.Data, helpers test.Helpers) string {
 func GenuineMono16bit44k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "genuine-mono-16bit-44k.flac"), []string{
+	return genuineMono16bit44k().Path(data, helpers)
+}
+
+func genuineMono16bit44k() Generator {
+	return ffmpegGenerator("GenuineMono16bit44k", "genuine-mono-16bit-44k.flac", []string{
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.5",
 		"-af", "volume=-6dB",
 		"-ac", "1", "-ar", "44100", "-sample_fmt", "s16",
@@ -93,7 +106,11 @@ func GenuineMono16bit44k(data test.Data, helpers test.Helpers) string {
 func FakeHiresPadded24bit(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generateWithPipe(helpers, filepath.Join(data.Temp().Dir(), "fake-hires-padded-24bit.flac"),
+	return fakeHiresPadded24bit().Path(data, helpers)
+}
+
+func fakeHiresPadded24bit() Generator {
+	return ffmpegPipeGenerator("FakeHiresPadded24bit", "fake-hires-padded-24bit.flac",
 		[]string{
 			"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 			"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
@@ -110,7 +127,11 @@ func FakeHiresPadded24bit(data test.Data, helpers test.Helpers) string {
 func Upsampled44kTo96k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generateWithPipe(helpers, filepath.Join(data.Temp().Dir(), "upsampled-44k-to-96k.flac"),
+	return upsampled44kTo96k().Path(data, helpers)
+}
+
+func upsampled44kTo96k() Generator {
+	return ffmpegPipeGenerator("Upsampled44kTo96k", "upsampled-44k-to-96k.flac",
 		[]string{
 			"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.5",
 			"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
@@ -127,7 +148,11 @@ func Upsampled44kTo96k(data test.Data, helpers test.Helpers) string {
 func FakeStereoMonoDuplicate(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "fake-stereo-mono-duplicate.flac"), []string{
+	return fakeStereoMonoDuplicate().Path(data, helpers)
+}
+
+func fakeStereoMonoDuplicate() Generator {
+	return ffmpegGenerator("FakeStereoMonoDuplicate", "fake-stereo-mono-duplicate.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -138,7 +163,11 @@ func FakeStereoMonoDuplicate(data test.Data, helpers test.Helpers) string {
 func TrueStereoDifferentChannels(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "true-stereo-different-channels.flac"), []string{
+	return trueStereoDifferentChannels().Path(data, helpers)
+}
+
+func trueStereoDifferentChannels() Generator {
+	return ffmpegGenerator("TrueStereoDifferentChannels", "true-stereo-different-channels.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -150,7 +179,11 @@ func TrueStereoDifferentChannels(data test.Data, helpers test.Helpers) string {
 func PhaseCancellationInverted(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "phase-cancellation-inverted.flac"), []string{
+	return phaseCancellationInverted().Path(data, helpers)
+}
+
+func phaseCancellationInverted() Generator {
+	return ffmpegGenerator("PhaseCancellationInverted", "phase-cancellation-inverted.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=-1*c0,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -161,7 +194,11 @@ func PhaseCancellationInverted(data test.Data, helpers test.Helpers) string {
 func ClippedHard(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "clipped-hard.flac"), []string{
+	return clippedHard().Path(data, helpers)
+}
+
+func clippedHard() Generator {
+	return ffmpegGenerator("ClippedHard", "clipped-hard.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=20dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -172,7 +209,11 @@ func ClippedHard(data test.Data, helpers test.Helpers) string {
 func ClippedLimited(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "clipped-limited.flac"), []string{
+	return clippedLimited().Path(data, helpers)
+}
+
+func clippedLimited() Generator {
+	return ffmpegGenerator("ClippedLimited", "clipped-limited.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=15dB,alimiter=limit=1:attack=0.1:release=10",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -183,7 +224,11 @@ func ClippedLimited(data test.Data, helpers test.Helpers) string {
 func DCOffsetPositive(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "dc-offset-positive.flac"), []string{
+	return dcOffsetPositive().Path(data, helpers)
+}
+
+func dcOffsetPositive() Generator {
+	return ffmpegGenerator("DCOffsetPositive", "dc-offset-positive.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,dcshift=0.1,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -194,7 +239,11 @@ func DCOffsetPositive(data test.Data, helpers test.Helpers) string {
 func DCOffsetNegative(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "dc-offset-negative.flac"), []string{
+	return dcOffsetNegative().Path(data, helpers)
+}
+
+func dcOffsetNegative() Generator {
+	return ffmpegGenerator("DCOffsetNegative", "dc-offset-negative.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,dcshift=-0.15,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -205,7 +254,11 @@ func DCOffsetNegative(data test.Data, helpers test.Helpers) string {
 func SilenceMiddleGap(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "silence-middle-gap.flac"), []string{
+	return silenceMiddleGap().Path(data, helpers)
+}
+
+func silenceMiddleGap() Generator {
+	return ffmpegGenerator("SilenceMiddleGap", "silence-middle-gap.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
 		"-f", "lavfi", "-i", "anullsrc=r=44100:cl=stereo:d=6",
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
@@ -218,7 +271,11 @@ func SilenceMiddleGap(data test.Data, helpers test.Helpers) string {
 func SilenceLongIntro(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "silence-long-intro.flac"), []string{
+	return silenceLongIntro().Path(data, helpers)
+}
+
+func silenceLongIntro() Generator {
+	return ffmpegGenerator("SilenceLongIntro", "silence-long-intro.flac", []string{
 		"-f", "lavfi", "-i", "anullsrc=r=44100:cl=stereo:d=5",
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=5",
 		"-filter_complex", "[1]pan=stereo|c0=c0|c1=c0[a];[0][a]concat=n=2:v=0:a=1,volume=-6dB",
@@ -230,7 +287,11 @@ func SilenceLongIntro(data test.Data, helpers test.Helpers) string {
 func TruncatedAbruptCut(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "truncated-abrupt-cut.flac"), []string{
+	return truncatedAbruptCut().Path(data, helpers)
+}
+
+func truncatedAbruptCut() Generator {
+	return ffmpegGenerator("TruncatedAbruptCut", "truncated-abrupt-cut.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
 		"-t", "5.123",
@@ -242,18 +303,27 @@ func TruncatedAbruptCut(data test.Data, helpers test.Helpers) string {
 func ProperFadeout(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "proper-fadeout.flac"), []string{
+	return properFadeout().Path(data, helpers)
+}
+
+func properFadeout() Generator {
+	return ffmpegGenerator("ProperFadeout", "proper-fadeout.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB,afade=t=out:st=8:d=2",
 		"-ar", "44100", "-sample_fmt", "s16",
 	})
 }
 
-// DynamicsExcellent returns path to audio with excellent dynamics (LRA ~15+ LU).
+// DynamicsExcellent returns path to pink noise under a deep 0.5 Hz tremolo. Its momentary loudness
+// swings widely, but 3 s short-term windows average the tremolo out: the loudness range measures ~2 LU.
 func DynamicsExcellent(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "dynamics-excellent.flac"), []string{
+	return dynamicsExcellent().Path(data, helpers)
+}
+
+func dynamicsExcellent() Generator {
+	return ffmpegGenerator("DynamicsExcellent", "dynamics-excellent.flac", []string{
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.3",
 		"-af", "pan=stereo|c0=c0|c1=c0,tremolo=f=0.5:d=0.8,volume=-12dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -265,7 +335,11 @@ func DynamicsExcellent(data test.Data, helpers test.Helpers) string {
 func DynamicsOK(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "dynamics-ok.flac"), []string{
+	return dynamicsOK().Path(data, helpers)
+}
+
+func dynamicsOK() Generator {
+	return ffmpegGenerator("DynamicsOK", "dynamics-ok.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=659:duration=" + defaultDuration,
@@ -279,7 +353,11 @@ func DynamicsOK(data test.Data, helpers test.Helpers) string {
 func DynamicsMediocre(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "dynamics-mediocre.flac"), []string{
+	return dynamicsMediocre().Path(data, helpers)
+}
+
+func dynamicsMediocre() Generator {
+	return ffmpegGenerator("DynamicsMediocre", "dynamics-mediocre.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amix=inputs=2,pan=stereo|c0=c0|c1=c0,volume=-6dB",
@@ -292,7 +370,11 @@ func DynamicsMediocre(data test.Data, helpers test.Helpers) string {
 func DynamicsFucked(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "dynamics-fucked.flac"), []string{
+	return dynamicsFucked().Path(data, helpers)
+}
+
+func dynamicsFucked() Generator {
+	return ffmpegGenerator("DynamicsFucked", "dynamics-fucked.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -304,7 +386,11 @@ func DynamicsFucked(data test.Data, helpers test.Helpers) string {
 func LossyTranscodeMP3128k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generateWithPipe(helpers, filepath.Join(data.Temp().Dir(), "lossy-transcode-mp3-128k.flac"),
+	return lossyTranscodeMP3128k().Path(data, helpers)
+}
+
+func lossyTranscodeMP3128k() Generator {
+	return ffmpegPipeGenerator("LossyTranscodeMP3128k", "lossy-transcode-mp3-128k.flac",
 		[]string{
 			"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.5",
 			"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
@@ -324,7 +410,11 @@ func LossyTranscodeMP3128k(data test.Data, helpers test.Helpers) string {
 func HumMains50Hz(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "hum-mains-50hz.flac"), []string{
+	return humMains50Hz().Path(data, helpers)
+}
+
+func humMains50Hz() Generator {
+	return ffmpegGenerator("HumMains50Hz", "hum-mains-50hz.flac", []string{
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.005",
 		"-f", "lavfi", "-i", "sine=frequency=50:duration=" + defaultDuration,
 		"-filter_complex", "[0]volume=-40dB[n];[1]volume=-20dB[h];[n][h]amix=inputs=2:normalize=0,pan=stereo|c0=c0|c1=c0",
@@ -336,7 +426,11 @@ func HumMains50Hz(data test.Data, helpers test.Helpers) string {
 func ChannelImbalanceLeft(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "channel-imbalance-left.flac"), []string{
+	return channelImbalanceLeft().Path(data, helpers)
+}
+
+func channelImbalanceLeft() Generator {
+	return ffmpegGenerator("ChannelImbalanceLeft", "channel-imbalance-left.flac", []string{
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.5",
 		"-af", "pan=stereo|c0=1.0*c0|c1=0.1*c0,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -348,7 +442,11 @@ func ChannelImbalanceLeft(data test.Data, helpers test.Helpers) string {
 func NoiseFloorHigh(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "noise-floor-high.flac"), []string{
+	return noiseFloorHigh().Path(data, helpers)
+}
+
+func noiseFloorHigh() Generator {
+	return ffmpegGenerator("NoiseFloorHigh", "noise-floor-high.flac", []string{
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=white:a=0.5",
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -361,7 +459,11 @@ func NoiseFloorHigh(data test.Data, helpers test.Helpers) string {
 func NoiseFloorClean(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "noise-floor-clean.flac"), []string{
+	return noiseFloorClean().Path(data, helpers)
+}
+
+func noiseFloorClean() Generator {
+	return ffmpegGenerator("NoiseFloorClean", "noise-floor-clean.flac", []string{
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.5",
 		"-af", "lowpass=f=8000,lowpass=f=8000,lowpass=f=8000,lowpass=f=8000,pan=stereo|c0=c0|c1=c0,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -372,7 +474,11 @@ func NoiseFloorClean(data test.Data, helpers test.Helpers) string {
 func LowLoudnessQuiet(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "low-loudness-quiet.flac"), []string{
+	return lowLoudnessQuiet().Path(data, helpers)
+}
+
+func lowLoudnessQuiet() Generator {
+	return ffmpegGenerator("LowLoudnessQuiet", "low-loudness-quiet.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-30dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -383,7 +489,11 @@ func LowLoudnessQuiet(data test.Data, helpers test.Helpers) string {
 func MultiStream3Audio(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "multi-stream-3-audio.mkv"), []string{
+	return multiStream3Audio().Path(data, helpers)
+}
+
+func multiStream3Audio() Generator {
+	return ffmpegGenerator("MultiStream3Audio", "multi-stream-3-audio.mkv", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=880:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "anoisesrc=d=" + defaultDuration + ":c=pink:a=0.2",
@@ -400,7 +510,11 @@ func MultiStream3Audio(data test.Data, helpers test.Helpers) string {
 func FormatFLAC(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-flac.flac"), []string{
+	return formatFLAC().Path(data, helpers)
+}
+
+func formatFLAC() Generator {
+	return ffmpegGenerator("FormatFLAC", "format-flac.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -412,7 +526,11 @@ func FormatFLAC(data test.Data, helpers test.Helpers) string {
 func FormatALAC(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-alac.m4a"), []string{
+	return formatALAC().Path(data, helpers)
+}
+
+func formatALAC() Generator {
+	return ffmpegGenerator("FormatALAC", "format-alac.m4a", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -424,7 +542,11 @@ func FormatALAC(data test.Data, helpers test.Helpers) string {
 func FormatAAC256k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-aac-256k.m4a"), []string{
+	return formatAAC256k().Path(data, helpers)
+}
+
+func formatAAC256k() Generator {
+	return ffmpegGenerator("FormatAAC256k", "format-aac-256k.m4a", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -436,7 +558,11 @@ func FormatAAC256k(data test.Data, helpers test.Helpers) string {
 func FormatAAC64k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-aac-64k.m4a"), []string{
+	return formatAAC64k().Path(data, helpers)
+}
+
+func formatAAC64k() Generator {
+	return ffmpegGenerator("FormatAAC64k", "format-aac-64k.m4a", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -448,7 +574,11 @@ func FormatAAC64k(data test.Data, helpers test.Helpers) string {
 func FormatMP3320k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-mp3-320k.mp3"), []string{
+	return formatMP3320k().Path(data, helpers)
+}
+
+func formatMP3320k() Generator {
+	return ffmpegGenerator("FormatMP3320k", "format-mp3-320k.mp3", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -460,7 +590,11 @@ func FormatMP3320k(data test.Data, helpers test.Helpers) string {
 func FormatMP396k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-mp3-96k.mp3"), []string{
+	return formatMP396k().Path(data, helpers)
+}
+
+func formatMP396k() Generator {
+	return ffmpegGenerator("FormatMP396k", "format-mp3-96k.mp3", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -472,7 +606,11 @@ func FormatMP396k(data test.Data, helpers test.Helpers) string {
 func FormatOggVorbis(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-ogg-vorbis.ogg"), []string{
+	return formatOggVorbis().Path(data, helpers)
+}
+
+func formatOggVorbis() Generator {
+	return ffmpegGenerator("FormatOggVorbis", "format-ogg-vorbis.ogg", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -484,7 +622,11 @@ func FormatOggVorbis(data test.Data, helpers test.Helpers) string {
 func FormatOpus192k(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-opus-192k.opus"), []string{
+	return formatOpus192k().Path(data, helpers)
+}
+
+func formatOpus192k() Generator {
+	return ffmpegGenerator("FormatOpus192k", "format-opus-192k.opus", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + defaultDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + defaultDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -496,7 +638,11 @@ func FormatOpus192k(data test.Data, helpers test.Helpers) string {
 func FormatMP4VideoOnly(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-mp4-video-only.mp4"), []string{
+	return formatMP4VideoOnly().Path(data, helpers)
+}
+
+func formatMP4VideoOnly() Generator {
+	return ffmpegGenerator("FormatMP4VideoOnly", "format-mp4-video-only.mp4", []string{
 		"-f", "lavfi", "-i", "testsrc=duration=" + shortDuration + ":size=320x240:rate=30",
 		"-c:v", "libx264", "-preset", "ultrafast",
 		"-an",
@@ -507,7 +653,11 @@ func FormatMP4VideoOnly(data test.Data, helpers test.Helpers) string {
 func FormatMP4MultiAudio(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "format-mp4-multi-audio.mp4"), []string{
+	return formatMP4MultiAudio().Path(data, helpers)
+}
+
+func formatMP4MultiAudio() Generator {
+	return ffmpegGenerator("FormatMP4MultiAudio", "format-mp4-multi-audio.mp4", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
 		"-f", "lavfi", "-i", "sine=frequency=880:duration=" + shortDuration,
 		"-filter_complex", "[0]pan=stereo|c0=c0|c1=c0,volume=-6dB[a0];[1]pan=stereo|c0=c0|c1=c0,volume=-6dB[a1]",
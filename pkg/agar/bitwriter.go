@@ -0,0 +1,150 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+// CRC polynomials used by FLAC frame headers and footers.
+const (
+	crc8Poly  = 0x07
+	crc16Poly = 0x8005
+)
+
+// Widths of the bitWriter operations.
+const (
+	// maxUnaryRun is the longest run of zero bits written at once.
+	maxUnaryRun = 63
+	// foldedBits is the width of the values zigzag folds.
+	foldedBits = 64
+)
+
+// bitWriter accumulates MSB-first bit fields, as used by FLAC.
+type bitWriter struct {
+	buf []byte
+	// acc holds the pending bits of the last partial byte in its low bits.
+	acc  byte
+	bits int
+}
+
+// write appends the low width bits of val, most significant bit first.
+func (w *bitWriter) write(val uint64, width int) {
+	for width > 0 {
+		free := bitsPerByte - w.bits
+		take := min(free, width)
+		chunk := byte((val >> (width - take)) & (1<<take - 1))
+
+		w.acc = w.acc<<take | chunk
+		w.bits += take
+		width -= take
+
+		if w.bits == bitsPerByte {
+			w.buf = append(w.buf, w.acc)
+			w.acc = 0
+			w.bits = 0
+		}
+	}
+}
+
+// writeSigned appends val as a width-bit two's complement number.
+func (w *bitWriter) writeSigned(val int64, width int) {
+	w.write(uint64(val), width) //nolint:gosec // G115: two's complement truncation is intended.
+}
+
+// writeUnary appends count zero bits followed by a one bit.
+func (w *bitWriter) writeUnary(count uint64) {
+	for count >= maxUnaryRun {
+		w.write(0, maxUnaryRun)
+		count -= maxUnaryRun
+	}
+
+	w.write(1, int(count)+1) //nolint:gosec // G115: count is below 63 here.
+}
+
+// writeRice appends val as a Rice code with parameter param (zigzag-folded quotient in unary, then param bits).
+func (w *bitWriter) writeRice(val int64, param int) {
+	folded := zigzag(val)
+
+	w.writeUnary(folded >> param)
+	w.write(folded, param)
+}
+
+// appendBits appends every bit written to other.
+func (w *bitWriter) appendBits(other *bitWriter) {
+	for _, b := range other.buf {
+		w.write(uint64(b), bitsPerByte)
+	}
+
+	w.write(uint64(other.acc), other.bits)
+}
+
+// align pads with zero bits up to the next byte boundary.
+func (w *bitWriter) align() {
+	if w.bits > 0 {
+		w.write(0, bitsPerByte-w.bits)
+	}
+}
+
+// len returns the number of bits written.
+func (w *bitWriter) len() int {
+	return len(w.buf)*bitsPerByte + w.bits
+}
+
+// bytes returns the written data. The writer must be byte-aligned.
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
+
+// zigzag folds a signed value so that small magnitudes map to small unsigned values.
+func zigzag(val int64) uint64 {
+	return uint64(val<<1) ^ uint64(val>>(foldedBits-1)) //nolint:gosec // G115: bit folding.
+}
+
+// crc8 computes the FLAC frame header CRC-8 (polynomial x^8 + x^2 + x + 1, initial value 0).
+func crc8(data []byte) byte {
+	var crc byte
+
+	for _, b := range data {
+		crc ^= b
+
+		for range bitsPerByte {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ crc8Poly
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// crc16 computes the FLAC frame footer CRC-16 (polynomial x^16 + x^15 + x^2 + 1, initial value 0).
+func crc16(data []byte) uint16 {
+	var crc uint16
+
+	for _, b := range data {
+		crc ^= uint16(b) << bitsPerByte
+
+		for range bitsPerByte {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ crc16Poly
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,278 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mycophonic/primordium/filesystem"
+)
+
+// Fixture cache parameters.
+const (
+	// cacheFormatVersion is part of every key: bump it when generator output changes without a change
+	// of arguments, so that stale entries are never reused.
+	cacheFormatVersion = "agar-fixture-cache-v1"
+	// cacheEntryPermissions makes cache entries read-only, so that modifying a hard linked fixture in
+	// place fails instead of corrupting the cache.
+	cacheEntryPermissions = 0o444
+	cacheDirName          = "agar"
+	cacheFixturesDir      = "fixtures"
+)
+
+// ErrFixtureCache is returned when a fixture cannot be stored in or retrieved from the cache.
+var ErrFixtureCache = errors.New("fixture cache failure")
+
+// CacheOptions configures the fixture cache.
+type CacheOptions struct {
+	// Dir is the cache directory. Empty selects agar/fixtures under os.UserCacheDir.
+	Dir string
+	// Link hard links cached fixtures into test temp dirs instead of copying them, falling back to a
+	// copy across filesystems. Linked fixtures are read-only: only enable it when tests never modify
+	// fixtures in place.
+	Link bool
+}
+
+// FixtureCache is a content-addressed store of generated fixtures, shared across tests, packages and
+// runs. Entries are keyed by a hash of the fixture name, the generator arguments and steps, and the
+// versions of the tools the generator runs, so that upgrading a tool regenerates the fixtures it made.
+type FixtureCache struct {
+	dir  string
+	link bool
+}
+
+//nolint:gochecknoglobals // the cache is enabled once per test binary, typically from TestMain
+var fixtureCache atomic.Pointer[FixtureCache]
+
+// toolVersionCache holds the version of each tool, probed once per test binary.
+//
+//nolint:gochecknoglobals // tool versions are probed once per test binary
+var toolVersionCache struct {
+	sync.Mutex
+
+	versions map[string]string
+}
+
+// cacheKeyTools lists the tools whose versions are part of the keys computed by FixtureCache.Key.
+//
+//nolint:gochecknoglobals // lookup table
+var cacheKeyTools = []string{ffmpegBinary, soxBinary, metaflacBinary}
+
+// toolVersionFlags maps tools to the flag printing their version, when it is not --version.
+//
+//nolint:gochecknoglobals // lookup table
+var toolVersionFlags = map[string]string{ffmpegBinary: "-version"}
+
+// toolVersions returns the versions of tools, one binary=version line each.
+func toolVersions(tools []string) string {
+	toolVersionCache.Lock()
+	defer toolVersionCache.Unlock()
+
+	if toolVersionCache.versions == nil {
+		toolVersionCache.versions = make(map[string]string)
+	}
+
+	lines := make([]string, 0, len(tools))
+
+	for _, tool := range tools {
+		version, ok := toolVersionCache.versions[tool]
+		if !ok {
+			version = toolVersion(tool)
+			toolVersionCache.versions[tool] = version
+		}
+
+		lines = append(lines, tool+"="+version)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// EnableFixtureCache makes fixture generators reuse cached fixtures, generating missing ones once.
+// It is opt-in, and typically called from TestMain. The cache directory is created if needed.
+func EnableFixtureCache(opts CacheOptions) (*FixtureCache, error) {
+	dir := opts.Dir
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFixtureCache, err)
+		}
+
+		dir = filepath.Join(userCache, cacheDirName, cacheFixturesDir)
+	}
+
+	if err := os.MkdirAll(dir, filesystem.DirPermissionsDefault); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFixtureCache, err)
+	}
+
+	cache := &FixtureCache{dir: dir, link: opts.Link}
+	fixtureCache.Store(cache)
+
+	return cache, nil
+}
+
+// DisableFixtureCache makes fixture generators run every time again.
+func DisableFixtureCache() {
+	fixtureCache.Store(nil)
+}
+
+// Dir returns the cache directory.
+func (c *FixtureCache) Dir() string {
+	return c.dir
+}
+
+// Key returns the cache key of a fixture named name generated from args, with the versions of ffmpeg,
+// sox and metaflac.
+func (c *FixtureCache) Key(name string, args ...[]string) string {
+	return c.key(name, cacheKeyTools, args)
+}
+
+// key returns the cache key of a fixture named name generated by tools from args. Only the versions of
+// tools are probed, so that native fixtures never run an external tool.
+func (c *FixtureCache) key(name string, tools []string, args [][]string) string {
+	var input strings.Builder
+
+	// Fields are NUL-separated and lists NUL-terminated, so that no two inputs hash the same content.
+	for _, field := range []string{cacheFormatVersion, toolVersions(tools), name} {
+		input.WriteString(field + "\x00")
+	}
+
+	for _, list := range args {
+		for _, arg := range list {
+			input.WriteString(arg + "\x00")
+		}
+
+		input.WriteString("\x00")
+	}
+
+	sum := sha256.Sum256([]byte(input.String()))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Fetch places the entry for key at destination, calling create to generate it into the path it is
+// given when the cache does not hold it yet. Processes generating the same key are serialized with a
+// file lock, and entries only appear once create has fully succeeded.
+func (c *FixtureCache) Fetch(key, destination string, create func(path string) error) error {
+	entry := filepath.Join(c.dir, key+"-"+filepath.Base(destination))
+	lockPath := entry + ".lock"
+
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDONLY, filesystem.FilePermissionsDefault)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFixtureCache, err)
+	}
+
+	if err = lockFile.Close(); err != nil {
+		return fmt.Errorf("%w: %w", ErrFixtureCache, err)
+	}
+
+	err = filesystem.WithLock(lockPath, func() error {
+		if _, statErr := os.Stat(entry); errors.Is(statErr, fs.ErrNotExist) {
+			if createErr := c.store(entry, create); createErr != nil {
+				return createErr
+			}
+		}
+
+		return c.place(entry, destination)
+	})
+	if err != nil && !errors.Is(err, ErrFixtureCache) {
+		err = fmt.Errorf("%w: %w", ErrFixtureCache, err)
+	}
+
+	return err
+}
+
+// store generates an entry under a temporary name and renames it into place.
+func (c *FixtureCache) store(entry string, create func(path string) error) error {
+	partial := filepath.Join(filepath.Dir(entry), ".partial-"+filepath.Base(entry))
+
+	if err := os.Remove(partial); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err := create(partial); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(partial); err != nil {
+		return fmt.Errorf("%w: generator did not create %s: %w", ErrFixtureCache, filepath.Base(entry), err)
+	}
+
+	if err := os.Chmod(partial, cacheEntryPermissions); err != nil {
+		return err
+	}
+
+	return os.Rename(partial, entry)
+}
+
+// place hard links or copies an entry to destination.
+func (c *FixtureCache) place(entry, destination string) error {
+	if err := os.Remove(destination); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if c.link {
+		if err := os.Link(entry, destination); err == nil {
+			return nil
+		}
+	}
+
+	content, err := os.ReadFile(entry) //nolint:gosec // G304: entry is in the cache directory.
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(destination, content, filesystem.FilePermissionsPrivate)
+}
+
+// toolVersion returns the first line of the version output of binary, or "none" when it is unavailable.
+func toolVersion(binary string) string {
+	flag, ok := toolVersionFlags[binary]
+	if !ok {
+		flag = "--version"
+	}
+
+	path, err := LookFor(binary)
+	if err != nil {
+		return "none"
+	}
+
+	ctx, cancel := ToolContext(nil)
+	defer cancel()
+
+	var out bytes.Buffer
+
+	cmd := newToolCommand(ctx, path, flag)
+	cmd.Stdout = &out
+
+	if err = runTool(ctx, "fixture cache", cmd); err != nil {
+		return "unknown"
+	}
+
+	line, _, _ := strings.Cut(strings.TrimSpace(out.String()), "\n")
+
+	return line
+}
@@ -0,0 +1,241 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+//nolint:paralleltest // replaces PATH and the fixture cache of the test binary.
+func TestFixtureCacheNativeSkipsTools(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+
+	// Fake tools record that they ran.
+	bin, marker := t.TempDir(), filepath.Join(t.TempDir(), "probed")
+	for _, tool := range []string{"ffmpeg", "sox", "metaflac"} {
+		script := "#!/bin/sh\necho " + tool + " >> " + marker + "\n"
+		if err := os.WriteFile(filepath.Join(bin, tool), []byte(script), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PATH", bin)
+
+	if _, err := agar.EnableFixtureCache(agar.CacheOptions{Dir: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(agar.DisableFixtureCache)
+
+	generator := agar.WhiteNoiseWAVGenerator(agar.Format{SampleRate: 8000, BitDepth: 16, Channels: 1}, 1)
+	for range 2 {
+		if _, err := generator.Generate(context.Background(), t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if probed, err := os.ReadFile(marker); err == nil {
+		t.Errorf("caching a native fixture ran %s", probed)
+	}
+}
+
+// enableTestCache enables the fixture cache in a fresh directory for the duration of the test.
+func enableTestCache(t *testing.T) *agar.FixtureCache {
+	t.Helper()
+
+	cache, err := agar.EnableFixtureCache(agar.CacheOptions{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(agar.DisableFixtureCache)
+
+	return cache
+}
+
+// generateContent generates the fixture of generator into a fresh directory and returns its content.
+func generateContent(t *testing.T, generator agar.Generator) string {
+	t.Helper()
+
+	path, err := generator.Generate(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+//nolint:paralleltest // replaces the fixture cache of the test binary.
+func TestFixtureCacheHit(t *testing.T) {
+	cache := enableTestCache(t)
+
+	var runs atomic.Int32
+
+	generator := agar.CountingGenerator("hit.bin", "content", &runs)
+
+	for range 3 {
+		if content := generateContent(t, generator); content != "content" {
+			t.Fatalf("generated %q", content)
+		}
+	}
+
+	if runs.Load() != 1 {
+		t.Errorf("generator ran %d times, expected once", runs.Load())
+	}
+
+	entries, err := filepath.Glob(filepath.Join(cache.Dir(), "*-hit.bin"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("cache entries %q: %v", entries, err)
+	}
+
+	if info, err := os.Stat(entries[0]); err != nil || info.Mode().Perm()&0o222 != 0 {
+		t.Errorf("cache entry is writable or missing: %v", err)
+	}
+
+	// Fixtures are copies: modifying one leaves the cache entry alone.
+	path, err := generator.Generate(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(path, []byte("modified"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if content := generateContent(t, generator); content != "content" {
+		t.Errorf("generated %q after modifying a copy", content)
+	}
+}
+
+//nolint:paralleltest // replaces the fixture cache of the test binary.
+func TestFixtureCacheStepKeys(t *testing.T) {
+	enableTestCache(t)
+
+	var runs atomic.Int32
+
+	base := agar.CountingGenerator("steps.bin", "base", &runs)
+
+	// Each chain of step parameters has its own entry; repeating a chain hits the cache.
+	for _, tc := range []struct {
+		generator agar.Generator
+		expected  string
+		runs      int32
+	}{
+		{base, "base", 1},
+		{base.WithAppendStep("-a"), "base-a", 2},
+		{base.WithAppendStep("-b"), "base-b", 3},
+		{base.WithAppendStep("-a").WithAppendStep("-b"), "base-a-b", 4},
+		{base.WithAppendStep("-a-b"), "base-a-b", 5},
+		{base.WithAppendStep("-a"), "base-a", 5},
+		{base, "base", 5},
+	} {
+		if content := generateContent(t, tc.generator); content != tc.expected || runs.Load() != tc.runs {
+			t.Errorf("generated %q with %d runs, expected %q with %d", content, runs.Load(), tc.expected, tc.runs)
+		}
+	}
+
+	cache := enableTestCache(t)
+	if cache.Key("name", []string{"a", "b"}) == cache.Key("name", []string{"a"}, []string{"b"}) ||
+		cache.Key("name", []string{"a"}) == cache.Key("other", []string{"a"}) {
+		t.Error("distinct arguments share a cache key")
+	}
+}
+
+//nolint:paralleltest // replaces the fixture cache of the test binary.
+func TestFixtureCacheContention(t *testing.T) {
+	cache := enableTestCache(t)
+
+	const fetchers = 8
+
+	var (
+		runs, active, maxActive atomic.Int32
+		wait                    sync.WaitGroup
+	)
+
+	errs := make([]error, fetchers)
+	paths := make([]string, fetchers)
+
+	for idx := range fetchers {
+		paths[idx] = filepath.Join(t.TempDir(), "contended.bin")
+
+		wait.Go(func() {
+			errs[idx] = cache.Fetch("contended", paths[idx], func(path string) error {
+				runs.Add(1)
+
+				current := active.Add(1)
+				defer active.Add(-1)
+
+				for previous := maxActive.Load(); current > previous; previous = maxActive.Load() {
+					maxActive.CompareAndSwap(previous, current)
+				}
+
+				time.Sleep(20 * time.Millisecond)
+
+				return os.WriteFile(path, []byte("contended"), 0o600)
+			})
+		})
+	}
+
+	wait.Wait()
+
+	if runs.Load() != 1 || maxActive.Load() != 1 {
+		t.Errorf("create ran %d times, %d at once, expected once", runs.Load(), maxActive.Load())
+	}
+
+	for idx, path := range paths {
+		if errs[idx] != nil {
+			t.Fatal(errs[idx])
+		}
+
+		if content, err := os.ReadFile(path); err != nil || string(content) != "contended" {
+			t.Errorf("fetcher %d placed %q: %v", idx, content, err)
+		}
+	}
+
+	// A failed creation leaves no entry behind, so the next fetch creates it again.
+	failed := cache.Fetch("failing", filepath.Join(t.TempDir(), "failing.bin"), func(string) error {
+		return errCreate
+	})
+	if !errors.Is(failed, errCreate) || !errors.Is(failed, agar.ErrFixtureCache) {
+		t.Errorf("failed creation: got %v", failed)
+	}
+
+	if err := cache.Fetch("failing", filepath.Join(t.TempDir(), "failing.bin"), func(path string) error {
+		return os.WriteFile(path, []byte("retried"), 0o600)
+	}); err != nil {
+		t.Errorf("retry after a failed creation: %v", err)
+	}
+}
+
+var errCreate = errors.New("create failed")
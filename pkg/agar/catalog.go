@@ -0,0 +1,389 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"path"
+	"slices"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// Fixture containers, as the file extension of their usual name.
+const (
+	ContainerFLAC = "flac"
+	ContainerMP4  = "mp4"
+	ContainerMKV  = "mkv"
+	ContainerMP3  = "mp3"
+	ContainerOgg  = "ogg"
+	ContainerRF64 = "rf64"
+	ContainerW64  = "w64"
+	ContainerJPEG = "jpeg"
+	ContainerPNG  = "png"
+)
+
+// Fixture codecs, as ffmpeg codec names.
+const (
+	CodecFLAC   = "flac"
+	CodecALAC   = "alac"
+	CodecPCM    = "pcm_s16le"
+	CodecAAC    = "aac"
+	CodecMP3    = "mp3"
+	CodecVorbis = "vorbis"
+	CodecOpus   = "opus"
+	CodecH264   = "h264"
+	CodecMJPEG  = "mjpeg"
+	CodecPNG    = "png"
+)
+
+// Fixture durations.
+const (
+	fixtureDuration      = 10 * time.Second
+	shortFixtureDuration = 3 * time.Second
+	silenceGapDuration   = 12 * time.Second
+	oversizedDuration    = time.Second
+)
+
+// Fixture is a catalog entry: a fixture generator and the documented properties of the file it generates.
+type Fixture struct {
+	Generator
+
+	// Container is the file format, one of the Container constants.
+	Container string
+	// Codec is the codec of the audio streams (of the video stream or image without audio), one of the
+	// Codec constants.
+	Codec string
+	// Streams is the number of audio streams.
+	Streams int
+	// SampleRate is the sample rate of the first audio stream, in Hz.
+	SampleRate int
+	// BitDepth is the bit depth of lossless audio. It is zero for lossy codecs, and when the generator
+	// leaves ffmpeg to pick the sample format.
+	BitDepth int
+	// Channels is the channel count of each audio stream.
+	Channels int
+	// Duration is the duration of the audio actually present.
+	Duration time.Duration
+	// Oversized is set when the headers declare far more audio than Duration.
+	Oversized bool
+	// Malformed is set when the stream is structurally damaged on purpose (see FLACFault). The other
+	// fields describe the audio before the damage, which probing may not recover.
+	Malformed bool
+	// Tagged is set when the fixture carries test metadata tags.
+	Tagged bool
+
+	// Clipped is set when the audio has hard clipping runs.
+	Clipped bool
+	// Upsampled is set when the audio was resampled up from a lower rate.
+	Upsampled bool
+	// Padded is set when the audio was padded from a lower bit depth.
+	Padded bool
+	// FakeStereo is set when every channel was generated from the same mono signal. Lossy coding may
+	// leave decoded channels slightly different.
+	FakeStereo bool
+	// DR is the documented DR score, zero when none is documented.
+	DR int
+	// Cutoff is the documented brick-wall lowpass, in Hz, zero when none is documented.
+	Cutoff float64
+}
+
+// Catalog is a list of fixtures, filtered with Where, e.g. Fixtures().Where(Lossless).Where(Rate(96000)).
+type Catalog []Fixture
+
+// FixturePredicate selects fixtures of a Catalog.
+type FixturePredicate func(fixture Fixture) bool
+
+// Fixtures returns the catalog of every fixture function taking no parameters besides the test data and
+// helpers, in a stable order.
+func Fixtures() Catalog {
+	flac := func(gen Generator, rate, depth, channels int, duration time.Duration) Fixture {
+		return Fixture{
+			Generator: gen, Container: ContainerFLAC, Codec: CodecFLAC, Streams: 1,
+			SampleRate: rate, BitDepth: depth, Channels: channels, Duration: duration,
+		}
+	}
+
+	// cd is the common format of the generated FLAC fixtures: 16-bit 44.1 kHz mono panned to stereo.
+	cd := func(gen Generator, duration time.Duration) Fixture {
+		fixture := flac(gen, 44100, BitDepth16, 2, duration)
+		fixture.FakeStereo = true
+
+		return fixture
+	}
+
+	lossy := func(gen Generator, container, codec string, rate int, duration time.Duration) Fixture {
+		return Fixture{
+			Generator: gen, Container: container, Codec: codec, Streams: 1,
+			SampleRate: rate, Channels: 2, Duration: duration,
+		}
+	}
+
+	tagged := func(fixture Fixture) Fixture {
+		fixture.Tagged = true
+
+		return fixture
+	}
+
+	with := func(fixture Fixture, edit func(fixture *Fixture)) Fixture {
+		edit(&fixture)
+
+		return fixture
+	}
+
+	catalog := Catalog{
+		cd(genuine16bit44k(), fixtureDuration),
+		with(cd(genuine24bit96k(), fixtureDuration), func(f *Fixture) { f.SampleRate, f.BitDepth = 96000, BitDepth24 }),
+		with(cd(genuine24bit48k(), fixtureDuration), func(f *Fixture) { f.SampleRate, f.BitDepth = 48000, BitDepth24 }),
+		flac(genuineMono16bit44k(), 44100, BitDepth16, 1, fixtureDuration),
+		with(cd(fakeHiresPadded24bit(), fixtureDuration), func(f *Fixture) { f.BitDepth, f.Padded = BitDepth24, true }),
+		with(cd(upsampled44kTo96k(), fixtureDuration), func(f *Fixture) {
+			f.SampleRate, f.BitDepth, f.Upsampled, f.Cutoff = 96000, BitDepth24, true, upsampledCutoff
+		}),
+		cd(fakeStereoMonoDuplicate(), fixtureDuration),
+		flac(trueStereoDifferentChannels(), 44100, BitDepth16, 2, fixtureDuration),
+		flac(phaseCancellationInverted(), 44100, BitDepth16, 2, fixtureDuration),
+		with(cd(clippedHard(), fixtureDuration), func(f *Fixture) { f.Clipped = true }),
+		cd(clippedLimited(), fixtureDuration),
+		cd(dcOffsetPositive(), fixtureDuration),
+		cd(dcOffsetNegative(), fixtureDuration),
+		cd(silenceMiddleGap(), silenceGapDuration),
+		cd(silenceLongIntro(), fixtureDuration),
+		cd(truncatedAbruptCut(), truncatedDuration),
+		cd(properFadeout(), fixtureDuration),
+		cd(dynamicsExcellent(), fixtureDuration),
+		with(cd(dynamicsOK(), fixtureDuration), func(f *Fixture) { f.DR = drOK }),
+		with(cd(dynamicsMediocre(), fixtureDuration), func(f *Fixture) { f.DR = drMediocre }),
+		with(cd(dynamicsFucked(), fixtureDuration), func(f *Fixture) { f.DR = drBrickwalled }),
+		with(cd(lossyTranscodeMP3128k(), fixtureDuration), func(f *Fixture) {
+			// ffmpeg picks the bit depth of the decoded MP3.
+			f.BitDepth, f.Cutoff = 0, lossyCutoff
+		}),
+		cd(humMains50Hz(), fixtureDuration),
+		flac(channelImbalanceLeft(), 44100, BitDepth16, 2, fixtureDuration),
+		cd(noiseFloorHigh(), fixtureDuration),
+		cd(noiseFloorClean(), fixtureDuration),
+		cd(lowLoudnessQuiet(), fixtureDuration),
+		with(cd(multiStream3Audio(), fixtureDuration), func(f *Fixture) {
+			f.Container, f.Streams, f.BitDepth = ContainerMKV, 3, 0
+		}),
+		flac(formatFLAC(), 44100, 0, 2, fixtureDuration),
+		with(flac(formatALAC(), 44100, 0, 2, fixtureDuration), func(f *Fixture) {
+			f.Container, f.Codec = ContainerMP4, CodecALAC
+		}),
+		lossy(formatAAC256k(), ContainerMP4, CodecAAC, 44100, fixtureDuration),
+		lossy(formatAAC64k(), ContainerMP4, CodecAAC, 44100, fixtureDuration),
+		lossy(formatMP3320k(), ContainerMP3, CodecMP3, 44100, fixtureDuration),
+		lossy(formatMP396k(), ContainerMP3, CodecMP3, 44100, fixtureDuration),
+		lossy(formatOggVorbis(), ContainerOgg, CodecVorbis, 44100, fixtureDuration),
+		lossy(formatOpus192k(), ContainerOgg, CodecOpus, 48000, fixtureDuration),
+		{Generator: formatMP4VideoOnly(), Container: ContainerMP4, Codec: CodecH264, Duration: shortFixtureDuration},
+		with(lossy(formatMP4MultiAudio(), ContainerMP4, CodecAAC, 44100, shortFixtureDuration), func(f *Fixture) {
+			f.Streams, f.FakeStereo = 2, true
+		}),
+		tagged(lossy(TaggedMP3Generator(ID3v24), ContainerMP3, CodecMP3, 44100, shortFixtureDuration)),
+		tagged(lossy(TaggedMP3Generator(ID3v23), ContainerMP3, CodecMP3, 44100, shortFixtureDuration)),
+		tagged(lossy(TaggedMP3Generator(ID3v22), ContainerMP3, CodecMP3, 44100, shortFixtureDuration)),
+		tagged(lossy(TaggedMP3Generator(ID3v11), ContainerMP3, CodecMP3, 44100, shortFixtureDuration)),
+		lossy(untaggedMP3(), ContainerMP3, CodecMP3, 44100, shortFixtureDuration),
+		tagged(lossy(taggedAAC(), ContainerMP4, CodecAAC, 44100, fixtureDuration)),
+		with(tagged(flac(taggedALAC(), 44100, 0, 2, fixtureDuration)), func(f *Fixture) {
+			f.Container, f.Codec = ContainerMP4, CodecALAC
+		}),
+		tagged(lossy(taggedAACWithUnknown(), ContainerMP4, CodecAAC, 44100, fixtureDuration)),
+		tagged(lossy(taggedAACWithMultiArtist(), ContainerMP4, CodecAAC, 44100, fixtureDuration)),
+		tagged(lossy(taggedAACWithMusicBrainz(), ContainerMP4, CodecAAC, 44100, fixtureDuration)),
+		lossy(untaggedAAC(), ContainerMP4, CodecAAC, 44100, shortFixtureDuration),
+		tagged(lossy(taggedOggVorbis(), ContainerOgg, CodecVorbis, 44100, shortFixtureDuration)),
+		tagged(lossy(taggedOggVorbisMultiArtist(), ContainerOgg, CodecVorbis, 44100, shortFixtureDuration)),
+		lossy(untaggedOggVorbis(), ContainerOgg, CodecVorbis, 44100, shortFixtureDuration),
+		tagged(cd(taggedFLAC(), shortFixtureDuration)),
+		cd(untaggedFLAC(), shortFixtureDuration),
+		tagged(cd(taggedFLACMultiArtist(), shortFixtureDuration)),
+		{Generator: testCoverJPEG(), Container: ContainerJPEG, Codec: CodecMJPEG},
+		{Generator: testCoverPNG(), Container: ContainerPNG, Codec: CodecPNG},
+		{Generator: testCoverAlternate(), Container: ContainerJPEG, Codec: CodecMJPEG},
+		with(flac(oversizedRF64(), 44100, BitDepth16, 2, oversizedDuration), func(f *Fixture) {
+			f.Container, f.Codec, f.Oversized = ContainerRF64, CodecPCM, true
+		}),
+		with(flac(oversizedW64(), 44100, BitDepth16, 2, oversizedDuration), func(f *Fixture) {
+			f.Container, f.Codec, f.Oversized = ContainerW64, CodecPCM, true
+		}),
+	}
+
+	for _, fault := range FLACFaults() {
+		catalog = append(catalog, with(flac(MalformedFLACGenerator(fault), 44100, BitDepth16, 2, shortFixtureDuration),
+			func(f *Fixture) { f.Malformed = true }))
+	}
+
+	return catalog
+}
+
+// Where returns the fixtures matching every predicate.
+func (c Catalog) Where(predicates ...FixturePredicate) Catalog {
+	var out Catalog
+
+	for _, fixture := range c {
+		if !slices.ContainsFunc(predicates, func(predicate FixturePredicate) bool {
+			return !predicate(fixture)
+		}) {
+			out = append(out, fixture)
+		}
+	}
+
+	return out
+}
+
+// Lookup returns the fixture with the given name.
+func (c Catalog) Lookup(name string) (Fixture, bool) {
+	idx := slices.IndexFunc(c, func(fixture Fixture) bool {
+		return fixture.Name == name
+	})
+	if idx < 0 {
+		return Fixture{}, false
+	}
+
+	return c[idx], true
+}
+
+// Names returns the names of the fixtures.
+func (c Catalog) Names() []string {
+	names := make([]string, 0, len(c))
+	for _, fixture := range c {
+		names = append(names, fixture.Name)
+	}
+
+	return names
+}
+
+// Generators returns the generators of the fixtures.
+func (c Catalog) Generators() []Generator {
+	generators := make([]Generator, 0, len(c))
+	for _, fixture := range c {
+		generators = append(generators, fixture.Generator)
+	}
+
+	return generators
+}
+
+// Tests returns one tigron subtest per fixture, built by build and described by the fixture name unless
+// build sets a description. Use Fixture.Generate, or Generator.Path from a Setup, to create the file.
+func (c Catalog) Tests(build func(fixture Fixture) *test.Case) []*test.Case {
+	cases := make([]*test.Case, 0, len(c))
+
+	for _, fixture := range c {
+		testCase := build(fixture)
+		if testCase.Description == "" {
+			testCase.Description = fixture.Name
+		}
+
+		cases = append(cases, testCase)
+	}
+
+	return cases
+}
+
+// Audio selects well-formed fixtures with at least one audio stream.
+func Audio(fixture Fixture) bool {
+	return fixture.Streams > 0 && !fixture.Malformed
+}
+
+// Lossless selects audio fixtures encoded with a lossless codec, whatever their source.
+func Lossless(fixture Fixture) bool {
+	return Audio(fixture) && slices.Contains([]string{CodecFLAC, CodecALAC, CodecPCM}, fixture.Codec)
+}
+
+// Lossy selects audio fixtures encoded with a lossy codec.
+func Lossy(fixture Fixture) bool {
+	return Audio(fixture) && !Lossless(fixture)
+}
+
+// Tagged selects fixtures carrying test metadata tags.
+func Tagged(fixture Fixture) bool {
+	return fixture.Tagged
+}
+
+// Malformed selects structurally damaged fixtures, which Audio leaves out.
+func Malformed(fixture Fixture) bool {
+	return fixture.Malformed
+}
+
+// Clipped selects fixtures with hard clipping.
+func Clipped(fixture Fixture) bool {
+	return fixture.Clipped
+}
+
+// Upsampled selects fixtures resampled up from a lower rate.
+func Upsampled(fixture Fixture) bool {
+	return fixture.Upsampled
+}
+
+// FakeStereo selects fixtures whose channels were all generated from the same mono signal.
+func FakeStereo(fixture Fixture) bool {
+	return fixture.FakeStereo
+}
+
+// Rate selects fixtures at the given sample rate.
+func Rate(sampleRate int) FixturePredicate {
+	return func(fixture Fixture) bool {
+		return fixture.SampleRate == sampleRate
+	}
+}
+
+// Depth selects fixtures of the given bit depth.
+func Depth(bitDepth int) FixturePredicate {
+	return func(fixture Fixture) bool {
+		return fixture.BitDepth == bitDepth
+	}
+}
+
+// Channels selects fixtures with the given channel count.
+func Channels(channels int) FixturePredicate {
+	return func(fixture Fixture) bool {
+		return fixture.Channels == channels
+	}
+}
+
+// Container selects fixtures in the given container.
+func Container(container string) FixturePredicate {
+	return func(fixture Fixture) bool {
+		return fixture.Container == container
+	}
+}
+
+// Codec selects fixtures encoded with the given codec.
+func Codec(codec string) FixturePredicate {
+	return func(fixture Fixture) bool {
+		return fixture.Codec == codec
+	}
+}
+
+// Named selects fixtures whose name matches a path.Match pattern, such as "Tagged*".
+func Named(pattern string) FixturePredicate {
+	return func(fixture Fixture) bool {
+		matched, err := path.Match(pattern, fixture.Name)
+
+		return err == nil && matched
+	}
+}
+
+// Not selects fixtures not matching predicate.
+func Not(predicate FixturePredicate) FixturePredicate {
+	return func(fixture Fixture) bool {
+		return !predicate(fixture)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/require"
+	"github.com/containerd/nerdctl/mod/tigron/test"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// TestCatalogMatchesProbe generates every catalog fixture and checks its documented format against ffprobe.
+//
+//nolint:paralleltest // the test case runs in parallel.
+func TestCatalogMatchesProbe(t *testing.T) {
+	testCase := &test.Case{
+		Require: require.All(
+			require.Binary("ffmpeg"),
+			require.Binary("ffprobe"),
+			require.Binary("metaflac"),
+			require.Binary("vorbiscomment"),
+		),
+		SubTests: agar.Fixtures().Tests(func(fixture agar.Fixture) *test.Case {
+			return &test.Case{
+				Setup: func(data test.Data, helpers test.Helpers) {
+					path := fixture.Path(data, helpers)
+
+					ctx, cancel := agar.ToolContext(helpers.T())
+					defer cancel()
+
+					if err := fixture.Verify(ctx, path); err != nil {
+						helpers.T().Log(err.Error())
+						helpers.T().FailNow()
+					}
+				},
+			}
+		}),
+	}
+
+	testCase.Run(t)
+}
+
+// nativeFormat is the format of a natively generated fixture, as parsed from its headers.
+type nativeFormat struct {
+	sampleRate, bitDepth, channels int
+	duration                       time.Duration
+	// oversized is set when the headers declare more audio than present.
+	oversized bool
+}
+
+// TestCatalogMatchesNativeFixtures generates the fixtures written without external tools and checks their
+// documented format against their headers.
+func TestCatalogMatchesNativeFixtures(t *testing.T) {
+	t.Parallel()
+
+	natives := agar.Fixtures().Where(func(fixture agar.Fixture) bool {
+		return fixture.Malformed || fixture.Oversized
+	})
+	if len(natives) != len(agar.FLACFaults())+2 {
+		t.Fatalf("unexpected native fixtures %v", natives.Names())
+	}
+
+	for _, fixture := range natives {
+		t.Run(fixture.Name, func(t *testing.T) {
+			t.Parallel()
+
+			path, err := fixture.Generate(context.Background(), t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var parsed nativeFormat
+
+			switch fixture.Container {
+			case agar.ContainerFLAC:
+				// The damage leaves no STREAMINFO to check, or lies about the sample count on purpose.
+				if fixture.Name == "FLACMissingStreamInfo" {
+					if string(file[:4]) != "fLaC" || file[4]&0x7F == 0 {
+						t.Errorf("unexpected metadata block % X", file[:5])
+					}
+
+					return
+				}
+
+				parsed = flacNativeFormat(t, file)
+				if fixture.Name == "FLACWrongTotalSamples" {
+					parsed.duration = fixture.Duration
+				}
+			case agar.ContainerRF64:
+				parsed = largeWAVNativeFormat(t, rf64Chunks(t, file))
+			case agar.ContainerW64:
+				// The riff size of an oversized file is declared too.
+				parsed = largeWAVNativeFormat(t, w64Chunks(t, file, binary.LittleEndian.Uint64(file[16:])))
+			default:
+				t.Fatalf("unexpected container %q", fixture.Container)
+			}
+
+			expected := nativeFormat{
+				sampleRate: fixture.SampleRate,
+				bitDepth:   fixture.BitDepth,
+				channels:   fixture.Channels,
+				duration:   fixture.Duration,
+				oversized:  fixture.Oversized,
+			}
+			if parsed != expected {
+				t.Errorf("headers describe %+v, catalog documents %+v", parsed, expected)
+			}
+		})
+	}
+}
+
+// flacNativeFormat parses the STREAMINFO block opening a FLAC stream.
+func flacNativeFormat(t *testing.T, file []byte) nativeFormat {
+	t.Helper()
+
+	if string(file[:4]) != "fLaC" || file[4]&0x7F != 0 {
+		t.Fatalf("no leading STREAMINFO block: % X", file[:5])
+	}
+
+	// Sample rate (20 bits), channels - 1 (3 bits), bits per sample - 1 (5 bits), total samples (36 bits).
+	packed := binary.BigEndian.Uint64(file[8+10:])
+	rate := int(packed >> 44)
+
+	return nativeFormat{
+		sampleRate: rate,
+		bitDepth:   int(packed>>36&0x1F) + 1,
+		channels:   int(packed>>41&0x07) + 1,
+		duration:   time.Duration(packed&(1<<36-1)) * time.Second / time.Duration(rate),
+	}
+}
+
+// largeWAVNativeFormat parses the fmt and data chunks of an RF64 or Wave64 file, the data chunk size
+// declared in the ds64 chunk taking precedence.
+func largeWAVNativeFormat(t *testing.T, chunks []largeWAVChunk) nativeFormat {
+	t.Helper()
+
+	var (
+		parsed   nativeFormat
+		data     *largeWAVChunk
+		declared uint64
+	)
+
+	for idx, chunk := range chunks {
+		switch chunk.id {
+		case "ds64":
+			declared = binary.LittleEndian.Uint64(chunk.body[8:])
+		case "fmt ":
+			parsed.channels = int(binary.LittleEndian.Uint16(chunk.body[2:]))
+			parsed.sampleRate = int(binary.LittleEndian.Uint32(chunk.body[4:]))
+			parsed.bitDepth = int(binary.LittleEndian.Uint16(chunk.body[14:]))
+		case "data":
+			data = &chunks[idx]
+		}
+	}
+
+	if data == nil || parsed.sampleRate == 0 {
+		t.Fatalf("missing fmt or data chunk in %+v", chunks)
+	}
+
+	if declared == 0 {
+		declared = data.size
+	}
+
+	frameSize := parsed.channels * parsed.bitDepth / 8
+	parsed.duration = time.Duration(len(data.body)/frameSize) * time.Second / time.Duration(parsed.sampleRate)
+	parsed.oversized = declared > 2*uint64(len(data.body))
+
+	return parsed
+}
@@ -0,0 +1,253 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tag chunk identifiers of RIFF/WAVE and AIFF files.
+const (
+	wavID3Chunk   = "id3 "
+	aiffID3Chunk  = "ID3 "
+	wavListChunk  = "LIST"
+	wavInfoList   = "INFO"
+	infoSeparator = "; "
+)
+
+// ErrChunkMalformed is returned when the chunks of a RIFF/WAVE or AIFF file cannot be parsed.
+var ErrChunkMalformed = errors.New("malformed RIFF/IFF chunk structure")
+
+// wavInfoFields maps semantic names to RIFF LIST/INFO chunk IDs, in writing order.
+//
+//nolint:gochecknoglobals // lookup table
+var wavInfoFields = []struct{ semantic, id string }{
+	{"title", "INAM"},
+	{"artist", "IART"},
+	{"album", "IPRD"},
+	{"date", "ICRD"},
+	{"genre", "IGNR"},
+	{"comment", "ICMT"},
+	{"copyright", "ICOP"},
+	{"encoder", "ISFT"},
+}
+
+// aiffTextFields maps semantic names to AIFF text chunk IDs, in writing order.
+//
+//nolint:gochecknoglobals // lookup table
+var aiffTextFields = []struct{ semantic, id string }{
+	{"title", "NAME"},
+	{"artist", "AUTH"},
+	{"copyright", "(c) "},
+	{"comment", "ANNO"},
+}
+
+// iffChunk is a chunk of a RIFF or IFF file, pad byte excluded.
+type iffChunk struct {
+	id   string
+	data []byte
+}
+
+// parseIFFChunks splits the body of a RIFF or FORM container (after its form type) into chunks.
+func parseIFFChunks(body []byte, order binary.ByteOrder) ([]iffChunk, error) {
+	var chunks []iffChunk
+
+	for len(body) > 0 {
+		if len(body) < chunkHeaderSize {
+			return nil, fmt.Errorf("%w: truncated chunk header", ErrChunkMalformed)
+		}
+
+		size := int(order.Uint32(body[4:]))
+		if size > len(body)-chunkHeaderSize {
+			return nil, fmt.Errorf("%w: chunk %q overruns the file", ErrChunkMalformed, body[:4])
+		}
+
+		chunks = append(chunks, iffChunk{id: string(body[:4]), data: body[chunkHeaderSize : chunkHeaderSize+size]})
+		body = body[min(chunkHeaderSize+size+size%2, len(body)):]
+	}
+
+	return chunks, nil
+}
+
+// replaceWAVTags replaces the LIST/INFO and id3 chunks of a RIFF/WAVE file with tags, written
+// after the existing chunks.
+func replaceWAVTags(content []byte, tags Tags) ([]byte, error) {
+	if len(content) < riffHeaderSize || string(content[:4]) != "RIFF" || string(content[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%w: not a RIFF/WAVE file", ErrChunkMalformed)
+	}
+
+	chunks, err := parseIFFChunks(content[riffHeaderSize:], binary.LittleEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	id3Tag, err := encodeTagsID3(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString("RIFF")
+	writeLE32(&buf, 0) // patched below
+	buf.WriteString("WAVE")
+
+	for _, chunk := range chunks {
+		isInfo := chunk.id == wavListChunk && len(chunk.data) >= 4 && string(chunk.data[:4]) == wavInfoList
+		if !isInfo && !strings.EqualFold(chunk.id, wavID3Chunk) {
+			writeRIFFChunk(&buf, chunk.id, chunk.data)
+		}
+	}
+
+	var info bytes.Buffer
+
+	for _, field := range wavInfoFields {
+		if values := tags.Text[field.semantic]; len(values) > 0 {
+			writeRIFFChunk(&info, field.id, append([]byte(strings.Join(values, infoSeparator)), 0))
+		}
+	}
+
+	if tags.Track > 0 {
+		writeRIFFChunk(&info, "ITRK", append([]byte(strconv.Itoa(tags.Track)), 0))
+	}
+
+	if info.Len() > 0 {
+		writeRIFFChunk(&buf, wavListChunk, append([]byte(wavInfoList), info.Bytes()...))
+	}
+
+	writeRIFFChunk(&buf, wavID3Chunk, id3Tag)
+
+	out := buf.Bytes()
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-chunkHeaderSize)) //nolint:gosec // G115: RIFF is 32-bit.
+
+	return out, nil
+}
+
+// replaceAIFFTags replaces the text and ID3 chunks of an AIFF or AIFF-C file with tags, written
+// after the existing chunks.
+func replaceAIFFTags(content []byte, tags Tags) ([]byte, error) {
+	if len(content) < riffHeaderSize || string(content[:4]) != "FORM" ||
+		(string(content[8:12]) != "AIFF" && string(content[8:12]) != "AIFC") {
+		return nil, fmt.Errorf("%w: not an AIFF file", ErrChunkMalformed)
+	}
+
+	chunks, err := parseIFFChunks(content[riffHeaderSize:], binary.BigEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	id3Tag, err := encodeTagsID3(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	replaced := make(map[string]bool, len(aiffTextFields))
+	for _, field := range aiffTextFields {
+		replaced[field.id] = true
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString("FORM")
+	writeBE32(&buf, 0) // patched below
+	buf.Write(content[8:12])
+
+	for _, chunk := range chunks {
+		if !replaced[chunk.id] && !strings.EqualFold(chunk.id, aiffID3Chunk) {
+			writeIFFChunk(&buf, chunk.id, chunk.data)
+		}
+	}
+
+	for _, field := range aiffTextFields {
+		if values := tags.Text[field.semantic]; len(values) > 0 {
+			writeIFFChunk(&buf, field.id, []byte(strings.Join(values, infoSeparator)))
+		}
+	}
+
+	writeIFFChunk(&buf, aiffID3Chunk, id3Tag)
+
+	out := buf.Bytes()
+	binary.BigEndian.PutUint32(out[4:], uint32(len(out)-chunkHeaderSize)) //nolint:gosec // G115: IFF is 32-bit.
+
+	return out, nil
+}
+
+// parseChunkTags reads the tags of a RIFF/WAVE or AIFF file: its ID3 chunk when present, otherwise
+// its LIST/INFO or AIFF text chunks.
+func parseChunkTags(content []byte) (*ParsedTags, error) {
+	if len(content) < riffHeaderSize {
+		return nil, fmt.Errorf("%w: truncated header", ErrChunkMalformed)
+	}
+
+	var (
+		order  binary.ByteOrder = binary.LittleEndian
+		fields                  = wavInfoFields
+	)
+
+	if string(content[:4]) == "FORM" {
+		order, fields = binary.BigEndian, aiffTextFields
+	}
+
+	chunks, err := parseIFFChunks(content[riffHeaderSize:], order)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chunk := range chunks {
+		if strings.EqualFold(chunk.id, wavID3Chunk) {
+			return parseID3(chunk.data)
+		}
+	}
+
+	// Without an ID3 chunk, RIFF text sits in a LIST/INFO chunk and AIFF text in top-level chunks.
+	for _, chunk := range chunks {
+		if chunk.id == wavListChunk && len(chunk.data) >= 4 && string(chunk.data[:4]) == wavInfoList {
+			chunks, err = parseIFFChunks(chunk.data[4:], order)
+			if err != nil {
+				return nil, err
+			}
+
+			break
+		}
+	}
+
+	tags := NewParsedTags()
+
+	for _, chunk := range chunks {
+		value := string(bytes.TrimRight(chunk.data, "\x00"))
+
+		if chunk.id == "ITRK" {
+			tags.Track, tags.TrackTotal = parsePairValue(value)
+			tags.Text["tracknumber"] = append(tags.Text["tracknumber"], value)
+
+			continue
+		}
+
+		for _, field := range fields {
+			if field.id == chunk.id {
+				tags.Text[field.semantic] = append(tags.Text[field.semantic], value)
+			}
+		}
+	}
+
+	return tags, nil
+}
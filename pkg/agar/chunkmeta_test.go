@@ -0,0 +1,66 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+func TestWriteTagsReplacesAIFFID3ChunkAnyCase(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 44100, BitDepth: 16, Channels: 1}
+
+	pcm, err := agar.RenderPCM(format, 10*time.Millisecond, agar.Sine{Frequency: 1000, Level: -6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aiff, err := agar.EncodeAIFF(pcm, format, agar.AIFFOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A lowercase "id3 " chunk, as written by some taggers.
+	aiff = append(aiff, "id3 \x00\x00\x00\x04ID3\x00"...)
+	binary.BigEndian.PutUint32(aiff[4:], uint32(len(aiff)-8))
+
+	path := filepath.Join(t.TempDir(), "tagged.aiff")
+	if err = os.WriteFile(path, aiff, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = agar.WriteTags(path, agar.DefaultTags()); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := bytes.Count(bytes.ToLower(content), []byte("id3 ")); count != 1 {
+		t.Errorf("%d ID3 chunks, expected 1", count)
+	}
+}
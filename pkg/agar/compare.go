@@ -0,0 +1,304 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// Codec frame sizes and priming, in samples per channel.
+const (
+	mp3FrameSamples    = 1152
+	aacFrameSamples    = 1024
+	heAACFrameSamples  = 2048
+	opusPreSkipSamples = 312
+	vorbisLongBlock    = 2048
+
+	// lossyMaxLSB is the per-sample difference, in 16-bit LSB, that floating-point decoders commonly disagree by.
+	lossyMaxLSB = 2
+	// lossyOutlierRatio is the fraction of samples allowed to differ by more (codec edge cases).
+	lossyOutlierRatio = 0.01
+	// percentFactor converts a ratio to a percentage.
+	percentFactor = 100
+
+	// floatPrecision is the mantissa precision of 32-bit float samples, implicit bit included, used as
+	// the resolution of every float format.
+	floatPrecision = 24
+)
+
+// ErrPCMMismatch is returned when compared PCM exceeds a tolerance.
+var ErrPCMMismatch = errors.New("PCM mismatch")
+
+// PCMTolerance bounds the differences accepted between two decodes of the same audio.
+// Sample errors are in full-scale units (1.0 is full scale); see LSB.
+type PCMTolerance struct {
+	Name string
+	// MaxSampleError is the largest per-sample difference not counted as an outlier.
+	MaxSampleError float64
+	// MaxOutlierRatio is the fraction of samples allowed to exceed MaxSampleError.
+	MaxOutlierRatio float64
+	// MaxLengthDiff is the accepted length difference, in frames.
+	MaxLengthDiff int
+	// MinSNR is the lowest accepted signal-to-noise ratio in dB. Zero disables the check.
+	MinSNR float64
+}
+
+// Tolerance presets. Lossy presets accept +/-2 LSB at 16 bits on 99% of samples, and a length difference
+// of one codec frame or priming delay, which decoders handle differently.
+//
+//nolint:gochecknoglobals // presets
+var (
+	// ToleranceExact requires identical samples and lengths.
+	ToleranceExact = PCMTolerance{Name: "exact"}
+	// ToleranceMP3 allows one MP3 frame (1152 samples) of length difference.
+	ToleranceMP3 = lossyTolerance("mp3", mp3FrameSamples)
+	// ToleranceAAC allows one AAC-LC frame (1024 samples) of length difference.
+	ToleranceAAC = lossyTolerance("aac", aacFrameSamples)
+	// ToleranceHEAAC allows one HE-AAC frame (2048 samples) of length difference.
+	ToleranceHEAAC = lossyTolerance("he-aac", heAACFrameSamples)
+	// ToleranceOpus allows the usual Opus pre-skip (312 samples at 48 kHz) of length difference.
+	ToleranceOpus = lossyTolerance("opus", opusPreSkipSamples)
+	// ToleranceVorbis allows one long Vorbis block (2048 samples) of length difference.
+	ToleranceVorbis = lossyTolerance("vorbis", vorbisLongBlock)
+)
+
+// LSB returns the value of one least significant bit at bitDepth, in full-scale units.
+func LSB(bitDepth int) float64 {
+	return math.Ldexp(1, 1-bitDepth)
+}
+
+// ChannelError holds the error measurements of one channel.
+type ChannelError struct {
+	MaxAbsError float64
+	RMSError    float64
+	// SNR is the power of the expected signal over the power of the error in dB, +Inf when identical.
+	SNR float64
+}
+
+// PCMComparison holds the differences between expected and actual PCM over their common length.
+type PCMComparison struct {
+	Format Format
+	// Frames is the number of compared frames.
+	Frames int
+	// LengthDiff is the actual length minus the expected length, in frames.
+	LengthDiff int
+	ChannelError
+	// Channels holds the measurements of each channel.
+	Channels []ChannelError
+	// Outliers counts samples exceeding the MaxSampleError of the tolerance last checked.
+	Outliers int
+	// Histogram counts sample errors by magnitude in LSB of the format: index 0 counts exact matches,
+	// index n errors of n significant bits (1 LSB, 2-3 LSB, 4-7 LSB...). Float formats use the LSB of
+	// a 32-bit float mantissa (24 bits).
+	Histogram []int
+
+	errors [][]float64
+}
+
+// ComparePCM measures the differences between expected and actual interleaved PCM in format.
+func ComparePCM(expected, actual []byte, format Format) (*PCMComparison, error) {
+	expectedChannels, err := pcmChannels(expected, format)
+	if err != nil {
+		return nil, fmt.Errorf("expected: %w", err)
+	}
+
+	actualChannels, err := pcmChannels(actual, format)
+	if err != nil {
+		return nil, fmt.Errorf("actual: %w", err)
+	}
+
+	return comparePCMChannels(expectedChannels, actualChannels, format), nil
+}
+
+// comparePCMChannels compares de-interleaved channels over their common length.
+func comparePCMChannels(expected, actual [][]float64, format Format) *PCMComparison {
+	precision := pcmPrecision(format)
+	lsb := LSB(precision)
+
+	comparison := &PCMComparison{
+		Format:     format,
+		Frames:     min(len(expected[0]), len(actual[0])),
+		LengthDiff: len(actual[0]) - len(expected[0]),
+		Channels:   make([]ChannelError, len(expected)),
+		Histogram:  make([]int, precision+2), // exact matches up to errors beyond full scale
+		errors:     make([][]float64, len(expected)),
+	}
+
+	var totalSignal, totalError float64
+
+	for ch := range expected {
+		var signal, power float64
+
+		channelErrors := make([]float64, comparison.Frames)
+
+		for idx := range comparison.Frames {
+			diff := math.Abs(actual[ch][idx] - expected[ch][idx])
+			channelErrors[idx] = diff
+			signal += expected[ch][idx] * expected[ch][idx]
+			power += diff * diff
+
+			comparison.Channels[ch].MaxAbsError = max(comparison.Channels[ch].MaxAbsError, diff)
+			comparison.Histogram[min(bits.Len64(uint64(math.Round(diff/lsb))), len(comparison.Histogram)-1)]++
+		}
+
+		comparison.errors[ch] = channelErrors
+		comparison.Channels[ch].RMSError = math.Sqrt(power / float64(max(1, comparison.Frames)))
+		comparison.Channels[ch].SNR = signalToNoise(signal, power)
+		comparison.MaxAbsError = max(comparison.MaxAbsError, comparison.Channels[ch].MaxAbsError)
+
+		totalSignal += signal
+		totalError += power
+	}
+
+	comparison.RMSError = math.Sqrt(totalError / float64(max(1, comparison.Frames*len(expected))))
+	comparison.SNR = signalToNoise(totalSignal, totalError)
+
+	return comparison
+}
+
+// Check counts the outliers of the comparison under tolerance, and returns an ErrPCMMismatch error
+// describing every exceeded bound.
+func (c *PCMComparison) Check(tolerance PCMTolerance) error {
+	c.Outliers = 0
+
+	for _, channelErrors := range c.errors {
+		for _, diff := range channelErrors {
+			if diff > tolerance.MaxSampleError {
+				c.Outliers++
+			}
+		}
+	}
+
+	var problems []string
+
+	if lengthDiff := max(c.LengthDiff, -c.LengthDiff); lengthDiff > tolerance.MaxLengthDiff {
+		problems = append(problems, fmt.Sprintf("length differs by %d frames (tolerance %d)",
+			lengthDiff, tolerance.MaxLengthDiff))
+	}
+
+	samples := c.Frames * len(c.Channels)
+	if float64(c.Outliers) > tolerance.MaxOutlierRatio*float64(samples) {
+		problems = append(problems, fmt.Sprintf("%d samples (%.2f%%) differ by more than %g (tolerance %.2f%%)",
+			c.Outliers, percent(c.Outliers, samples), tolerance.MaxSampleError,
+			tolerance.MaxOutlierRatio*percentFactor))
+	}
+
+	if tolerance.MinSNR != 0 && c.SNR < tolerance.MinSNR {
+		problems = append(problems, fmt.Sprintf("SNR %.2f dB (minimum %.2f dB)", c.SNR, tolerance.MinSNR))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrPCMMismatch, tolerance.Name, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// String summarizes the comparison: length, error statistics per channel and error distribution.
+func (c *PCMComparison) String() string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "%d frames compared, length diff %+d frames, SNR %.2f dB, max error %g, RMS error %g\n",
+		c.Frames, c.LengthDiff, c.SNR, c.MaxAbsError, c.RMSError)
+
+	for ch, channel := range c.Channels {
+		fmt.Fprintf(&builder, "  channel %d: SNR %.2f dB, max error %g, RMS error %g\n",
+			ch, channel.SNR, channel.MaxAbsError, channel.RMSError)
+	}
+
+	builder.WriteString("  error distribution (LSB):")
+
+	for bucket, count := range c.Histogram {
+		if count > 0 {
+			builder.WriteString(" " + histogramLabel(bucket) + ":" + strconv.Itoa(count))
+		}
+	}
+
+	return builder.String()
+}
+
+// AssertPCMWithin compares expected and actual PCM and fails the test, logging the comparison, when
+// they differ beyond tolerance. The label identifies the comparison (e.g. "saprobe vs ffmpeg").
+func AssertPCMWithin(t tig.T, label string, expected, actual []byte, format Format, tolerance PCMTolerance) {
+	t.Helper()
+
+	comparison, err := ComparePCM(expected, actual, format)
+	if err == nil {
+		err = comparison.Check(tolerance)
+	}
+
+	if err != nil {
+		t.Log(label + ": " + err.Error())
+
+		if comparison != nil {
+			t.Log(comparison.String())
+		}
+
+		t.Fail()
+	}
+}
+
+// lossyTolerance returns the default lossy tolerance with a length tolerance of frameSamples.
+func lossyTolerance(name string, frameSamples int) PCMTolerance {
+	return PCMTolerance{
+		Name:            name,
+		MaxSampleError:  lossyMaxLSB * LSB(BitDepth16),
+		MaxOutlierRatio: lossyOutlierRatio,
+		MaxLengthDiff:   frameSamples,
+	}
+}
+
+// pcmPrecision returns the resolution in bits the errors of format are histogrammed at.
+func pcmPrecision(format Format) int {
+	if format.Float {
+		return floatPrecision
+	}
+
+	return format.BitDepth
+}
+
+// histogramLabel renders the LSB range of a histogram bucket.
+func histogramLabel(bucket int) string {
+	if bucket <= 1 {
+		return strconv.Itoa(bucket)
+	}
+
+	return strconv.Itoa(1<<(bucket-1)) + "-" + strconv.Itoa(1<<bucket-1)
+}
+
+func signalToNoise(signal, noise float64) float64 {
+	if noise == 0 {
+		return math.Inf(1)
+	}
+
+	return powerToDB(signal / noise)
+}
+
+func percent(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(count) / float64(total) * percentFactor
+}
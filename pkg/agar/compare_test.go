@@ -0,0 +1,188 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// compareFormat is 16-bit mono, so that one LSB is one integer step of every sample.
+//
+//nolint:gochecknoglobals // shared test format.
+var compareFormat = agar.Format{SampleRate: 48000, BitDepth: agar.BitDepth16, Channels: 1}
+
+func compareSine(t *testing.T) []byte {
+	t.Helper()
+
+	pcm, err := agar.RenderPCM(compareFormat, time.Second, agar.Sine{Frequency: 1000, Level: -6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pcm
+}
+
+// offsetSamples returns a copy of 16-bit pcm with delta LSB added to one sample in every.
+func offsetSamples(pcm []byte, delta int16, every int) []byte {
+	out := slices.Clone(pcm)
+
+	for idx := 0; idx < len(out); idx += 2 * every {
+		sample := int16(binary.LittleEndian.Uint16(out[idx:]))         //nolint:gosec // G115: two's complement.
+		binary.LittleEndian.PutUint16(out[idx:], uint16(sample+delta)) //nolint:gosec // G115: two's complement.
+	}
+
+	return out
+}
+
+func TestComparePCMIdentical(t *testing.T) {
+	t.Parallel()
+
+	pcm := compareSine(t)
+
+	comparison, err := agar.ComparePCM(pcm, pcm, compareFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !math.IsInf(comparison.SNR, 1) || comparison.MaxAbsError != 0 || comparison.Histogram[0] != 48000 {
+		t.Errorf("identical PCM: %s", comparison)
+	}
+
+	if err = comparison.Check(agar.ToleranceExact); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestComparePCMSNR(t *testing.T) {
+	t.Parallel()
+
+	pcm := compareSine(t)
+
+	// One LSB on every sample: the noise power is LSB^2 and the signal power that of a -6 dBFS sine.
+	comparison, err := agar.ComparePCM(pcm, offsetSamples(pcm, 1, 1), compareFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lsb := agar.LSB(agar.BitDepth16)
+	expected := 10 * math.Log10(math.Pow(agar.DBToAmplitude(-6), 2)/2/(lsb*lsb))
+
+	if math.Abs(comparison.SNR-expected) > 0.05 || comparison.Channels[0].SNR != comparison.SNR {
+		t.Errorf("SNR %.2f dB, expected %.2f dB", comparison.SNR, expected)
+	}
+
+	if comparison.MaxAbsError != lsb || comparison.RMSError != lsb || comparison.Histogram[1] != 48000 {
+		t.Errorf("one LSB error: %s", comparison)
+	}
+
+	for _, tc := range []struct {
+		minSNR float64
+		ok     bool
+	}{
+		{0, true},
+		{math.Floor(expected), true},
+		{math.Ceil(expected), false},
+	} {
+		tolerance := agar.PCMTolerance{Name: "snr", MaxSampleError: lsb, MinSNR: tc.minSNR}
+
+		err := comparison.Check(tolerance)
+		if (err == nil) != tc.ok || (err != nil && !strings.Contains(err.Error(), "SNR")) {
+			t.Errorf("minimum SNR %.0f dB: got %v", tc.minSNR, err)
+		}
+	}
+}
+
+func TestComparePCMPresets(t *testing.T) {
+	t.Parallel()
+
+	pcm := compareSine(t)
+	frameSize := compareFormat.FrameSize()
+
+	for _, tc := range []struct {
+		tolerance   agar.PCMTolerance
+		frames      int
+		exactPasses bool
+	}{
+		{agar.ToleranceExact, 0, true},
+		{agar.ToleranceMP3, 1152, false},
+		{agar.ToleranceAAC, 1024, false},
+		{agar.ToleranceHEAAC, 2048, false},
+		{agar.ToleranceOpus, 312, false},
+		{agar.ToleranceVorbis, 2048, false},
+	} {
+		for _, check := range []struct {
+			name   string
+			actual []byte
+			ok     bool
+		}{
+			{"identical", pcm, true},
+			{"2 LSB everywhere", offsetSamples(pcm, -2, 1), !tc.exactPasses},
+			{"3 LSB on 1% of samples", offsetSamples(pcm, 3, 100), !tc.exactPasses},
+			{"3 LSB on 2% of samples", offsetSamples(pcm, 3, 50), false},
+			{"longer by the frame size", append(slices.Clone(pcm), make([]byte, tc.frames*frameSize)...), true},
+			{"longer than the frame size", append(slices.Clone(pcm), make([]byte, (tc.frames+1)*frameSize)...), false},
+			{"shorter than the frame size", pcm[:len(pcm)-(tc.frames+1)*frameSize], false},
+		} {
+			comparison, err := agar.ComparePCM(pcm, check.actual, compareFormat)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = comparison.Check(tc.tolerance)
+			if (err == nil) != check.ok || (err != nil && !errors.Is(err, agar.ErrPCMMismatch)) {
+				t.Errorf("%s, %s: got %v", tc.tolerance.Name, check.name, err)
+			}
+		}
+	}
+}
+
+func TestAssertPCMWithin(t *testing.T) {
+	t.Parallel()
+
+	pcm := compareSine(t)
+
+	passed := recordAssertion(t, func(recorder *recordingT) {
+		agar.AssertPCMWithin(recorder, "same", pcm, offsetSamples(pcm, 1, 1), compareFormat, agar.ToleranceMP3)
+	})
+	if passed.failed || len(passed.logs) != 0 {
+		t.Errorf("one LSB under the MP3 tolerance: failed %v, logged %q", passed.failed, passed.logs)
+	}
+
+	failed := recordAssertion(t, func(recorder *recordingT) {
+		agar.AssertPCMWithin(recorder, "decoder vs reference", pcm, offsetSamples(pcm, 1, 1), compareFormat,
+			agar.ToleranceExact)
+	})
+	if !failed.failed || len(failed.logs) != 2 || !strings.HasPrefix(failed.logs[0], "decoder vs reference: ") ||
+		!strings.Contains(failed.logs[1], "error distribution") {
+		t.Errorf("one LSB under the exact tolerance: failed %v, logged %q", failed.failed, failed.logs)
+	}
+
+	malformed := recordAssertion(t, func(recorder *recordingT) {
+		agar.AssertPCMWithin(recorder, "odd", pcm, pcm[1:], compareFormat, agar.ToleranceExact)
+	})
+	if !malformed.failed || len(malformed.logs) != 1 {
+		t.Errorf("partial frame: failed %v, logged %q", malformed.failed, malformed.logs)
+	}
+}
@@ -0,0 +1,503 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+
+	"github.com/mycophonic/primordium/filesystem"
+)
+
+const (
+	// mutationLogSuffix is appended to a corrupted file's path to name its mutation log.
+	mutationLogSuffix = ".mutations.json"
+	// maxGarbageLength bounds InsertGarbage, far above any fixture size.
+	maxGarbageLength = 1 << 30
+)
+
+// Errors returned by Corrupt and the mutations.
+var (
+	ErrMutationRange  = errors.New("mutation range outside of file")
+	ErrMutationKind   = errors.New("unknown mutation kind")
+	ErrMutationSource = errors.New("file does not match the mutation log source")
+)
+
+// Mutation is a reproducible byte-level change applied by Corrupt.
+// Implementations are plain values so that they can be recorded in and replayed from a mutation log.
+type Mutation interface {
+	// Kind is the stable name identifying the mutation in logs.
+	Kind() string
+	// Apply returns content with the mutation applied. It may modify content in place.
+	Apply(content []byte) ([]byte, error)
+}
+
+// pinnedMutation is implemented by mutations reading external files, which must record the hash of
+// those files in the mutation log.
+type pinnedMutation interface {
+	pin() (Mutation, error)
+}
+
+// TruncateAt cuts the file at Offset bytes.
+type TruncateAt struct {
+	Offset int64 `json:"offset"`
+}
+
+// TruncatePercent keeps the first Percent (0-100) of the file.
+type TruncatePercent struct {
+	Percent float64 `json:"percent"`
+}
+
+// FlipBits inverts Count distinct bits chosen by a PRNG seeded with Seed, within the
+// Length bytes starting at Offset (zero Length extends to the end of the file).
+type FlipBits struct {
+	Count  int    `json:"count"`
+	Seed   uint64 `json:"seed"`
+	Offset int64  `json:"offset,omitempty"`
+	Length int64  `json:"length,omitempty"`
+}
+
+// ZeroRange overwrites Length bytes starting at Offset with zeros.
+type ZeroRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// InsertGarbage inserts Length pseudo-random bytes generated from Seed at Offset. Length is at most 1 GiB.
+type InsertGarbage struct {
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Seed   uint64 `json:"seed"`
+}
+
+// DuplicateRange repeats the Length bytes starting at Offset right after themselves.
+type DuplicateRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// DropRange removes Length bytes starting at Offset.
+type DropRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// Splice keeps the file up to Offset and continues it with the file at Path from OtherOffset on,
+// e.g. to graft the frames of one stream onto the headers of another. SHA256 pins the content of the
+// file at Path: Corrupt records it when empty, and Apply fails with ErrMutationSource when the file
+// no longer matches it.
+type Splice struct {
+	Path        string `json:"path"`
+	SHA256      string `json:"sha256,omitempty"`
+	Offset      int64  `json:"offset"`
+	OtherOffset int64  `json:"other_offset"`
+}
+
+// MutationRecord is a mutation as stored in a mutation log.
+type MutationRecord struct {
+	Kind   string          `json:"kind"`
+	Params json.RawMessage `json:"params"`
+}
+
+// MutationLog records how a corrupted file was derived from its source, for exact replay.
+type MutationLog struct {
+	// SourceSHA256 is the hash of the file before the first mutation.
+	SourceSHA256 string `json:"source_sha256"`
+	// ResultSHA256 is the hash of the file after the last mutation.
+	ResultSHA256 string           `json:"result_sha256"`
+	Mutations    []MutationRecord `json:"mutations"`
+}
+
+// Kind implements Mutation.
+func (TruncateAt) Kind() string { return "truncate" }
+
+// Apply implements Mutation.
+func (mut TruncateAt) Apply(content []byte) ([]byte, error) {
+	if err := checkRange(content, mut.Offset, 0); err != nil {
+		return nil, err
+	}
+
+	return content[:mut.Offset], nil
+}
+
+// Kind implements Mutation.
+func (TruncatePercent) Kind() string { return "truncate-percent" }
+
+// Apply implements Mutation.
+func (mut TruncatePercent) Apply(content []byte) ([]byte, error) {
+	const full = 100
+
+	if mut.Percent < 0 || mut.Percent > full {
+		return nil, fmt.Errorf("%w: %g%%", ErrMutationRange, mut.Percent)
+	}
+
+	return content[:int(float64(len(content))*mut.Percent/full)], nil
+}
+
+// Kind implements Mutation.
+func (FlipBits) Kind() string { return "flip-bits" }
+
+// Apply implements Mutation.
+func (mut FlipBits) Apply(content []byte) ([]byte, error) {
+	length := rangeLength(content, mut.Offset, mut.Length)
+	if err := checkRange(content, mut.Offset, length); err != nil {
+		return nil, err
+	}
+
+	available := uint64(length) * bitsPerByte //nolint:gosec // G115: positive.
+	if mut.Count < 0 || uint64(mut.Count) > available {
+		return nil, fmt.Errorf("%w: cannot flip %d bits in %d bytes", ErrMutationRange, mut.Count, length)
+	}
+
+	rng := newXorshift(mut.Seed)
+	flipped := make(map[uint64]bool, mut.Count)
+
+	for len(flipped) < mut.Count {
+		bit := rng.next() % available
+		if flipped[bit] {
+			continue
+		}
+
+		flipped[bit] = true
+		content[mut.Offset+int64(bit/bitsPerByte)] ^= 0x80 >> (bit % bitsPerByte) //nolint:gosec // G115: in range.
+	}
+
+	return content, nil
+}
+
+// Kind implements Mutation.
+func (ZeroRange) Kind() string { return "zero-range" }
+
+// Apply implements Mutation.
+func (mut ZeroRange) Apply(content []byte) ([]byte, error) {
+	if err := checkRange(content, mut.Offset, mut.Length); err != nil {
+		return nil, err
+	}
+
+	clear(content[mut.Offset : mut.Offset+mut.Length])
+
+	return content, nil
+}
+
+// Kind implements Mutation.
+func (InsertGarbage) Kind() string { return "insert-garbage" }
+
+// Apply implements Mutation.
+func (mut InsertGarbage) Apply(content []byte) ([]byte, error) {
+	if err := checkRange(content, mut.Offset, 0); err != nil {
+		return nil, err
+	}
+
+	if mut.Length < 0 || mut.Length > maxGarbageLength {
+		return nil, fmt.Errorf("%w: cannot insert %d bytes", ErrMutationRange, mut.Length)
+	}
+
+	rng := newXorshift(mut.Seed)
+	garbage := make([]byte, mut.Length)
+
+	for idx := range garbage {
+		garbage[idx] = byte(rng.next())
+	}
+
+	return splice(content[:mut.Offset], garbage, content[mut.Offset:]), nil
+}
+
+// Kind implements Mutation.
+func (DuplicateRange) Kind() string { return "duplicate-range" }
+
+// Apply implements Mutation.
+func (mut DuplicateRange) Apply(content []byte) ([]byte, error) {
+	if err := checkRange(content, mut.Offset, mut.Length); err != nil {
+		return nil, err
+	}
+
+	end := mut.Offset + mut.Length
+
+	return splice(content[:end], content[mut.Offset:end], content[end:]), nil
+}
+
+// Kind implements Mutation.
+func (DropRange) Kind() string { return "drop-range" }
+
+// Apply implements Mutation.
+func (mut DropRange) Apply(content []byte) ([]byte, error) {
+	if err := checkRange(content, mut.Offset, mut.Length); err != nil {
+		return nil, err
+	}
+
+	return splice(content[:mut.Offset], content[mut.Offset+mut.Length:]), nil
+}
+
+// Kind implements Mutation.
+func (Splice) Kind() string { return "splice" }
+
+// Apply implements Mutation.
+func (mut Splice) Apply(content []byte) ([]byte, error) {
+	if err := checkRange(content, mut.Offset, 0); err != nil {
+		return nil, err
+	}
+
+	other, err := os.ReadFile(mut.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading splice source: %w", err)
+	}
+
+	if sum := sha256Hex(other); mut.SHA256 != "" && sum != mut.SHA256 {
+		return nil, fmt.Errorf("%w: splice source %s has sha256 %s, expected %s",
+			ErrMutationSource, mut.Path, sum, mut.SHA256)
+	}
+
+	if err := checkRange(other, mut.OtherOffset, 0); err != nil {
+		return nil, fmt.Errorf("splice source: %w", err)
+	}
+
+	return splice(content[:mut.Offset], other[mut.OtherOffset:]), nil
+}
+
+// pin records the hash of the splice source, so that the mutation log identifies it.
+func (mut Splice) pin() (Mutation, error) {
+	if mut.SHA256 == "" {
+		other, err := os.ReadFile(mut.Path)
+		if err != nil {
+			return nil, fmt.Errorf("reading splice source: %w", err)
+		}
+
+		mut.SHA256 = sha256Hex(other)
+	}
+
+	return mut, nil
+}
+
+// MutationLogPath returns the path of the mutation log Corrupt writes for path.
+func MutationLogPath(path string) string {
+	return path + mutationLogSuffix
+}
+
+// Corrupt applies mutations in order to the file at path, in place, and records them in the
+// mutation log at MutationLogPath(path). Corrupting an already corrupted file extends its log.
+func Corrupt(path string, mutations ...Mutation) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	log := MutationLog{SourceSHA256: sha256Hex(content)}
+
+	// Extend the existing log when it describes the current content.
+	if previous, err := ReadMutationLog(MutationLogPath(path)); err == nil && previous.ResultSHA256 == log.SourceSHA256 {
+		log = previous
+	}
+
+	for idx, mutation := range mutations {
+		if pinned, ok := mutation.(pinnedMutation); ok {
+			if mutation, err = pinned.pin(); err != nil {
+				return fmt.Errorf("mutation %d: %w", idx, err)
+			}
+		}
+
+		content, err = mutation.Apply(content)
+		if err != nil {
+			return fmt.Errorf("mutation %d (%s): %w", idx, mutation.Kind(), err)
+		}
+
+		params, err := json.Marshal(mutation)
+		if err != nil {
+			return fmt.Errorf("recording mutation %d (%s): %w", idx, mutation.Kind(), err)
+		}
+
+		log.Mutations = append(log.Mutations, MutationRecord{Kind: mutation.Kind(), Params: params})
+	}
+
+	log.ResultSHA256 = sha256Hex(content)
+
+	if err := os.WriteFile(path, content, filesystem.FilePermissionsPrivate); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	encoded, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding mutation log: %w", err)
+	}
+
+	if err := os.WriteFile(MutationLogPath(path), encoded, filesystem.FilePermissionsPrivate); err != nil {
+		return fmt.Errorf("writing mutation log: %w", err)
+	}
+
+	return nil
+}
+
+// ReadMutationLog reads a mutation log written by Corrupt.
+func ReadMutationLog(logPath string) (MutationLog, error) {
+	var log MutationLog
+
+	content, err := os.ReadFile(logPath) //nolint:gosec // G304: path is caller-provided test output.
+	if err != nil {
+		return log, fmt.Errorf("reading mutation log: %w", err)
+	}
+
+	if err := json.Unmarshal(content, &log); err != nil {
+		return log, fmt.Errorf("decoding mutation log %s: %w", logPath, err)
+	}
+
+	return log, nil
+}
+
+// Decode returns the recorded mutations, ready to be passed to Corrupt.
+func (log MutationLog) Decode() ([]Mutation, error) {
+	mutations := make([]Mutation, 0, len(log.Mutations))
+
+	for idx, record := range log.Mutations {
+		mutation, err := decodeMutation(record)
+		if err != nil {
+			return nil, fmt.Errorf("mutation %d: %w", idx, err)
+		}
+
+		mutations = append(mutations, mutation)
+	}
+
+	return mutations, nil
+}
+
+// ReplayCorruption applies the mutations recorded in logPath to path, which must be an intact copy
+// of the original source file. The replayed file gets its own mutation log.
+func ReplayCorruption(path, logPath string) error {
+	log, err := ReadMutationLog(logPath)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if sum := sha256Hex(content); sum != log.SourceSHA256 {
+		return fmt.Errorf("%w: %s has sha256 %s, expected %s", ErrMutationSource, path, sum, log.SourceSHA256)
+	}
+
+	mutations, err := log.Decode()
+	if err != nil {
+		return err
+	}
+
+	return Corrupt(path, mutations...)
+}
+
+// Corrupted copies source into the test temp directory, applies mutations to the copy and returns its path.
+// The mutation log path is logged so that failures can be replayed with ReplayCorruption.
+func Corrupted(data test.Data, helpers test.Helpers, source string, mutations ...Mutation) string {
+	helpers.T().Helper()
+
+	path := filepath.Join(data.Temp().Dir(), "corrupted-"+filepath.Base(source))
+
+	content, err := os.ReadFile(source) //nolint:gosec // G304: path is caller-provided test fixture.
+	if err == nil {
+		err = os.WriteFile(path, content, filesystem.FilePermissionsPrivate)
+	}
+
+	if err == nil {
+		err = Corrupt(path, mutations...)
+	}
+
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	helpers.T().Log("corrupted " + source + ", mutation log: " + MutationLogPath(path))
+
+	return path
+}
+
+func decodeMutation(record MutationRecord) (Mutation, error) {
+	switch record.Kind {
+	case TruncateAt{}.Kind():
+		return decodeParams[TruncateAt](record.Params)
+	case TruncatePercent{}.Kind():
+		return decodeParams[TruncatePercent](record.Params)
+	case FlipBits{}.Kind():
+		return decodeParams[FlipBits](record.Params)
+	case ZeroRange{}.Kind():
+		return decodeParams[ZeroRange](record.Params)
+	case InsertGarbage{}.Kind():
+		return decodeParams[InsertGarbage](record.Params)
+	case DuplicateRange{}.Kind():
+		return decodeParams[DuplicateRange](record.Params)
+	case DropRange{}.Kind():
+		return decodeParams[DropRange](record.Params)
+	case Splice{}.Kind():
+		return decodeParams[Splice](record.Params)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrMutationKind, record.Kind)
+	}
+}
+
+func decodeParams[T Mutation](params json.RawMessage) (Mutation, error) {
+	var mutation T
+	if err := json.Unmarshal(params, &mutation); err != nil {
+		return nil, fmt.Errorf("decoding %s parameters: %w", mutation.Kind(), err)
+	}
+
+	return mutation, nil
+}
+
+// checkRange verifies that the length bytes at offset lie within content.
+func checkRange(content []byte, offset, length int64) error {
+	// Compare against the remaining size: offset+length may overflow.
+	if offset < 0 || length < 0 || offset > int64(len(content)) || length > int64(len(content))-offset {
+		return fmt.Errorf("%w: %d bytes at offset %d, file is %d bytes", ErrMutationRange, length, offset, len(content))
+	}
+
+	return nil
+}
+
+// rangeLength resolves a zero length to the rest of the content.
+func rangeLength(content []byte, offset, length int64) int64 {
+	if length == 0 {
+		return max(int64(len(content))-offset, 0)
+	}
+
+	return length
+}
+
+// splice concatenates parts into a new slice.
+func splice(parts ...[]byte) []byte {
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+
+	out := make([]byte, 0, size)
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+
+	return out
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,157 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+func TestMutationRangeOverflow(t *testing.T) {
+	t.Parallel()
+
+	for _, mutation := range []agar.Mutation{
+		agar.ZeroRange{Offset: 8, Length: math.MaxInt64},
+		agar.DropRange{Offset: math.MaxInt64, Length: 1},
+		agar.DuplicateRange{Offset: 1, Length: math.MaxInt64 - 1},
+		agar.FlipBits{Count: 1, Offset: 4, Length: math.MaxInt64},
+		agar.InsertGarbage{Offset: 0, Length: math.MaxInt64},
+	} {
+		if _, err := mutation.Apply(make([]byte, 16)); !errors.Is(err, agar.ErrMutationRange) {
+			t.Errorf("%s %+v: got %v, expected ErrMutationRange", mutation.Kind(), mutation, err)
+		}
+	}
+}
+
+func TestSpliceRecordsSourceHash(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	target, source := filepath.Join(dir, "target.bin"), filepath.Join(dir, "source.bin")
+
+	for path, content := range map[string]string{target: "headers|frames", source: "other|payload"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := agar.Corrupt(target, agar.Splice{Path: source, Offset: 8, OtherOffset: 6}); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := agar.ReadMutationLog(agar.MutationLogPath(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutations, err := log.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	splice, ok := mutations[0].(agar.Splice)
+	if !ok || splice.SHA256 == "" {
+		t.Fatalf("recorded %+v, expected a splice with the source hash", mutations[0])
+	}
+
+	if err = os.WriteFile(source, []byte("changed|payload"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = splice.Apply([]byte("headers|frames")); !errors.Is(err, agar.ErrMutationSource) {
+		t.Errorf("replay on a changed source: got %v, expected ErrMutationSource", err)
+	}
+}
+
+// TestReplayCorruption records a corruption made of every mutation kind, over two Corrupt calls, and
+// checks that replaying its log on an intact copy reproduces the same bytes.
+func TestReplayCorruption(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	original, replayed, other := filepath.Join(dir, "original.bin"), filepath.Join(dir, "replayed.bin"),
+		filepath.Join(dir, "other.bin")
+
+	source := make([]byte, 4096)
+	for idx := range source {
+		source[idx] = byte(idx * 7)
+	}
+
+	for _, path := range []string{original, replayed, other} {
+		if err := os.WriteFile(path, source, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := agar.Corrupt(original,
+		agar.FlipBits{Count: 16, Seed: 3, Offset: 100, Length: 200},
+		agar.ZeroRange{Offset: 400, Length: 32},
+		agar.InsertGarbage{Offset: 1000, Length: 64, Seed: 5},
+		agar.DuplicateRange{Offset: 2000, Length: 100},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = agar.Corrupt(original,
+		agar.DropRange{Offset: 10, Length: 20},
+		agar.Splice{Path: other, Offset: 3000, OtherOffset: 500},
+		agar.TruncatePercent{Percent: 90},
+		agar.TruncateAt{Offset: 3500},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = agar.ReplayCorruption(replayed, agar.MutationLogPath(original)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := os.ReadFile(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(replayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("replay wrote %d bytes that differ from the %d recorded ones", len(got), len(expected))
+	}
+
+	log, err := agar.ReadMutationLog(agar.MutationLogPath(replayed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(log.Mutations) != 8 {
+		t.Errorf("replayed log holds %d mutations, expected 8", len(log.Mutations))
+	}
+
+	// The corrupted file is not the source the log describes.
+	if err = agar.ReplayCorruption(replayed, agar.MutationLogPath(original)); !errors.Is(err, agar.ErrMutationSource) {
+		t.Errorf("replay on a corrupted file: got %v, expected ErrMutationSource", err)
+	}
+}
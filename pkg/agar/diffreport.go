@@ -0,0 +1,481 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// Diff report defaults.
+const (
+	// DefaultDiffRegions is the number of regions reported when DiffOptions.MaxRegions is zero.
+	DefaultDiffRegions = 10
+	// DefaultDiffMergeGap is the gap merged into a region when DiffOptions.MergeGap is zero, in frames.
+	DefaultDiffMergeGap = 32
+
+	// signatureMatchRatio is the fraction of samples a transform must explain for a signature to be reported.
+	signatureMatchRatio = 0.99
+	// dumpSampleRate is the sample rate DumpWAV declares for PCM of unknown sample rate.
+	dumpSampleRate = 44100
+)
+
+// DiffSignatureKind identifies a common failure signature.
+type DiffSignatureKind string
+
+// Failure signatures detected by DiffPCM.
+const (
+	// SignatureChannelSwap means two channels of actual hold each other's samples.
+	SignatureChannelSwap DiffSignatureKind = "channel swap"
+	// SignatureSignInversion means actual holds the negated samples of expected.
+	SignatureSignInversion DiffSignatureKind = "sign inversion"
+	// SignatureSampleShift means actual is expected shifted by one frame.
+	SignatureSampleShift DiffSignatureKind = "off-by-one sample shift"
+	// SignatureByteSwap means actual holds the samples of expected in the opposite byte order.
+	SignatureByteSwap DiffSignatureKind = "byte-order swap"
+	// SignatureTruncatedTail means actual matches expected but stops early.
+	SignatureTruncatedTail DiffSignatureKind = "truncated tail"
+)
+
+// DiffOptions controls DiffPCM and AssertLosslessPCM.
+type DiffOptions struct {
+	// MaxFrames is the number of differing frames whose values are listed. Zero selects 5.
+	MaxFrames int
+	// MaxRegions is the number of regions listed. Zero selects DefaultDiffRegions.
+	MaxRegions int
+	// MergeGap is the largest run of matching frames merged into the surrounding region.
+	// Zero selects DefaultDiffMergeGap; a negative value only groups contiguous frames.
+	MergeGap int
+	// DumpWAV makes AssertLosslessPCM write both buffers as WAV files into the test temp dir on failure.
+	DumpWAV bool
+}
+
+// DiffRegion is a run of differing frames, separated from the next by more than the merge gap.
+type DiffRegion struct {
+	// StartFrame and EndFrame bound the region, end excluded.
+	StartFrame int
+	EndFrame   int
+	// Start and End are the timestamps of the bounds, zero when the sample rate is unknown.
+	Start time.Duration
+	End   time.Duration
+	// Samples counts the differing samples of the region.
+	Samples int
+	// MaxDiff is the largest difference, in LSB.
+	MaxDiff float64
+}
+
+// DiffSignature is a failure signature explaining the differences.
+type DiffSignature struct {
+	Kind DiffSignatureKind
+	// Detail qualifies the signature (channels swapped, shift direction, missing length).
+	Detail string
+	// Ratio is the fraction of compared samples the signature explains.
+	Ratio float64
+}
+
+// DiffFrame holds the values of a differing frame, per channel: integer sample values for integer
+// formats, full-scale values for float formats.
+type DiffFrame struct {
+	Frame    int
+	Expected []float64
+	Actual   []float64
+}
+
+// PCMDiff is a sample-accurate report of the differences between two PCM buffers.
+type PCMDiff struct {
+	Format Format
+	// ExpectedFrames and ActualFrames are the buffer lengths; the common length is compared.
+	ExpectedFrames int
+	ActualFrames   int
+	// DifferingSamples counts the differing samples over the common length.
+	DifferingSamples int
+	// Regions holds the first regions of differing frames, and RegionCount the number of regions.
+	Regions     []DiffRegion
+	RegionCount int
+	// Frames lists the values of the first differing frames.
+	Frames []DiffFrame
+	// Signatures lists the failure signatures found.
+	Signatures []DiffSignature
+	// Comparison holds the error statistics and the histogram of difference magnitudes.
+	Comparison *PCMComparison
+}
+
+// DiffPCM compares expected and actual interleaved PCM in format sample by sample.
+// A zero sample rate is accepted, in which case regions carry no timestamps.
+func DiffPCM(expected, actual []byte, format Format, opts DiffOptions) (*PCMDiff, error) {
+	layout := format
+	if layout.SampleRate == 0 {
+		layout.SampleRate = 1
+	}
+
+	expectedChannels, err := pcmChannels(expected, layout)
+	if err != nil {
+		return nil, fmt.Errorf("expected: %w", err)
+	}
+
+	actualChannels, err := pcmChannels(actual, layout)
+	if err != nil {
+		return nil, fmt.Errorf("actual: %w", err)
+	}
+
+	diff := &PCMDiff{
+		Format:         format,
+		ExpectedFrames: len(expectedChannels[0]),
+		ActualFrames:   len(actualChannels[0]),
+		Comparison:     comparePCMChannels(expectedChannels, actualChannels, format),
+	}
+
+	diff.collect(expectedChannels, actualChannels, opts)
+	diff.detectSignatures(expected, actual, expectedChannels, actualChannels)
+
+	return diff, nil
+}
+
+// Equal reports whether the buffers are identical, lengths included.
+func (d *PCMDiff) Equal() bool {
+	return d.DifferingSamples == 0 && d.ExpectedFrames == d.ActualFrames
+}
+
+// String renders the report: counts, signatures, regions, magnitude histogram and first differing frames.
+func (d *PCMDiff) String() string {
+	var builder strings.Builder
+
+	samples := d.Comparison.Frames * d.Format.Channels
+	fmt.Fprintf(&builder, "%d of %d samples differ (%.2f%%), %d expected frames, %d actual frames",
+		d.DifferingSamples, samples, percent(d.DifferingSamples, samples), d.ExpectedFrames, d.ActualFrames)
+
+	for _, signature := range d.Signatures {
+		fmt.Fprintf(&builder, "\n  signature: %s (%s, %.2f%% of samples)",
+			signature.Kind, signature.Detail, signature.Ratio*percentFactor)
+	}
+
+	for idx, region := range d.Regions {
+		fmt.Fprintf(&builder, "\n  region %d: frames %d-%d", idx+1, region.StartFrame, region.EndFrame-1)
+
+		if d.Format.SampleRate > 0 {
+			fmt.Fprintf(&builder, " (%v-%v)", region.Start, region.End)
+		}
+
+		fmt.Fprintf(&builder, ", %d samples, max diff %s LSB", region.Samples, formatSampleValue(region.MaxDiff))
+	}
+
+	if hidden := d.RegionCount - len(d.Regions); hidden > 0 {
+		fmt.Fprintf(&builder, "\n  ... %d more regions", hidden)
+	}
+
+	if d.DifferingSamples > 0 {
+		builder.WriteString("\n  diff magnitude (LSB):")
+
+		for bucket, count := range d.Comparison.Histogram[1:] {
+			if count > 0 {
+				builder.WriteString(" " + histogramLabel(bucket+1) + ":" + strconv.Itoa(count))
+			}
+		}
+	}
+
+	for _, frame := range d.Frames {
+		fmt.Fprintf(&builder, "\n  frame %d: expected %s, actual %s",
+			frame.Frame, formatSampleValues(frame.Expected), formatSampleValues(frame.Actual))
+	}
+
+	return builder.String()
+}
+
+// DumpWAV writes both buffers as WAV files named after name into dir, and returns their paths.
+// PCM of unknown (zero) sample rate is written at 44.1 kHz.
+func (d *PCMDiff) DumpWAV(dir, name string, expected, actual []byte) (string, string, error) {
+	base := filepath.Join(dir, diffFileName(name))
+	expectedPath, actualPath := base+"-expected.wav", base+"-actual.wav"
+
+	format := d.Format
+	if format.SampleRate == 0 {
+		format.SampleRate = dumpSampleRate
+	}
+
+	if err := WriteWAV(expectedPath, expected, format); err != nil {
+		return "", "", err
+	}
+
+	if err := WriteWAV(actualPath, actual, format); err != nil {
+		return "", "", err
+	}
+
+	return expectedPath, actualPath, nil
+}
+
+// AssertLosslessPCM requires expected and actual PCM to be identical, and otherwise fails the test
+// logging a DiffPCM report, after writing both buffers as WAV files into the test temp dir for
+// listening when opts.DumpWAV is set.
+func AssertLosslessPCM(t tig.T, label string, expected, actual []byte, format Format, opts DiffOptions) {
+	t.Helper()
+
+	diff, err := DiffPCM(expected, actual, format, opts)
+	if err != nil {
+		t.Log(label + ": " + err.Error())
+		t.Fail()
+
+		return
+	}
+
+	if diff.Equal() {
+		return
+	}
+
+	t.Log(label + ": PCM mismatch: " + diff.String())
+
+	if opts.DumpWAV {
+		expectedPath, actualPath, err := diff.DumpWAV(t.TempDir(), label, expected, actual)
+		if err != nil {
+			t.Log(label + ": dumping WAV files: " + err.Error())
+		} else {
+			t.Log(label + ": expected written to " + expectedPath + ", actual to " + actualPath)
+		}
+	}
+
+	t.Fail()
+}
+
+// collect groups differing frames into regions and records the first differing frames.
+func (d *PCMDiff) collect(expected, actual [][]float64, opts DiffOptions) {
+	maxFrames := opts.MaxFrames
+	if maxFrames == 0 {
+		maxFrames = defaultMaxDiffSamples
+	}
+
+	maxRegions := opts.MaxRegions
+	if maxRegions == 0 {
+		maxRegions = DefaultDiffRegions
+	}
+
+	mergeGap := opts.MergeGap
+	if mergeGap == 0 {
+		mergeGap = DefaultDiffMergeGap
+	}
+
+	scale := sampleScale(d.Format)
+	lsb := LSB(pcmPrecision(d.Format))
+
+	var region *DiffRegion
+
+	for frame := range d.Comparison.Frames {
+		differing := 0
+		maxDiff := 0.0
+
+		for ch := range expected {
+			if diff := math.Abs(actual[ch][frame] - expected[ch][frame]); diff > 0 {
+				differing++
+				maxDiff = max(maxDiff, diff/lsb)
+			}
+		}
+
+		if differing == 0 {
+			continue
+		}
+
+		d.DifferingSamples += differing
+
+		if len(d.Frames) < maxFrames {
+			diffFrame := DiffFrame{
+				Frame:    frame,
+				Expected: make([]float64, len(expected)),
+				Actual:   make([]float64, len(expected)),
+			}
+
+			for ch := range expected {
+				diffFrame.Expected[ch] = expected[ch][frame] * scale
+				diffFrame.Actual[ch] = actual[ch][frame] * scale
+			}
+
+			d.Frames = append(d.Frames, diffFrame)
+		}
+
+		if region == nil || frame-region.EndFrame > max(0, mergeGap) {
+			d.RegionCount++
+
+			if len(d.Regions) < maxRegions {
+				d.Regions = append(d.Regions, DiffRegion{StartFrame: frame})
+				region = &d.Regions[len(d.Regions)-1]
+			} else {
+				// Past the reported regions, a scratch region keeps the count accurate.
+				region = &DiffRegion{StartFrame: frame}
+			}
+		}
+
+		region.EndFrame = frame + 1
+		region.Samples += differing
+		region.MaxDiff = max(region.MaxDiff, maxDiff)
+	}
+
+	if d.Format.SampleRate > 0 {
+		for idx := range d.Regions {
+			d.Regions[idx].Start = framesDuration(d.Regions[idx].StartFrame, d.Format.SampleRate)
+			d.Regions[idx].End = framesDuration(d.Regions[idx].EndFrame, d.Format.SampleRate)
+		}
+	}
+}
+
+// detectSignatures tests the differences against each known failure signature.
+func (d *PCMDiff) detectSignatures(expectedPCM, actualPCM []byte, expected, actual [][]float64) {
+	frames := d.Comparison.Frames
+	if frames == 0 {
+		return
+	}
+
+	// Transforms are only reported when they explain more samples than the identity.
+	identity := matchRatio(expected, actual, 0, frames, func(ch, frame int) float64 { return expected[ch][frame] })
+
+	report := func(kind DiffSignatureKind, detail string, ratio float64) {
+		if ratio >= signatureMatchRatio && ratio > identity {
+			d.Signatures = append(d.Signatures, DiffSignature{Kind: kind, Detail: detail, Ratio: ratio})
+		}
+	}
+
+	for first := range expected {
+		for second := first + 1; second < len(expected); second++ {
+			swapped := func(ch, frame int) float64 {
+				switch ch {
+				case first:
+					return expected[second][frame]
+				case second:
+					return expected[first][frame]
+				default:
+					return expected[ch][frame]
+				}
+			}
+
+			report(SignatureChannelSwap, fmt.Sprintf("channels %d and %d", first, second),
+				matchRatio(expected, actual, 0, frames, swapped))
+		}
+	}
+
+	report(SignatureSignInversion, "actual is negated",
+		matchRatio(expected, actual, 0, frames, func(ch, frame int) float64 { return -expected[ch][frame] }))
+
+	report(SignatureSampleShift, "actual is delayed by one frame",
+		matchRatio(expected, actual, 1, frames, func(ch, frame int) float64 { return expected[ch][frame-1] }))
+
+	report(SignatureSampleShift, "actual is ahead by one frame",
+		matchRatio(expected, actual, 0, frames-1, func(ch, frame int) float64 { return expected[ch][frame+1] }))
+
+	if bps := d.Format.BytesPerSample(); bps > 1 {
+		report(SignatureByteSwap, fmt.Sprintf("%d-byte samples", bps),
+			byteSwapRatio(expectedPCM, actualPCM, bps, frames*d.Format.Channels))
+	}
+
+	if missing := d.ExpectedFrames - d.ActualFrames; missing > 0 && d.DifferingSamples == 0 {
+		detail := fmt.Sprintf("actual is missing the last %d frames", missing)
+		if d.Format.SampleRate > 0 {
+			detail += " (" + framesDuration(missing, d.Format.SampleRate).String() + ")"
+		}
+
+		d.Signatures = append(d.Signatures, DiffSignature{Kind: SignatureTruncatedTail, Detail: detail, Ratio: 1})
+	}
+}
+
+// matchRatio returns the fraction of the samples of frames [start, end) of actual equal to want.
+func matchRatio(expected, actual [][]float64, start, end int, want func(ch, frame int) float64) float64 {
+	if end <= start {
+		return 0
+	}
+
+	matches := 0
+
+	for ch := range expected {
+		for frame := start; frame < end; frame++ {
+			if actual[ch][frame] == want(ch, frame) {
+				matches++
+			}
+		}
+	}
+
+	return float64(matches) / float64((end-start)*len(expected))
+}
+
+// byteSwapRatio returns the fraction of the first samples of actual equal to the samples of expected
+// with their bytes reversed.
+func byteSwapRatio(expected, actual []byte, bytesPerSample, samples int) float64 {
+	matches := 0
+
+	for idx := range samples {
+		offset := idx * bytesPerSample
+		swapped := true
+
+		for pos := range bytesPerSample {
+			if expected[offset+pos] != actual[offset+bytesPerSample-1-pos] {
+				swapped = false
+
+				break
+			}
+		}
+
+		if swapped {
+			matches++
+		}
+	}
+
+	return float64(matches) / float64(samples)
+}
+
+// sampleScale returns the factor converting full-scale values to the sample values of format.
+func sampleScale(format Format) float64 {
+	if format.Float {
+		return 1
+	}
+
+	return math.Ldexp(1, format.BitDepth-1)
+}
+
+func framesDuration(frames, sampleRate int) time.Duration {
+	return time.Duration(frames) * time.Second / time.Duration(sampleRate)
+}
+
+func formatSampleValue(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, FloatBits64)
+}
+
+func formatSampleValues(values []float64) string {
+	formatted := make([]string, len(values))
+	for idx, val := range values {
+		formatted[idx] = formatSampleValue(val)
+	}
+
+	return "[" + strings.Join(formatted, " ") + "]"
+}
+
+// diffFileName turns a comparison label into a file name, replacing anything but letters and digits.
+func diffFileName(label string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+
+		return '-'
+	}, label)
+
+	if name = strings.Trim(name, "-"); name == "" {
+		return "pcm"
+	}
+
+	return name
+}
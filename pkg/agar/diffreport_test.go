@@ -0,0 +1,256 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// diffFormat is 16-bit stereo, so that samples are edited as int16.
+//
+//nolint:gochecknoglobals // shared test format.
+var diffFormat = agar.Format{SampleRate: 44100, BitDepth: agar.BitDepth16, Channels: 2}
+
+func diffSource(t *testing.T) []byte {
+	t.Helper()
+
+	pcm, err := agar.RenderPCM(diffFormat, time.Second,
+		agar.PinkNoise{Level: -12}, agar.Sine{Frequency: 300, Level: -12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pcm
+}
+
+// mapSamples returns a copy of 16-bit pcm with every sample replaced by edit(index, sample).
+func mapSamples(pcm []byte, edit func(idx int, sample int16) int16) []byte {
+	out := slices.Clone(pcm)
+
+	for idx := 0; idx < len(out); idx += 2 {
+		sample := int16(binary.LittleEndian.Uint16(out[idx:]))                //nolint:gosec // G115: two's complement.
+		binary.LittleEndian.PutUint16(out[idx:], uint16(edit(idx/2, sample))) //nolint:gosec // G115: two's complement.
+	}
+
+	return out
+}
+
+func sampleAt(pcm []byte, frame, channel int) float64 {
+	offset := (frame*diffFormat.Channels + channel) * 2
+
+	return float64(int16(binary.LittleEndian.Uint16(pcm[offset:]))) //nolint:gosec // G115: two's complement.
+}
+
+func TestDiffPCMFirstDifference(t *testing.T) {
+	t.Parallel()
+
+	expected := diffSource(t)
+	actual := mapSamples(expected, func(idx int, sample int16) int16 {
+		switch idx {
+		case 1000*2 + 1, 1010*2 + 1:
+			return sample + 3
+		case 1020 * 2, 2000 * 2:
+			return sample - 1
+		default:
+			return sample
+		}
+	})
+
+	diff, err := agar.DiffPCM(expected, actual, diffFormat, agar.DiffOptions{MaxFrames: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.Equal() || diff.DifferingSamples != 4 || len(diff.Signatures) != 0 {
+		t.Fatalf("report:\n%s", diff)
+	}
+
+	first := agar.DiffFrame{
+		Frame:    1000,
+		Expected: []float64{sampleAt(expected, 1000, 0), sampleAt(expected, 1000, 1)},
+		Actual:   []float64{sampleAt(expected, 1000, 0), sampleAt(expected, 1000, 1) + 3},
+	}
+
+	if len(diff.Frames) != 2 || !slices.Equal(diff.Frames[0].Expected, first.Expected) ||
+		!slices.Equal(diff.Frames[0].Actual, first.Actual) || diff.Frames[0].Frame != first.Frame ||
+		diff.Frames[1].Frame != 1010 {
+		t.Errorf("frames %+v, expected %+v first", diff.Frames, first)
+	}
+
+	// Frames 1000 to 1020 merge within the default gap, frame 2000 starts another region.
+	expectedRegions := []agar.DiffRegion{
+		{StartFrame: 1000, EndFrame: 1021, Start: 22675736, End: 23151927, Samples: 3, MaxDiff: 3},
+		{StartFrame: 2000, EndFrame: 2001, Start: 45351473, End: 45374149, Samples: 1, MaxDiff: 1},
+	}
+
+	if !slices.Equal(diff.Regions, expectedRegions) || diff.RegionCount != 2 {
+		t.Errorf("regions %+v, expected %+v", diff.Regions, expectedRegions)
+	}
+
+	report := diff.String()
+	for _, line := range []string{
+		"4 of 88200 samples differ",
+		"region 1: frames 1000-1020 (22.675736ms-23.151927ms), 3 samples, max diff 3 LSB",
+		"frame 1000: expected",
+	} {
+		if !strings.Contains(report, line) {
+			t.Errorf("report %q, expected it to mention %q", report, line)
+		}
+	}
+
+	if strings.Contains(report, "more regions") {
+		t.Errorf("report %q lists every region, expected no hidden regions", report)
+	}
+
+	limited, err := agar.DiffPCM(expected, actual, diffFormat, agar.DiffOptions{MaxRegions: 1, MergeGap: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(limited.Regions) != 1 || limited.RegionCount != 4 || !strings.Contains(limited.String(), "3 more regions") {
+		t.Errorf("limited report:\n%s", limited)
+	}
+}
+
+func TestDiffPCMSignatures(t *testing.T) {
+	t.Parallel()
+
+	expected := diffSource(t)
+	frameSize := diffFormat.FrameSize()
+
+	for _, tc := range []struct {
+		actual    []byte
+		signature agar.DiffSignatureKind
+		detail    string
+	}{
+		{
+			mapSamples(expected, func(idx int, _ int16) int16 {
+				return int16(sampleAt(expected, idx/2, 1-idx%2))
+			}),
+			agar.SignatureChannelSwap, "channels 0 and 1",
+		},
+		{
+			mapSamples(expected, func(_ int, sample int16) int16 { return -sample }),
+			agar.SignatureSignInversion, "actual is negated",
+		},
+		{
+			append(make([]byte, frameSize), expected[:len(expected)-frameSize]...),
+			agar.SignatureSampleShift, "actual is delayed by one frame",
+		},
+		{
+			expected[frameSize:],
+			agar.SignatureSampleShift, "actual is ahead by one frame",
+		},
+		{
+			mapSamples(expected, func(_ int, sample int16) int16 {
+				return int16(uint16(sample)>>8 | uint16(sample)<<8) //nolint:gosec // G115: two's complement.
+			}),
+			agar.SignatureByteSwap, "2-byte samples",
+		},
+		{
+			expected[:len(expected)-441*frameSize],
+			agar.SignatureTruncatedTail, "actual is missing the last 441 frames (10ms)",
+		},
+	} {
+		diff, err := agar.DiffPCM(expected, tc.actual, diffFormat, agar.DiffOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(diff.Signatures) != 1 || diff.Signatures[0].Kind != tc.signature ||
+			diff.Signatures[0].Detail != tc.detail {
+			t.Errorf("%s (%s): got %+v", tc.signature, tc.detail, diff.Signatures)
+		}
+	}
+}
+
+func TestDiffPCMUnknownSampleRate(t *testing.T) {
+	t.Parallel()
+
+	expected := diffSource(t)
+	actual := mapSamples(expected, func(idx int, sample int16) int16 {
+		if idx == 10 {
+			return sample + 1
+		}
+
+		return sample
+	})
+
+	format := diffFormat
+	format.SampleRate = 0
+
+	diff, err := agar.DiffPCM(expected, actual, format, agar.DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff.Regions) != 1 || diff.Regions[0].StartFrame != 5 || diff.Regions[0].Start != 0 ||
+		strings.Contains(diff.String(), "ms") {
+		t.Errorf("report without sample rate:\n%s", diff)
+	}
+
+	expectedPath, actualPath, err := diff.DumpWAV(t.TempDir(), "No Rate", expected, actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, pcm := range map[string][]byte{expectedPath: expected, actualPath: actual} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rate := binary.LittleEndian.Uint32(content[24:])
+		if rate != 44100 || !strings.HasSuffix(string(content), string(pcm)) {
+			t.Errorf("%s: %d Hz, expected the PCM at 44100 Hz", path, rate)
+		}
+	}
+}
+
+func TestAssertLosslessPCM(t *testing.T) {
+	t.Parallel()
+
+	expected := diffSource(t)
+
+	identical := recordAssertion(t, func(recorder *recordingT) {
+		agar.AssertLosslessPCM(recorder, "identical", expected, slices.Clone(expected), diffFormat, agar.DiffOptions{})
+	})
+	if identical.failed || len(identical.logs) != 0 {
+		t.Errorf("identical PCM: failed %v, logged %q", identical.failed, identical.logs)
+	}
+
+	recorder := recordAssertion(t, func(recorder *recordingT) {
+		agar.AssertLosslessPCM(recorder, "Decoder Output", expected, expected[diffFormat.FrameSize():], diffFormat,
+			agar.DiffOptions{DumpWAV: true})
+	})
+	if !recorder.failed || len(recorder.logs) != 2 || !strings.Contains(recorder.logs[0], "off-by-one sample shift") {
+		t.Fatalf("shifted PCM: failed %v, logged %q", recorder.failed, recorder.logs)
+	}
+
+	dumped, err := filepath.Glob(filepath.Join(recorder.dir, "*.wav"))
+	if err != nil || len(dumped) != 2 {
+		t.Errorf("dumped %q, expected the expected and actual WAV files: %v", dumped, err)
+	}
+}
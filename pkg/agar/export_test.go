@@ -0,0 +1,64 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+// CountingGenerator returns a native generator writing content to file, counting its runs in runs.
+func CountingGenerator(file, content string, runs *atomic.Int32) Generator {
+	return nativeGenerator("Counting", file, func(path string) error {
+		runs.Add(1)
+
+		return os.WriteFile(path, []byte(content), 0o600)
+	})
+}
+
+// WithAppendStep returns g followed by a step appending suffix to the fixture, keyed on suffix.
+func (g Generator) WithAppendStep(suffix string) Generator {
+	return g.then(generatorStep{
+		key: []string{"append", suffix},
+		run: func(_ context.Context, _, path string) error {
+			file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+
+			if _, err = file.WriteString(suffix); err != nil {
+				_ = file.Close()
+
+				return err
+			}
+
+			return file.Close()
+		},
+	})
+}
+
+// SetGoldenBinary makes path the binary run by Golden for the duration of the test, as Setup does without
+// customizing tigron.
+func SetGoldenBinary(t *testing.T, path string) {
+	previous := setupBinary.Swap(&path)
+
+	t.Cleanup(func() {
+		setupBinary.Store(previous)
+	})
+}
@@ -19,8 +19,8 @@ package agar
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
-	"os/exec"
 	"strconv"
 	"testing"
 )
@@ -42,8 +42,11 @@ type FFmpegOptions struct {
 	// When nil, stdout is captured and returned in FFmpegResult.Stdout.
 	Stdout io.Writer
 	// Stderr receives the command's standard error when non-nil.
-	// When nil, stderr is captured and included in the fatal message on failure.
+	// Stderr is captured in any case and included in the fatal message on failure.
 	Stderr io.Writer
+	// Context bounds the invocation. When nil, ToolContext derives one from the test deadline.
+	// FFmpegContext ignores it in favor of its ctx argument.
+	Context context.Context
 }
 
 // FFmpegResult holds captured output from an ffmpeg invocation.
@@ -54,18 +57,40 @@ type FFmpegResult struct {
 }
 
 // FFmpeg runs ffmpeg with the given options.
-// It fatals the test if ffmpeg cannot be found or the command returns an error.
+// It fatals the test if ffmpeg cannot be found, the command returns an error or times out.
 func FFmpeg(t *testing.T, opts FFmpegOptions) FFmpegResult {
 	t.Helper()
 
+	ctx := opts.Context
+	if ctx == nil {
+		var cancel context.CancelFunc
+
+		ctx, cancel = ToolContext(t)
+		defer cancel()
+	}
+
+	result, err := runFFmpeg(ctx, t.Name(), opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return result
+}
+
+// FFmpegContext runs ffmpeg with the given options, bounded by ctx rather than opts.Context.
+// It returns an error if ffmpeg cannot be found, the command returns an error or times out.
+func FFmpegContext(ctx context.Context, opts FFmpegOptions) (FFmpegResult, error) {
+	return runFFmpeg(ctx, ffmpegBinary, opts)
+}
+
+// runFFmpeg runs ffmpeg with opts bounded by ctx, reporting failures for generator.
+func runFFmpeg(ctx context.Context, generator string, opts FFmpegOptions) (FFmpegResult, error) {
 	ffmpegPath, err := LookFor(ffmpegBinary)
 	if err != nil {
-		t.Log(ffmpegBinary + ": " + err.Error())
-		t.FailNow()
+		return FFmpegResult{}, fmt.Errorf("%s: %w", ffmpegBinary, err)
 	}
 
-	//nolint:gosec // arguments are test-controlled
-	cmd := exec.CommandContext(context.Background(), ffmpegPath, opts.Args...)
+	cmd := newToolCommand(ctx, ffmpegPath, opts.Args...)
 
 	if opts.Stdin != nil {
 		cmd.Stdin = opts.Stdin
@@ -79,21 +104,15 @@ func FFmpeg(t *testing.T, opts FFmpegOptions) FFmpegResult {
 		cmd.Stdout = &stdoutBuf
 	}
 
-	var stderrBuf bytes.Buffer
+	cmd.Stderr = opts.Stderr
 
-	if opts.Stderr != nil {
-		cmd.Stderr = opts.Stderr
-	} else {
-		cmd.Stderr = &stderrBuf
-	}
-
-	if err := cmd.Run(); err != nil {
-		t.Fatalf("ffmpeg: %v\n%s", err, stderrBuf.String())
+	if err := runTool(ctx, generator, cmd); err != nil {
+		return FFmpegResult{}, err
 	}
 
 	return FFmpegResult{
 		Stdout: stdoutBuf.Bytes(),
-	}
+	}, nil
 }
 
 // FFmpegEncodeOptions configures encoding raw PCM to a compressed format.
@@ -121,6 +140,19 @@ type FFmpegEncodeOptions struct {
 func FFmpegEncode(t *testing.T, opts FFmpegEncodeOptions) {
 	t.Helper()
 
+	FFmpeg(t, FFmpegOptions{Args: ffmpegEncodeArgs(opts)})
+}
+
+// FFmpegEncodeContext encodes a raw PCM file to the target format, bounded by ctx.
+// It returns an error if ffmpeg cannot be found, the command returns an error or times out.
+func FFmpegEncodeContext(ctx context.Context, opts FFmpegEncodeOptions) error {
+	_, err := FFmpegContext(ctx, FFmpegOptions{Args: ffmpegEncodeArgs(opts)})
+
+	return err
+}
+
+// ffmpegEncodeArgs returns the ffmpeg arguments encoding as described by opts.
+func ffmpegEncodeArgs(opts FFmpegEncodeOptions) []string {
 	args := []string{
 		"-y",
 		"-f", RawPCMFormat(opts.BitDepth),
@@ -131,9 +163,8 @@ func FFmpegEncode(t *testing.T, opts FFmpegEncodeOptions) {
 	args = append(args, opts.InputArgs...)
 	args = append(args, "-i", opts.Src)
 	args = append(args, opts.CodecArgs...)
-	args = append(args, opts.Dst)
 
-	FFmpeg(t, FFmpegOptions{Args: args})
+	return append(args, opts.Dst)
 }
 
 // FFmpegDecodeOptions configures decoding an audio file to raw PCM.
@@ -157,6 +188,28 @@ type FFmpegDecodeOptions struct {
 func FFmpegDecode(t *testing.T, opts FFmpegDecodeOptions) []byte {
 	t.Helper()
 
+	result := FFmpeg(t, FFmpegOptions{
+		Args:   ffmpegDecodeArgs(opts),
+		Stdout: opts.Stdout,
+	})
+
+	return result.Stdout
+}
+
+// FFmpegDecodeContext decodes an audio file to raw PCM, bounded by ctx.
+// Returns captured PCM bytes when Stdout is nil; returns nil when Stdout is non-nil.
+// It returns an error if ffmpeg cannot be found, the command returns an error or times out.
+func FFmpegDecodeContext(ctx context.Context, opts FFmpegDecodeOptions) ([]byte, error) {
+	result, err := FFmpegContext(ctx, FFmpegOptions{
+		Args:   ffmpegDecodeArgs(opts),
+		Stdout: opts.Stdout,
+	})
+
+	return result.Stdout, err
+}
+
+// ffmpegDecodeArgs returns the ffmpeg arguments decoding as described by opts to standard output.
+func ffmpegDecodeArgs(opts FFmpegDecodeOptions) []string {
 	args := []string{
 		"-i", opts.Src,
 		"-f", RawPCMFormat(opts.BitDepth),
@@ -168,14 +221,8 @@ func FFmpegDecode(t *testing.T, opts FFmpegDecodeOptions) []byte {
 
 	args = append(args, "-acodec", RawPCMCodec(opts.BitDepth))
 	args = append(args, opts.Args...)
-	args = append(args, "-")
 
-	result := FFmpeg(t, FFmpegOptions{
-		Args:   args,
-		Stdout: opts.Stdout,
-	})
-
-	return result.Stdout
+	return append(args, "-")
 }
 
 // RawPCMFormat returns the ffmpeg raw format name for a given bit depth.
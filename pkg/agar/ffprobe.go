@@ -23,7 +23,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os/exec"
 	"strconv"
 )
 
@@ -75,15 +74,22 @@ type FFProbeFormat struct {
 }
 
 // FFProbe runs ffprobe on the given file and returns parsed JSON metadata.
-// It probes both streams and format information.
+// It probes both streams and format information, within ToolContext(nil).
 func FFProbe(path string) (*FFProbeResult, error) {
+	ctx, cancel := ToolContext(nil)
+	defer cancel()
+
+	return FFProbeContext(ctx, path)
+}
+
+// FFProbeContext is FFProbe bounded by ctx.
+func FFProbeContext(ctx context.Context, path string) (*FFProbeResult, error) {
 	ffprobePath, err := LookFor(ffprobeBinary)
 	if err != nil {
 		return nil, err
 	}
 
-	//nolint:gosec // path is intentionally user-provided
-	cmd := exec.CommandContext(context.Background(), ffprobePath,
+	cmd := newToolCommand(ctx, ffprobePath,
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_format",
@@ -91,17 +97,16 @@ func FFProbe(path string) (*FFProbeResult, error) {
 		path,
 	)
 
-	var stderr bytes.Buffer
+	var output bytes.Buffer
 
-	cmd.Stderr = &stderr
+	cmd.Stdout = &output
 
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("ffprobe %s: %w: %s", path, err, stderr.String())
+	if err = runTool(ctx, path, cmd); err != nil {
+		return nil, err
 	}
 
 	var result FFProbeResult
-	if err = json.Unmarshal(output, &result); err != nil {
+	if err = json.Unmarshal(output.Bytes(), &result); err != nil {
 		return nil, fmt.Errorf("ffprobe JSON parse: %w", err)
 	}
 
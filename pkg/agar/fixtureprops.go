@@ -0,0 +1,382 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// Expected levels of the ffmpeg generated fixtures.
+const (
+	// ffmpegSineLevel is the peak level of the lavfi sine source (amplitude 1/8).
+	ffmpegSineLevel = -18.06
+	// fixtureGainDB is the volume filter applied by most fixtures.
+	fixtureGainDB = -6
+	// quietGainDB is the volume filter applied by LowLoudnessQuiet.
+	quietGainDB = -30
+
+	// Documented DR scores of the dynamics fixtures.
+	drBrickwalled = 3
+	drMediocre    = 6
+	drOK          = 8
+
+	// dcShiftPositive and dcShiftNegative are the dcshift filters of the DC offset fixtures.
+	dcShiftPositive = 0.1
+	dcShiftNegative = -0.15
+
+	// drTolerance is the accepted deviation of a documented "DR ~N" score.
+	drTolerance = 1
+	// levelToleranceDB is the accepted deviation of a documented level.
+	levelToleranceDB = 0.5
+	// dcOffsetTolerance is the accepted deviation of a documented DC offset, in linear full scale.
+	dcOffsetTolerance = 0.005
+	// stereoChannels is the channel count of ChannelImbalanceLeft.
+	stereoChannels = 2
+	// quietLoudness is the integrated loudness of LowLoudnessQuiet: the 440 Hz source sine reads
+	// -18.7 LUFS in stereo, 30 dB above.
+	quietLoudness = -48.7
+	// loudnessToleranceLU is the accepted deviation of a documented integrated loudness.
+	loudnessToleranceLU = 0.5
+	// truePeakCeiling is the highest true peak of ClippedLimited, in dBTP. The limiter holds sample
+	// peaks at full scale, while the squashed waveform may overshoot slightly between samples.
+	truePeakCeiling = 0.5
+	// cutoffDropDB is the level drop, relative to the 1-4 kHz band, at which cutoffs are measured.
+	cutoffDropDB = 40
+	// cutoffToleranceHz is the accepted deviation of a documented cutoff frequency.
+	cutoffToleranceHz = 2000
+	// lossyCutoff is the lowpass of LossyTranscodeMP3128k, in Hz.
+	lossyCutoff = 16000
+	// upsampledCutoff is the Nyquist frequency of the source of Upsampled44kTo96k, in Hz.
+	upsampledCutoff = 22050
+	// upsampledCutoffTolerance allows for spectral leakage and smoothing above upsampledCutoff.
+	upsampledCutoffTolerance = 500
+	// hiresCutoff is the lowest cutoff of Genuine24bit96k, whose noise reaches Nyquist, in Hz.
+	hiresCutoff = 44000
+	// peakToleranceBins is the accepted deviation of a documented tone frequency, in FFT bins.
+	peakToleranceBins = 2
+	// peakProminenceDB is the minimum prominence of the documented tones.
+	peakProminenceDB = 20
+	// humFrequency is the mains frequency of HumMains50Hz, in Hz.
+	humFrequency = 50
+	// Noise floor bands of the NoiseFloor fixtures, in Hz, and the expected ratios in dB.
+	noiseBandLow       = 14000
+	noiseBandHigh      = 18000
+	noiseRefLow        = 1000
+	noiseRefHigh       = 10000
+	noiseFlatTolerance = 2
+	noiseCleanCeiling  = -40
+	// imbalanceDB is the level difference of ChannelImbalanceLeft (1.0 vs 0.1 pan gains).
+	imbalanceDB = 20
+	// truncatedDuration is the length of TruncatedAbruptCut.
+	truncatedDuration = 5123 * time.Millisecond
+	// durationTolerance is the accepted deviation of a documented duration.
+	durationTolerance = 10 * time.Millisecond
+	// Documented crest factors of the dynamics fixtures: sqrt(2) for one sine, 2 for two and sqrt(6)
+	// for three sines of equal amplitude.
+	crestOneSine    = math.Sqrt2
+	crestTwoSines   = 2
+	crestThreeSines = 2.449489742783178
+)
+
+// ultrasonicTones are the sine frequencies of Genuine24bit96k, in Hz.
+//
+//nolint:gochecknoglobals // lookup table
+var ultrasonicTones = []float64{25000, 30000, 35000, 40000}
+
+// ErrFixtureProperty is returned when a fixture does not have a property it documents.
+var ErrFixtureProperty = errors.New("fixture does not have its documented property")
+
+// FixtureProperty is a measurable property a fixture generator documents.
+type FixtureProperty struct {
+	// Fixture is the name of the generator.
+	Fixture string
+	// Description states the property.
+	Description string
+	// Generate is the fixture generator.
+	Generate func(data test.Data, helpers test.Helpers) string
+	// Check returns an ErrFixtureProperty error when the decoded fixture lacks the property.
+	Check func(pcm []byte, format Format) error
+}
+
+// FixtureProperties returns the documented properties of the fixtures that can be measured.
+func FixtureProperties() []FixtureProperty {
+	return []FixtureProperty{
+		{"DynamicsFucked", "DR ~3", DynamicsFucked, checkDR(drBrickwalled)},
+		{"DynamicsMediocre", "DR ~6", DynamicsMediocre, checkDR(drMediocre)},
+		{"DynamicsOK", "DR ~8", DynamicsOK, checkDR(drOK)},
+		{"DynamicsFucked", "crest factor sqrt(2)", DynamicsFucked, checkCrestFactor(crestOneSine)},
+		{"DynamicsMediocre", "crest factor 2", DynamicsMediocre, checkCrestFactor(crestTwoSines)},
+		{"DynamicsOK", "crest factor sqrt(6)", DynamicsOK, checkCrestFactor(crestThreeSines)},
+		{"ClippedHard", "clipping runs on every channel", ClippedHard, checkClipped()},
+		{
+			"DCOffsetPositive", "positive DC offset", DCOffsetPositive,
+			checkDCOffset(dcShiftPositive * DBToAmplitude(fixtureGainDB)),
+		},
+		{
+			"DCOffsetNegative", "negative DC offset", DCOffsetNegative,
+			checkDCOffset(dcShiftNegative * DBToAmplitude(fixtureGainDB)),
+		},
+		{"LowLoudnessQuiet", "-30dB", LowLoudnessQuiet, checkPeak(ffmpegSineLevel + quietGainDB)},
+		{"LowLoudnessQuiet", "-30dB integrated loudness", LowLoudnessQuiet, checkIntegrated(quietLoudness)},
+		{"ClippedLimited", "true peak at most +0.5 dBTP", ClippedLimited, checkTruePeak(truePeakCeiling)},
+		{"ChannelImbalanceLeft", "left channel 20 dB louder", ChannelImbalanceLeft, checkImbalance(imbalanceDB)},
+		{
+			"LossyTranscodeMP3128k", "brick-wall cutoff at ~16 kHz", LossyTranscodeMP3128k,
+			checkCutoff(lossyCutoff-cutoffToleranceHz, lossyCutoff+cutoffToleranceHz),
+		},
+		{
+			"Upsampled44kTo96k", "nothing above 22.05 kHz", Upsampled44kTo96k,
+			checkCutoff(0, upsampledCutoff+upsampledCutoffTolerance),
+		},
+		{"Genuine24bit96k", "content up to Nyquist", Genuine24bit96k, checkCutoff(hiresCutoff, math.Inf(1))},
+		{
+			"Genuine24bit96k", "tones at 25, 30, 35 and 40 kHz", Genuine24bit96k,
+			checkPeaks(ultrasonicTones...),
+		},
+		{"HumMains50Hz", "50Hz mains hum", HumMains50Hz, checkPeaks(humFrequency)},
+		{
+			"NoiseFloorHigh", "14-18 kHz band at the level of 1-10 kHz", NoiseFloorHigh,
+			checkNoiseFloor(-noiseFlatTolerance, noiseFlatTolerance),
+		},
+		{
+			"NoiseFloorClean", "14-18 kHz band far below 1-10 kHz", NoiseFloorClean,
+			checkNoiseFloor(math.Inf(-1), noiseCleanCeiling),
+		},
+		{"TruncatedAbruptCut", "cut at 5.123 s", TruncatedAbruptCut, checkDuration(truncatedDuration)},
+	}
+}
+
+// FixturePropertyTests returns one tigron subtest per fixture property, generating the fixture,
+// decoding it with DecodeFile and running the check. Append them to the SubTests of a Setup case.
+func FixturePropertyTests() []*test.Case {
+	properties := FixtureProperties()
+	cases := make([]*test.Case, 0, len(properties))
+
+	for _, property := range properties {
+		cases = append(cases, &test.Case{
+			Description: property.Fixture + ": " + property.Description,
+			Setup: func(data test.Data, helpers test.Helpers) {
+				helpers.T().Helper()
+
+				ctx, cancel := ToolContext(helpers.T())
+				defer cancel()
+
+				pcm, format, err := DecodeFileContext(ctx, property.Generate(data, helpers))
+				if err == nil {
+					err = property.Check(pcm, format)
+				}
+
+				if err != nil {
+					helpers.T().Log(err.Error())
+					helpers.T().FailNow()
+				}
+			},
+		})
+	}
+
+	return cases
+}
+
+// checkAnalysis adapts a check over an Analysis to FixtureProperty.Check.
+func checkAnalysis(check func(analysis *Analysis) error) func(pcm []byte, format Format) error {
+	return func(pcm []byte, format Format) error {
+		analysis, err := AnalyzePCM(pcm, format)
+		if err != nil {
+			return err
+		}
+
+		return check(analysis)
+	}
+}
+
+// checkLoudness adapts a check over a Loudness to FixtureProperty.Check.
+func checkLoudness(check func(loudness *Loudness) error) func(pcm []byte, format Format) error {
+	return func(pcm []byte, format Format) error {
+		loudness, err := MeasureLoudness(pcm, format)
+		if err != nil {
+			return err
+		}
+
+		return check(loudness)
+	}
+}
+
+// checkSpectrum adapts a check over a default PowerSpectrum to FixtureProperty.Check.
+func checkSpectrum(check func(spectrum *Spectrum) error) func(pcm []byte, format Format) error {
+	return func(pcm []byte, format Format) error {
+		spectrum, err := PowerSpectrum(pcm, format, SpectrumOptions{})
+		if err != nil {
+			return err
+		}
+
+		return check(spectrum)
+	}
+}
+
+func checkDR(expected int) func(pcm []byte, format Format) error {
+	return checkAnalysis(func(analysis *Analysis) error {
+		if math.Abs(float64(analysis.DR-expected)) > drTolerance {
+			return fmt.Errorf("%w: DR%d, expected DR%d", ErrFixtureProperty, analysis.DR, expected)
+		}
+
+		return nil
+	})
+}
+
+func checkCrestFactor(expected float64) func(pcm []byte, format Format) error {
+	return checkAnalysis(func(analysis *Analysis) error {
+		for idx, channel := range analysis.Channels {
+			if math.Abs(channel.CrestFactorDB()-AmplitudeToDB(expected)) > levelToleranceDB {
+				return fmt.Errorf("%w: crest factor %.2f dB on channel %d, expected %.2f dB",
+					ErrFixtureProperty, channel.CrestFactorDB(), idx, AmplitudeToDB(expected))
+			}
+		}
+
+		return nil
+	})
+}
+
+func checkClipped() func(pcm []byte, format Format) error {
+	return checkAnalysis(func(analysis *Analysis) error {
+		for idx, channel := range analysis.Channels {
+			if channel.ClipRuns == 0 {
+				return fmt.Errorf("%w: no clipping run on channel %d", ErrFixtureProperty, idx)
+			}
+		}
+
+		return nil
+	})
+}
+
+func checkDCOffset(expected float64) func(pcm []byte, format Format) error {
+	return checkAnalysis(func(analysis *Analysis) error {
+		for idx, channel := range analysis.Channels {
+			if math.Abs(channel.DCOffset-expected) > dcOffsetTolerance {
+				return fmt.Errorf("%w: DC offset %.4f on channel %d, expected %.4f",
+					ErrFixtureProperty, channel.DCOffset, idx, expected)
+			}
+		}
+
+		return nil
+	})
+}
+
+func checkPeak(expectedDB float64) func(pcm []byte, format Format) error {
+	return checkAnalysis(func(analysis *Analysis) error {
+		for idx, channel := range analysis.Channels {
+			if math.Abs(channel.PeakDB()-expectedDB) > levelToleranceDB {
+				return fmt.Errorf("%w: peak %.2f dBFS on channel %d, expected %.2f dBFS",
+					ErrFixtureProperty, channel.PeakDB(), idx, expectedDB)
+			}
+		}
+
+		return nil
+	})
+}
+
+func checkImbalance(expectedDB float64) func(pcm []byte, format Format) error {
+	return checkAnalysis(func(analysis *Analysis) error {
+		if len(analysis.Channels) != stereoChannels {
+			return fmt.Errorf("%w: %d channels, expected stereo", ErrFixtureProperty, len(analysis.Channels))
+		}
+
+		difference := analysis.Channels[0].RMSDB() - analysis.Channels[1].RMSDB()
+		if math.Abs(difference-expectedDB) > levelToleranceDB {
+			return fmt.Errorf("%w: left channel %.2f dB louder, expected %.2f dB",
+				ErrFixtureProperty, difference, expectedDB)
+		}
+
+		return nil
+	})
+}
+
+func checkDuration(expected time.Duration) func(pcm []byte, format Format) error {
+	return checkAnalysis(func(analysis *Analysis) error {
+		if (analysis.Duration() - expected).Abs() > durationTolerance {
+			return fmt.Errorf("%w: duration %v, expected %v", ErrFixtureProperty, analysis.Duration(), expected)
+		}
+
+		return nil
+	})
+}
+
+func checkIntegrated(expected float64) func(pcm []byte, format Format) error {
+	return checkLoudness(func(loudness *Loudness) error {
+		if math.Abs(loudness.Integrated-expected) > loudnessToleranceLU {
+			return fmt.Errorf("%w: integrated loudness %.2f LUFS, expected %.2f LUFS",
+				ErrFixtureProperty, loudness.Integrated, expected)
+		}
+
+		return nil
+	})
+}
+
+func checkTruePeak(ceiling float64) func(pcm []byte, format Format) error {
+	return checkLoudness(func(loudness *Loudness) error {
+		if loudness.TruePeak > ceiling {
+			return fmt.Errorf("%w: true peak %.2f dBTP, expected at most %.2f dBTP",
+				ErrFixtureProperty, loudness.TruePeak, ceiling)
+		}
+
+		return nil
+	})
+}
+
+func checkCutoff(low, high float64) func(pcm []byte, format Format) error {
+	return checkSpectrum(func(spectrum *Spectrum) error {
+		if cutoff := spectrum.Cutoff(cutoffDropDB); cutoff < low || cutoff > high {
+			return fmt.Errorf("%w: cutoff at %.0f Hz, expected between %.0f and %.0f Hz",
+				ErrFixtureProperty, cutoff, low, high)
+		}
+
+		return nil
+	})
+}
+
+// checkPeaks verifies that each frequency is among the prominent peaks of the spectrum.
+func checkPeaks(frequencies ...float64) func(pcm []byte, format Format) error {
+	return checkSpectrum(func(spectrum *Spectrum) error {
+		peaks := spectrum.Peaks(0, peakProminenceDB)
+
+		for _, frequency := range frequencies {
+			if !slices.ContainsFunc(peaks, func(peak SpectralPeak) bool {
+				return math.Abs(peak.Frequency-frequency) <= peakToleranceBins*spectrum.BinWidth
+			}) {
+				return fmt.Errorf("%w: no spectral peak at %.0f Hz", ErrFixtureProperty, frequency)
+			}
+		}
+
+		return nil
+	})
+}
+
+func checkNoiseFloor(low, high float64) func(pcm []byte, format Format) error {
+	return checkSpectrum(func(spectrum *Spectrum) error {
+		ratio := spectrum.BandEnergyRatio(noiseBandLow, noiseBandHigh, noiseRefLow, noiseRefHigh)
+		if ratio < low || ratio > high {
+			return fmt.Errorf("%w: 14-18 kHz band %.2f dB relative to 1-10 kHz", ErrFixtureProperty, ratio)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,36 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"testing"
+
+	"github.com/containerd/nerdctl/mod/tigron/require"
+	"github.com/containerd/nerdctl/mod/tigron/test"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+//nolint:paralleltest // the test case runs in parallel.
+func TestFixtureProperties(t *testing.T) {
+	testCase := &test.Case{
+		Require:  require.Binary("ffmpeg"),
+		SubTests: agar.FixturePropertyTests(),
+	}
+
+	testCase.Run(t)
+}
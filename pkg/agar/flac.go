@@ -0,0 +1,1200 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"crypto/md5" //nolint:gosec // FLAC STREAMINFO mandates MD5.
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+
+	"github.com/mycophonic/primordium/filesystem"
+)
+
+// FLACSubframeType selects how a FLAC subframe is encoded.
+type FLACSubframeType int
+
+// FLAC subframe types.
+const (
+	// FLACSubframeAuto picks the smallest of constant, verbatim and fixed orders 0-4.
+	FLACSubframeAuto FLACSubframeType = iota
+	// FLACSubframeConstant requires every sample in the block to be identical.
+	FLACSubframeConstant
+	// FLACSubframeVerbatim stores samples unencoded.
+	FLACSubframeVerbatim
+	// FLACSubframeFixed uses the fixed polynomial predictor of FLACSubframeOptions.Order (0-4).
+	FLACSubframeFixed
+	// FLACSubframeLPC uses a quantized linear predictor of FLACSubframeOptions.Order (1-32).
+	FLACSubframeLPC
+)
+
+// FLACChannelAssignment selects the inter-channel decorrelation of stereo frames.
+type FLACChannelAssignment int
+
+// FLAC channel assignments. Decorrelated modes require exactly two channels.
+const (
+	FLACChannelIndependent FLACChannelAssignment = iota
+	FLACChannelLeftSide
+	FLACChannelRightSide
+	FLACChannelMidSide
+)
+
+// FLAC metadata block types.
+const (
+	FLACBlockStreamInfo    = 0
+	FLACBlockPadding       = 1
+	FLACBlockApplication   = 2
+	FLACBlockSeekTable     = 3
+	FLACBlockVorbisComment = 4
+	FLACBlockCueSheet      = 5
+	FLACBlockPicture       = 6
+)
+
+const (
+	// DefaultFLACBlockSize matches the reference encoder's default.
+	DefaultFLACBlockSize = 4096
+
+	flacMinBitDepth       = 4
+	flacMaxChannels       = 8
+	flacMaxBlockSize      = 65535
+	flacMaxFixedOrder     = 4
+	flacMaxLPCOrder       = 32
+	flacMaxLPCPrecision   = 15
+	flacDefaultLPCOrder   = 8
+	flacDefaultPrecision  = 12
+	flacMaxShift          = 15
+	flacMaxPartitionOrder = 15
+	flacAutoPartitionMax  = 8
+	flacRiceEscape        = 0xF
+	flacRice2Escape       = 0x1F
+	flacRiceMaxParam      = 14
+	flacRice2MaxParam     = 30
+	flacEscapeBitsWidth   = 5
+	flacMaxEscapeBits     = 1<<flacEscapeBitsWidth - 1
+	flacResidualBits      = 32
+	flacHeaderExtra8Bits  = 8
+	flacHeaderExtra16Bits = 16
+	flacStreamInfoSize    = 34
+	flacBlockHeaderSize   = 4
+	flacMaxBlockLength    = 1<<24 - 1
+	flacSyncCode          = 0x3FFE
+	flacTotalSamplesMax   = 1<<36 - 1
+	flacTukeyRatio        = 0.5
+	flacHeaderSmallSize   = 6
+	flacHeaderLargeSize   = 7
+	flacRateCodeKHz       = 12
+	flacRateCodeHz        = 13
+	flacRateCodeTensHz    = 14
+	flacChannelCodeOffset = 7
+)
+
+// Errors returned by the FLAC encoder.
+var (
+	ErrFLACUnsupported   = errors.New("format not supported by FLAC")
+	ErrFLACOption        = errors.New("invalid FLAC encoder option")
+	ErrFLACNotConstant   = errors.New("constant subframe requested for non-constant samples")
+	ErrFLACWastedBits    = errors.New("samples do not have the requested wasted bits")
+	ErrFLACResidualRange = errors.New("residual does not fit in 32 bits")
+	ErrFLACEscapeWidth   = errors.New("residual does not fit in the escape width")
+)
+
+// FLACSubframeOptions controls the encoding of a single subframe.
+type FLACSubframeOptions struct {
+	Type FLACSubframeType
+	// Order is the predictor order: 0-4 for fixed, 1-32 for LPC (zero selects 8).
+	// Blocks too short for the order fall back to verbatim.
+	Order int
+	// Precision is the LPC coefficient precision in bits (1-15, zero selects 12).
+	Precision int
+	// Coefficients, when set, are used verbatim as the quantized LPC coefficients (len must equal Order)
+	// together with Shift, instead of being computed from the signal.
+	Coefficients []int32
+	Shift        int
+	// WastedBits declares that many low-order zero bits shared by every sample of the subframe.
+	// Encoding fails if the samples are not multiples of 2^WastedBits.
+	WastedBits int
+	// PartitionOrder forces the Rice partition order when positive, otherwise the best order
+	// up to 8 is chosen. Use ForcePartitionOrder to force order zero. A forced order is lowered
+	// for blocks that cannot be evenly split into that many partitions.
+	PartitionOrder      int
+	ForcePartitionOrder bool
+	// RiceParameter forces the Rice parameter of every partition when positive,
+	// otherwise the optimal parameter is chosen per partition.
+	RiceParameter int
+	// Rice2 forces the 5-bit parameter residual coding method. It is selected automatically
+	// when a parameter above 14 is needed.
+	Rice2 bool
+	// Escape stores every partition as raw signed samples behind the escape code.
+	// EscapeBits sets their width (at most 31, and wide enough for the residuals); zero uses the
+	// minimal width.
+	Escape     bool
+	EscapeBits int
+}
+
+// FLACMetadataBlock is a raw metadata block written after STREAMINFO.
+type FLACMetadataBlock struct {
+	Type byte
+	Data []byte
+}
+
+// FLACOptions configures EncodeFLAC.
+type FLACOptions struct {
+	// BlockSize is the number of samples per frame (zero selects DefaultFLACBlockSize).
+	BlockSize int
+	// BlockSizes, when non-empty, is cycled through to size consecutive frames instead of BlockSize.
+	BlockSizes []int
+	// VariableBlocking sets the variable blocking strategy bit: frame headers then carry the
+	// first sample number instead of the frame number. Use it together with BlockSizes to
+	// produce conformant variable block size streams, or without it to produce non-conformant ones.
+	VariableBlocking bool
+	// ChannelAssignment selects stereo decorrelation for two-channel input.
+	ChannelAssignment FLACChannelAssignment
+	// Subframe applies to every subframe unless SubframeFunc is set.
+	Subframe FLACSubframeOptions
+	// SubframeFunc, when set, returns the options for a given frame and channel.
+	SubframeFunc func(frame, channel int) FLACSubframeOptions
+	// Metadata blocks are written after STREAMINFO, in order.
+	Metadata []FLACMetadataBlock
+	// Padding appends a PADDING block of that many bytes when positive.
+	Padding int
+}
+
+// flacFrameSpan locates an encoded frame within the output stream.
+type flacFrameSpan struct {
+	offset     int
+	headerSize int
+	size       int
+	blockSize  int
+}
+
+// flacStream is an encoded stream along with the layout information needed to damage it.
+type flacStream struct {
+	data             []byte
+	streamInfoOffset int
+	frames           []flacFrameSpan
+	format           Format
+	totalSamples     uint64
+}
+
+// EncodeFLAC encodes integer PCM (see Format) to a native FLAC stream.
+// The encoder trades compression for control: every structural choice can be forced through opts,
+// which makes it suitable for producing decoder edge cases that ffmpeg or libFLAC never emit.
+func EncodeFLAC(pcm []byte, format Format, opts FLACOptions) ([]byte, error) {
+	stream, err := encodeFLACStream(pcm, format, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return stream.data, nil
+}
+
+// WriteFLAC encodes pcm with EncodeFLAC and writes the result to path.
+func WriteFLAC(path string, pcm []byte, format Format, opts FLACOptions) error {
+	encoded, err := EncodeFLAC(pcm, format, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, encoded, filesystem.FilePermissionsPrivate); err != nil {
+		return fmt.Errorf("writing FLAC file: %w", err)
+	}
+
+	return nil
+}
+
+// NativeFLAC returns path to a FLAC file named name encoded from pcm by the native encoder.
+func NativeFLAC(data test.Data, helpers test.Helpers, name string, pcm []byte, format Format, opts FLACOptions) string {
+	helpers.T().Helper()
+
+	return NativeFLACGenerator(name, pcm, format, opts).Path(data, helpers)
+}
+
+// NativeFLACGenerator returns the generator of NativeFLAC. The fixture cache keys pcm by its SHA-256, and
+// is bypassed when opts sets a SubframeFunc, whose choices cannot be keyed.
+func NativeFLACGenerator(name string, pcm []byte, format Format, opts FLACOptions) Generator {
+	gen := nativeGenerator("NativeFLAC", name+".flac", func(path string) error {
+		return WriteFLAC(path, pcm, format, opts)
+	}, sha256Hex(pcm), fmt.Sprintf("%+v", format), fmt.Sprintf("%#v", opts))
+	gen.uncached = opts.SubframeFunc != nil
+
+	return gen
+}
+
+//nolint:gocognit,cyclop // sequential validation and assembly is easier to follow inline.
+func encodeFLACStream(pcm []byte, format Format, opts FLACOptions) (*flacStream, error) {
+	if err := validateFLACFormat(format); err != nil {
+		return nil, err
+	}
+
+	frameSize := format.FrameSize()
+	if len(pcm)%frameSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes is not a multiple of frame size %d",
+			ErrFLACUnsupported, len(pcm), frameSize)
+	}
+
+	totalSamples := len(pcm) / frameSize
+	if totalSamples > flacTotalSamplesMax {
+		return nil, fmt.Errorf("%w: %d samples", ErrFLACUnsupported, totalSamples)
+	}
+
+	if opts.ChannelAssignment != FLACChannelIndependent && format.Channels != 2 {
+		return nil, fmt.Errorf("%w: channel assignment %d needs two channels", ErrFLACOption, opts.ChannelAssignment)
+	}
+
+	sizes, err := flacBlockSizes(totalSamples, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := deinterleave(pcm, format)
+
+	stream := &flacStream{
+		streamInfoOffset: len("fLaC") + flacBlockHeaderSize,
+		format:           format,
+		totalSamples:     uint64(totalSamples), //nolint:gosec // G115: positive.
+	}
+
+	blocks := []FLACMetadataBlock{{Type: FLACBlockStreamInfo, Data: make([]byte, flacStreamInfoSize)}}
+	blocks = append(blocks, opts.Metadata...)
+
+	if opts.Padding > 0 {
+		blocks = append(blocks, FLACMetadataBlock{Type: FLACBlockPadding, Data: make([]byte, opts.Padding)})
+	}
+
+	out, err := encodeFLACMetadata(blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	minFrame, maxFrame := math.MaxInt, 0
+	position := 0
+
+	for frameIdx, size := range sizes {
+		block := make([][]int64, format.Channels)
+		for ch := range block {
+			block[ch] = channels[ch][position : position+size]
+		}
+
+		number := uint64(frameIdx) //nolint:gosec // G115: positive.
+		if opts.VariableBlocking {
+			number = uint64(position) //nolint:gosec // G115: positive.
+		}
+
+		frame, headerSize, err := encodeFLACFrame(block, format, opts, frameIdx, number)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", frameIdx, err)
+		}
+
+		stream.frames = append(stream.frames, flacFrameSpan{
+			offset:     len(out),
+			headerSize: headerSize,
+			size:       len(frame),
+			blockSize:  size,
+		})
+
+		out = append(out, frame...)
+		minFrame = min(minFrame, len(frame))
+		maxFrame = max(maxFrame, len(frame))
+		position += size
+	}
+
+	if len(sizes) == 0 {
+		minFrame = 0
+	}
+
+	minBlock, maxBlock := flacStreamInfoBlockSizes(sizes, opts)
+	sum := md5.Sum(pcm) //nolint:gosec // FLAC STREAMINFO mandates MD5.
+
+	copy(out[stream.streamInfoOffset:], flacStreamInfo(flacStreamInfoFields{
+		minBlockSize: minBlock,
+		maxBlockSize: maxBlock,
+		minFrameSize: minFrame,
+		maxFrameSize: maxFrame,
+		sampleRate:   format.SampleRate,
+		channels:     format.Channels,
+		bitDepth:     format.BitDepth,
+		totalSamples: stream.totalSamples,
+		md5:          sum,
+	}))
+
+	stream.data = out
+
+	return stream, nil
+}
+
+func validateFLACFormat(format Format) error {
+	if err := format.Validate(); err != nil {
+		return err
+	}
+
+	if format.Float || format.BitDepth < flacMinBitDepth || format.Channels > flacMaxChannels ||
+		format.SampleRate >= 1<<20 {
+		return fmt.Errorf("%w: %+v", ErrFLACUnsupported, format)
+	}
+
+	return nil
+}
+
+// flacBlockSizes splits totalSamples into frame sizes.
+func flacBlockSizes(totalSamples int, opts FLACOptions) ([]int, error) {
+	pattern := opts.BlockSizes
+	if len(pattern) == 0 {
+		blockSize := opts.BlockSize
+		if blockSize == 0 {
+			blockSize = DefaultFLACBlockSize
+		}
+
+		pattern = []int{blockSize}
+	}
+
+	for _, size := range pattern {
+		if size < 1 || size > flacMaxBlockSize {
+			return nil, fmt.Errorf("%w: block size %d", ErrFLACOption, size)
+		}
+	}
+
+	var sizes []int
+
+	for remaining, idx := totalSamples, 0; remaining > 0; idx++ {
+		size := min(pattern[idx%len(pattern)], remaining)
+		sizes = append(sizes, size)
+		remaining -= size
+	}
+
+	return sizes, nil
+}
+
+// flacStreamInfoBlockSizes returns the STREAMINFO min/max block sizes.
+// As in libFLAC, a fixed block size stream declares its nominal size even when the last block is shorter.
+func flacStreamInfoBlockSizes(sizes []int, opts FLACOptions) (minBlock, maxBlock int) {
+	if len(opts.BlockSizes) == 0 {
+		nominal := opts.BlockSize
+		if nominal == 0 {
+			nominal = DefaultFLACBlockSize
+		}
+
+		return nominal, nominal
+	}
+
+	counted := sizes
+	if len(sizes) > 1 {
+		counted = sizes[:len(sizes)-1]
+	}
+
+	minBlock, maxBlock = math.MaxInt, 0
+
+	for _, size := range counted {
+		minBlock = min(minBlock, size)
+		maxBlock = max(maxBlock, size)
+	}
+
+	if maxBlock == 0 {
+		return 0, 0
+	}
+
+	return minBlock, maxBlock
+}
+
+// deinterleave splits interleaved PCM into per-channel sample slices.
+func deinterleave(pcm []byte, format Format) [][]int64 {
+	bps := format.BytesPerSample()
+	numSamples := len(pcm) / format.FrameSize()
+	channels := make([][]int64, format.Channels)
+
+	for ch := range channels {
+		channels[ch] = make([]int64, numSamples)
+	}
+
+	for idx := range numSamples {
+		for ch := range format.Channels {
+			channels[ch][idx] = int64(pcmSampleAt(pcm[(idx*format.Channels+ch)*bps:], bps))
+		}
+	}
+
+	return channels
+}
+
+type flacStreamInfoFields struct {
+	minBlockSize int
+	maxBlockSize int
+	minFrameSize int
+	maxFrameSize int
+	sampleRate   int
+	channels     int
+	bitDepth     int
+	totalSamples uint64
+	md5          [md5.Size]byte
+}
+
+// flacStreamInfo serializes a STREAMINFO block body.
+func flacStreamInfo(fields flacStreamInfoFields) []byte {
+	var writer bitWriter
+
+	//nolint:gosec // G115: fields are bounded by the STREAMINFO field widths.
+	{
+		writer.write(uint64(fields.minBlockSize), 16)
+		writer.write(uint64(fields.maxBlockSize), 16)
+		writer.write(uint64(fields.minFrameSize), 24)
+		writer.write(uint64(fields.maxFrameSize), 24)
+		writer.write(uint64(fields.sampleRate), 20)
+		writer.write(uint64(fields.channels-1), 3)
+		writer.write(uint64(fields.bitDepth-1), 5)
+		writer.write(fields.totalSamples, 36)
+	}
+
+	return append(writer.bytes(), fields.md5[:]...)
+}
+
+// flacBlockHeader serializes a metadata block header.
+func flacBlockHeader(blockType byte, length int, last bool) []byte {
+	header := make([]byte, flacBlockHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(length)) //nolint:gosec // G115: checked against 24 bits.
+
+	header[0] = blockType
+	if last {
+		header[0] |= flacBlockLastFlag
+	}
+
+	return header
+}
+
+// encodeFLACFrame encodes one frame and returns it with its header size (CRC-8 included).
+func encodeFLACFrame(
+	block [][]int64,
+	format Format,
+	opts FLACOptions,
+	frameIdx int,
+	number uint64,
+) ([]byte, int, error) {
+	blockSize := len(block[0])
+
+	subframes, bitDepths := decorrelate(block, format.BitDepth, opts.ChannelAssignment)
+
+	header := flacFrameHeader(format, opts, blockSize, number)
+
+	var writer bitWriter
+
+	for _, b := range header {
+		writer.write(uint64(b), bitsPerByte)
+	}
+
+	for ch, samples := range subframes {
+		subOpts := opts.Subframe
+		if opts.SubframeFunc != nil {
+			subOpts = opts.SubframeFunc(frameIdx, ch)
+		}
+
+		sub, err := encodeFLACSubframe(samples, bitDepths[ch], subOpts)
+		if err != nil {
+			return nil, 0, fmt.Errorf("channel %d: %w", ch, err)
+		}
+
+		writer.appendBits(sub)
+	}
+
+	writer.align()
+
+	frame := writer.bytes()
+	frame = binary.BigEndian.AppendUint16(frame, crc16(frame))
+
+	return frame, len(header), nil
+}
+
+// decorrelate applies the channel assignment and returns the subframe samples with their bit depths.
+func decorrelate(block [][]int64, bitDepth int, assignment FLACChannelAssignment) ([][]int64, []int) {
+	if assignment == FLACChannelIndependent {
+		depths := make([]int, len(block))
+		for idx := range depths {
+			depths[idx] = bitDepth
+		}
+
+		return block, depths
+	}
+
+	left, right := block[0], block[1]
+	side := make([]int64, len(left))
+
+	for idx := range side {
+		side[idx] = left[idx] - right[idx]
+	}
+
+	switch assignment {
+	case FLACChannelLeftSide:
+		return [][]int64{left, side}, []int{bitDepth, bitDepth + 1}
+	case FLACChannelRightSide:
+		return [][]int64{side, right}, []int{bitDepth + 1, bitDepth}
+	default:
+		mid := make([]int64, len(left))
+		for idx := range mid {
+			mid[idx] = (left[idx] + right[idx]) >> 1
+		}
+
+		return [][]int64{mid, side}, []int{bitDepth, bitDepth + 1}
+	}
+}
+
+// flacFrameHeader serializes a frame header including its CRC-8.
+func flacFrameHeader(format Format, opts FLACOptions, blockSize int, number uint64) []byte {
+	var writer bitWriter
+
+	writer.write(flacSyncCode, 14)
+	writer.write(0, 1)
+
+	if opts.VariableBlocking {
+		writer.write(1, 1)
+	} else {
+		writer.write(0, 1)
+	}
+
+	sizeCode, sizeExtra, sizeExtraBits := flacBlockSizeCode(blockSize)
+	rateCode, rateExtra, rateExtraBits := flacSampleRateCode(format.SampleRate)
+
+	channelCode := uint64(format.Channels - 1) //nolint:gosec // G115: validated.
+	if opts.ChannelAssignment != FLACChannelIndependent {
+		channelCode = uint64(opts.ChannelAssignment) + flacChannelCodeOffset //nolint:gosec // G115: enum.
+	}
+
+	writer.write(sizeCode, 4)
+	writer.write(rateCode, 4)
+	writer.write(channelCode, 4)
+	writer.write(flacSampleSizeCode(format.BitDepth), 3)
+	writer.write(0, 1)
+
+	for _, b := range flacUTF8(number) {
+		writer.write(uint64(b), bitsPerByte)
+	}
+
+	writer.write(sizeExtra, sizeExtraBits)
+	writer.write(rateExtra, rateExtraBits)
+
+	header := writer.bytes()
+
+	return append(header, crc8(header))
+}
+
+// flacBlockSizeCode returns the 4-bit block size code and any trailing header field.
+func flacBlockSizeCode(blockSize int) (code, extra uint64, extraBits int) {
+	const (
+		flacBlockSize192 = 192
+		flacBlockSize576 = 576
+		flacBlockSize256 = 256
+	)
+
+	if blockSize == flacBlockSize192 {
+		return 1, 0, 0
+	}
+
+	for exp := range 4 {
+		if blockSize == flacBlockSize576<<exp {
+			return uint64(2 + exp), 0, 0 //nolint:gosec // G115: small.
+		}
+	}
+
+	for exp := range bitsPerByte {
+		if blockSize == flacBlockSize256<<exp {
+			return uint64(bitsPerByte + exp), 0, 0 //nolint:gosec // G115: small.
+		}
+	}
+
+	if blockSize <= flacBlockSize256 {
+		return flacHeaderSmallSize, uint64(blockSize - 1), flacHeaderExtra8Bits //nolint:gosec // G115: positive.
+	}
+
+	return flacHeaderLargeSize, uint64(blockSize - 1), flacHeaderExtra16Bits //nolint:gosec // G115: positive.
+}
+
+// flacSampleRateCode returns the 4-bit sample rate code and any trailing header field.
+func flacSampleRateCode(sampleRate int) (code, extra uint64, extraBits int) {
+	const (
+		hzPerKHz  = 1000
+		hzPerTens = 10
+	)
+
+	standard := map[int]uint64{
+		88200: 1, 176400: 2, 192000: 3, 8000: 4, 16000: 5, 22050: 6,
+		24000: 7, 32000: 8, 44100: 9, 48000: 10, 96000: 11,
+	}
+
+	if code, ok := standard[sampleRate]; ok {
+		return code, 0, 0
+	}
+
+	//nolint:gosec // G115: ranges checked in each branch.
+	switch {
+	case sampleRate%hzPerKHz == 0 && sampleRate/hzPerKHz <= math.MaxUint8:
+		return flacRateCodeKHz, uint64(sampleRate / hzPerKHz), flacHeaderExtra8Bits
+	case sampleRate <= math.MaxUint16:
+		return flacRateCodeHz, uint64(sampleRate), flacHeaderExtra16Bits
+	case sampleRate%hzPerTens == 0 && sampleRate/hzPerTens <= math.MaxUint16:
+		return flacRateCodeTensHz, uint64(sampleRate / hzPerTens), flacHeaderExtra16Bits
+	default:
+		return 0, 0, 0
+	}
+}
+
+// flacSampleSizeCode returns the 3-bit sample size code (zero defers to STREAMINFO).
+func flacSampleSizeCode(bitDepth int) uint64 {
+	switch bitDepth {
+	case BitDepth8:
+		return 1
+	case BitDepth12:
+		return 2
+	case BitDepth16:
+		return 4
+	case BitDepth20:
+		return 5
+	case BitDepth24:
+		return 6
+	case BitDepth32:
+		return 7
+	default:
+		return 0
+	}
+}
+
+// flacUTF8 encodes a frame or sample number with FLAC's extended UTF-8 scheme (up to 36 bits).
+func flacUTF8(val uint64) []byte {
+	const continuationBits = 6
+
+	if val < 0x80 {
+		return []byte{byte(val)}
+	}
+
+	// Number of continuation bytes needed for val.
+	extra := 1
+	for extra < continuationBits && val >= 1<<(continuationBits*extra+continuationBits-extra) {
+		extra++
+	}
+
+	out := make([]byte, extra+1)
+
+	for idx := extra; idx > 0; idx-- {
+		out[idx] = 0x80 | byte(val&0x3F)
+		val >>= continuationBits
+	}
+
+	lead := byte(0xFF << (bitsPerByte - extra - 1))
+	out[0] = lead | byte(val)
+
+	return out
+}
+
+// encodeFLACSubframe encodes one channel of a frame at bitDepth bits per sample.
+//
+//nolint:gocognit,cyclop // one case per subframe type.
+func encodeFLACSubframe(samples []int64, bitDepth int, opts FLACSubframeOptions) (*bitWriter, error) {
+	wasted := opts.WastedBits
+	if wasted < 0 || wasted >= bitDepth {
+		return nil, fmt.Errorf("%w: %d wasted bits at %d bits per sample", ErrFLACOption, wasted, bitDepth)
+	}
+
+	if wasted > 0 {
+		shifted := make([]int64, len(samples))
+
+		for idx, val := range samples {
+			if val&(1<<wasted-1) != 0 {
+				return nil, fmt.Errorf("%w: %d", ErrFLACWastedBits, wasted)
+			}
+
+			shifted[idx] = val >> wasted
+		}
+
+		samples = shifted
+		bitDepth -= wasted
+	}
+
+	order := opts.Order
+
+	switch opts.Type {
+	case FLACSubframeConstant:
+		for _, val := range samples {
+			if val != samples[0] {
+				return nil, ErrFLACNotConstant
+			}
+		}
+
+		return flacSubframeConstant(samples[0], bitDepth, wasted), nil
+	case FLACSubframeVerbatim:
+		return flacSubframeVerbatim(samples, bitDepth, wasted), nil
+	case FLACSubframeFixed:
+		if order < 0 || order > flacMaxFixedOrder {
+			return nil, fmt.Errorf("%w: fixed order %d", ErrFLACOption, order)
+		}
+
+		if order >= len(samples) {
+			return flacSubframeVerbatim(samples, bitDepth, wasted), nil
+		}
+
+		return flacSubframeFixed(samples, bitDepth, wasted, order, opts)
+	case FLACSubframeLPC:
+		if order == 0 {
+			order = flacDefaultLPCOrder
+		}
+
+		if order < 1 || order > flacMaxLPCOrder {
+			return nil, fmt.Errorf("%w: LPC order %d", ErrFLACOption, order)
+		}
+
+		if order >= len(samples) {
+			return flacSubframeVerbatim(samples, bitDepth, wasted), nil
+		}
+
+		return flacSubframeLPC(samples, bitDepth, wasted, order, opts)
+	default:
+		return flacSubframeAuto(samples, bitDepth, wasted, opts), nil
+	}
+}
+
+// flacSubframeAuto returns the smallest encoding among constant, verbatim and fixed orders 0-4.
+func flacSubframeAuto(samples []int64, bitDepth, wasted int, opts FLACSubframeOptions) *bitWriter {
+	constant := true
+
+	for _, val := range samples {
+		if val != samples[0] {
+			constant = false
+
+			break
+		}
+	}
+
+	if constant {
+		return flacSubframeConstant(samples[0], bitDepth, wasted)
+	}
+
+	best := flacSubframeVerbatim(samples, bitDepth, wasted)
+
+	for order := range min(flacMaxFixedOrder, len(samples)-1) + 1 {
+		candidate, err := flacSubframeFixed(samples, bitDepth, wasted, order, opts)
+		if err == nil && candidate.len() < best.len() {
+			best = candidate
+		}
+	}
+
+	return best
+}
+
+// flacSubframeHeader writes the zero pad bit, type code and wasted bits flag.
+func flacSubframeHeader(writer *bitWriter, typeCode uint64, wasted int) {
+	writer.write(0, 1)
+	writer.write(typeCode, 6)
+
+	if wasted == 0 {
+		writer.write(0, 1)
+
+		return
+	}
+
+	writer.write(1, 1)
+	writer.writeUnary(uint64(wasted - 1)) //nolint:gosec // G115: positive.
+}
+
+func flacSubframeConstant(val int64, bitDepth, wasted int) *bitWriter {
+	writer := &bitWriter{}
+	flacSubframeHeader(writer, 0, wasted)
+	writer.writeSigned(val, bitDepth)
+
+	return writer
+}
+
+func flacSubframeVerbatim(samples []int64, bitDepth, wasted int) *bitWriter {
+	writer := &bitWriter{}
+	flacSubframeHeader(writer, 1, wasted)
+
+	for _, val := range samples {
+		writer.writeSigned(val, bitDepth)
+	}
+
+	return writer
+}
+
+func flacSubframeFixed(
+	samples []int64,
+	bitDepth, wasted, order int,
+	opts FLACSubframeOptions,
+) (*bitWriter, error) {
+	residual := make([]int64, len(samples)-order)
+
+	for idx := order; idx < len(samples); idx++ {
+		var prediction int64
+
+		switch order {
+		case 1:
+			prediction = samples[idx-1]
+		case 2:
+			prediction = 2*samples[idx-1] - samples[idx-2]
+		case 3:
+			prediction = 3*samples[idx-1] - 3*samples[idx-2] + samples[idx-3]
+		case 4:
+			prediction = 4*samples[idx-1] - 6*samples[idx-2] + 4*samples[idx-3] - samples[idx-4]
+		default:
+		}
+
+		residual[idx-order] = samples[idx] - prediction
+	}
+
+	writer := &bitWriter{}
+	flacSubframeHeader(writer, uint64(0x08|order), wasted) //nolint:gosec // G115: order is 0-4.
+
+	for _, val := range samples[:order] {
+		writer.writeSigned(val, bitDepth)
+	}
+
+	if err := writeFLACResidual(writer, residual, len(samples), order, opts); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func flacSubframeLPC(
+	samples []int64,
+	bitDepth, wasted, order int,
+	opts FLACSubframeOptions,
+) (*bitWriter, error) {
+	precision := opts.Precision
+	if precision == 0 {
+		precision = flacDefaultPrecision
+	}
+
+	if precision < 1 || precision > flacMaxLPCPrecision {
+		return nil, fmt.Errorf("%w: LPC precision %d", ErrFLACOption, precision)
+	}
+
+	coefficients, shift := opts.Coefficients, opts.Shift
+
+	if coefficients == nil {
+		coefficients, shift = quantizeLPC(computeLPC(samples, order), precision)
+	} else if len(coefficients) != order || shift < 0 || shift > flacMaxShift {
+		return nil, fmt.Errorf("%w: %d coefficients for order %d, shift %d",
+			ErrFLACOption, len(coefficients), order, shift)
+	}
+
+	residual := make([]int64, len(samples)-order)
+
+	for idx := order; idx < len(samples); idx++ {
+		var sum int64
+
+		for coef, val := range coefficients {
+			sum += int64(val) * samples[idx-coef-1]
+		}
+
+		residual[idx-order] = samples[idx] - sum>>shift
+	}
+
+	writer := &bitWriter{}
+	flacSubframeHeader(writer, uint64(0x20|(order-1)), wasted) //nolint:gosec // G115: order is 1-32.
+
+	for _, val := range samples[:order] {
+		writer.writeSigned(val, bitDepth)
+	}
+
+	writer.write(uint64(precision-1), 4) //nolint:gosec // G115: validated.
+	writer.writeSigned(int64(shift), 5)
+
+	for _, val := range coefficients {
+		writer.writeSigned(int64(val), precision)
+	}
+
+	if err := writeFLACResidual(writer, residual, len(samples), order, opts); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// computeLPC derives predictor coefficients with a Tukey window, autocorrelation and Levinson-Durbin.
+func computeLPC(samples []int64, order int) []float64 {
+	count := len(samples)
+	windowed := make([]float64, count)
+	taper := int(flacTukeyRatio * float64(count) / 2)
+
+	for idx, val := range samples {
+		weight := 1.0
+
+		switch {
+		case idx < taper:
+			weight = 0.5 * (1 - math.Cos(math.Pi*float64(idx)/float64(taper)))
+		case idx >= count-taper:
+			weight = 0.5 * (1 - math.Cos(math.Pi*float64(count-1-idx)/float64(taper)))
+		default:
+		}
+
+		windowed[idx] = float64(val) * weight
+	}
+
+	autoc := make([]float64, order+1)
+
+	for lag := range autoc {
+		for idx := lag; idx < count; idx++ {
+			autoc[lag] += windowed[idx] * windowed[idx-lag]
+		}
+	}
+
+	lpc := make([]float64, order)
+	if autoc[0] == 0 {
+		return lpc
+	}
+
+	errPower := autoc[0]
+
+	for step := range order {
+		reflection := -autoc[step+1]
+		for idx := range step {
+			reflection -= lpc[idx] * autoc[step-idx]
+		}
+
+		reflection /= errPower
+
+		previous := append([]float64(nil), lpc[:step]...)
+		lpc[step] = reflection
+
+		for idx := range step {
+			lpc[idx] = previous[idx] + reflection*previous[step-1-idx]
+		}
+
+		errPower *= 1 - reflection*reflection
+		if errPower <= 0 {
+			break
+		}
+	}
+
+	// Levinson-Durbin yields error filter coefficients; predictor coefficients are their negation.
+	for idx := range lpc {
+		lpc[idx] = -lpc[idx]
+	}
+
+	return lpc
+}
+
+// quantizeLPC quantizes coefficients to precision bits, returning them with the right shift,
+// following libFLAC's error-feedback quantizer. Like libFLAC, the shift keeps one bit of sign headroom,
+// so that the largest coefficient is not clamped.
+func quantizeLPC(lpc []float64, precision int) ([]int32, int) {
+	quantized := make([]int32, len(lpc))
+
+	var cmax float64
+	for _, val := range lpc {
+		cmax = max(cmax, math.Abs(val))
+	}
+
+	if cmax == 0 {
+		return quantized, 0
+	}
+
+	_, log2cmax := math.Frexp(cmax)
+	// cmax < 2^log2cmax, so scaling by 2^(precision-1-log2cmax) keeps it below 2^(precision-1).
+	shift := min(max(precision-1-log2cmax, 0), flacMaxShift)
+
+	qmax := float64(int64(1)<<(precision-1) - 1)
+	qmin := -float64(int64(1) << (precision - 1))
+
+	var carry float64
+
+	for idx, val := range lpc {
+		carry += val * math.Ldexp(1, shift)
+		rounded := min(max(math.Round(carry), qmin), qmax)
+		carry -= rounded
+		quantized[idx] = int32(rounded)
+	}
+
+	return quantized, shift
+}
+
+// writeFLACResidual writes a partitioned Rice residual for a block of blockSize samples with predictor order.
+//
+//nolint:gocognit,cyclop // parameter selection per partition is inherently branchy.
+func writeFLACResidual(writer *bitWriter, residual []int64, blockSize, order int, opts FLACSubframeOptions) error {
+	for _, val := range residual {
+		if val > math.MaxInt32 || val < math.MinInt32 {
+			return fmt.Errorf("%w: %d", ErrFLACResidualRange, val)
+		}
+	}
+
+	partitionOrder := opts.PartitionOrder
+	if !opts.ForcePartitionOrder && partitionOrder == 0 {
+		partitionOrder = bestPartitionOrder(residual, blockSize, order, opts)
+	}
+
+	if partitionOrder < 0 || partitionOrder > flacMaxPartitionOrder {
+		return fmt.Errorf("%w: partition order %d", ErrFLACOption, partitionOrder)
+	}
+
+	// Like libFLAC, lower a forced order that the block cannot be split into (typically the last, short block).
+	for partitionOrder > 0 && (blockSize%(1<<partitionOrder) != 0 || blockSize>>partitionOrder < order) {
+		partitionOrder--
+	}
+
+	if opts.Escape && (opts.EscapeBits < 0 || opts.EscapeBits > flacMaxEscapeBits) {
+		return fmt.Errorf("%w: escape width %d", ErrFLACOption, opts.EscapeBits)
+	}
+
+	partitions := splitPartitions(residual, blockSize, order, partitionOrder)
+	params := make([]int, len(partitions))
+	rice2 := opts.Rice2
+
+	for idx, part := range partitions {
+		params[idx] = opts.RiceParameter
+		if params[idx] <= 0 {
+			params[idx], _ = bestRiceParameter(part)
+		}
+
+		if params[idx] > flacRiceMaxParam {
+			rice2 = true
+		}
+
+		if params[idx] > flacRice2MaxParam {
+			return fmt.Errorf("%w: Rice parameter %d", ErrFLACOption, params[idx])
+		}
+	}
+
+	paramBits, escape := 4, uint64(flacRiceEscape)
+	if rice2 {
+		writer.write(1, 2)
+
+		paramBits, escape = 5, flacRice2Escape
+	} else {
+		writer.write(0, 2)
+	}
+
+	writer.write(uint64(partitionOrder), 4) //nolint:gosec // G115: validated.
+
+	for idx, part := range partitions {
+		if opts.Escape {
+			width, needed := opts.EscapeBits, minSignedWidth(part)
+			if width == 0 {
+				width = needed
+			}
+
+			// The 5-bit width field cannot describe 32-bit residuals, and narrower widths truncate them.
+			if width > flacMaxEscapeBits || width < needed {
+				return fmt.Errorf("%w: escape width %d for residuals of %d bits", ErrFLACEscapeWidth, width, needed)
+			}
+
+			writer.write(escape, paramBits)
+			writer.write(uint64(width), flacEscapeBitsWidth) //nolint:gosec // G115: 5-bit field.
+
+			for _, val := range part {
+				writer.writeSigned(val, width)
+			}
+
+			continue
+		}
+
+		writer.write(uint64(params[idx]), paramBits) //nolint:gosec // G115: validated.
+
+		for _, val := range part {
+			writer.writeRice(val, params[idx])
+		}
+	}
+
+	return nil
+}
+
+// splitPartitions divides residual into 2^partitionOrder partitions; the first is shortened by order.
+func splitPartitions(residual []int64, blockSize, order, partitionOrder int) [][]int64 {
+	count := 1 << partitionOrder
+	size := blockSize >> partitionOrder
+	partitions := make([][]int64, count)
+	start := 0
+
+	for idx := range partitions {
+		length := size
+		if idx == 0 {
+			length -= order
+		}
+
+		partitions[idx] = residual[start : start+length]
+		start += length
+	}
+
+	return partitions
+}
+
+// bestPartitionOrder returns the partition order (up to 8) minimizing the Rice-coded size.
+func bestPartitionOrder(residual []int64, blockSize, order int, opts FLACSubframeOptions) int {
+	best, bestBits := 0, math.MaxInt
+
+	for partitionOrder := 0; partitionOrder <= flacAutoPartitionMax; partitionOrder++ {
+		if blockSize%(1<<partitionOrder) != 0 || blockSize>>partitionOrder < order {
+			break
+		}
+
+		bits := 0
+
+		for _, part := range splitPartitions(residual, blockSize, order, partitionOrder) {
+			param := opts.RiceParameter
+
+			var partBits int
+			if param > 0 {
+				partBits = riceBits(part, param)
+			} else {
+				_, partBits = bestRiceParameter(part)
+			}
+
+			bits += partBits + flacEscapeBitsWidth
+		}
+
+		if bits < bestBits {
+			best, bestBits = partitionOrder, bits
+		}
+	}
+
+	return best
+}
+
+// bestRiceParameter returns the Rice parameter minimizing the coded size of part, and that size in bits.
+func bestRiceParameter(part []int64) (param, bits int) {
+	param, bits = 0, riceBits(part, 0)
+
+	for candidate := 1; candidate <= flacRice2MaxParam; candidate++ {
+		candidateBits := riceBits(part, candidate)
+		if candidateBits < bits {
+			param, bits = candidate, candidateBits
+		}
+	}
+
+	return param, bits
+}
+
+func riceBits(part []int64, param int) int {
+	bits := len(part) * (param + 1)
+
+	for _, val := range part {
+		bits += int(zigzag(val) >> param) //nolint:gosec // G115: residuals fit 32 bits.
+	}
+
+	return bits
+}
+
+// minSignedWidth returns the smallest two's complement width holding every value: zero when they are
+// all zero, and at least one bit otherwise, since -1 needs a sign bit.
+func minSignedWidth(values []int64) int {
+	width := 0
+
+	for _, val := range values {
+		for val != 0 && width <= flacResidualBits &&
+			(width == 0 || val < -(1<<(width-1)) || val >= 1<<(width-1)) {
+			width++
+		}
+	}
+
+	return width
+}
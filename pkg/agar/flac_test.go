@@ -0,0 +1,174 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+func TestEncodeFLACEscapeBits(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 44100, BitDepth: 16, Channels: 1}
+
+	noise, err := agar.RenderPCM(format, 20*time.Millisecond, agar.WhiteNoise{Seed: 1, Level: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A staircase going down one step every third sample: its first order residuals are all 0 or -1.
+	staircase := make([]byte, 0, 2*4096)
+	for idx := range 4096 {
+		staircase = binary.LittleEndian.AppendUint16(staircase, uint16(-int16(idx/3))) //nolint:gosec // G115: wraps.
+	}
+
+	escape := func(bits int) agar.FLACOptions {
+		return agar.FLACOptions{Subframe: agar.FLACSubframeOptions{
+			Type: agar.FLACSubframeFixed, Order: 1, Escape: true, EscapeBits: bits,
+		}}
+	}
+
+	for bits, expected := range map[int]error{
+		0:  nil,
+		20: nil,
+		31: nil,
+		32: agar.ErrFLACOption,
+		-1: agar.ErrFLACOption,
+		2:  agar.ErrFLACEscapeWidth,
+	} {
+		encoded, err := agar.EncodeFLAC(noise, format, escape(bits))
+		if !errors.Is(err, expected) {
+			t.Errorf("EscapeBits %d: got %v, expected %v", bits, err, expected)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if _, decoded := decodeFLAC(t, encoded); !bytes.Equal(decoded, noise) {
+			t.Errorf("EscapeBits %d: decoded noise differs", bits)
+		}
+	}
+
+	for _, bits := range []int{0, 1, 31} {
+		encoded, err := agar.EncodeFLAC(staircase, format, escape(bits))
+		if err != nil {
+			t.Fatalf("EscapeBits %d: %v", bits, err)
+		}
+
+		if _, decoded := decodeFLAC(t, encoded); !bytes.Equal(decoded, staircase) {
+			t.Errorf("EscapeBits %d: decoded staircase differs", bits)
+		}
+	}
+}
+
+// TestEncodeFLACRoundTrip decodes streams written with each kind of subframe, channel assignment and
+// block size layout.
+func TestEncodeFLACRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	stereo := agar.Format{SampleRate: 44100, BitDepth: 16, Channels: 2}
+
+	pcm, err := agar.RenderPCM(stereo, 300*time.Millisecond,
+		agar.Mix{agar.Sine{Frequency: 440, Level: -6}, agar.WhiteNoise{Seed: 1, Level: -40}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subframe := func(opts agar.FLACSubframeOptions) agar.FLACOptions {
+		return agar.FLACOptions{Subframe: opts}
+	}
+
+	cases := map[string]agar.FLACOptions{
+		"default":      {},
+		"verbatim":     subframe(agar.FLACSubframeOptions{Type: agar.FLACSubframeVerbatim}),
+		"fixed":        subframe(agar.FLACSubframeOptions{Type: agar.FLACSubframeFixed, Order: 3, PartitionOrder: 3}),
+		"lpc":          subframe(agar.FLACSubframeOptions{Type: agar.FLACSubframeLPC, Order: 12}),
+		"lpc-32":       subframe(agar.FLACSubframeOptions{Type: agar.FLACSubframeLPC, Order: 32, Precision: 15}),
+		"rice2":        subframe(agar.FLACSubframeOptions{Type: agar.FLACSubframeFixed, Order: 1, Rice2: true}),
+		"left-side":    {ChannelAssignment: agar.FLACChannelLeftSide},
+		"right-side":   {ChannelAssignment: agar.FLACChannelRightSide},
+		"mid-side":     {ChannelAssignment: agar.FLACChannelMidSide},
+		"block-sizes":  {BlockSizes: []int{1, 17, 192, 4608, 1000}, VariableBlocking: true},
+		"metadata":     {Padding: 100, Metadata: []agar.FLACMetadataBlock{{Type: 2, Data: []byte("agar")}}},
+		"subframe-fun": {SubframeFunc: alternateSubframes},
+	}
+
+	for name, opts := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			encoded, err := agar.EncodeFLAC(pcm, stereo, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, decoded := decodeFLAC(t, encoded)
+			if info.sampleRate != 44100 || info.channels != 2 || info.bitDepth != 16 {
+				t.Errorf("STREAMINFO %+v", info)
+			}
+
+			if !bytes.Equal(decoded, pcm) {
+				t.Error("decoded audio differs")
+			}
+		})
+	}
+
+	for _, format := range []agar.Format{
+		{SampleRate: 8000, BitDepth: 8, Channels: 1},
+		{SampleRate: 12345, BitDepth: 12, Channels: 3},
+		{SampleRate: 96000, BitDepth: 24, Channels: 6},
+		{SampleRate: 192000, BitDepth: 32, Channels: 2},
+	} {
+		t.Run(fmt.Sprintf("%d-bit %d Hz", format.BitDepth, format.SampleRate), func(t *testing.T) {
+			t.Parallel()
+
+			chirp, err := agar.RenderPCM(format, 50*time.Millisecond, agar.Chirp{Start: 100, End: 3000, Level: -1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lpc := subframe(agar.FLACSubframeOptions{Type: agar.FLACSubframeLPC})
+
+			for _, opts := range []agar.FLACOptions{{}, lpc} {
+				encoded, err := agar.EncodeFLAC(chirp, format, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, decoded := decodeFLAC(t, encoded); !bytes.Equal(decoded, chirp) {
+					t.Errorf("%+v: decoded audio differs", opts.Subframe)
+				}
+			}
+		})
+	}
+}
+
+// alternateSubframes alternates verbatim and fixed subframes between frames and channels.
+func alternateSubframes(frame, channel int) agar.FLACSubframeOptions {
+	return agar.FLACSubframeOptions{
+		Type:  []agar.FLACSubframeType{agar.FLACSubframeVerbatim, agar.FLACSubframeFixed}[(frame+channel)%2],
+		Order: 1, PartitionOrder: 2,
+	}
+}
@@ -0,0 +1,385 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // FLAC STREAMINFO signatures are MD5.
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+var errFLACDecode = errors.New("invalid FLAC stream")
+
+// flacBits reads a FLAC stream MSB first.
+type flacBits struct {
+	data []byte
+	pos  int
+}
+
+func (r *flacBits) uint(width int) uint64 {
+	var val uint64
+
+	for range width {
+		if r.pos/8 >= len(r.data) {
+			panic(fmt.Sprintf("read past the end of a %d byte stream", len(r.data)))
+		}
+
+		val = val<<1 | uint64(r.data[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+
+	return val
+}
+
+func (r *flacBits) int(width int) int64 {
+	if width == 0 {
+		return 0
+	}
+
+	return int64(r.uint(width)<<(64-width)) >> (64 - width) //nolint:gosec // G115: sign extension.
+}
+
+func (r *flacBits) unary() uint64 {
+	var count uint64
+
+	for r.uint(1) == 0 {
+		count++
+	}
+
+	return count
+}
+
+// flacStreamInfo holds the STREAMINFO fields the tests check.
+type flacStreamInfo struct {
+	minBlock, maxBlock int
+	sampleRate         int
+	channels           int
+	bitDepth           int
+	totalSamples       uint64
+	md5                []byte
+}
+
+// decodeFLAC decodes a FLAC stream written by EncodeFLAC into interleaved PCM in the layout RenderPCM
+// uses, checking the frame CRCs and the STREAMINFO sample count and MD5 signature. It is a test oracle,
+// not a general decoder: it relies on STREAMINFO for the sample size.
+func decodeFLAC(t *testing.T, stream []byte) (flacStreamInfo, []byte) {
+	t.Helper()
+
+	info, samples, err := decodeFLACSamples(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	format := agar.Format{SampleRate: info.sampleRate, BitDepth: info.bitDepth, Channels: info.channels}
+	width := format.BytesPerSample()
+	pcm := make([]byte, 0, len(samples)*width)
+
+	for _, sample := range samples {
+		for idx := range width {
+			pcm = append(pcm, byte(sample>>(8*idx)))
+		}
+	}
+
+	if frames := uint64(len(samples) / max(info.channels, 1)); frames != info.totalSamples {
+		t.Errorf("STREAMINFO declares %d samples, stream holds %d", info.totalSamples, frames)
+	}
+
+	if sum := md5.Sum(pcm); !bytes.Equal(sum[:], info.md5) { //nolint:gosec // FLAC signature.
+		t.Errorf("STREAMINFO MD5 %x, decoded audio hashes to %x", info.md5, sum)
+	}
+
+	return info, pcm
+}
+
+func decodeFLACSamples(stream []byte) (flacStreamInfo, []int64, error) {
+	var info flacStreamInfo
+
+	if len(stream) < 4 || string(stream[:4]) != "fLaC" {
+		return info, nil, fmt.Errorf("%w: no fLaC marker", errFLACDecode)
+	}
+
+	pos := 4
+
+	for last := false; !last; {
+		header := stream[pos]
+		length := int(stream[pos+1])<<16 | int(stream[pos+2])<<8 | int(stream[pos+3])
+		body := stream[pos+4 : pos+4+length]
+		last = header&0x80 != 0
+		pos += 4 + length
+
+		if header&0x7F != 0 {
+			continue
+		}
+
+		reader := &flacBits{data: body}
+		info.minBlock, info.maxBlock = int(reader.uint(16)), int(reader.uint(16))
+		reader.uint(48) // frame sizes
+		info.sampleRate = int(reader.uint(20))
+		info.channels = int(reader.uint(3)) + 1
+		info.bitDepth = int(reader.uint(5)) + 1
+		info.totalSamples = reader.uint(36)
+		info.md5 = body[18:34]
+	}
+
+	var samples []int64
+
+	reader := &flacBits{data: stream, pos: pos * 8}
+	for reader.pos/8 < len(stream) {
+		frame, err := decodeFLACFrame(reader, info.bitDepth)
+		if err != nil {
+			return info, nil, err
+		}
+
+		for idx := range frame[0] {
+			for _, channel := range frame {
+				samples = append(samples, channel[idx])
+			}
+		}
+	}
+
+	return info, samples, nil
+}
+
+//nolint:cyclop,funlen // one field after the other.
+func decodeFLACFrame(reader *flacBits, bitDepth int) ([][]int64, error) {
+	start := reader.pos / 8
+
+	if sync := reader.uint(14); sync != 0x3FFE {
+		return nil, fmt.Errorf("%w: sync %#x at byte %d", errFLACDecode, sync, start)
+	}
+
+	reader.uint(2) // reserved bit and blocking strategy
+	blockSizeCode, rateCode := reader.uint(4), reader.uint(4)
+	assignment := int(reader.uint(4))
+	reader.uint(4) // sample size and reserved bit
+
+	// UTF-8 coded frame or sample number.
+	for first, mask := reader.uint(8), uint64(0x40); first&0x80 != 0 && first&mask != 0; mask >>= 1 {
+		reader.uint(8)
+	}
+
+	var blockSize int
+
+	switch {
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		blockSize = int(reader.uint(8)) + 1
+	case blockSizeCode == 7:
+		blockSize = int(reader.uint(16)) + 1
+	default:
+		blockSize = 256 << (blockSizeCode - 8)
+	}
+
+	switch rateCode {
+	case 12:
+		reader.uint(8)
+	case 13, 14:
+		reader.uint(16)
+	}
+
+	if crc := flacCRC8(reader.data[start : reader.pos/8]); crc != byte(reader.uint(8)) {
+		return nil, fmt.Errorf("%w: header CRC-8 of the frame at byte %d", errFLACDecode, start)
+	}
+
+	channels := assignment + 1
+	if assignment >= 8 {
+		channels = 2
+	}
+
+	frame := make([][]int64, channels)
+
+	for channel := range frame {
+		depth := bitDepth
+		// The side channel carries one more bit.
+		if assignment == 8 && channel == 1 || assignment == 9 && channel == 0 || assignment == 10 && channel == 1 {
+			depth++
+		}
+
+		subframe, err := decodeFLACSubframe(reader, blockSize, depth)
+		if err != nil {
+			return nil, fmt.Errorf("frame at byte %d, channel %d: %w", start, channel, err)
+		}
+
+		frame[channel] = subframe
+	}
+
+	for idx := range blockSize {
+		switch assignment {
+		case 8: // left/side
+			frame[1][idx] = frame[0][idx] - frame[1][idx]
+		case 9: // side/right
+			frame[0][idx] += frame[1][idx]
+		case 10: // mid/side
+			mid := frame[0][idx]<<1 | frame[1][idx]&1
+			frame[0][idx], frame[1][idx] = (mid+frame[1][idx])>>1, (mid-frame[1][idx])>>1
+		}
+	}
+
+	reader.pos = (reader.pos + 7) / 8 * 8
+	if crc := flacCRC16(reader.data[start : reader.pos/8]); crc != uint16(reader.uint(16)) {
+		return nil, fmt.Errorf("%w: footer CRC-16 of the frame at byte %d", errFLACDecode, start)
+	}
+
+	return frame, nil
+}
+
+//nolint:cyclop // one case per subframe type.
+func decodeFLACSubframe(reader *flacBits, blockSize, depth int) ([]int64, error) {
+	reader.uint(1) // padding
+	kind := int(reader.uint(6))
+
+	wasted := 0
+	if reader.uint(1) == 1 {
+		wasted = int(reader.unary()) + 1
+	}
+
+	depth -= wasted
+	samples := make([]int64, blockSize)
+
+	var (
+		order        int
+		coefficients []int64
+		shift        int64
+	)
+
+	switch {
+	case kind == 0:
+		val := reader.int(depth)
+		for idx := range samples {
+			samples[idx] = val
+		}
+	case kind == 1:
+		for idx := range samples {
+			samples[idx] = reader.int(depth)
+		}
+	case kind >= 8 && kind <= 12:
+		order = kind - 8
+		coefficients = [][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}[order]
+	case kind >= 32:
+		order = kind - 31
+	default:
+		return nil, fmt.Errorf("%w: subframe type %d", errFLACDecode, kind)
+	}
+
+	if kind >= 8 {
+		for idx := range order {
+			samples[idx] = reader.int(depth)
+		}
+
+		if kind >= 32 {
+			precision := int(reader.uint(4)) + 1
+			shift = reader.int(5)
+
+			coefficients = make([]int64, order)
+			for idx := range coefficients {
+				coefficients[idx] = reader.int(precision)
+			}
+		}
+
+		residuals := decodeFLACResidual(reader, blockSize, order)
+
+		for idx := order; idx < blockSize; idx++ {
+			var prediction int64
+			for lag, coefficient := range coefficients {
+				prediction += coefficient * samples[idx-lag-1]
+			}
+
+			samples[idx] = residuals[idx-order] + prediction>>shift
+		}
+	}
+
+	for idx := range samples {
+		samples[idx] <<= wasted
+	}
+
+	return samples, nil
+}
+
+func decodeFLACResidual(reader *flacBits, blockSize, order int) []int64 {
+	paramWidth, escape := 4, uint64(15)
+	if reader.uint(2) == 1 {
+		paramWidth, escape = 5, 31
+	}
+
+	partitionOrder := int(reader.uint(4))
+	residuals := make([]int64, 0, blockSize-order)
+
+	for partition := range 1 << partitionOrder {
+		count := blockSize >> partitionOrder
+		if partition == 0 {
+			count -= order
+		}
+
+		param := reader.uint(paramWidth)
+		if param == escape {
+			width := int(reader.uint(5))
+			for range count {
+				residuals = append(residuals, reader.int(width))
+			}
+
+			continue
+		}
+
+		for range count {
+			folded := reader.unary()<<param | reader.uint(int(param))
+			residuals = append(residuals, int64(folded>>1)^-int64(folded&1)) //nolint:gosec // G115: zigzag.
+		}
+	}
+
+	return residuals
+}
+
+func flacCRC8(data []byte) byte {
+	var crc byte
+
+	for _, val := range data {
+		crc ^= val
+		for range 8 {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+
+	for _, val := range data {
+		crc ^= uint16(val) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
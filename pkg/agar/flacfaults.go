@@ -0,0 +1,402 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+
+	"github.com/mycophonic/primordium/filesystem"
+)
+
+// FLACFault identifies a structural fault injected into a FLAC stream.
+type FLACFault int
+
+// Injectable FLAC faults. Frame-level faults damage the middle frame of the stream so that
+// decoders able to resynchronize still find valid frames on both sides.
+const (
+	// FLACFaultHeaderCRC corrupts the CRC-8 of a frame header.
+	FLACFaultHeaderCRC FLACFault = iota
+	// FLACFaultFooterCRC corrupts the CRC-16 of a frame footer.
+	FLACFaultFooterCRC
+	// FLACFaultSync corrupts the sync code of a frame.
+	FLACFaultSync
+	// FLACFaultTotalSamples makes STREAMINFO declare one second more than the stream holds.
+	FLACFaultTotalSamples
+	// FLACFaultMD5 makes the STREAMINFO MD5 signature disagree with the decoded audio.
+	FLACFaultMD5
+	// FLACFaultBlockSizes makes STREAMINFO declare min/max block sizes smaller than the largest frames.
+	FLACFaultBlockSizes
+	// FLACFaultMissingStreamInfo removes STREAMINFO, leaving a PADDING block as the only metadata.
+	FLACFaultMissingStreamInfo
+	// FLACFaultBlockLength makes the length of a PADDING block run into the first audio frame.
+	FLACFaultBlockLength
+	// FLACFaultTruncatedFrame cuts the stream in the middle of a frame.
+	FLACFaultTruncatedFrame
+)
+
+const (
+	// malformedBlockSize is small enough for a few seconds of audio to span many frames.
+	malformedBlockSize = 4096
+	// malformedPadding is the PADDING block size used by the metadata faults.
+	malformedPadding = 256
+	// malformedLengthOverrun is how far a lying PADDING block length reaches into the first frame.
+	malformedLengthOverrun = 100
+	// flacMinLegalBlockSize is the smallest block size STREAMINFO may declare.
+	flacMinLegalBlockSize = 16
+
+	streamInfoTotalSamplesOffset = 14
+	streamInfoMD5Offset          = 18
+	streamInfoMinBlockOffset     = 0
+	streamInfoMaxBlockOffset     = 2
+	blockHeaderLengthOffset      = 1
+	totalSamplesLowBits          = 32
+	totalSamplesHighMask         = 0x0F
+)
+
+// ErrUnknownFLACFault is returned for faults outside the FLACFault constants.
+var ErrUnknownFLACFault = errors.New("unknown FLAC fault")
+
+// flacFaultNames maps faults to their kebab-case name and to the fixture function injecting them.
+//
+//nolint:gochecknoglobals // lookup table
+var flacFaultNames = map[FLACFault]struct{ name, fixture string }{
+	FLACFaultHeaderCRC:         {"bad-header-crc", "FLACBadHeaderCRC"},
+	FLACFaultFooterCRC:         {"bad-footer-crc", "FLACBadFooterCRC"},
+	FLACFaultSync:              {"lost-sync", "FLACLostSync"},
+	FLACFaultTotalSamples:      {"wrong-total-samples", "FLACWrongTotalSamples"},
+	FLACFaultMD5:               {"wrong-md5", "FLACWrongMD5"},
+	FLACFaultBlockSizes:        {"wrong-block-sizes", "FLACWrongBlockSizes"},
+	FLACFaultMissingStreamInfo: {"missing-streaminfo", "FLACMissingStreamInfo"},
+	FLACFaultBlockLength:       {"block-length-lie", "FLACBlockLengthLie"},
+	FLACFaultTruncatedFrame:    {"truncated-mid-frame", "FLACTruncatedMidFrame"},
+}
+
+// FLACFaults lists every injectable fault.
+func FLACFaults() []FLACFault {
+	return []FLACFault{
+		FLACFaultHeaderCRC,
+		FLACFaultFooterCRC,
+		FLACFaultSync,
+		FLACFaultTotalSamples,
+		FLACFaultMD5,
+		FLACFaultBlockSizes,
+		FLACFaultMissingStreamInfo,
+		FLACFaultBlockLength,
+		FLACFaultTruncatedFrame,
+	}
+}
+
+// String returns the kebab-case name of the fault, as used in fixture file names.
+func (fault FLACFault) String() string {
+	if names, ok := flacFaultNames[fault]; ok {
+		return names.name
+	}
+
+	return fmt.Sprintf("flac-fault-%d", int(fault))
+}
+
+// MalformedFLAC is a structurally damaged FLAC file along with a description of the damage.
+type MalformedFLAC struct {
+	Path  string
+	Fault FLACFault
+	// Description says what was damaged, where, and what the correct value would have been.
+	Description string
+}
+
+// EncodeMalformedFLAC encodes pcm with EncodeFLAC and injects fault into the result.
+// It returns the damaged stream and a description of the injected fault.
+func EncodeMalformedFLAC(pcm []byte, format Format, opts FLACOptions, fault FLACFault) ([]byte, string, error) {
+	if fault == FLACFaultMissingStreamInfo || fault == FLACFaultBlockLength {
+		// Both faults need a metadata block following STREAMINFO.
+		opts.Padding = max(opts.Padding, malformedPadding)
+	}
+
+	stream, err := encodeFLACStream(pcm, format, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	needsFrame := fault == FLACFaultHeaderCRC || fault == FLACFaultFooterCRC ||
+		fault == FLACFaultSync || fault == FLACFaultTruncatedFrame
+	if needsFrame && len(stream.frames) == 0 {
+		return nil, "", fmt.Errorf("%w: %s needs at least one audio frame", ErrFLACOption, fault)
+	}
+
+	return injectFLACFault(stream, fault)
+}
+
+// WriteMalformedFLAC writes a damaged FLAC stream (see EncodeMalformedFLAC) to path and returns the fault description.
+func WriteMalformedFLAC(path string, pcm []byte, format Format, opts FLACOptions, fault FLACFault) (string, error) {
+	encoded, description, err := EncodeMalformedFLAC(pcm, format, opts, fault)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(path, encoded, filesystem.FilePermissionsPrivate); err != nil {
+		return "", fmt.Errorf("writing FLAC file: %w", err)
+	}
+
+	return description, nil
+}
+
+// MalformedFLACFile returns a 3 second 16-bit stereo 440Hz sine FLAC file damaged with fault.
+func MalformedFLACFile(data test.Data, helpers test.Helpers, fault FLACFault) MalformedFLAC {
+	helpers.T().Helper()
+
+	ctx, cancel := ToolContext(helpers.T())
+	defer cancel()
+
+	malformed, err := GenerateMalformedFLAC(ctx, data.Temp().Dir(), fault)
+	failOnError(helpers, err)
+
+	return malformed
+}
+
+// GenerateMalformedFLAC generates the file of MalformedFLACFile in dir.
+func GenerateMalformedFLAC(ctx context.Context, dir string, fault FLACFault) (MalformedFLAC, error) {
+	_, description, err := encodeMalformedFixture(fault)
+	if err != nil {
+		return MalformedFLAC{}, err
+	}
+
+	path, err := MalformedFLACGenerator(fault).Generate(ctx, dir)
+	if err != nil {
+		return MalformedFLAC{}, err
+	}
+
+	return MalformedFLAC{Path: path, Fault: fault, Description: description}, nil
+}
+
+// MalformedFLACGenerator returns the generator of MalformedFLACFile, named after the fixture function
+// injecting fault, such as "FLACBadHeaderCRC".
+func MalformedFLACGenerator(fault FLACFault) Generator {
+	name := "MalformedFLACFile"
+	if names, ok := flacFaultNames[fault]; ok {
+		name = names.fixture
+	}
+
+	return nativeGenerator(name, "malformed-"+fault.String()+".flac", func(path string) error {
+		encoded, _, err := encodeMalformedFixture(fault)
+		if err != nil {
+			return err
+		}
+
+		if err = os.WriteFile(path, encoded, filesystem.FilePermissionsPrivate); err != nil {
+			return fmt.Errorf("writing FLAC file: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// encodeMalformedFixture encodes the audio of MalformedFLACFile and injects fault into it.
+func encodeMalformedFixture(fault FLACFault) ([]byte, string, error) {
+	format := Format{SampleRate: 44100, BitDepth: BitDepth16, Channels: 2}
+
+	pcm, err := RenderPCM(format, shortFixtureDuration, Sine{Frequency: 440, Level: -6})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return EncodeMalformedFLAC(pcm, format, FLACOptions{BlockSize: malformedBlockSize}, fault)
+}
+
+// MalformedFLACFiles returns one damaged FLAC file per fault listed in FLACFaults.
+func MalformedFLACFiles(data test.Data, helpers test.Helpers) []MalformedFLAC {
+	helpers.T().Helper()
+
+	faults := FLACFaults()
+	files := make([]MalformedFLAC, 0, len(faults))
+
+	for _, fault := range faults {
+		files = append(files, MalformedFLACFile(data, helpers, fault))
+	}
+
+	return files
+}
+
+// FLACBadHeaderCRC returns a FLAC file whose middle frame has a corrupted header CRC-8.
+func FLACBadHeaderCRC(data test.Data, helpers test.Helpers) MalformedFLAC {
+	helpers.T().Helper()
+
+	return MalformedFLACFile(data, helpers, FLACFaultHeaderCRC)
+}
+
+// FLACBadFooterCRC returns a FLAC file whose middle frame has a corrupted footer CRC-16.
+func FLACBadFooterCRC(data test.Data, helpers test.Helpers) MalformedFLAC {
+	helpers.T().Helper()
+
+	return MalformedFLACFile(data, helpers, FLACFaultFooterCRC)
+}
+
+// FLACLostSync returns a FLAC file whose middle frame has a corrupted sync code.
+func FLACLostSync(data test.Data, helpers test.Helpers) MalformedFLAC {
+	helpers.T().Helper()
+
+	return MalformedFLACFile(data, helpers, FLACFaultSync)
+}
+
+// FLACWrongTotalSamples returns a FLAC file whose STREAMINFO declares one second more audio than present.
+func FLACWrongTotalSamples(data test.Data, helpers test.Helpers) MalformedFLAC {
+	helpers.T().Helper()
+
+	return MalformedFLACFile(data, helpers, FLACFaultTotalSamples)
+}
+
+// FLACWrongMD5 returns a FLAC file whose STREAMINFO MD5 does not match the audio.
+func FLACWrongMD5(data test.Data, helpers test.Helpers) MalformedFLAC {
+	helpers.T().Helper()
+
+	return MalformedFLACFile(data, helpers, FLACFaultMD5)
+}
+
+// FLACWrongBlockSizes returns a FLAC file whose STREAMINFO declares block sizes smaller than its frames.
+func FLACWrongBlockSizes(data test.Data, helpers test.Helpers) MalformedFLAC {
+	helpers.T().Helper()
+
+	return MalformedFLACFile(data, helpers, FLACFaultBlockSizes)
+}
+
+// FLACMissingStreamInfo returns a FLAC file without a STREAMINFO block.
+func FLACMissingStreamInfo(data test.Data, helpers test.Helpers) MalformedFLAC {
+	helpers.T().Helper()
+
+	return MalformedFLACFile(data, helpers, FLACFaultMissingStreamInfo)
+}
+
+// FLACBlockLengthLie returns a FLAC file whose PADDING block length runs into the first audio frame.
+func FLACBlockLengthLie(data test.Data, helpers test.Helpers) MalformedFLAC {
+	helpers.T().Helper()
+
+	return MalformedFLACFile(data, helpers, FLACFaultBlockLength)
+}
+
+// FLACTruncatedMidFrame returns a FLAC file cut in the middle of a frame.
+func FLACTruncatedMidFrame(data test.Data, helpers test.Helpers) MalformedFLAC {
+	helpers.T().Helper()
+
+	return MalformedFLACFile(data, helpers, FLACFaultTruncatedFrame)
+}
+
+//nolint:cyclop,funlen // one case per fault.
+func injectFLACFault(stream *flacStream, fault FLACFault) ([]byte, string, error) {
+	out := stream.data
+	info := out[stream.streamInfoOffset : stream.streamInfoOffset+flacStreamInfoSize]
+
+	var (
+		frameIdx int
+		frame    flacFrameSpan
+	)
+
+	if len(stream.frames) > 0 {
+		frameIdx = len(stream.frames) / 2
+		frame = stream.frames[frameIdx]
+	}
+
+	switch fault {
+	case FLACFaultHeaderCRC:
+		pos := frame.offset + frame.headerSize - 1
+		original := out[pos]
+		out[pos] ^= 0xFF
+
+		return out, fmt.Sprintf("frame %d at byte %d: header CRC-8 is 0x%02X, should be 0x%02X",
+			frameIdx, frame.offset, out[pos], original), nil
+	case FLACFaultFooterCRC:
+		pos := frame.offset + frame.size - 2
+		original := binary.BigEndian.Uint16(out[pos:])
+		binary.BigEndian.PutUint16(out[pos:], ^original)
+
+		return out, fmt.Sprintf("frame %d at byte %d: footer CRC-16 is 0x%04X, should be 0x%04X",
+			frameIdx, frame.offset, ^original, original), nil
+	case FLACFaultSync:
+		original := binary.BigEndian.Uint16(out[frame.offset:])
+		out[frame.offset] = 0
+
+		return out, fmt.Sprintf("frame %d at byte %d: sync bytes are 0x%04X, should be 0x%04X",
+			frameIdx, frame.offset, binary.BigEndian.Uint16(out[frame.offset:]), original), nil
+	case FLACFaultTotalSamples:
+		actual := stream.totalSamples
+		declared := actual + uint64(stream.format.SampleRate) //nolint:gosec // G115: positive.
+
+		info[streamInfoTotalSamplesOffset-1] = info[streamInfoTotalSamplesOffset-1]&^totalSamplesHighMask |
+			byte(declared>>totalSamplesLowBits)&totalSamplesHighMask
+		binary.BigEndian.PutUint32(info[streamInfoTotalSamplesOffset:], uint32(declared)) //nolint:gosec // G115: low bits.
+
+		return out, fmt.Sprintf("STREAMINFO declares %d total samples, stream holds %d", declared, actual), nil
+	case FLACFaultMD5:
+		original := fmt.Sprintf("%x", info[streamInfoMD5Offset:])
+
+		for idx := streamInfoMD5Offset; idx < flacStreamInfoSize; idx++ {
+			info[idx] ^= 0xFF
+		}
+
+		return out, fmt.Sprintf("STREAMINFO MD5 is %x, decoded audio hashes to %s",
+			info[streamInfoMD5Offset:], original), nil
+	case FLACFaultBlockSizes:
+		minBlock := binary.BigEndian.Uint16(info[streamInfoMinBlockOffset:])
+		maxBlock := binary.BigEndian.Uint16(info[streamInfoMaxBlockOffset:])
+
+		// Half the largest block size, kept legal: the largest frames then exceed the declared maximum.
+		// With varying BlockSizes, smaller frames may still fit within it.
+		declared := max(maxBlock/2, flacMinLegalBlockSize)
+		if declared >= maxBlock {
+			return nil, "", fmt.Errorf("%w: %s needs blocks larger than %d samples",
+				ErrFLACOption, fault, flacMinLegalBlockSize)
+		}
+
+		binary.BigEndian.PutUint16(info[streamInfoMinBlockOffset:], declared)
+		binary.BigEndian.PutUint16(info[streamInfoMaxBlockOffset:], declared)
+
+		return out, fmt.Sprintf("STREAMINFO declares min/max block size %d/%d, frames use %d/%d",
+			declared, declared, minBlock, maxBlock), nil
+	case FLACFaultMissingStreamInfo:
+		start := stream.streamInfoOffset - flacBlockHeaderSize
+		damaged := append(out[:start:start], out[stream.streamInfoOffset+flacStreamInfoSize:]...)
+
+		return damaged, fmt.Sprintf("STREAMINFO block removed, first metadata block is type %d",
+			damaged[start]&^0x80), nil
+	case FLACFaultBlockLength:
+		// The block following STREAMINFO is the padding added by EncodeMalformedFLAC or any caller metadata.
+		header := stream.streamInfoOffset + flacStreamInfoSize
+		length := int(binary.BigEndian.Uint32(out[header:]) & flacMaxBlockLength)
+
+		firstFrame := len(out)
+		if len(stream.frames) > 0 {
+			firstFrame = stream.frames[0].offset
+		}
+
+		declared := firstFrame - header - flacBlockHeaderSize + malformedLengthOverrun
+		lengthField := binary.BigEndian.AppendUint32(nil, uint32(declared)) //nolint:gosec // G115: small.
+		copy(out[header+blockHeaderLengthOffset:header+flacBlockHeaderSize], lengthField[1:])
+
+		return out, fmt.Sprintf("metadata block type %d at byte %d declares %d bytes, holds %d",
+			out[header]&^0x80, header, declared, length), nil
+	case FLACFaultTruncatedFrame:
+		cut := frame.offset + frame.size/2
+
+		return out[:cut], fmt.Sprintf("stream truncated at byte %d, %d bytes into the %d byte frame %d",
+			cut, frame.size/2, frame.size, frameIdx), nil
+	default:
+		return nil, "", fmt.Errorf("%w: %d", ErrUnknownFLACFault, int(fault))
+	}
+}
@@ -0,0 +1,115 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+func TestFLACFaultBlockSizesDeclaresSmallerBlocks(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 44100, BitDepth: 16, Channels: 2}
+
+	pcm, err := agar.RenderPCM(format, 200*time.Millisecond, agar.Sine{Frequency: 440, Level: -6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, blockSize := range []int{4096, 1024, 256, 32, 17} {
+		t.Run(strconv.Itoa(blockSize), func(t *testing.T) {
+			t.Parallel()
+
+			encoded, description, err := agar.EncodeMalformedFLAC(pcm, format,
+				agar.FLACOptions{BlockSize: blockSize}, agar.FLACFaultBlockSizes)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// STREAMINFO follows the "fLaC" marker and its 4-byte block header.
+			declaredMin := int(binary.BigEndian.Uint16(encoded[8:]))
+			declaredMax := int(binary.BigEndian.Uint16(encoded[10:]))
+
+			if declaredMax >= blockSize || declaredMin > declaredMax || declaredMin < 16 {
+				t.Errorf("declared %d/%d for %d-sample blocks: %s", declaredMin, declaredMax, blockSize, description)
+			}
+		})
+	}
+
+	_, _, err = agar.EncodeMalformedFLAC(pcm, format, agar.FLACOptions{BlockSize: 16}, agar.FLACFaultBlockSizes)
+	if !errors.Is(err, agar.ErrFLACOption) {
+		t.Errorf("16-sample blocks: got %v, expected ErrFLACOption", err)
+	}
+}
+
+// TestMalformedFLACFixtures checks that every fault has a catalog fixture whose file GenerateMalformedFLAC
+// writes and Verify recognizes.
+func TestMalformedFLACFixtures(t *testing.T) {
+	t.Parallel()
+
+	for _, fault := range agar.FLACFaults() {
+		t.Run(fault.String(), func(t *testing.T) {
+			t.Parallel()
+
+			gen := agar.MalformedFLACGenerator(fault)
+
+			fixture, ok := agar.Fixtures().Lookup(gen.Name)
+			if !ok || !fixture.Malformed || fixture.File != gen.File {
+				t.Fatalf("%s: not a malformed catalog fixture: %+v", gen.Name, fixture)
+			}
+
+			malformed, err := agar.GenerateMalformedFLAC(context.Background(), t.TempDir(), fault)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if malformed.Fault != fault || malformed.Description == "" {
+				t.Errorf("got %+v", malformed)
+			}
+
+			if err = fixture.Verify(context.Background(), malformed.Path); err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+
+			content, err := os.ReadFile(malformed.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content[len(content)-1] ^= 0xFF
+			if err = os.WriteFile(malformed.Path, content, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = fixture.Verify(context.Background(), malformed.Path); !errors.Is(err, agar.ErrFixtureProperty) {
+				t.Errorf("Verify of a modified file: got %v, expected ErrFixtureProperty", err)
+			}
+		})
+	}
+
+	if agar.Fixtures().Where(agar.Audio, agar.Malformed) != nil {
+		t.Error("Audio selects malformed fixtures")
+	}
+}
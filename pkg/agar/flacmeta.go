@@ -0,0 +1,155 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const (
+	flacBlockLastFlag = 0x80
+	flacBlockTypeMask = 0x7F
+	// flacPictureImageSize covers the width, height, color depth and color count of a PICTURE block.
+	flacPictureImageSize = 16
+
+	// vorbisVendor is the vendor string of the comment headers written by agar.
+	vorbisVendor = "agar"
+)
+
+// ErrFLACMalformed is returned when the metadata blocks of a FLAC file cannot be parsed.
+var ErrFLACMalformed = errors.New("malformed FLAC file")
+
+// parseFLACMetadata splits a FLAC file into its metadata blocks (STREAMINFO included) and audio frames.
+func parseFLACMetadata(content []byte) ([]FLACMetadataBlock, []byte, error) {
+	if len(content) < len("fLaC") || string(content[:4]) != "fLaC" {
+		return nil, nil, fmt.Errorf("%w: missing fLaC marker", ErrFLACMalformed)
+	}
+
+	var blocks []FLACMetadataBlock
+
+	rest := content[4:]
+
+	for {
+		if len(rest) < flacBlockHeaderSize {
+			return nil, nil, fmt.Errorf("%w: truncated metadata block header", ErrFLACMalformed)
+		}
+
+		header := rest[0]
+		length := int(binary.BigEndian.Uint32(rest) & flacMaxBlockLength)
+
+		if len(rest) < flacBlockHeaderSize+length {
+			return nil, nil, fmt.Errorf("%w: truncated metadata block", ErrFLACMalformed)
+		}
+
+		blocks = append(blocks, FLACMetadataBlock{
+			Type: header & flacBlockTypeMask,
+			Data: rest[flacBlockHeaderSize : flacBlockHeaderSize+length],
+		})
+		rest = rest[flacBlockHeaderSize+length:]
+
+		if header&flacBlockLastFlag != 0 {
+			return blocks, rest, nil
+		}
+	}
+}
+
+// encodeFLACMetadata serializes blocks after the fLaC marker, flagging the last one.
+func encodeFLACMetadata(blocks []FLACMetadataBlock) ([]byte, error) {
+	out := []byte("fLaC")
+
+	for idx, block := range blocks {
+		if len(block.Data) > flacMaxBlockLength {
+			return nil, fmt.Errorf("%w: metadata block of %d bytes", ErrFLACOption, len(block.Data))
+		}
+
+		out = append(out, flacBlockHeader(block.Type, len(block.Data), idx == len(blocks)-1)...)
+		out = append(out, block.Data...)
+	}
+
+	return out, nil
+}
+
+// replaceFLACTags replaces the VORBIS_COMMENT and PICTURE blocks of a FLAC file, keeping the other
+// blocks in order and any padding last.
+func replaceFLACTags(content []byte, comments []string, pictures []Picture) ([]byte, error) {
+	blocks, frames, err := parseFLACMetadata(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept, padding []FLACMetadataBlock
+
+	for _, block := range blocks {
+		switch block.Type {
+		case FLACBlockVorbisComment, FLACBlockPicture:
+		case FLACBlockPadding:
+			padding = append(padding, block)
+		default:
+			kept = append(kept, block)
+		}
+	}
+
+	kept = append(kept, FLACMetadataBlock{Type: FLACBlockVorbisComment, Data: encodeVorbisComments(comments)})
+
+	for _, picture := range pictures {
+		kept = append(kept, FLACMetadataBlock{Type: FLACBlockPicture, Data: encodeFLACPicture(picture)})
+	}
+
+	metadata, err := encodeFLACMetadata(append(kept, padding...))
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Concat(metadata, frames), nil
+}
+
+// encodeVorbisComments encodes a comment header body (without codec signature or framing bit):
+// vendor string, then the "KEY=value" comments, all length-prefixed little endian.
+func encodeVorbisComments(comments []string) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(vorbisVendor)))
+	out = append(out, vorbisVendor...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(comments))) //nolint:gosec // G115: bounded by memory.
+
+	for _, comment := range comments {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(comment))) //nolint:gosec // G115: bounded by memory.
+		out = append(out, comment...)
+	}
+
+	return out
+}
+
+// encodeFLACPicture encodes the body of a FLAC PICTURE block, also used base64 encoded as
+// METADATA_BLOCK_PICTURE in Ogg comments. Image dimensions, depth and palette size are left at zero.
+func encodeFLACPicture(picture Picture) []byte {
+	mime := picture.MIME
+	if mime == "" {
+		mime = sniffImageMIME(picture.Data)
+	}
+
+	out := binary.BigEndian.AppendUint32(nil, uint32(picture.Type))
+	out = binary.BigEndian.AppendUint32(out, uint32(len(mime))) //nolint:gosec // G115: bounded by memory.
+	out = append(out, mime...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(picture.Description))) //nolint:gosec // G115: bounded by memory.
+	out = append(out, picture.Description...)
+	out = append(out, make([]byte, flacPictureImageSize)...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(picture.Data))) //nolint:gosec // G115: bounded by memory.
+
+	return append(out, picture.Data...)
+}
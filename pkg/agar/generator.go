@@ -0,0 +1,192 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/mycophonic/primordium/filesystem"
+)
+
+// ErrUnknownGenerator is returned when no fixture generator has the requested name.
+var ErrUnknownGenerator = errors.New("unknown fixture generator")
+
+// Generator creates one fixture file without a test, reporting failures as errors, so that fixtures can
+// be generated from TestMain, benchmarks and plain programs. Each tigron fixture function (e.g.
+// Genuine16bit44k) wraps the generator of the same name, so both produce the same file.
+//
+// Generators returns the generators of every fixture function without parameters, Fixtures describes
+// them, and constructors such as WhiteNoiseWAVGenerator, NativeFLACGenerator or MalformedFLACGenerator
+// cover parameterized ones. Fixtures derived from an existing file (e.g. Corrupted) already have
+// error-returning counterparts (Corrupt).
+type Generator struct {
+	// Name is the name of the fixture function, e.g. "Genuine16bit44k".
+	Name string
+	// File is the base name of the generated file, e.g. "genuine-16bit-44k.flac".
+	File string
+
+	// key identifies the generated content in the fixture cache, along with File.
+	key [][]string
+	// tools lists the external binaries the generator runs, whose versions are part of the cache key.
+	tools []string
+	// uncached bypasses the fixture cache, for content the key cannot identify.
+	uncached bool
+	// create writes the fixture to path, reporting tool failures for generator.
+	create func(ctx context.Context, generator, path string) error
+}
+
+// Generate creates the fixture in dir, which is created if needed, and returns its path. External tools
+// run bounded by ctx: ToolContext(nil) gives them the default timeout. Generation goes through the
+// fixture cache when it is enabled.
+func (g Generator) Generate(ctx context.Context, dir string) (string, error) {
+	if g.create == nil {
+		return "", fmt.Errorf("%w: %q", ErrUnknownGenerator, g.Name)
+	}
+
+	if err := os.MkdirAll(dir, filesystem.DirPermissionsDefault); err != nil {
+		return "", fmt.Errorf("%s: %w", g.File, err)
+	}
+
+	path := filepath.Join(dir, g.File)
+	create := func(target string) error {
+		return g.create(ctx, g.File, target)
+	}
+
+	cache := fixtureCache.Load()
+	if cache == nil || g.uncached {
+		if err := create(path); err != nil {
+			// Do not leave a partial fixture behind.
+			_ = os.Remove(path)
+
+			return "", generatorError(g.File, err)
+		}
+
+		return path, nil
+	}
+
+	if err := cache.Fetch(cache.key(g.File, g.tools, g.key), path, create); err != nil {
+		return "", fmt.Errorf("%s: %w", g.File, err)
+	}
+
+	return path, nil
+}
+
+// generatorError prefixes err with the fixture file, unless it comes from runTool, which names it already.
+func generatorError(file string, err error) error {
+	if errors.Is(err, ErrToolFailed) || errors.Is(err, ErrToolTimeout) {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", file, err)
+}
+
+// renamed returns g named name and writing file.
+func (g Generator) renamed(name, file string) Generator {
+	g.Name, g.File = name, file
+
+	return g
+}
+
+// generatorStep modifies the output of a generator in place.
+type generatorStep struct {
+	// key identifies the step and its parameters in the fixture cache.
+	key []string
+	// tools lists the external binaries the step runs.
+	tools []string
+	run   func(ctx context.Context, generator, path string) error
+}
+
+// then returns g running step on its output once it is created.
+func (g Generator) then(step generatorStep) Generator {
+	create := g.create
+	g.key = append(slices.Clone(g.key), step.key)
+	g.tools = slices.Concat(g.tools, step.tools)
+	g.create = func(ctx context.Context, generator, path string) error {
+		if err := create(ctx, generator, path); err != nil {
+			return err
+		}
+
+		return step.run(ctx, generator, path)
+	}
+
+	return g
+}
+
+// toolStep returns a generator step running binary with the arguments args returns for the output path.
+// The arguments for an empty path identify the step in the fixture cache.
+func toolStep(binary string, args func(path string) []string) generatorStep {
+	return generatorStep{
+		key:   append([]string{binary}, args("")...),
+		tools: []string{binary},
+		run: func(ctx context.Context, generator, path string) error {
+			return runBinary(ctx, generator, binary, args(path)...)
+		},
+	}
+}
+
+// nativeGenerator returns a generator writing file with write, without external tools. key lists the
+// parameters of write not reflected in file.
+func nativeGenerator(name, file string, write func(path string) error, key ...string) Generator {
+	return Generator{
+		Name: name,
+		File: file,
+		key:  [][]string{key},
+		create: func(_ context.Context, _, path string) error {
+			return write(path)
+		},
+	}
+}
+
+// Generators returns the generators of every fixture function taking no parameters besides the test
+// data and helpers, in the order of Fixtures.
+func Generators() []Generator {
+	return Fixtures().Generators()
+}
+
+// LookupGenerator returns the generator listed by Generators with the given name.
+func LookupGenerator(name string) (Generator, error) {
+	for _, gen := range Generators() {
+		if gen.Name == name {
+			return gen, nil
+		}
+	}
+
+	return Generator{}, fmt.Errorf("%w: %q", ErrUnknownGenerator, name)
+}
+
+// Path generates the fixture in the test temp directory, within the tool context of the test, and returns
+// its path, failing the test when generation fails. It is the tigron counterpart of Generate.
+func (g Generator) Path(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	ctx, cancel := ToolContext(helpers.T())
+	defer cancel()
+
+	path, err := g.Generate(ctx, data.Temp().Dir())
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	return path
+}
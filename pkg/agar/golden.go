@@ -0,0 +1,406 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+	"github.com/mycophonic/primordium/filesystem"
+)
+
+// ErrGolden is returned when a snapshot cannot be taken.
+var ErrGolden = errors.New("golden snapshot failure")
+
+const (
+	// goldenUpdateFlag is the test binary flag rewriting snapshots instead of comparing them, when the
+	// test package registers it.
+	goldenUpdateFlag = "update"
+	// goldenUpdateEnv is the environment variable rewriting snapshots instead of comparing them.
+	goldenUpdateEnv = "AGAR_UPDATE_GOLDEN"
+	// goldenDir is the slash separated directory of snapshot files, relative to the package under test.
+	goldenDir = "testdata/golden"
+	// goldenExtension is the extension of snapshot files.
+	goldenExtension = ".golden"
+	// goldenOutputDir is the name of the output directory handed to the binary, under the test temp dir.
+	goldenOutputDir = "golden-output"
+
+	// Placeholders replacing machine and run dependent text in snapshots.
+	goldenOutputPlaceholder    = "$OUTPUT"
+	goldenTempPlaceholder      = "$TEMP"
+	goldenTmpDirPlaceholder    = "$TMPDIR"
+	goldenTimestampPlaceholder = "$TIMESTAMP"
+)
+
+// goldenTimestampRE matches ISO 8601 dates with a time of day, as printed by most tools.
+var goldenTimestampRE = regexp.MustCompile(
+	`\b\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::\d{2}(?:[.,]\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?`,
+)
+
+// updatingGolden reports whether snapshots are rewritten: when AGAR_UPDATE_GOLDEN is true, or the test
+// package registered an -update flag and it is set. The flag is looked up when asserting, once the test
+// binary has parsed its flags.
+func updatingGolden() bool {
+	if update, err := strconv.ParseBool(os.Getenv(goldenUpdateEnv)); err == nil {
+		return update
+	}
+
+	if updateFlag := flag.Lookup(goldenUpdateFlag); updateFlag != nil {
+		update, _ := strconv.ParseBool(updateFlag.Value.String())
+
+		return update
+	}
+
+	return false
+}
+
+// Snapshot is the observable outcome of a run of the binary under test.
+type Snapshot struct {
+	// ExitCode is the exit code of the binary.
+	ExitCode int
+	// Stdout is the standard output of the binary.
+	Stdout string
+	// Stderr is the standard error of the binary.
+	Stderr string
+	// Files lists the files written to the output directory, sorted by path.
+	Files []SnapshotFile
+}
+
+// SnapshotFile is a file written by the binary under test.
+type SnapshotFile struct {
+	// Path is slash separated, relative to the output directory.
+	Path string
+	// Size is the size in bytes.
+	Size int64
+	// SHA256 is the hex encoded SHA-256 of the content.
+	SHA256 string
+}
+
+// String renders the snapshot in the format of golden files.
+func (s *Snapshot) String() string {
+	var builder strings.Builder
+
+	section := func(name, content string) {
+		builder.WriteString("-- " + name + " --\n")
+		builder.WriteString(content)
+
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			builder.WriteString("\n")
+		}
+	}
+
+	fmt.Fprintf(&builder, "exit code: %d\n", s.ExitCode)
+	section("stdout", s.Stdout)
+	section("stderr", s.Stderr)
+
+	builder.WriteString("-- files --\n")
+
+	for _, file := range s.Files {
+		fmt.Fprintf(&builder, "%s %d %s\n", file.SHA256, file.Size, file.Path)
+	}
+
+	return builder.String()
+}
+
+// TakeSnapshot runs binary with args and the environment env, bounded by ctx, and records its exit
+// code, output, and the files it leaves in output. A non-zero exit is part of the snapshot, not an
+// error.
+func TakeSnapshot(ctx context.Context, binary string, args, env []string, output string) (*Snapshot, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := newToolCommand(ctx, binary, args...)
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	snapshot := &Snapshot{}
+	started := time.Now()
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if ctx.Err() != nil || !errors.As(err, &exitErr) {
+			return nil, toolError(ctx, "TakeSnapshot", cmd, err, stderr.String(), time.Since(started))
+		}
+
+		snapshot.ExitCode = exitErr.ExitCode()
+	}
+
+	snapshot.Stdout, snapshot.Stderr = stdout.String(), stderr.String()
+
+	err := filepath.WalkDir(output, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		file, err := snapshotFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(output, path)
+		if err != nil {
+			return err
+		}
+
+		file.Path = filepath.ToSlash(rel)
+		snapshot.Files = append(snapshot.Files, file)
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %w", ErrGolden, err)
+	}
+
+	return snapshot, nil
+}
+
+// snapshotFile hashes the file at path.
+func snapshotFile(path string) (SnapshotFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return SnapshotFile{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return SnapshotFile{}, err
+	}
+
+	return SnapshotFile{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// Golden is a snapshot test of the binary configured by Setup: it runs the binary, optionally on a
+// fixture, and compares its exit code, stdout, stderr and the hashes of the files it writes with the
+// snapshot stored under testdata/golden. Running the tests with AGAR_UPDATE_GOLDEN=1, or with -update
+// when the test package registers that flag, records missing snapshots and rewrites the ones that differ.
+//
+// Temp paths and timestamps are replaced with the $OUTPUT, $TEMP, $TMPDIR and $TIMESTAMP placeholders,
+// so that snapshots do not depend on the machine or the run. Output files are compared by hash: the
+// binary must write them deterministically.
+type Golden struct {
+	// Name is the slash separated snapshot path under testdata/golden, without the .golden extension.
+	// Empty uses the test name, so each subtest gets its own snapshot.
+	Name string
+	// Fixture generates the input of the binary. The zero Generator runs the binary without fixture.
+	Fixture Generator
+	// Args returns the arguments of the binary, given the fixture path (empty without Fixture) and an
+	// empty output directory, whose files are recorded in the snapshot.
+	Args func(fixture, output string) []string
+	// Env sets environment variables on top of the ones Setup passes on to the binary.
+	Env map[string]string
+	// Normalize further rewrites stdout and stderr, once temp paths and timestamps are replaced.
+	Normalize func(text string) string
+	// Update rewrites the snapshot instead of comparing it, as AGAR_UPDATE_GOLDEN does.
+	Update bool
+}
+
+// Case returns a test case running the snapshot test, described by the fixture name when there is one.
+func (g Golden) Case() *test.Case {
+	return &test.Case{
+		Description: cmp.Or(g.Name, g.Fixture.Name, "golden"),
+		Setup:       g.Assert,
+	}
+}
+
+// Assert runs the snapshot test, failing the test when the snapshot differs from the golden file.
+func (g Golden) Assert(data test.Data, helpers test.Helpers) {
+	helpers.T().Helper()
+
+	binary := setupBinary.Load()
+	if binary == nil {
+		helpers.T().Log("agar.Setup must be called before running golden snapshot tests")
+		helpers.T().FailNow()
+	}
+
+	var fixture string
+	if g.Fixture.Name != "" {
+		fixture = g.Fixture.Path(data, helpers)
+	}
+
+	output := data.Temp().Dir(goldenOutputDir)
+
+	var args []string
+	if g.Args != nil {
+		args = g.Args(fixture, output)
+	}
+
+	ctx, cancel := ToolContext(helpers.T())
+	defer cancel()
+
+	snapshot, err := TakeSnapshot(ctx, *binary, args, g.environ(), output)
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+
+	normalize := goldenNormalizer(output, data.Temp().Dir(), os.TempDir())
+	snapshot.Stdout = normalize(snapshot.Stdout)
+	snapshot.Stderr = normalize(snapshot.Stderr)
+
+	if g.Normalize != nil {
+		snapshot.Stdout = g.Normalize(snapshot.Stdout)
+		snapshot.Stderr = g.Normalize(snapshot.Stderr)
+	}
+
+	name := cmp.Or(g.Name, helpers.T().Name())
+	assertGolden(helpers.T(), filepath.FromSlash(goldenDir+"/"+name+goldenExtension), snapshot.String(),
+		g.Update || updatingGolden())
+}
+
+// environ returns the whitelisted environment of the test process with Env on top.
+func (g Golden) environ() []string {
+	var env []string
+
+	for _, variable := range os.Environ() {
+		key, _, _ := strings.Cut(variable, "=")
+		if slices.ContainsFunc(envWhitelist, func(pattern string) bool {
+			prefix, wildcard := strings.CutSuffix(pattern, "*")
+
+			return key == pattern || wildcard && strings.HasPrefix(key, prefix)
+		}) {
+			env = append(env, variable)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(g.Env)) {
+		env = append(env, key+"="+g.Env[key])
+	}
+
+	return env
+}
+
+// goldenNormalizer returns a function replacing the output, temp and system temp directories, as given
+// and with symlinks resolved, and timestamps, with placeholders.
+func goldenNormalizer(output, temp, tmpDir string) func(text string) string {
+	type replacement struct{ from, to string }
+
+	var replacements []replacement
+
+	for _, dir := range []replacement{
+		{output, goldenOutputPlaceholder},
+		{temp, goldenTempPlaceholder},
+		{tmpDir, goldenTmpDirPlaceholder},
+	} {
+		dir.from = filepath.Clean(dir.from)
+		replacements = append(replacements, dir)
+
+		if resolved, err := filepath.EvalSymlinks(dir.from); err == nil && resolved != dir.from {
+			replacements = append(replacements, replacement{resolved, dir.to})
+		}
+	}
+
+	// Longer paths first, so that the output directory wins over the temp directory holding it.
+	slices.SortStableFunc(replacements, func(a, b replacement) int {
+		return len(b.from) - len(a.from)
+	})
+
+	pairs := make([]string, 0, len(replacements)*2) //nolint:mnd // from and to.
+	for _, r := range replacements {
+		pairs = append(pairs, r.from, r.to)
+	}
+
+	replacer := strings.NewReplacer(pairs...)
+
+	return func(text string) string {
+		return goldenTimestampRE.ReplaceAllLiteralString(replacer.Replace(text), goldenTimestampPlaceholder)
+	}
+}
+
+// AssertGolden compares actual with the golden file at path, failing t on differences. With
+// AGAR_UPDATE_GOLDEN=1 or -update (see Golden), it writes actual to the golden file instead.
+func AssertGolden(t tig.T, path, actual string) {
+	t.Helper()
+
+	assertGolden(t, path, actual, updatingGolden())
+}
+
+// assertGolden compares actual with the golden file at path, or writes it there when update is set.
+func assertGolden(t tig.T, path, actual string, update bool) {
+	t.Helper()
+
+	if update {
+		err := os.MkdirAll(filepath.Dir(path), filesystem.DirPermissionsDefault)
+		if err == nil {
+			err = filesystem.WriteFile(path, []byte(actual), filesystem.FilePermissionsDefault)
+		}
+
+		if err != nil {
+			t.Log(fmt.Sprintf("%v: %v", ErrGolden, err))
+			t.FailNow()
+		}
+
+		t.Log("updated " + path)
+
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Log(fmt.Sprintf("%v: %v\nrun the tests with %s=1 to record:\n%s", ErrGolden, err, goldenUpdateEnv, actual))
+		t.FailNow()
+	}
+
+	if string(expected) != actual {
+		t.Log(fmt.Sprintf("snapshot differs from %s (run the tests with %s=1 to accept it)\n%s\nactual snapshot:\n%s",
+			path, goldenUpdateEnv, goldenDifference(string(expected), actual), actual))
+		t.FailNow()
+	}
+}
+
+// goldenDifference describes the first line that differs between expected and actual.
+func goldenDifference(expected, actual string) string {
+	expectedLines, actualLines := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+
+	line := func(lines []string, index int) string {
+		if index < len(lines) {
+			return strconv.Quote(lines[index])
+		}
+
+		return "<end of snapshot>"
+	}
+
+	for index := range max(len(expectedLines), len(actualLines)) {
+		if index >= len(expectedLines) || index >= len(actualLines) || expectedLines[index] != actualLines[index] {
+			return fmt.Sprintf("line %d:\n  expected: %s\n  actual:   %s",
+				index+1, line(expectedLines, index), line(actualLines, index))
+		}
+	}
+
+	return ""
+}
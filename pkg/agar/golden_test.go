@@ -0,0 +1,184 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// recordingT is a tig.T recording the outcome of an assertion instead of failing the test.
+type recordingT struct {
+	dir    string
+	failed bool
+	logs   []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) FailNow() {
+	r.failed = true
+	runtime.Goexit()
+}
+
+func (r *recordingT) Fail() {
+	r.failed = true
+}
+
+func (r *recordingT) Log(args ...any) {
+	r.logs = append(r.logs, fmt.Sprint(args...))
+}
+
+func (r *recordingT) Name() string {
+	return "recording"
+}
+
+func (r *recordingT) TempDir() string {
+	return r.dir
+}
+
+func (r *recordingT) Skip(args ...any) {
+	r.Log(args...)
+	runtime.Goexit()
+}
+
+// recordAssertion runs assert on its own goroutine, which FailNow exits, and returns its outcome.
+func recordAssertion(t *testing.T, assert func(recorder *recordingT)) *recordingT {
+	t.Helper()
+
+	recorder := &recordingT{dir: t.TempDir()}
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		assert(recorder)
+	}()
+	<-done
+
+	return recorder
+}
+
+// assertGolden runs AssertGolden against a recordingT.
+func assertGolden(t *testing.T, path, actual string) *recordingT {
+	t.Helper()
+
+	return recordAssertion(t, func(recorder *recordingT) {
+		agar.AssertGolden(recorder, path, actual)
+	})
+}
+
+//nolint:paralleltest // sets AGAR_UPDATE_GOLDEN for the test binary.
+func TestAssertGoldenRecordCompareMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testdata", "golden", "snapshot.golden")
+
+	t.Setenv("AGAR_UPDATE_GOLDEN", "0")
+
+	if outcome := assertGolden(t, path, "exit code: 0\n"); !outcome.failed ||
+		!strings.Contains(strings.Join(outcome.logs, "\n"), agar.ErrGolden.Error()) {
+		t.Errorf("missing snapshot: got %+v, expected an ErrGolden failure", outcome)
+	}
+
+	t.Setenv("AGAR_UPDATE_GOLDEN", "1")
+
+	if outcome := assertGolden(t, path, "exit code: 0\n"); outcome.failed {
+		t.Fatalf("record: %v", outcome.logs)
+	}
+
+	if recorded, err := os.ReadFile(path); err != nil || string(recorded) != "exit code: 0\n" {
+		t.Fatalf("recorded %q, %v", recorded, err)
+	}
+
+	t.Setenv("AGAR_UPDATE_GOLDEN", "")
+
+	if outcome := assertGolden(t, path, "exit code: 0\n"); outcome.failed {
+		t.Errorf("compare: %v", outcome.logs)
+	}
+
+	outcome := assertGolden(t, path, "exit code: 1\n")
+	if !outcome.failed || !strings.Contains(strings.Join(outcome.logs, "\n"), `"exit code: 1"`) {
+		t.Errorf("mismatch: got %+v, expected a failure showing the differing line", outcome)
+	}
+
+	if recorded, err := os.ReadFile(path); err != nil || string(recorded) != "exit code: 0\n" {
+		t.Errorf("a mismatch rewrote the snapshot: %q, %v", recorded, err)
+	}
+}
+
+// goldenHelperScript prints its output directory, the temp directory holding it and the time, and writes a
+// deterministic file to the output directory.
+const goldenHelperScript = `#!/bin/sh
+echo "output: $1"
+echo "temp: $(dirname "$1")"
+echo "at $(date -u +%Y-%m-%dT%H:%M:%SZ)"
+echo "written at $(date +'%Y-%m-%d %H:%M:%S')" >&2
+printf deterministic > "$1/result.txt"
+`
+
+//nolint:paralleltest // changes the working directory and the binary under test.
+func TestGoldenNormalizesHelperOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the helper binary is a shell script")
+	}
+
+	helper := filepath.Join(t.TempDir(), "helper")
+	if err := os.WriteFile(helper, []byte(goldenHelperScript), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	agar.SetGoldenBinary(t, helper)
+	t.Chdir(t.TempDir())
+	t.Setenv("AGAR_UPDATE_GOLDEN", "0")
+
+	golden := agar.Golden{
+		Name: "helper",
+		Args: func(_, output string) []string {
+			return []string{output}
+		},
+	}
+
+	// Record the snapshot, then compare a second run, in other temp directories and at another time.
+	for _, update := range []bool{true, false} {
+		golden.Update = update
+		testCase := golden.Case()
+		testCase.NoParallel = true
+		testCase.Run(t)
+	}
+
+	recorded, err := os.ReadFile(filepath.Join("testdata", "golden", "helper.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256([]byte("deterministic"))
+	expected := "exit code: 0\n" +
+		"-- stdout --\noutput: $OUTPUT\ntemp: $TEMP\nat $TIMESTAMP\n" +
+		"-- stderr --\nwritten at $TIMESTAMP\n" +
+		"-- files --\n" + hex.EncodeToString(hash[:]) + " 13 result.txt\n"
+
+	if string(recorded) != expected {
+		t.Errorf("recorded snapshot:\n%s\nexpected:\n%s", recorded, expected)
+	}
+}
@@ -17,62 +17,76 @@
 package agar
 
 import (
+	"bytes"
 	"context"
-	"os/exec"
-
-	"github.com/containerd/nerdctl/mod/tigron/test"
+	"fmt"
+	"time"
 )
 
-// generate runs an ffmpeg command to create an audio file in the test's temp directory.
-func generate(helpers test.Helpers, outputPath string, args []string) string {
-	helpers.T().Helper()
-
-	ffmpeg := lookForOrFail(helpers.T(), ffmpegBinary)
-
-	fullArgs := append([]string{"-y"}, args...)
-	fullArgs = append(fullArgs, outputPath)
-
-	helpers.Custom(ffmpeg, fullArgs...).Run(&test.Expected{})
-
-	return outputPath
+// ffmpegGenerator returns a generator running a single ffmpeg command writing file.
+func ffmpegGenerator(name, file string, args []string) Generator {
+	return Generator{
+		Name:  name,
+		File:  file,
+		key:   [][]string{args},
+		tools: []string{ffmpegBinary},
+		create: func(ctx context.Context, generator, path string) error {
+			fullArgs := append([]string{"-y"}, args...)
+			fullArgs = append(fullArgs, path)
+
+			return runBinary(ctx, generator, ffmpegBinary, fullArgs...)
+		},
+	}
 }
 
-// generateWithPipe runs two ffmpeg commands piped together.
-func generateWithPipe(helpers test.Helpers, outputPath string, firstArgs, secondArgs []string) string {
-	helpers.T().Helper()
-
-	ffmpeg := lookForOrFail(helpers.T(), ffmpegBinary)
-	ctx := context.Background()
-
-	//nolint:gosec // this is a test helper
-	first := exec.CommandContext(ctx, ffmpeg, append([]string{"-y"}, firstArgs...)...)
+// ffmpegPipeGenerator returns a generator running two ffmpeg commands piped together.
+func ffmpegPipeGenerator(name, file string, firstArgs, secondArgs []string) Generator {
+	return Generator{
+		Name:  name,
+		File:  file,
+		key:   [][]string{firstArgs, secondArgs},
+		tools: []string{ffmpegBinary},
+		create: func(ctx context.Context, generator, path string) error {
+			ffmpeg, err := LookFor(ffmpegBinary)
+			if err != nil {
+				return fmt.Errorf("%s: %w", ffmpegBinary, err)
+			}
+
+			return runPipe(ctx, generator, ffmpeg, path, firstArgs, secondArgs)
+		},
+	}
+}
 
-	//nolint:gosec // this is a test helper
-	second := exec.CommandContext(ctx, ffmpeg, append([]string{"-y", "-i", "-"}, secondArgs...)...)
-	second.Args = append(second.Args, outputPath)
+// runPipe pipes the output of a first ffmpeg command into a second one writing outputPath.
+func runPipe(ctx context.Context, generator, ffmpeg, outputPath string, firstArgs, secondArgs []string) error {
+	first := newToolCommand(ctx, ffmpeg, append([]string{"-y"}, firstArgs...)...)
+	second := newToolCommand(ctx, ffmpeg, append(append([]string{"-y", "-i", "-"}, secondArgs...), outputPath)...)
 
 	pipe, err := first.StdoutPipe()
 	if err != nil {
-		helpers.T().Log(err.Error())
-		helpers.T().Fail()
+		return err
 	}
 
 	second.Stdin = pipe
 
-	if err := first.Start(); err != nil {
-		helpers.T().Log(err.Error())
-		helpers.T().Fail()
+	var firstStderr bytes.Buffer
+
+	first.Stderr = &firstStderr
+	started := time.Now()
+
+	if err = first.Start(); err != nil {
+		return toolError(ctx, generator, first, err, "", time.Since(started))
 	}
 
-	if output, err := second.CombinedOutput(); err != nil {
-		helpers.T().Log(err.Error(), string(output))
-		helpers.T().Fail()
+	secondErr := runTool(ctx, generator, second)
+	if secondErr != nil {
+		// Unblock the first command if it is still writing.
+		_ = pipe.Close()
 	}
 
-	if err := first.Wait(); err != nil {
-		helpers.T().Log(err.Error())
-		helpers.T().Fail()
+	if err = first.Wait(); err != nil && secondErr == nil {
+		return toolError(ctx, generator, first, err, firstStderr.String(), time.Since(started))
 	}
 
-	return outputPath
+	return secondErr
 }
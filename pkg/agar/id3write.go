@@ -0,0 +1,724 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ID3 writer limits.
+const (
+	id3MaxSynchsafe    = 1<<28 - 1
+	id3v22MaxFrameSize = 1<<24 - 1
+	id3v1TrackMax      = 255
+	id3v1GenreNone     = 255
+	id3v23Separator    = "/"
+	id3DefaultLanguage = "eng"
+	id3v22Major        = 2
+	id3v23Major        = 3
+	id3v24Major        = 4
+)
+
+// ID3PictureFrontCover is the APIC picture type of a front cover.
+const ID3PictureFrontCover byte = 3
+
+// ErrID3Option is returned when an ID3 tag cannot be written with the requested options.
+var ErrID3Option = errors.New("invalid ID3 writer option")
+
+// ID3Encoding selects the text encoding of written ID3v2 frames.
+type ID3Encoding int
+
+// ID3v2 text encodings. UTF-16BE and UTF-8 only exist in ID3v2.4.
+const (
+	// ID3EncodingAuto uses UTF-8 for ID3v2.4 and UTF-16 with a byte order mark for earlier versions.
+	ID3EncodingAuto ID3Encoding = iota
+	ID3EncodingLatin1
+	ID3EncodingUTF16
+	ID3EncodingUTF16BE
+	ID3EncodingUTF8
+)
+
+// ID3Options controls how an ID3v2 tag is written.
+type ID3Options struct {
+	// Version is ID3v22, ID3v23 or ID3v24. Empty means ID3v24.
+	Version  ID3Version
+	Encoding ID3Encoding
+	// Unsynchronisation applies the unsynchronisation scheme: to the whole tag before ID3v2.4,
+	// to every frame in ID3v2.4.
+	Unsynchronisation bool
+	// Padding is the number of zero bytes written after the last frame.
+	Padding int
+	// Footer appends the tag at the end of the file (before any ID3v1 tag) and closes it with a footer.
+	// Only ID3v2.4 supports footers, which exclude padding.
+	Footer bool
+}
+
+// ID3Frame is a raw ID3v2 frame written verbatim. ID is a four-character ID3v2.3/2.4 frame ID, or a
+// three-character ID3v2.2 one. Four-character IDs are translated when writing ID3v2.2.
+type ID3Frame struct {
+	ID   string
+	Data []byte
+}
+
+// ID3UserText is a TXXX user-defined text frame.
+type ID3UserText struct {
+	Description string
+	Values      []string
+}
+
+// ID3Comment is a COMM frame with an ISO-639-2 language code ("eng" when empty).
+type ID3Comment struct {
+	Language    string
+	Description string
+	Text        string
+}
+
+// ID3Picture is an APIC frame. MIME is detected from the image signature when empty.
+type ID3Picture struct {
+	MIME        string
+	Type        byte
+	Description string
+	Data        []byte
+}
+
+// ID3Tag is the content of an ID3v2 tag: the MP3Tags fields plus the frames the id3v2 tool cannot write.
+// Frames are written in field order, generated frames first.
+type ID3Tag struct {
+	MP3Tags
+
+	// Artists, when set, replaces Artist with a multi-value TPE1 frame. ID3v2.4 separates values with
+	// null characters, earlier versions join them with "/".
+	Artists []string
+	// Text holds additional text information frames by frame ID (e.g. "TSRC"), written in frame ID
+	// order. Multiple values are stored like Artists.
+	Text     map[string][]string
+	UserText []ID3UserText
+	Comments []ID3Comment
+	// Lyrics holds USLT frames, which share the layout of COMM frames.
+	Lyrics   []ID3Comment
+	Pictures []ID3Picture
+	Frames   []ID3Frame
+}
+
+// EncodeID3v2 encodes tag as a complete ID3v2 tag, header (and footer) included.
+//
+//nolint:cyclop // version specific header handling.
+func EncodeID3v2(tag ID3Tag, opts ID3Options) ([]byte, error) {
+	version, err := id3MajorVersion(opts.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding, err := id3TextEncoding(opts.Encoding, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Footer && version != id3v24Major {
+		return nil, fmt.Errorf("%w: footers require ID3v2.4", ErrID3Option)
+	}
+
+	if opts.Footer && opts.Padding > 0 {
+		return nil, fmt.Errorf("%w: a tag with a footer cannot have padding", ErrID3Option)
+	}
+
+	if opts.Padding < 0 {
+		return nil, fmt.Errorf("%w: negative padding %d", ErrID3Option, opts.Padding)
+	}
+
+	writer := id3Writer{version: version, encoding: encoding, unsync: opts.Unsynchronisation}
+
+	frames, err := writer.frames(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+
+	for _, frame := range frames {
+		encoded, err := writer.frame(frame)
+		if err != nil {
+			return nil, err
+		}
+
+		body = append(body, encoded...)
+	}
+
+	// Before ID3v2.4, unsynchronisation applies to the tag as a whole.
+	if opts.Unsynchronisation && version < id3v24Major {
+		body = applyUnsync(body)
+	}
+
+	body = append(body, make([]byte, opts.Padding)...)
+
+	if len(body) > id3MaxSynchsafe {
+		return nil, fmt.Errorf("%w: tag size %d exceeds 28 bits", ErrID3Option, len(body))
+	}
+
+	var flags byte
+	if opts.Unsynchronisation {
+		flags |= id3FlagUnsync
+	}
+
+	if opts.Footer {
+		flags |= id3v24FlagFooter
+	}
+
+	header := appendSynchsafe([]byte{'I', 'D', '3', version, 0, flags}, len(body))
+	out := slices.Concat(header, body)
+
+	if opts.Footer {
+		out = append(out, "3DI"...)
+		out = append(out, header[3:]...)
+	}
+
+	return out, nil
+}
+
+// WriteID3v2 replaces the ID3v2 tags of the MP3 file at path with tag, keeping any ID3v1 tag.
+func WriteID3v2(path string, tag ID3Tag, opts ID3Options) error {
+	encoded, err := EncodeID3v2(tag, opts)
+	if err != nil {
+		return err
+	}
+
+	return rewriteID3(path, func(_, audio, _, v1 []byte) []byte {
+		if opts.Footer {
+			return slices.Concat(audio, encoded, v1)
+		}
+
+		return slices.Concat(encoded, audio, v1)
+	})
+}
+
+// EncodeID3v1 encodes the ID3v1.1 tag of tags. Fields are truncated to their fixed sizes, characters
+// outside Latin-1 become "?", and genres missing from the ID3v1 list are left unset.
+func EncodeID3v1(tags MP3Tags) []byte {
+	block := make([]byte, id3v1Size)
+	copy(block, "TAG")
+
+	field := func(offset, size int, value string) {
+		copy(block[offset:offset+size], encodeLatin1Lossy(value))
+	}
+
+	title := 3
+	artist := title + id3v1FieldSize
+	album := artist + id3v1FieldSize
+	year := album + id3v1FieldSize
+	comment := year + id3v1YearSize
+	genre := comment + id3v1FieldSize
+
+	field(title, id3v1FieldSize, tags.Title)
+	field(artist, id3v1FieldSize, tags.Artist)
+	field(album, id3v1FieldSize, tags.Album)
+
+	if tags.Year > 0 {
+		field(year, id3v1YearSize, strconv.Itoa(tags.Year))
+	}
+
+	// ID3v1.1 takes the last two comment bytes for a zero byte and the track number.
+	field(comment, id3v1FieldSize-2, tags.Comment)
+
+	if tags.Track > 0 && tags.Track <= id3v1TrackMax {
+		block[genre-1] = byte(tags.Track)
+	}
+
+	block[genre] = id3v1GenreNone
+
+	for index, name := range id3Genres {
+		if strings.EqualFold(name, tags.Genre) {
+			block[genre] = byte(index)
+
+			break
+		}
+	}
+
+	return block
+}
+
+// WriteID3v1 replaces the ID3v1 tag of the MP3 file at path with the ID3v1.1 encoding of tags,
+// keeping any ID3v2 tag.
+func WriteID3v1(path string, tags MP3Tags) error {
+	return rewriteID3(path, func(prepended, audio, appended, _ []byte) []byte {
+		return slices.Concat(prepended, audio, appended, EncodeID3v1(tags))
+	})
+}
+
+// rewriteID3 replaces the file at path with the output of build, which receives the file split into
+// its prepended ID3v2 tag, audio, appended ID3v2 tag and ID3v1 tag (each possibly empty).
+func rewriteID3(path string, build func(prepended, audio, appended, v1 []byte) []byte) error {
+	content, err := os.ReadFile(path) //nolint:gosec // G304: path is caller-provided test fixture.
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var prepended, appended, v1 []byte
+
+	if hasID3v1(content) {
+		v1 = content[len(content)-id3v1Size:]
+		content = content[:len(content)-id3v1Size]
+	}
+
+	tags := findID3v2Tags(content)
+
+	if len(tags) > 0 && string(content[:3]) == "ID3" {
+		prepended, tags = tags[0], tags[1:]
+		content = content[len(prepended):]
+	}
+
+	if len(tags) > 0 {
+		appended = tags[0]
+		content = content[:len(content)-len(appended)]
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	if err := os.WriteFile(path, build(prepended, content, appended, v1), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// id3MajorVersion returns the ID3v2 major version of an ID3Version.
+func id3MajorVersion(version ID3Version) (byte, error) {
+	switch version {
+	case ID3v22:
+		return id3v22Major, nil
+	case ID3v23:
+		return id3v23Major, nil
+	case ID3v24, "":
+		return id3v24Major, nil
+	default:
+		return 0, fmt.Errorf("%w: cannot write ID3 version %q as ID3v2", ErrID3Option, version)
+	}
+}
+
+// id3TextEncoding returns the encoding byte of an ID3Encoding for the given major version.
+func id3TextEncoding(encoding ID3Encoding, version byte) (byte, error) {
+	switch encoding {
+	case ID3EncodingAuto:
+		if version == id3v24Major {
+			return id3EncodingUTF8, nil
+		}
+
+		return id3EncodingUTF16, nil
+	case ID3EncodingLatin1:
+		return id3EncodingLatin1, nil
+	case ID3EncodingUTF16:
+		return id3EncodingUTF16, nil
+	case ID3EncodingUTF16BE, ID3EncodingUTF8:
+		if version != id3v24Major {
+			return 0, fmt.Errorf("%w: UTF-16BE and UTF-8 require ID3v2.4", ErrID3Option)
+		}
+
+		if encoding == ID3EncodingUTF8 {
+			return id3EncodingUTF8, nil
+		}
+
+		return id3EncodingUTF16BE, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown text encoding %d", ErrID3Option, encoding)
+	}
+}
+
+// id3Writer encodes frames for one tag version and text encoding.
+type id3Writer struct {
+	version  byte
+	encoding byte
+	unsync   bool
+}
+
+// frames returns the frames of tag, IDs in their ID3v2.3/2.4 form.
+//
+//nolint:cyclop,funlen // one block per field.
+func (writer id3Writer) frames(tag ID3Tag) ([]ID3Frame, error) {
+	var frames []ID3Frame
+
+	addText := func(frameID string, values ...string) error {
+		data, err := writer.text(values...)
+		if err != nil {
+			return err
+		}
+
+		frames = append(frames, ID3Frame{ID: frameID, Data: data})
+
+		return nil
+	}
+
+	text := []struct {
+		frameID string
+		value   string
+	}{
+		{"TIT2", tag.Title},
+		{"TPE1", tag.Artist},
+		{"TALB", tag.Album},
+		{"TPE2", tag.AlbumArtist},
+		{"TCON", tag.Genre},
+		{"TCOM", tag.Composer},
+	}
+
+	for _, field := range text {
+		values := []string{field.value}
+		if field.frameID == "TPE1" && len(tag.Artists) > 0 {
+			values = writer.multiple(tag.Artists)
+		}
+
+		if values[0] == "" {
+			continue
+		}
+
+		if err := addText(field.frameID, values...); err != nil {
+			return nil, err
+		}
+	}
+
+	if tag.Year > 0 {
+		frameID := "TYER"
+		if writer.version == id3v24Major {
+			frameID = "TDRC"
+		}
+
+		if err := addText(frameID, strconv.Itoa(tag.Year)); err != nil {
+			return nil, err
+		}
+	}
+
+	if tag.Track > 0 {
+		if err := addText("TRCK", formatID3Pair(tag.Track, tag.TrackTotal)); err != nil {
+			return nil, err
+		}
+	}
+
+	if tag.Disc > 0 {
+		if err := addText("TPOS", formatID3Pair(tag.Disc, tag.DiscTotal)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, frameID := range slices.Sorted(maps.Keys(tag.Text)) {
+		if err := addText(frameID, writer.multiple(tag.Text[frameID])...); err != nil {
+			return nil, err
+		}
+	}
+
+	comments := tag.Comments
+	if tag.Comment != "" {
+		comments = append([]ID3Comment{{Text: tag.Comment}}, comments...)
+	}
+
+	for _, comment := range comments {
+		data, err := writer.comment(comment)
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, ID3Frame{ID: "COMM", Data: data})
+	}
+
+	for _, lyrics := range tag.Lyrics {
+		data, err := writer.comment(lyrics)
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, ID3Frame{ID: "USLT", Data: data})
+	}
+
+	for _, userText := range tag.UserText {
+		data, err := writer.text(append([]string{userText.Description}, writer.multiple(userText.Values)...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, ID3Frame{ID: "TXXX", Data: data})
+	}
+
+	for _, picture := range tag.Pictures {
+		data, err := writer.picture(picture)
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, ID3Frame{ID: "APIC", Data: data})
+	}
+
+	return append(frames, tag.Frames...), nil
+}
+
+// frame encodes a frame header and body for the writer's version.
+func (writer id3Writer) frame(frame ID3Frame) ([]byte, error) {
+	frameID := frame.ID
+
+	if writer.version == id3v22Major && len(frameID) == id3v2FrameIDSize {
+		mapped, ok := id3v23ToV22FrameID(frameID)
+		if !ok {
+			return nil, fmt.Errorf("%w: frame %s has no ID3v2.2 equivalent", ErrID3Option, frameID)
+		}
+
+		frameID = mapped
+	}
+
+	idSize := id3v2FrameIDSize
+	if writer.version == id3v22Major {
+		idSize = id3v22FrameIDSize
+	}
+
+	if len(frameID) != idSize {
+		return nil, fmt.Errorf("%w: invalid ID3v2.%d frame ID %q", ErrID3Option, writer.version, frameID)
+	}
+
+	data := frame.Data
+	out := []byte(frameID)
+
+	switch writer.version {
+	case id3v22Major:
+		if len(data) > id3v22MaxFrameSize {
+			return nil, fmt.Errorf("%w: frame %s exceeds 24 bits", ErrID3Option, frameID)
+		}
+
+		out = append(out, byte(len(data)>>16), byte(len(data)>>8), byte(len(data))) //nolint:gosec // G115: size checked above.
+	case id3v23Major:
+		out = binary.BigEndian.AppendUint32(out, uint32(len(data))) //nolint:gosec // G115: bounded by the tag size check.
+		out = append(out, 0, 0)
+	default:
+		var format byte
+
+		if writer.unsync {
+			data = applyUnsync(data)
+			format = id3v24FrameUnsync
+		}
+
+		if len(data) > id3MaxSynchsafe {
+			return nil, fmt.Errorf("%w: frame %s exceeds 28 bits", ErrID3Option, frameID)
+		}
+
+		out = appendSynchsafe(out, len(data))
+		out = append(out, 0, format)
+	}
+
+	return append(out, data...), nil
+}
+
+// text encodes a text frame body: the encoding byte, then values separated by terminators.
+func (writer id3Writer) text(values ...string) ([]byte, error) {
+	out := []byte{writer.encoding}
+
+	for idx, value := range values {
+		if idx > 0 {
+			out = append(out, writer.terminator()...)
+		}
+
+		encoded, err := writer.encode(value)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, encoded...)
+	}
+
+	return out, nil
+}
+
+// multiple returns the values of a multi-value field: kept apart in ID3v2.4, where frames hold
+// null-separated values, and joined with "/" before.
+func (writer id3Writer) multiple(values []string) []string {
+	if writer.version == id3v24Major || len(values) < 2 {
+		return values
+	}
+
+	return []string{strings.Join(values, id3v23Separator)}
+}
+
+// comment encodes a COMM or USLT frame body.
+func (writer id3Writer) comment(comment ID3Comment) ([]byte, error) {
+	language := comment.Language
+	if language == "" {
+		language = id3DefaultLanguage
+	}
+
+	if len(language) != id3LanguageSize {
+		return nil, fmt.Errorf("%w: comment language %q is not a three-letter code", ErrID3Option, language)
+	}
+
+	description, err := writer.encode(comment.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := writer.encode(comment.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Concat([]byte{writer.encoding}, []byte(language), description, writer.terminator(), text), nil
+}
+
+// picture encodes an APIC frame body, or a PIC frame body for ID3v2.2.
+func (writer id3Writer) picture(picture ID3Picture) ([]byte, error) {
+	mime := picture.MIME
+	if mime == "" {
+		mime = sniffImageMIME(picture.Data)
+	}
+
+	description, err := writer.encode(picture.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []byte{writer.encoding}
+
+	if writer.version == id3v22Major {
+		// ID3v2.2 identifies the image format with three characters instead of a MIME type.
+		format := strings.ToUpper(strings.TrimPrefix(mime, "image/"))
+		if format == "JPEG" {
+			format = "JPG"
+		}
+
+		if len(format) != id3v22FrameIDSize {
+			return nil, fmt.Errorf("%w: MIME type %q has no ID3v2.2 image format", ErrID3Option, mime)
+		}
+
+		out = append(out, format...)
+	} else {
+		out = append(append(out, mime...), 0)
+	}
+
+	return slices.Concat(out, []byte{picture.Type}, description, writer.terminator(), picture.Data), nil
+}
+
+// encode encodes one string, without terminator, in the writer's text encoding.
+func (writer id3Writer) encode(value string) ([]byte, error) {
+	switch writer.encoding {
+	case id3EncodingUTF16:
+		out := []byte{0xFF, 0xFE}
+		for _, unit := range utf16.Encode([]rune(value)) {
+			out = binary.LittleEndian.AppendUint16(out, unit)
+		}
+
+		return out, nil
+	case id3EncodingUTF16BE:
+		var out []byte
+		for _, unit := range utf16.Encode([]rune(value)) {
+			out = binary.BigEndian.AppendUint16(out, unit)
+		}
+
+		return out, nil
+	case id3EncodingUTF8:
+		return []byte(value), nil
+	default:
+		out := make([]byte, 0, len(value))
+
+		for _, char := range value {
+			if char > 0xFF {
+				return nil, fmt.Errorf("%w: %q cannot be encoded in Latin-1", ErrID3Option, value)
+			}
+
+			out = append(out, byte(char))
+		}
+
+		return out, nil
+	}
+}
+
+// terminator returns the string terminator of the writer's text encoding.
+func (writer id3Writer) terminator() []byte {
+	if writer.encoding == id3EncodingUTF16 || writer.encoding == id3EncodingUTF16BE {
+		return []byte{0, 0}
+	}
+
+	return []byte{0}
+}
+
+// id3v23ToV22FrameID returns the ID3v2.2 frame ID of an ID3v2.3/2.4 frame ID.
+// TDRC, which has no ID3v2.2 equivalent, maps to the year frame.
+func id3v23ToV22FrameID(frameID string) (string, bool) {
+	if frameID == "TDRC" {
+		frameID = "TYER"
+	}
+
+	for short, long := range id3v22FrameIDs {
+		if long == frameID {
+			return short, true
+		}
+	}
+
+	return "", false
+}
+
+// formatID3Pair formats a number and optional total as "N" or "N/T".
+func formatID3Pair(number, total int) string {
+	if total > 0 {
+		return strconv.Itoa(number) + "/" + strconv.Itoa(total)
+	}
+
+	return strconv.Itoa(number)
+}
+
+// encodeLatin1Lossy encodes value in Latin-1, replacing other characters with "?".
+func encodeLatin1Lossy(value string) []byte {
+	out := make([]byte, 0, len(value))
+
+	for _, char := range value {
+		if char > 0xFF {
+			char = '?'
+		}
+
+		out = append(out, byte(char))
+	}
+
+	return out
+}
+
+// applyUnsync applies ID3v2 unsynchronisation: a zero byte is inserted after each 0xFF followed by
+// a byte that could form a false MPEG sync (0xE0 and above), by a zero byte, or ending data.
+func applyUnsync(data []byte) []byte {
+	const falseSync = 0xE0
+
+	out := make([]byte, 0, len(data))
+
+	for idx, b := range data {
+		out = append(out, b)
+
+		if b == 0xFF && (idx+1 == len(data) || data[idx+1] == 0 || data[idx+1] >= falseSync) {
+			out = append(out, 0)
+		}
+	}
+
+	return out
+}
+
+// appendSynchsafe appends a 28-bit synchsafe integer (7 bits per byte).
+func appendSynchsafe(out []byte, value int) []byte {
+	return append(out,
+		byte(value>>(3*synchsafeBits))&synchsafeMask,
+		byte(value>>(2*synchsafeBits))&synchsafeMask,
+		byte(value>>synchsafeBits)&synchsafeMask,
+		byte(value)&synchsafeMask,
+	)
+}
@@ -0,0 +1,197 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+func id3TestTag(artists ...string) agar.ID3Tag {
+	return agar.ID3Tag{
+		MP3Tags: agar.DefaultMP3Tags(),
+		Artists: artists,
+		UserText: []agar.ID3UserText{
+			{Description: "ARTISTS", Values: []string{"a", "b"}},
+			{Description: "MusicBrainz Album Id", Values: []string{"album-id"}},
+		},
+		Comments: []agar.ID3Comment{{Language: "fra", Description: "note", Text: "bonjour ÿ"}},
+		Pictures: []agar.ID3Picture{{
+			Type: agar.ID3PictureFrontCover, Description: "cover", Data: []byte("\x89PNG\r\n\x1a\n\xFF\xE0\xFF"),
+		}},
+		Frames: []agar.ID3Frame{{ID: "TBPM", Data: []byte("\x00120")}},
+	}
+}
+
+func TestWriteID3v2RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	// Frame sync patterns in the audio and in the picture exercise unsynchronisation.
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00, 0xFF, 0x00}, 50)
+	id3v1 := agar.EncodeID3v1(agar.MP3Tags{Title: "v1", Track: 9})
+
+	for _, opts := range id3OptionCombinations() {
+		name := fmt.Sprintf("%+v", opts)
+
+		artists := []string{"Ärtist", "Second 名前"}
+		if opts.Encoding == agar.ID3EncodingLatin1 {
+			artists = []string{"Ärtist", "Second"}
+		}
+
+		path := filepath.Join(t.TempDir(), "tags.mp3")
+		if err := os.WriteFile(path, slices.Concat(audio, id3v1), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		// Writing twice checks that the first tag is replaced rather than kept.
+		for range 2 {
+			if err := agar.WriteID3v2(path, id3TestTag(artists...), opts); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (opts.Footer && !bytes.HasPrefix(content, audio)) || !bytes.HasSuffix(content, id3v1) {
+			t.Errorf("%s: audio or ID3v1 tag moved", name)
+		}
+
+		major := map[agar.ID3Version]byte{agar.ID3v22: 2, agar.ID3v23: 3, agar.ID3v24: 4}[opts.Version]
+		if !opts.Footer && content[3] != major {
+			t.Errorf("%s: major version %d", name, content[3])
+		}
+
+		tags, err := agar.ParseID3v2(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		text := tags.Text
+		if text["title"][0] != "Test Title" || text["comment"][0] != "Test Comment" ||
+			text["comment:note"][0] != "bonjour ÿ" || text["tempo"][0] != "120" ||
+			text["date"][0] != "2000" || text["genre"][0] != "Jazz" ||
+			text["musicbrainz_albumid"][0] != "album-id" {
+			t.Errorf("%s: parsed %q", name, text)
+		}
+
+		if tags.Track != 3 || tags.TrackTotal != 6 || tags.Disc != 2 || tags.PictureCount != 1 {
+			t.Errorf("%s: track %d/%d, disc %d, %d pictures", name,
+				tags.Track, tags.TrackTotal, tags.Disc, tags.PictureCount)
+		}
+
+		// ID3v2.4 separates values with null characters, earlier versions join them with "/".
+		expected := artists
+		if opts.Version != agar.ID3v24 {
+			expected = []string{artists[0] + "/" + artists[1]}
+		}
+
+		if !slices.Equal(text["artist"], expected) {
+			t.Errorf("%s: artists %q, expected %q", name, text["artist"], expected)
+		}
+	}
+}
+
+// id3OptionCombinations returns every valid combination of version, encoding, unsynchronisation and footer.
+func id3OptionCombinations() []agar.ID3Options {
+	var combinations []agar.ID3Options
+
+	for _, version := range []agar.ID3Version{agar.ID3v22, agar.ID3v23, agar.ID3v24} {
+		encodings := []agar.ID3Encoding{agar.ID3EncodingAuto, agar.ID3EncodingLatin1, agar.ID3EncodingUTF16}
+		footers := []bool{false}
+
+		if version == agar.ID3v24 {
+			encodings = append(encodings, agar.ID3EncodingUTF16BE, agar.ID3EncodingUTF8)
+			footers = append(footers, true)
+		}
+
+		for _, encoding := range encodings {
+			for _, unsync := range []bool{false, true} {
+				for _, footer := range footers {
+					opts := agar.ID3Options{
+						Version: version, Encoding: encoding, Unsynchronisation: unsync, Footer: footer,
+					}
+					if !footer {
+						opts.Padding = 100
+					}
+
+					combinations = append(combinations, opts)
+				}
+			}
+		}
+	}
+
+	return combinations
+}
+
+func TestWriteID3v2Options(t *testing.T) {
+	t.Parallel()
+
+	for _, opts := range []agar.ID3Options{
+		{Version: agar.ID3v23, Footer: true},
+		{Version: agar.ID3v22, Encoding: agar.ID3EncodingUTF8},
+		{Version: agar.ID3v23, Encoding: agar.ID3EncodingUTF16BE},
+	} {
+		if _, err := agar.EncodeID3v2(id3TestTag(), opts); !errors.Is(err, agar.ErrID3Option) {
+			t.Errorf("%+v: got %v, expected ErrID3Option", opts, err)
+		}
+	}
+}
+
+func TestWriteID3v1(t *testing.T) {
+	t.Parallel()
+
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 50)
+
+	path := filepath.Join(t.TempDir(), "v1.mp3")
+	if err := os.WriteFile(path, audio, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := agar.WriteID3v1(path, agar.DefaultMP3Tags()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(content) != len(audio)+128 || !bytes.HasPrefix(content, audio) {
+		t.Fatalf("file holds %d bytes, expected the audio and one 128-byte tag", len(content))
+	}
+
+	tags, err := agar.ParseID3v2(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tags.Text["title"][0] != "Test Title" || tags.Text["genre"][0] != "Jazz" || tags.Track != 3 {
+		t.Errorf("parsed %q, track %d", tags.Text, tags.Track)
+	}
+}
@@ -16,29 +16,24 @@
 
 package agar
 
-import (
-	"path/filepath"
-
-	"github.com/containerd/nerdctl/mod/tigron/test"
-)
+import "github.com/containerd/nerdctl/mod/tigron/test"
 
 // GenerateTestJPEG creates a test JPEG image with a solid color background.
 // Returns the path to the generated image.
 func GenerateTestJPEG(data test.Data, helpers test.Helpers, color string) string {
 	helpers.T().Helper()
 
-	ffmpeg := lookForOrFail(helpers.T(), ffmpegBinary)
-	outputPath := filepath.Join(data.Temp().Dir(), "test-cover.jpg")
+	return TestJPEGGenerator(color).Path(data, helpers)
+}
 
-	helpers.Custom(ffmpeg, "-y",
+// TestJPEGGenerator returns the generator of 500x500 JPEG images with a solid color background.
+func TestJPEGGenerator(color string) Generator {
+	return ffmpegGenerator("GenerateTestJPEG", "test-cover-"+color+".jpg", []string{
 		"-f", "lavfi",
-		"-i", "color=c="+color+":s=500x500:d=1",
+		"-i", "color=c=" + color + ":s=500x500:d=1",
 		"-frames:v", "1",
 		"-q:v", "2",
-		outputPath,
-	).Run(&test.Expected{})
-
-	return outputPath
+	})
 }
 
 // GenerateTestPNG creates a test PNG image with a solid color background.
@@ -46,31 +41,38 @@ func GenerateTestJPEG(data test.Data, helpers test.Helpers, color string) string
 func GenerateTestPNG(data test.Data, helpers test.Helpers, color string) string {
 	helpers.T().Helper()
 
-	ffmpeg := lookForOrFail(helpers.T(), ffmpegBinary)
-	outputPath := filepath.Join(data.Temp().Dir(), "test-cover.png")
+	return TestPNGGenerator(color).Path(data, helpers)
+}
 
-	helpers.Custom(ffmpeg, "-y",
+// TestPNGGenerator returns the generator of 500x500 PNG images with a solid color background.
+func TestPNGGenerator(color string) Generator {
+	return ffmpegGenerator("GenerateTestPNG", "test-cover-"+color+".png", []string{
 		"-f", "lavfi",
-		"-i", "color=c="+color+":s=500x500:d=1",
+		"-i", "color=c=" + color + ":s=500x500:d=1",
 		"-frames:v", "1",
-		outputPath,
-	).Run(&test.Expected{})
-
-	return outputPath
+	})
 }
 
 // TestCoverJPEG returns path to a default test cover image (blue, JPEG).
 func TestCoverJPEG(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return GenerateTestJPEG(data, helpers, "blue")
+	return testCoverJPEG().Path(data, helpers)
+}
+
+func testCoverJPEG() Generator {
+	return TestJPEGGenerator("blue").renamed("TestCoverJPEG", "test-cover-blue.jpg")
 }
 
 // TestCoverPNG returns path to a default test cover image (red, PNG).
 func TestCoverPNG(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return GenerateTestPNG(data, helpers, "red")
+	return testCoverPNG().Path(data, helpers)
+}
+
+func testCoverPNG() Generator {
+	return TestPNGGenerator("red").renamed("TestCoverPNG", "test-cover-red.png")
 }
 
 // TestCoverAlternate returns path to an alternate test cover image (green, JPEG).
@@ -78,5 +80,9 @@ func TestCoverPNG(data test.Data, helpers test.Helpers) string {
 func TestCoverAlternate(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return GenerateTestJPEG(data, helpers, "green")
+	return testCoverAlternate().Path(data, helpers)
+}
+
+func testCoverAlternate() Generator {
+	return TestJPEGGenerator("green").renamed("TestCoverAlternate", "test-cover-green.jpg")
 }
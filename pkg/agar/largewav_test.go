@@ -0,0 +1,243 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// largeWAVChunk is a chunk of an RF64 or Wave64 file.
+type largeWAVChunk struct {
+	id   string
+	size uint64
+	body []byte
+}
+
+// rf64Chunks checks the RF64 preamble of file and returns its chunks, with the body of the data chunk
+// truncated to what is actually present.
+func rf64Chunks(t *testing.T, file []byte) []largeWAVChunk {
+	t.Helper()
+
+	if string(file[:4]) != "RF64" || binary.LittleEndian.Uint32(file[4:]) != 0xFFFFFFFF ||
+		string(file[8:12]) != "WAVE" {
+		t.Fatalf("not an RF64 preamble: % X", file[:12])
+	}
+
+	var chunks []largeWAVChunk
+
+	for rest := file[12:]; len(rest) >= 8; {
+		chunk := largeWAVChunk{id: string(rest[:4]), size: uint64(binary.LittleEndian.Uint32(rest[4:]))}
+		end := min(8+int(min(chunk.size, uint64(len(rest)))), len(rest))
+		chunk.body = rest[8:end]
+		chunks = append(chunks, chunk)
+		rest = rest[min(end+end%2, len(rest)):]
+	}
+
+	return chunks
+}
+
+// w64Chunks checks the Wave64 preamble of file against its length and returns its chunks, with the body of
+// the data chunk truncated to what is actually present.
+func w64Chunks(t *testing.T, file []byte, fileSize uint64) []largeWAVChunk {
+	t.Helper()
+
+	if string(file[:4]) != "riff" || string(file[24:28]) != "wave" {
+		t.Fatalf("not a Wave64 preamble: % X", file[:40])
+	}
+
+	if size := binary.LittleEndian.Uint64(file[16:]); size != fileSize {
+		t.Errorf("riff size %d, expected %d", size, fileSize)
+	}
+
+	var chunks []largeWAVChunk
+
+	for rest := file[40:]; len(rest) >= 24; {
+		chunk := largeWAVChunk{id: string(rest[:4]), size: binary.LittleEndian.Uint64(rest[16:]) - 24}
+		end := min(24+int(min(chunk.size, uint64(len(rest)))), len(rest))
+		chunk.body = rest[24:end]
+		chunks = append(chunks, chunk)
+		rest = rest[min((end+7)/8*8, len(rest)):]
+	}
+
+	return chunks
+}
+
+// largeWAVCases are the formats written by the RF64 and Wave64 round-trip tests. 24-bit mono leaves an
+// odd-sized data chunk, which must be padded.
+func largeWAVCases() []agar.Format {
+	return []agar.Format{
+		{SampleRate: 44100, BitDepth: 16, Channels: 2},
+		{SampleRate: 48000, BitDepth: 24, Channels: 1},
+	}
+}
+
+func TestRF64RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range largeWAVCases() {
+		t.Run(strconv.Itoa(format.BitDepth)+"-bit", func(t *testing.T) {
+			t.Parallel()
+
+			pcm := make([]byte, 3*format.FrameSize())
+			for idx := range pcm {
+				pcm[idx] = byte(idx*37 + 1)
+			}
+
+			for _, declared := range []int64{0, 1 << 30} {
+				path := filepath.Join(t.TempDir(), "large.rf64")
+
+				err := agar.WriteRF64WithOptions(path, pcm, format, agar.LargeWAVOptions{DeclaredFrames: declared})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				file, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				frames := uint64(max(declared, 3))
+				dataSize := frames * uint64(format.FrameSize())
+				chunks := rf64Chunks(t, file)
+
+				if len(chunks) != 3 || chunks[0].id != "ds64" || chunks[1].id != "fmt " || chunks[2].id != "data" {
+					t.Fatalf("declared %d: unexpected chunks %+v", declared, chunks)
+				}
+
+				ds64 := chunks[0].body
+				riffSize := uint64(len(file)) - 8
+				if declared != 0 {
+					riffSize = uint64(len(file)) - uint64(len(pcm)) + dataSize - 8
+				}
+
+				if got := binary.LittleEndian.Uint64(ds64); got != riffSize {
+					t.Errorf("declared %d: ds64 riff size %d, expected %d", declared, got, riffSize)
+				}
+
+				if got := binary.LittleEndian.Uint64(ds64[8:]); got != dataSize {
+					t.Errorf("declared %d: ds64 data size %d, expected %d", declared, got, dataSize)
+				}
+
+				if got := binary.LittleEndian.Uint64(ds64[16:]); got != frames {
+					t.Errorf("declared %d: ds64 frames %d, expected %d", declared, got, frames)
+				}
+
+				if chunks[2].size != 0xFFFFFFFF || !bytes.Equal(chunks[2].body[:len(pcm)], pcm) {
+					t.Errorf("declared %d: data chunk size %#x, body % X", declared, chunks[2].size, chunks[2].body)
+				}
+
+				if declared == 0 && len(file)%2 != 0 {
+					t.Errorf("odd file size %d: data chunk not padded", len(file))
+				}
+			}
+		})
+	}
+}
+
+func TestW64RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range largeWAVCases() {
+		t.Run(strconv.Itoa(format.BitDepth)+"-bit", func(t *testing.T) {
+			t.Parallel()
+
+			pcm := make([]byte, 3*format.FrameSize())
+			for idx := range pcm {
+				pcm[idx] = byte(idx*37 + 1)
+			}
+
+			for _, declared := range []int64{0, 1 << 30} {
+				path := filepath.Join(t.TempDir(), "large.w64")
+
+				err := agar.WriteW64WithOptions(path, pcm, format, agar.LargeWAVOptions{DeclaredFrames: declared})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				file, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				dataSize := uint64(max(declared, 3)) * uint64(format.FrameSize())
+				fileSize := uint64(len(file))
+
+				if declared != 0 {
+					fileSize += (dataSize+7)/8*8 - uint64(len(pcm))
+				} else if len(file)%8 != 0 {
+					t.Errorf("file size %d: data chunk not padded to 8 bytes", len(file))
+				}
+
+				chunks := w64Chunks(t, file, fileSize)
+				if len(chunks) != 2 || chunks[0].id != "fmt " || chunks[1].id != "data" {
+					t.Fatalf("declared %d: unexpected chunks %+v", declared, chunks)
+				}
+
+				if chunks[1].size != dataSize || !bytes.Equal(chunks[1].body[:len(pcm)], pcm) {
+					t.Errorf("declared %d: data chunk size %d, expected %d, body % X",
+						declared, chunks[1].size, dataSize, chunks[1].body)
+				}
+			}
+		})
+	}
+}
+
+func TestLargeWAVSparse(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 44100, BitDepth: 16, Channels: 2}
+	pcm := make([]byte, 4*format.FrameSize())
+	opts := agar.LargeWAVOptions{DeclaredFrames: 1000, Sparse: true}
+
+	for name, write := range map[string]func(path string) error{
+		"rf64": func(path string) error { return agar.WriteRF64WithOptions(path, pcm, format, opts) },
+		"w64":  func(path string) error { return agar.WriteW64WithOptions(path, pcm, format, opts) },
+	} {
+		path := filepath.Join(t.TempDir(), "sparse."+name)
+		if err := write(path); err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The header sizes describe the whole file once the declared audio is all there.
+		if name == "rf64" {
+			if riffSize := binary.LittleEndian.Uint64(rf64Chunks(t, file)[0].body); riffSize != uint64(len(file))-8 {
+				t.Errorf("rf64: ds64 riff size %d for a %d byte file", riffSize, len(file))
+			}
+		} else {
+			w64Chunks(t, file, uint64(len(file)))
+		}
+	}
+
+	err := agar.WriteRF64WithOptions(filepath.Join(t.TempDir(), "short.rf64"), pcm, format,
+		agar.LargeWAVOptions{DeclaredFrames: 3})
+	if !errors.Is(err, agar.ErrDeclaredSizeTooSmall) {
+		t.Errorf("declaring fewer frames than present: got %v, expected ErrDeclaredSizeTooSmall", err)
+	}
+}
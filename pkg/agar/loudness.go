@@ -0,0 +1,379 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// ITU-R BS.1770-4 and EBU Tech 3342 measurement parameters.
+const (
+	momentaryWindow = 400 * time.Millisecond
+	shortTermWindow = 3 * time.Second
+	loudnessHop     = 100 * time.Millisecond
+
+	// loudnessOffset is the -0.691 dB term calibrating a 1 kHz full-scale sine to -3.01 LKFS per channel.
+	loudnessOffset = -0.691
+	// absoluteGate is the absolute gating threshold in LUFS.
+	absoluteGate = -70
+	// integratedRelativeGate and rangeRelativeGate are relative gating thresholds in LU.
+	integratedRelativeGate = -10
+	rangeRelativeGate      = -20
+	// rangeLowPercentile and rangeHighPercentile bound the loudness range distribution.
+	rangeLowPercentile  = 0.10
+	rangeHighPercentile = 0.95
+
+	// surroundWeight is the channel weight of the surround channels of 5.0 and 5.1 layouts.
+	surroundWeight = 1.41
+	// Channel counts with surround channels.
+	surroundChannels    = 5
+	surroundLFEChannels = 6
+	// lfeChannel is the LFE index of the 5.1 layout (L R C LFE Ls Rs), excluded from the measurement.
+	lfeChannel = 3
+
+	// truePeakOversampling is the oversampling factor of the true-peak measurement.
+	truePeakOversampling = 4
+	// truePeakTaps is the number of taps per phase of the interpolation filter.
+	truePeakTaps = 32
+	// hannHalf is the offset and amplitude of the Hann window.
+	hannHalf = 0.5
+)
+
+// K-weighting pre-filter (high shelf) and RLB high-pass parameters, from which the biquad coefficients
+// are derived for any sample rate. At 48 kHz they yield the coefficients tabulated in BS.1770.
+const (
+	shelfFrequency = 1681.974450955533
+	shelfGainDB    = 3.999843853973347
+	shelfQ         = 0.7071752369554196
+	shelfBandGain  = 0.4996667741545416
+
+	highPassFrequency = 38.13547087602444
+	highPassQ         = 0.5003270373238773
+)
+
+// Loudness holds EBU R128 measurements. Levels are in LUFS, ranges in LU and peaks in dBTP.
+// Gated-out or empty measurements are negative infinity.
+type Loudness struct {
+	// Integrated is the gated integrated loudness.
+	Integrated float64
+	// Range is the loudness range (LRA) following EBU Tech 3342.
+	Range float64
+	// Momentary holds the loudness of 400 ms windows, every 100 ms.
+	Momentary []float64
+	// ShortTerm holds the loudness of 3 s windows, every 100 ms.
+	ShortTerm []float64
+	// TruePeak is the highest channel true peak.
+	TruePeak float64
+	// ChannelTruePeaks holds the 4x oversampled true peak of each channel.
+	ChannelTruePeaks []float64
+}
+
+// MaxMomentary returns the highest momentary loudness.
+func (l *Loudness) MaxMomentary() float64 {
+	return maxLoudness(l.Momentary)
+}
+
+// MaxShortTerm returns the highest short-term loudness.
+func (l *Loudness) MaxShortTerm() float64 {
+	return maxLoudness(l.ShortTerm)
+}
+
+// MeasureLoudness measures interleaved PCM following ITU-R BS.1770-4 and EBU R128.
+// Channels are weighted 1.0, except the surround channels of 5 channel (L R C Ls Rs) and 6 channel
+// (L R C LFE Ls Rs) audio, weighted 1.41, and the LFE channel, which is ignored.
+func MeasureLoudness(pcm []byte, format Format) (*Loudness, error) {
+	channels, err := pcmChannels(pcm, format)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := len(channels[0])
+	weights := loudnessWeights(format.Channels)
+
+	// power[n] accumulates the weighted K-filtered power of frames [0, n).
+	power := make([]float64, frames+1)
+	loudness := &Loudness{
+		TruePeak:         math.Inf(-1),
+		ChannelTruePeaks: make([]float64, format.Channels),
+	}
+
+	for ch, samples := range channels {
+		loudness.ChannelTruePeaks[ch] = AmplitudeToDB(truePeak(samples))
+		loudness.TruePeak = max(loudness.TruePeak, loudness.ChannelTruePeaks[ch])
+
+		if weights[ch] == 0 {
+			continue
+		}
+
+		for idx, val := range kWeight(samples, format.SampleRate) {
+			power[idx+1] += weights[ch] * val * val
+		}
+	}
+
+	for idx := range frames {
+		power[idx+1] += power[idx]
+	}
+
+	hop := max(1, SamplesFor(loudnessHop, format.SampleRate))
+	momentary := windowPowers(power, SamplesFor(momentaryWindow, format.SampleRate), hop)
+	shortTerm := windowPowers(power, SamplesFor(shortTermWindow, format.SampleRate), hop)
+
+	loudness.Momentary = powersToLoudness(momentary)
+	loudness.ShortTerm = powersToLoudness(shortTerm)
+	loudness.Integrated = integratedLoudness(momentary)
+	loudness.Range = loudnessRange(shortTerm)
+
+	return loudness, nil
+}
+
+// MeasureLoudnessFile decodes an audio file with DecodeFile and measures it with MeasureLoudness.
+func MeasureLoudnessFile(path string) (*Loudness, error) {
+	pcm, format, err := DecodeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return MeasureLoudness(pcm, format)
+}
+
+// loudnessWeights returns the BS.1770 channel weights for a channel count.
+func loudnessWeights(channels int) []float64 {
+	weights := make([]float64, channels)
+	for idx := range weights {
+		weights[idx] = 1
+	}
+
+	switch channels {
+	case surroundChannels:
+		weights[3], weights[4] = surroundWeight, surroundWeight
+	case surroundLFEChannels:
+		weights[lfeChannel] = 0
+		weights[4], weights[5] = surroundWeight, surroundWeight
+	default:
+	}
+
+	return weights
+}
+
+// kWeight applies the BS.1770 K-weighting filter (high shelf then RLB high-pass) to a channel.
+func kWeight(samples []float64, sampleRate int) []float64 {
+	rate := float64(sampleRate)
+
+	k := math.Tan(math.Pi * shelfFrequency / rate)
+	vh := math.Pow(10, shelfGainDB/decibelFactor)
+	vb := math.Pow(vh, shelfBandGain)
+	a0 := 1 + k/shelfQ + k*k
+
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	k = math.Tan(math.Pi * highPassFrequency / rate)
+	a0 = 1 + k/highPassQ + k*k
+
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/highPassQ + k*k) / a0,
+	}
+
+	return highPass.apply(shelf.apply(samples))
+}
+
+// biquad is a second order IIR section with a0 normalized to 1.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+// apply filters samples in direct form I.
+func (f biquad) apply(samples []float64) []float64 {
+	out := make([]float64, len(samples))
+
+	var x1, x2, y1, y2 float64
+
+	for idx, x0 := range samples {
+		y0 := f.b0*x0 + f.b1*x1 + f.b2*x2 - f.a1*y1 - f.a2*y2
+		x2, x1 = x1, x0
+		y2, y1 = y1, y0
+		out[idx] = y0
+	}
+
+	return out
+}
+
+// windowPowers returns the mean power of every full window of size samples, every hop samples,
+// from a cumulative power sum.
+func windowPowers(cumulative []float64, size, hop int) []float64 {
+	var powers []float64
+
+	for start := 0; size > 0 && start+size < len(cumulative); start += hop {
+		powers = append(powers, (cumulative[start+size]-cumulative[start])/float64(size))
+	}
+
+	return powers
+}
+
+// powerToLoudness converts a weighted mean power to LUFS.
+func powerToLoudness(power float64) float64 {
+	return loudnessOffset + decibelFactor/2*math.Log10(power)
+}
+
+func powersToLoudness(powers []float64) []float64 {
+	out := make([]float64, len(powers))
+	for idx, power := range powers {
+		out[idx] = powerToLoudness(power)
+	}
+
+	return out
+}
+
+// gatePowers returns the powers above the absolute gate and then above the relative gate, in LU below
+// the loudness of their mean.
+func gatePowers(powers []float64, relativeGate float64) []float64 {
+	gated := slices.DeleteFunc(slices.Clone(powers), func(power float64) bool {
+		return powerToLoudness(power) <= absoluteGate
+	})
+
+	if len(gated) == 0 {
+		return nil
+	}
+
+	threshold := powerToLoudness(meanPower(gated)) + relativeGate
+
+	return slices.DeleteFunc(gated, func(power float64) bool {
+		return powerToLoudness(power) <= threshold
+	})
+}
+
+// integratedLoudness gates momentary block powers as BS.1770-4 specifies.
+func integratedLoudness(blocks []float64) float64 {
+	gated := gatePowers(blocks, integratedRelativeGate)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+
+	return powerToLoudness(meanPower(gated))
+}
+
+// loudnessRange gates short-term powers and returns the spread between the 10th and 95th percentile,
+// as EBU Tech 3342 specifies.
+func loudnessRange(shortTerm []float64) float64 {
+	gated := powersToLoudness(gatePowers(shortTerm, rangeRelativeGate))
+	if len(gated) == 0 {
+		return 0
+	}
+
+	slices.Sort(gated)
+
+	percentile := func(fraction float64) float64 {
+		return gated[int(math.Round(fraction*float64(len(gated)-1)))]
+	}
+
+	return percentile(rangeHighPercentile) - percentile(rangeLowPercentile)
+}
+
+func meanPower(powers []float64) float64 {
+	var sum float64
+	for _, power := range powers {
+		sum += power
+	}
+
+	return sum / float64(len(powers))
+}
+
+func maxLoudness(values []float64) float64 {
+	if len(values) == 0 {
+		return math.Inf(-1)
+	}
+
+	return slices.Max(values)
+}
+
+// truePeak returns the highest absolute value of a channel oversampled 4x with a Hann-windowed
+// sinc interpolator, sample values included. Samples beyond either end are zero, so an abrupt start
+// or end registers the overshoot a reconstruction filter produces on playback.
+func truePeak(samples []float64) float64 {
+	phases := truePeakFilter()
+
+	var peak float64
+
+	for idx, val := range samples {
+		peak = max(peak, math.Abs(val))
+
+		for _, phase := range phases {
+			var sum float64
+
+			for tap, coefficient := range phase {
+				// Taps are centered between samples idx and idx+1.
+				position := idx + tap - truePeakTaps/2 + 1
+				if position >= 0 && position < len(samples) {
+					sum += coefficient * samples[position]
+				}
+			}
+
+			peak = max(peak, math.Abs(sum))
+		}
+	}
+
+	return peak
+}
+
+// truePeakFilter returns the polyphase coefficients interpolating at 1/4, 2/4 and 3/4 of a sample
+// period, each phase normalized to unity gain.
+func truePeakFilter() [][]float64 {
+	phases := make([][]float64, truePeakOversampling-1)
+	half := float64(truePeakTaps) / 2
+
+	for phase := range phases {
+		fraction := float64(phase+1) / truePeakOversampling
+		coefficients := make([]float64, truePeakTaps)
+
+		var sum float64
+
+		for tap := range coefficients {
+			// Distance in samples from the interpolated point to the tap.
+			distance := float64(tap) - half + 1 - fraction
+			window := hannHalf + hannHalf*math.Cos(math.Pi*distance/half)
+			coefficients[tap] = sinc(distance) * window
+			sum += coefficients[tap]
+		}
+
+		for tap := range coefficients {
+			coefficients[tap] /= sum
+		}
+
+		phases[phase] = coefficients
+	}
+
+	return phases
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
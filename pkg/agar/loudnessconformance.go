@@ -0,0 +1,173 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// LoudnessMetric selects the measurement a conformance signal is checked against.
+type LoudnessMetric string
+
+// Loudness metrics.
+const (
+	LoudnessIntegrated LoudnessMetric = "integrated"
+	LoudnessRange      LoudnessMetric = "range"
+	LoudnessTruePeak   LoudnessMetric = "true-peak"
+)
+
+// Conformance signal parameters.
+const (
+	conformanceFrequency = 1000
+	conformanceRate      = 48000
+	// Tolerances of EBU Tech 3341 (integrated loudness), EBU Tech 3342 (loudness range) and the
+	// true-peak meter requirement of EBU Tech 3341 (+0.2/-0.4 dB).
+	integratedTolerance    = 0.1
+	rangeTolerance         = 1
+	truePeakToleranceAbove = 0.2
+	truePeakToleranceBelow = 0.4
+)
+
+// ErrLoudnessConformance is returned when a measurement falls outside a conformance tolerance.
+var ErrLoudnessConformance = errors.New("loudness measurement out of tolerance")
+
+// LoudnessConformance is a test signal with the value a conformant meter reports for it.
+type LoudnessConformance struct {
+	Name string
+	// Format is the format to render the signal at. Every channel carries the same signal.
+	Format   Format
+	Signal   Signal
+	Duration time.Duration
+	Metric   LoudnessMetric
+	// Expected is the reference value, accepted within [Min, Max].
+	Expected float64
+	Min      float64
+	Max      float64
+}
+
+// Render renders the signal as PCM in the conformance format.
+func (c LoudnessConformance) Render() ([]byte, error) {
+	return RenderPCM(c.Format, c.Duration, c.Signal)
+}
+
+// WAV returns path to the signal rendered as a WAV file, for checking the loudness meter under test.
+// The file is named after the lowercased, dash-separated conformance name.
+func (c LoudnessConformance) WAV(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return SignalWAV(data, helpers, strings.ReplaceAll(strings.ToLower(c.Name), " ", "-"),
+		c.Format, c.Duration, c.Signal)
+}
+
+// Value returns the measured value of the conformance metric.
+func (c LoudnessConformance) Value(loudness *Loudness) float64 {
+	switch c.Metric {
+	case LoudnessRange:
+		return loudness.Range
+	case LoudnessTruePeak:
+		return loudness.TruePeak
+	default:
+		return loudness.Integrated
+	}
+}
+
+// Check returns an ErrLoudnessConformance error when the measured value is out of tolerance.
+func (c LoudnessConformance) Check(loudness *Loudness) error {
+	if value := c.Value(loudness); value < c.Min || value > c.Max {
+		return fmt.Errorf("%w: %s: %s %.2f, expected %.2f", ErrLoudnessConformance, c.Name, c.Metric, value, c.Expected)
+	}
+
+	return nil
+}
+
+// LoudnessConformanceSignals returns the synthetic stereo test signals of EBU Tech 3341 (integrated
+// loudness cases 1 to 5) and EBU Tech 3342 (loudness range cases 1 to 4), plus an intersample peak
+// signal for the true-peak meter: a sine at a quarter of the sample rate sampled 45 degrees off its
+// peaks, so that samples reach -3.01 dBFS while the true peak is at 0 dBTP.
+func LoudnessConformanceSignals() []LoudnessConformance {
+	format := Format{SampleRate: conformanceRate, BitDepth: BitDepth24, Channels: 2}
+
+	integrated := func(name string, segments ...Segment) LoudnessConformance {
+		return conformanceCase(name, format, Sequence(segments), LoudnessIntegrated, -23,
+			integratedTolerance, integratedTolerance)
+	}
+
+	loudnessRange := func(name string, expected float64, segments ...Segment) LoudnessConformance {
+		return conformanceCase(name, format, Sequence(segments), LoudnessRange, expected, rangeTolerance, rangeTolerance)
+	}
+
+	tone := func(level float64, duration time.Duration) Segment {
+		return Segment{Signal: Sine{Frequency: conformanceFrequency, Level: level}, Duration: duration}
+	}
+
+	const (
+		tenSeconds    = 10 * time.Second
+		twentySeconds = 20 * time.Second
+	)
+
+	cases := []LoudnessConformance{
+		integrated("EBU Tech 3341 case 1", tone(-23, twentySeconds)),
+		conformanceCase("EBU Tech 3341 case 2", format, Sequence{tone(-33, twentySeconds)}, LoudnessIntegrated, -33,
+			integratedTolerance, integratedTolerance),
+		integrated("EBU Tech 3341 case 3",
+			tone(-36, tenSeconds), tone(-23, 60*time.Second), tone(-36, tenSeconds)),
+		integrated("EBU Tech 3341 case 4",
+			tone(-72, tenSeconds), tone(-36, tenSeconds), tone(-23, 60*time.Second),
+			tone(-36, tenSeconds), tone(-72, tenSeconds)),
+		integrated("EBU Tech 3341 case 5",
+			tone(-26, twentySeconds), tone(-20, 20100*time.Millisecond), tone(-26, twentySeconds)),
+		loudnessRange("EBU Tech 3342 case 1", 10, tone(-20, twentySeconds), tone(-30, twentySeconds)),
+		loudnessRange("EBU Tech 3342 case 2", 5, tone(-20, twentySeconds), tone(-15, twentySeconds)),
+		loudnessRange("EBU Tech 3342 case 3", 20, tone(-40, twentySeconds), tone(-20, twentySeconds)),
+		loudnessRange("EBU Tech 3342 case 4", 15,
+			tone(-50, twentySeconds), tone(-35, twentySeconds), tone(-20, twentySeconds),
+			tone(-35, twentySeconds), tone(-50, twentySeconds)),
+	}
+
+	// A quarter of the sample rate, 45 degrees off the sampling instants.
+	intersample := Sine{Frequency: conformanceRate / 4, Phase: 1.0 / 8}
+
+	return append(cases, conformanceCase("true peak intersample", format,
+		Sequence{{Signal: intersample, Duration: tenSeconds}}, LoudnessTruePeak, 0,
+		truePeakToleranceBelow, truePeakToleranceAbove))
+}
+
+// conformanceCase builds a LoudnessConformance lasting as long as its sequence.
+func conformanceCase(
+	name string, format Format, sequence Sequence, metric LoudnessMetric, expected, below, above float64,
+) LoudnessConformance {
+	var duration time.Duration
+	for _, segment := range sequence {
+		duration += segment.Duration
+	}
+
+	return LoudnessConformance{
+		Name:     name,
+		Format:   format,
+		Signal:   sequence,
+		Duration: duration,
+		Metric:   metric,
+		Expected: expected,
+		Min:      expected - below,
+		Max:      expected + above,
+	}
+}
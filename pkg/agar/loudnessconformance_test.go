@@ -0,0 +1,47 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"testing"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+func TestLoudnessConformance(t *testing.T) {
+	t.Parallel()
+
+	for _, conformance := range agar.LoudnessConformanceSignals() {
+		t.Run(conformance.Name, func(t *testing.T) {
+			t.Parallel()
+
+			pcm, err := conformance.Render()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loudness, err := agar.MeasureLoudness(pcm, conformance.Format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = conformance.Check(loudness); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -17,8 +17,9 @@
 package agar
 
 import (
-	"path/filepath"
-	"strconv"
+	"context"
+	"fmt"
+	"strings"
 
 	"github.com/containerd/nerdctl/mod/tigron/test"
 )
@@ -26,7 +27,7 @@ import (
 // ID3Version represents an ID3 tag version.
 type ID3Version string
 
-// ID3 tag versions supported by the id3v2 tool.
+// ID3 tag versions.
 const (
 	ID3v11 ID3Version = "1.1" // ID3v1.1 (limited fields)
 	ID3v22 ID3Version = "2.2" // ID3v2.2
@@ -34,13 +35,6 @@ const (
 	ID3v24 ID3Version = "2.4" // ID3v2.4 (recommended)
 )
 
-// id3v2 command flags.
-const (
-	id3v2FlagV2Only = "--id3v2-only"
-	id3v2FlagV2     = "-2"
-	id3v2FlagV1     = "-1"
-)
-
 // MP3Tags holds metadata for MP3 files.
 type MP3Tags struct {
 	Title       string
@@ -75,83 +69,29 @@ func DefaultMP3Tags() MP3Tags {
 	}
 }
 
-// MP3SetID3Tags writes ID3 tags to an MP3 file using id3v2.
-// The version parameter controls which ID3 version to write.
+// MP3SetID3Tags writes ID3 tags to an MP3 file natively, in exactly the requested version.
+// ID3v1.1 replaces the ID3v1 tag; ID3v2 versions replace the ID3v2 tags, using the default encoding.
 func MP3SetID3Tags(helpers test.Helpers, path string, version ID3Version, tags MP3Tags) {
 	helpers.T().Helper()
 
-	args := []string{}
+	failOnError(helpers, writeID3Tags(path, version, tags))
+}
 
-	// Set ID3 version
+// writeID3Tags writes tags to an MP3 file in exactly the requested version.
+func writeID3Tags(path string, version ID3Version, tags MP3Tags) error {
 	if version == ID3v11 {
-		args = append(args, id3v2FlagV1)
-	} else {
-		// ID3v2.2, v2.3, v2.4 all use -2 flag
-		args = append(args, id3v2FlagV2, id3v2FlagV2Only)
-	}
-
-	// Basic tags supported by all versions
-	if tags.Title != "" {
-		args = append(args, "-t", tags.Title)
-	}
-
-	if tags.Artist != "" {
-		args = append(args, "-a", tags.Artist)
+		return WriteID3v1(path, tags)
 	}
 
-	if tags.Album != "" {
-		args = append(args, "-A", tags.Album)
-	}
-
-	if tags.Year > 0 {
-		args = append(args, "-y", strconv.Itoa(tags.Year))
-	}
-
-	if tags.Genre != "" {
-		args = append(args, "-g", tags.Genre)
-	}
-
-	if tags.Comment != "" {
-		args = append(args, "-c", tags.Comment)
-	}
-
-	// Track number - format depends on version
-	if tags.Track > 0 {
-		trackStr := strconv.Itoa(tags.Track)
-		if tags.TrackTotal > 0 && version != ID3v11 {
-			trackStr += "/" + strconv.Itoa(tags.TrackTotal)
-		}
-
-		args = append(args, "-T", trackStr)
-	}
-
-	// ID3v2-only frames
-	if version != ID3v11 {
-		// Album artist (TPE2)
-		if tags.AlbumArtist != "" {
-			args = append(args, "--TPE2", tags.AlbumArtist)
-		}
-
-		// Composer (TCOM)
-		if tags.Composer != "" {
-			args = append(args, "--TCOM", tags.Composer)
-		}
-
-		// Disc number (TPOS)
-		if tags.Disc > 0 {
-			discStr := strconv.Itoa(tags.Disc)
-			if tags.DiscTotal > 0 {
-				discStr += "/" + strconv.Itoa(tags.DiscTotal)
-			}
-
-			args = append(args, "--TPOS", discStr)
-		}
-	}
+	return WriteID3v2(path, ID3Tag{MP3Tags: tags}, ID3Options{Version: version})
+}
 
-	args = append(args, path)
+// MP3SetID3v2 replaces the ID3v2 tags of an MP3 file with tag, written with opts.
+// Modifies the file in-place.
+func MP3SetID3v2(helpers test.Helpers, path string, tag ID3Tag, opts ID3Options) {
+	helpers.T().Helper()
 
-	id3v2 := lookForOrFail(helpers.T(), id3v2Binary)
-	helpers.Custom(id3v2, args...).Run(&test.Expected{})
+	failOnError(helpers, WriteID3v2(path, tag, opts))
 }
 
 // TaggedMP3 returns path to MP3 with ID3v2.4 tags (default).
@@ -165,17 +105,25 @@ func TaggedMP3(data test.Data, helpers test.Helpers) string {
 func TaggedMP3WithVersion(data test.Data, helpers test.Helpers, version ID3Version) string {
 	helpers.T().Helper()
 
-	filename := "tagged-mp3-id3v" + string(version) + ".mp3"
-	path := generate(helpers, filepath.Join(data.Temp().Dir(), filename), []string{
+	return TaggedMP3Generator(version).Path(data, helpers)
+}
+
+// TaggedMP3Generator returns the generator of MP3 files with ID3 tags of the specified version, named
+// after the matching fixture function (e.g. "TaggedMP3ID3v24").
+func TaggedMP3Generator(version ID3Version) Generator {
+	name := "TaggedMP3ID3v" + strings.ReplaceAll(string(version), ".", "")
+
+	return ffmpegGenerator(name, "tagged-mp3-id3v"+string(version)+".mp3", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + shortDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
 		"-ar", "44100", "-c:a", "libmp3lame", "-b:a", "256k",
+	}).then(generatorStep{
+		key: []string{"id3", string(version), fmt.Sprintf("%+v", DefaultMP3Tags())},
+		run: func(_ context.Context, _, path string) error {
+			return writeID3Tags(path, version, DefaultMP3Tags())
+		},
 	})
-
-	MP3SetID3Tags(helpers, path, version, DefaultMP3Tags())
-
-	return path
 }
 
 // TaggedMP3ID3v24 returns path to MP3 with ID3v2.4 tags.
@@ -211,7 +159,11 @@ func TaggedMP3ID3v11(data test.Data, helpers test.Helpers) string {
 func UntaggedMP3(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "untagged.mp3"), []string{
+	return untaggedMP3().Path(data, helpers)
+}
+
+func untaggedMP3() Generator {
+	return ffmpegGenerator("UntaggedMP3", "untagged.mp3", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + shortDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -0,0 +1,786 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MP4 box layout.
+const (
+	mp4BoxHeaderSize      = 8
+	mp4LargeHeaderSize    = 16
+	mp4FullBoxHeaderSize  = 4
+	mp4DataHeaderSize     = 8
+	mp4PairSize           = 8
+	mp4DiskSize           = 6
+	mp4StcoEntrySize      = 4
+	mp4Co64EntrySize      = 8
+	mp4CopyrightSign      = 0xA9
+	mp4CopyrightPrefix    = "(c)"
+	mp4FreeformAtom       = "----"
+	mp4CoverAtom          = "covr"
+	mp4TrackAtom          = "trkn"
+	mp4DiscAtom           = "disk"
+	mp4GenreIDAtom        = "gnre"
+	mp4DataTypeImplicit   = 0
+	mp4DataTypeUTF8       = 1
+	mp4DataTypeJPEG       = 13
+	mp4DataTypePNG        = 14
+	mp4DataTypeBEInteger  = 21
+	mp4IntegerWidth8      = 1
+	mp4IntegerWidth16     = 2
+	mp4IntegerWidth32     = 4
+	mp4IntegerWidth64     = 8
+	mp4HandlerMetadata    = "mdir"
+	mp4HandlerApple       = "appl"
+	mp4HandlerBoxSize     = 33
+	mp4HandlerTypeOffset  = 8
+	mp4HandlerMakerOffset = 12
+)
+
+// ErrMP4Malformed is returned when an MP4 file's box structure cannot be parsed or rewritten.
+var ErrMP4Malformed = errors.New("malformed MP4 file")
+
+// mp4IntegerAtoms lists the ilst atoms holding big endian integers, with their width in bytes.
+//
+//nolint:gochecknoglobals // lookup table
+var mp4IntegerAtoms = map[string]int{
+	"tmpo": mp4IntegerWidth16,
+	"cpil": mp4IntegerWidth8,
+	"pgap": mp4IntegerWidth8,
+	"hdvd": mp4IntegerWidth8,
+	"stik": mp4IntegerWidth8,
+	"rtng": mp4IntegerWidth8,
+	"pcst": mp4IntegerWidth8,
+	"tvsn": mp4IntegerWidth32,
+	"tves": mp4IntegerWidth32,
+	"cnID": mp4IntegerWidth32,
+	"geID": mp4IntegerWidth32,
+}
+
+// mp4Containers lists the boxes whose children hold chunk offset tables.
+//
+//nolint:gochecknoglobals // lookup table
+var mp4Containers = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true}
+
+// MP4FreeformTag is a freeform ("----") atom identified by a reverse DNS mean and a name.
+type MP4FreeformTag struct {
+	Mean   string
+	Name   string
+	Values []string
+}
+
+// MP4Metadata is the complete content of an MP4 ilst atom.
+type MP4Metadata struct {
+	// Text maps atom names to values, one data atom per value. Names use the keys of
+	// mp4AtomToSemantic, with "(c)" standing for the © byte (e.g. "(c)nam", "aART").
+	// Integer atoms (tmpo, cpil, pgap, hdvd, stik, rtng, pcst, tvsn, tves, cnID, geID, gnre) take decimal strings.
+	Text map[string][]string
+
+	Track      int
+	TrackTotal int
+	Disc       int
+	DiscTotal  int
+
+	Freeform []MP4FreeformTag
+
+	// Artwork holds covr images in order. JPEG or PNG type is detected from the image signature.
+	Artwork [][]byte
+
+	// raw preserves items that could not be decoded, so read-modify-write cycles keep them.
+	raw [][]byte
+}
+
+// NewMP4Metadata returns empty metadata.
+func NewMP4Metadata() *MP4Metadata {
+	return &MP4Metadata{Text: make(map[string][]string)}
+}
+
+// SetFreeform replaces the values of the freeform tag mean/name, adding it when missing.
+func (meta *MP4Metadata) SetFreeform(mean, name string, values ...string) {
+	for idx := range meta.Freeform {
+		if meta.Freeform[idx].Mean == mean && strings.EqualFold(meta.Freeform[idx].Name, name) {
+			meta.Freeform[idx].Values = values
+
+			return
+		}
+	}
+
+	meta.Freeform = append(meta.Freeform, MP4FreeformTag{Mean: mean, Name: name, Values: values})
+}
+
+// ReadMP4Metadata reads the ilst atom of an MP4 file natively. Files without metadata yield empty metadata.
+func ReadMP4Metadata(path string) (*MP4Metadata, error) {
+	content, err := os.ReadFile(path) //nolint:gosec // G304: path is caller-provided test fixture.
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	meta := NewMP4Metadata()
+
+	moov, err := findMP4Box(content, "moov")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	ilst := mp4Path(moov.payload, "udta", "meta", "ilst")
+	if ilst == nil {
+		return meta, nil
+	}
+
+	items, err := parseMP4Boxes(ilst)
+	if err != nil {
+		return nil, fmt.Errorf("%s: ilst: %w", path, err)
+	}
+
+	for _, item := range items {
+		meta.addItem(item)
+	}
+
+	return meta, nil
+}
+
+// WriteMP4Metadata replaces the ilst atom of the MP4 file at path with meta, in a single rewrite.
+// Chunk offsets (stco/co64) are patched when moov precedes the media data it points to.
+func WriteMP4Metadata(path string, meta *MP4Metadata) error {
+	content, err := os.ReadFile(path) //nolint:gosec // G304: path is caller-provided test fixture.
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	rewritten, err := rewriteMP4Metadata(content, meta)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	if err := os.WriteFile(path, rewritten, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// UpdateMP4Metadata reads the metadata of path, lets update modify it, and writes it back.
+func UpdateMP4Metadata(path string, update func(meta *MP4Metadata)) error {
+	meta, err := ReadMP4Metadata(path)
+	if err != nil {
+		return err
+	}
+
+	update(meta)
+
+	return WriteMP4Metadata(path, meta)
+}
+
+// mp4Box is a parsed box. payload excludes the header; offset and size cover the whole box.
+type mp4Box struct {
+	kind    string
+	payload []byte
+	offset  int
+	size    int
+}
+
+// parseMP4Boxes splits data into consecutive boxes.
+func parseMP4Boxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < mp4BoxHeaderSize {
+			return nil, fmt.Errorf("%w: truncated box header at %d", ErrMP4Malformed, offset)
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[offset:]))
+		kind := string(data[offset+4 : offset+mp4BoxHeaderSize])
+		headerSize := mp4BoxHeaderSize
+
+		switch size {
+		case 0:
+			size = uint64(len(data) - offset) //nolint:gosec // G115: positive.
+		case 1:
+			if len(data)-offset < mp4LargeHeaderSize {
+				return nil, fmt.Errorf("%w: truncated large box header at %d", ErrMP4Malformed, offset)
+			}
+
+			size = binary.BigEndian.Uint64(data[offset+mp4BoxHeaderSize:])
+			headerSize = mp4LargeHeaderSize
+		default:
+		}
+
+		if size < uint64(headerSize) || size > uint64(len(data)-offset) { //nolint:gosec // G115: positive.
+			return nil, fmt.Errorf("%w: box %q at %d has size %d", ErrMP4Malformed, kind, offset, size)
+		}
+
+		end := offset + int(size) //nolint:gosec // G115: bounded by len(data).
+		boxes = append(boxes, mp4Box{
+			kind:    kind,
+			payload: data[offset+headerSize : end],
+			offset:  offset,
+			size:    end - offset,
+		})
+		offset = end
+	}
+
+	return boxes, nil
+}
+
+// findMP4Box returns the first top-level box of the given kind.
+func findMP4Box(content []byte, kind string) (mp4Box, error) {
+	boxes, err := parseMP4Boxes(content)
+	if err != nil {
+		return mp4Box{}, err
+	}
+
+	for _, box := range boxes {
+		if box.kind == kind {
+			return box, nil
+		}
+	}
+
+	return mp4Box{}, fmt.Errorf("%w: no %s box", ErrMP4Malformed, kind)
+}
+
+// mp4Path descends into nested boxes and returns the child data of the last one, or nil.
+func mp4Path(payload []byte, kinds ...string) []byte {
+	for _, kind := range kinds {
+		children, err := parseMP4Boxes(payload)
+		if err != nil {
+			return nil
+		}
+
+		idx := slices.IndexFunc(children, func(child mp4Box) bool { return child.kind == kind })
+		if idx < 0 {
+			return nil
+		}
+
+		payload = mp4ChildData(kind, children[idx].payload)
+	}
+
+	return payload
+}
+
+// mp4ChildData returns the part of a box payload holding child boxes. iTunes meta boxes are full boxes
+// (4 bytes of version and flags before the children), QuickTime ones are not.
+func mp4ChildData(kind string, payload []byte) []byte {
+	if kind == "meta" && len(payload) >= mp4FullBoxHeaderSize+mp4BoxHeaderSize &&
+		string(payload[4:8]) != "hdlr" {
+		return payload[mp4FullBoxHeaderSize:]
+	}
+
+	return payload
+}
+
+// appendMP4Box appends a box with the given kind and payload to out.
+func appendMP4Box(out []byte, kind string, payload ...[]byte) []byte {
+	size := mp4BoxHeaderSize
+	for _, part := range payload {
+		size += len(part)
+	}
+
+	out = binary.BigEndian.AppendUint32(out, uint32(size)) //nolint:gosec // G115: metadata boxes stay small.
+	out = append(out, kind...)
+
+	for _, part := range payload {
+		out = append(out, part...)
+	}
+
+	return out
+}
+
+// mp4AtomCode converts "(c)nam" or "©nam" to the four raw bytes of the atom type.
+func mp4AtomCode(name string) (string, error) {
+	code := []byte(strings.Replace(name, "©", mp4CopyrightPrefix, 1))
+	if bytes.HasPrefix(code, []byte(mp4CopyrightPrefix)) {
+		code = append([]byte{mp4CopyrightSign}, code[len(mp4CopyrightPrefix):]...)
+	}
+
+	if len(code) != len(mp4FreeformAtom) {
+		return "", fmt.Errorf("%w: invalid atom name %q", ErrMP4Malformed, name)
+	}
+
+	return string(code), nil
+}
+
+// mp4AtomName converts raw atom type bytes to the "(c)" notation used by mp4AtomToSemantic.
+func mp4AtomName(code string) string {
+	if len(code) > 0 && code[0] == mp4CopyrightSign {
+		return mp4CopyrightPrefix + code[1:]
+	}
+
+	return code
+}
+
+// mp4DataAtom returns a data atom with the given well-known type.
+func mp4DataAtom(dataType uint32, value []byte) []byte {
+	header := make([]byte, mp4DataHeaderSize)
+	binary.BigEndian.PutUint32(header, dataType)
+
+	return appendMP4Box(nil, "data", header, value)
+}
+
+// mp4ImageType returns the covr data type for an image.
+func mp4ImageType(image []byte) uint32 {
+	if bytes.HasPrefix(image, []byte("\x89PNG")) {
+		return mp4DataTypePNG
+	}
+
+	return mp4DataTypeJPEG
+}
+
+// encodeIlst serializes meta as an ilst payload.
+//
+//nolint:cyclop // one branch per atom family.
+func encodeIlst(meta *MP4Metadata) ([]byte, error) {
+	var ilst []byte
+
+	names := make([]string, 0, len(meta.Text))
+	for name := range meta.Text {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		code, err := mp4AtomCode(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var data []byte
+
+		for _, value := range meta.Text[name] {
+			atom, err := mp4ValueAtom(code, value)
+			if err != nil {
+				return nil, err
+			}
+
+			data = append(data, atom...)
+		}
+
+		if len(data) > 0 {
+			ilst = appendMP4Box(ilst, code, data)
+		}
+	}
+
+	if meta.Track > 0 || meta.TrackTotal > 0 {
+		pair := make([]byte, mp4PairSize)
+		binary.BigEndian.PutUint16(pair[2:], uint16(meta.Track))      //nolint:gosec // G115: track numbers are small.
+		binary.BigEndian.PutUint16(pair[4:], uint16(meta.TrackTotal)) //nolint:gosec // G115: track numbers are small.
+		ilst = appendMP4Box(ilst, mp4TrackAtom, mp4DataAtom(mp4DataTypeImplicit, pair))
+	}
+
+	if meta.Disc > 0 || meta.DiscTotal > 0 {
+		pair := make([]byte, mp4DiskSize)
+		binary.BigEndian.PutUint16(pair[2:], uint16(meta.Disc))      //nolint:gosec // G115: disc numbers are small.
+		binary.BigEndian.PutUint16(pair[4:], uint16(meta.DiscTotal)) //nolint:gosec // G115: disc numbers are small.
+		ilst = appendMP4Box(ilst, mp4DiscAtom, mp4DataAtom(mp4DataTypeImplicit, pair))
+	}
+
+	for _, tag := range meta.Freeform {
+		version := make([]byte, mp4FullBoxHeaderSize)
+		parts := [][]byte{
+			appendMP4Box(nil, "mean", version, []byte(tag.Mean)),
+			appendMP4Box(nil, "name", version, []byte(tag.Name)),
+		}
+
+		for _, value := range tag.Values {
+			parts = append(parts, mp4DataAtom(mp4DataTypeUTF8, []byte(value)))
+		}
+
+		ilst = appendMP4Box(ilst, mp4FreeformAtom, parts...)
+	}
+
+	if len(meta.Artwork) > 0 {
+		var covers []byte
+		for _, image := range meta.Artwork {
+			covers = append(covers, mp4DataAtom(mp4ImageType(image), image)...)
+		}
+
+		ilst = appendMP4Box(ilst, mp4CoverAtom, covers)
+	}
+
+	for _, item := range meta.raw {
+		ilst = append(ilst, item...)
+	}
+
+	return ilst, nil
+}
+
+// mp4ValueAtom encodes a text value for the atom with the given raw code.
+func mp4ValueAtom(code, value string) ([]byte, error) {
+	width, isInteger := mp4IntegerAtoms[code]
+
+	dataType := uint32(mp4DataTypeBEInteger)
+	if code == mp4GenreIDAtom {
+		width, isInteger, dataType = mp4IntegerWidth16, true, mp4DataTypeImplicit
+	}
+
+	if !isInteger {
+		return mp4DataAtom(mp4DataTypeUTF8, []byte(value)), nil
+	}
+
+	number, err := strconv.ParseInt(value, 10, width*bitsPerByte)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s expects a %d-bit integer: %w",
+			ErrMP4Malformed, mp4AtomName(code), width*bitsPerByte, err)
+	}
+
+	buf := make([]byte, mp4IntegerWidth64)
+	binary.BigEndian.PutUint64(buf, uint64(number)) //nolint:gosec // G115: two's complement truncation is intended.
+
+	return mp4DataAtom(dataType, buf[mp4IntegerWidth64-width:]), nil
+}
+
+// addItem decodes one ilst item into meta.
+//
+//nolint:cyclop // one branch per atom family.
+func (meta *MP4Metadata) addItem(item mp4Box) {
+	children, err := parseMP4Boxes(item.payload)
+	if err != nil {
+		meta.raw = append(meta.raw, appendMP4Box(nil, item.kind, item.payload))
+
+		return
+	}
+
+	var (
+		values    [][]byte
+		types     []uint32
+		mean, nam string
+	)
+
+	for _, child := range children {
+		switch {
+		case child.kind == "data" && len(child.payload) >= mp4DataHeaderSize:
+			types = append(types, binary.BigEndian.Uint32(child.payload)&0xFFFFFF)
+			values = append(values, child.payload[mp4DataHeaderSize:])
+		case child.kind == "mean" && len(child.payload) >= mp4FullBoxHeaderSize:
+			mean = string(child.payload[mp4FullBoxHeaderSize:])
+		case child.kind == "name" && len(child.payload) >= mp4FullBoxHeaderSize:
+			nam = string(child.payload[mp4FullBoxHeaderSize:])
+		default:
+		}
+	}
+
+	switch item.kind {
+	case mp4FreeformAtom:
+		tag := MP4FreeformTag{Mean: mean, Name: nam}
+		for _, value := range values {
+			tag.Values = append(tag.Values, string(value))
+		}
+
+		meta.Freeform = append(meta.Freeform, tag)
+	case mp4CoverAtom:
+		meta.Artwork = append(meta.Artwork, values...)
+	case mp4TrackAtom, mp4DiscAtom:
+		if len(values) == 0 || len(values[0]) < mp4DiskSize {
+			return
+		}
+
+		number := int(binary.BigEndian.Uint16(values[0][2:]))
+		total := int(binary.BigEndian.Uint16(values[0][4:]))
+
+		if item.kind == mp4TrackAtom {
+			meta.Track, meta.TrackTotal = number, total
+		} else {
+			meta.Disc, meta.DiscTotal = number, total
+		}
+	default:
+		// Decode every value before filing any, so that an item lands either in Text or in raw.
+		texts := make([]string, 0, len(values))
+
+		for idx, value := range values {
+			if types[idx] == mp4DataTypeUTF8 {
+				texts = append(texts, string(value))
+
+				continue
+			}
+
+			number, ok := mp4DecodeInteger(value)
+			if !ok {
+				meta.raw = append(meta.raw, appendMP4Box(nil, item.kind, item.payload))
+
+				return
+			}
+
+			texts = append(texts, strconv.FormatInt(number, 10))
+		}
+
+		name := mp4AtomName(item.kind)
+		meta.Text[name] = append(meta.Text[name], texts...)
+	}
+}
+
+// mp4DecodeInteger decodes a 1, 2, 4 or 8 byte big endian signed integer.
+func mp4DecodeInteger(value []byte) (int64, bool) {
+	switch len(value) {
+	case mp4IntegerWidth8:
+		return int64(int8(value[0])), true
+	case mp4IntegerWidth16:
+		return int64(int16(binary.BigEndian.Uint16(value))), true //nolint:gosec // G115: two's complement.
+	case mp4IntegerWidth32:
+		return int64(int32(binary.BigEndian.Uint32(value))), true //nolint:gosec // G115: two's complement.
+	case mp4IntegerWidth64:
+		return int64(binary.BigEndian.Uint64(value)), true //nolint:gosec // G115: two's complement.
+	default:
+		return 0, false
+	}
+}
+
+// rewriteMP4Metadata returns content with moov/udta/meta/ilst replaced by meta.
+func rewriteMP4Metadata(content []byte, meta *MP4Metadata) ([]byte, error) {
+	moov, err := findMP4Box(content, "moov")
+	if err != nil {
+		return nil, err
+	}
+
+	ilst, err := encodeIlst(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	newMoov, err := rebuildMoov(moov.payload, ilst)
+	if err != nil {
+		return nil, err
+	}
+
+	delta := int64(len(newMoov) - moov.size)
+	moovEnd := int64(moov.offset + moov.size)
+
+	// Media data located after moov moves by delta.
+	if delta != 0 {
+		if err := patchChunkOffsets(newMoov[mp4BoxHeaderSize:], moovEnd, delta); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]byte, 0, len(content)+len(newMoov)-moov.size)
+	out = append(out, content[:moov.offset]...)
+	out = append(out, newMoov...)
+
+	return append(out, content[moovEnd:]...), nil
+}
+
+// rebuildMoov returns a new moov box whose udta/meta/ilst is ilst, keeping every other box. The other
+// children of udta and meta (handler extras, chapter names, Xtra, ...) keep their place. A meta box is
+// created when ilst is set and none exists, and dropped when ilst is empty and only its handler remains.
+func rebuildMoov(payload, ilst []byte) ([]byte, error) {
+	children, err := parseMP4Boxes(payload)
+	if err != nil {
+		return nil, fmt.Errorf("moov: %w", err)
+	}
+
+	var (
+		body     []byte
+		seenUdta bool
+	)
+
+	for _, child := range children {
+		if child.kind != "udta" || seenUdta {
+			body = append(body, payload[child.offset:child.offset+child.size]...)
+
+			continue
+		}
+
+		seenUdta = true
+
+		udta, err := rebuildUdta(child.payload, ilst)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(udta) > 0 {
+			body = appendMP4Box(body, "udta", udta)
+		}
+	}
+
+	if !seenUdta && len(ilst) > 0 {
+		body = appendMP4Box(body, "udta", newMP4Meta(ilst))
+	}
+
+	return appendMP4Box(nil, "moov", body), nil
+}
+
+// rebuildUdta returns the udta payload with the ilst of its meta box replaced by ilst.
+func rebuildUdta(payload, ilst []byte) ([]byte, error) {
+	children, err := parseMP4Boxes(payload)
+	if err != nil {
+		return nil, fmt.Errorf("udta: %w", err)
+	}
+
+	var (
+		udta     []byte
+		seenMeta bool
+	)
+
+	for _, child := range children {
+		if child.kind != "meta" || seenMeta {
+			udta = append(udta, payload[child.offset:child.offset+child.size]...)
+
+			continue
+		}
+
+		seenMeta = true
+
+		meta, keep, err := rebuildMeta(child.payload, ilst)
+		if err != nil {
+			return nil, err
+		}
+
+		if keep {
+			udta = appendMP4Box(udta, "meta", meta)
+		}
+	}
+
+	if !seenMeta && len(ilst) > 0 {
+		udta = append(udta, newMP4Meta(ilst)...)
+	}
+
+	return udta, nil
+}
+
+// rebuildMeta returns the meta payload with its ilst child replaced by ilst, and whether anything but the
+// handler remains in it.
+func rebuildMeta(payload, ilst []byte) ([]byte, bool, error) {
+	childData := mp4ChildData("meta", payload)
+
+	children, err := parseMP4Boxes(childData)
+	if err != nil {
+		return nil, false, fmt.Errorf("meta: %w", err)
+	}
+
+	meta := slices.Clone(payload[:len(payload)-len(childData)])
+	kept, seenIlst := 0, false
+
+	for _, child := range children {
+		switch {
+		case child.kind != "ilst":
+			meta = append(meta, childData[child.offset:child.offset+child.size]...)
+
+			if child.kind != "hdlr" {
+				kept++
+			}
+		case !seenIlst && len(ilst) > 0:
+			meta = appendMP4Box(meta, "ilst", ilst)
+			kept++
+		default:
+		}
+
+		seenIlst = seenIlst || child.kind == "ilst"
+	}
+
+	if !seenIlst && len(ilst) > 0 {
+		meta = appendMP4Box(meta, "ilst", ilst)
+		kept++
+	}
+
+	return meta, kept > 0, nil
+}
+
+// newMP4Meta returns an iTunes meta box holding a metadata handler and ilst.
+func newMP4Meta(ilst []byte) []byte {
+	handler := make([]byte, mp4HandlerBoxSize-mp4BoxHeaderSize)
+	copy(handler[mp4HandlerTypeOffset:], mp4HandlerMetadata)
+	copy(handler[mp4HandlerMakerOffset:], mp4HandlerApple)
+
+	return appendMP4Box(nil, "meta",
+		make([]byte, mp4FullBoxHeaderSize),
+		appendMP4Box(nil, "hdlr", handler),
+		appendMP4Box(nil, "ilst", ilst),
+	)
+}
+
+// patchChunkOffsets shifts every stco/co64 entry pointing at or after threshold by delta.
+func patchChunkOffsets(payload []byte, threshold, delta int64) error {
+	boxes, err := parseMP4Boxes(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, box := range boxes {
+		switch {
+		case mp4Containers[box.kind]:
+			if err := patchChunkOffsets(box.payload, threshold, delta); err != nil {
+				return err
+			}
+		case box.kind == "stco" || box.kind == "co64":
+			if err := patchOffsetTable(box.payload, box.kind == "co64", threshold, delta); err != nil {
+				return err
+			}
+		default:
+		}
+	}
+
+	return nil
+}
+
+// patchOffsetTable patches the entries of an stco (32-bit) or co64 (64-bit) full box payload in place.
+func patchOffsetTable(payload []byte, wide bool, threshold, delta int64) error {
+	entrySize := mp4StcoEntrySize
+	if wide {
+		entrySize = mp4Co64EntrySize
+	}
+
+	if len(payload) < mp4FullBoxHeaderSize+4 {
+		return fmt.Errorf("%w: truncated chunk offset table", ErrMP4Malformed)
+	}
+
+	count := int(binary.BigEndian.Uint32(payload[mp4FullBoxHeaderSize:]))
+	entries := payload[mp4FullBoxHeaderSize+4:]
+
+	if len(entries) < count*entrySize {
+		return fmt.Errorf("%w: chunk offset table holds %d bytes for %d entries", ErrMP4Malformed, len(entries), count)
+	}
+
+	for idx := range count {
+		entry := entries[idx*entrySize:]
+
+		if wide {
+			offset := int64(binary.BigEndian.Uint64(entry)) //nolint:gosec // G115: file offsets fit int64.
+			if offset >= threshold {
+				binary.BigEndian.PutUint64(entry, uint64(offset+delta)) //nolint:gosec // G115: positive.
+			}
+
+			continue
+		}
+
+		offset := int64(binary.BigEndian.Uint32(entry))
+		if offset < threshold {
+			continue
+		}
+
+		if offset+delta > math.MaxUint32 {
+			return fmt.Errorf("%w: chunk offset %d overflows stco", ErrMP4Malformed, offset+delta)
+		}
+
+		binary.BigEndian.PutUint32(entry, uint32(offset+delta)) //nolint:gosec // G115: checked above.
+	}
+
+	return nil
+}
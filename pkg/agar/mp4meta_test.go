@@ -0,0 +1,85 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// mp4Box returns a box with the given kind and payload.
+func mp4Box(kind string, payload ...[]byte) []byte {
+	body := slices.Concat(payload...)
+
+	return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), append([]byte(kind), body...)...)
+}
+
+func TestWriteMP4MetadataKeepsOtherBoxes(t *testing.T) {
+	t.Parallel()
+
+	handler := mp4Box("hdlr", make([]byte, 8), []byte("mdirappl"), make([]byte, 13))
+	// A three byte integer does not decode: the whole item must survive as a single raw item.
+	odd := mp4Box("rtng",
+		mp4Box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte("text")),
+		mp4Box("data", []byte{0, 0, 0, 21, 0, 0, 0, 0}, []byte{1, 2, 3}))
+	meta := mp4Box("meta", make([]byte, 4), handler, mp4Box("ilst", odd), mp4Box("XID ", []byte("extra")))
+	udta := mp4Box("udta", mp4Box("chpl", []byte("chapters")), meta, mp4Box("Xtra", []byte("xtra")))
+	moov := mp4Box("moov", mp4Box("mvhd", make([]byte, 100)), udta)
+
+	path := filepath.Join(t.TempDir(), "boxes.m4a")
+	if err := os.WriteFile(path, slices.Concat(mp4Box("ftyp", []byte("M4A ")), moov), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := agar.UpdateMP4Metadata(path, func(meta *agar.MP4Metadata) {
+			meta.Text["(c)nam"] = []string{"Title"}
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kept := range []string{"chplchapters", "XID extra", "Xtraxtra", "mdirappl"} {
+		if !bytes.Contains(content, []byte(kept)) {
+			t.Errorf("lost %q", kept)
+		}
+	}
+
+	if count := bytes.Count(content, []byte("rtng")); count != 1 {
+		t.Errorf("undecodable rtng item written %d times", count)
+	}
+
+	read, err := agar.ReadMP4Metadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(read.Text["(c)nam"], []string{"Title"}) || read.Text["rtng"] != nil {
+		t.Errorf("read back %q", read.Text)
+	}
+}
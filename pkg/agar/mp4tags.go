@@ -17,8 +17,11 @@
 package agar
 
 import (
-	"path/filepath"
+	"context"
+	"fmt"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/containerd/nerdctl/mod/tigron/test"
 )
@@ -60,113 +63,218 @@ func DefaultMP4Tags() MP4Tags {
 	}
 }
 
-// MP4SetAllTags sets all tags on an MP4 file using atomicparsley.
-func MP4SetAllTags(helpers test.Helpers, path string, tags MP4Tags) {
-	helpers.T().Helper()
+// mp4TagAtoms maps AtomicParsley option names, as accepted by MP4SetTag, to ilst atoms.
+//
+//nolint:gochecknoglobals // lookup table
+var mp4TagAtoms = map[string]string{
+	"title":        "(c)nam",
+	"artist":       "(c)ART",
+	"album":        "(c)alb",
+	"albumArtist":  "aART",
+	"year":         "(c)day",
+	"genre":        "(c)gen",
+	"comment":      "(c)cmt",
+	"composer":     "(c)wrt",
+	"grouping":     "(c)grp",
+	"lyrics":       "(c)lyr",
+	"copyright":    "cprt",
+	"description":  "desc",
+	"longdesc":     "ldes",
+	"bpm":          "tmpo",
+	"compilation":  "cpil",
+	"gapless":      "pgap",
+	"hdvideo":      "hdvd",
+	"stik":         "stik",
+	"advisory":     "rtng",
+	"podcastFlag":  "pcst",
+	"category":     "catg",
+	"keyword":      "keyw",
+	"podcastURL":   "purl",
+	"podcastGUID":  "egid",
+	"purchaseDate": "purd",
+	"TVShowName":   "tvsh",
+	"TVEpisode":    "tven",
+	"TVNetwork":    "tvnn",
+	"TVSeasonNum":  "tvsn",
+	"TVEpisodeNum": "tves",
+	"storedesc":    "sdes",
+	"encodingTool": "(c)too",
+	"encodedBy":    "(c)enc",
+	"apID":         "apID",
+	"cnID":         "cnID",
+	"geID":         "geID",
+	"xID":          "xid ",
+}
 
-	if tags.Title != "" {
-		MP4SetTag(helpers, path, "title", tags.Title)
+// applyMP4Tags sets the non-zero fields of tags on meta.
+func applyMP4Tags(meta *MP4Metadata, tags MP4Tags) {
+	for atom, value := range map[string]string{
+		"(c)nam": tags.Title,
+		"(c)ART": tags.Artist,
+		"(c)alb": tags.Album,
+		"aART":   tags.AlbumArtist,
+		"(c)gen": tags.Genre,
+		"(c)cmt": tags.Comment,
+		"(c)wrt": tags.Composer,
+	} {
+		if value != "" {
+			meta.Text[atom] = []string{value}
+		}
 	}
 
-	if tags.Artist != "" {
-		MP4SetTag(helpers, path, "artist", tags.Artist)
+	if tags.Year > 0 {
+		meta.Text["(c)day"] = []string{strconv.Itoa(tags.Year)}
 	}
 
-	if tags.Album != "" {
-		MP4SetTag(helpers, path, "album", tags.Album)
+	if tags.Track > 0 {
+		meta.Track, meta.TrackTotal = tags.Track, tags.TrackTotal
 	}
 
-	if tags.AlbumArtist != "" {
-		MP4SetTag(helpers, path, "albumArtist", tags.AlbumArtist)
+	if tags.Disc > 0 {
+		meta.Disc, meta.DiscTotal = tags.Disc, tags.DiscTotal
 	}
+}
 
-	if tags.Year > 0 {
-		MP4SetTag(helpers, path, "year", strconv.Itoa(tags.Year))
-	}
+// MP4SetAllTags sets all non-empty tags on an MP4 file in a single rewrite.
+func MP4SetAllTags(helpers test.Helpers, path string, tags MP4Tags) {
+	helpers.T().Helper()
 
-	if tags.Genre != "" {
-		MP4SetTag(helpers, path, "genre", tags.Genre)
-	}
+	failOnError(helpers, UpdateMP4Metadata(path, func(meta *MP4Metadata) {
+		applyMP4Tags(meta, tags)
+	}))
+}
 
-	if tags.Comment != "" {
-		MP4SetTag(helpers, path, "comment", tags.Comment)
-	}
+// MP4SetTag sets a tag on an MP4 file.
+// Keys are AtomicParsley option names (title, artist, album, albumArtist, genre, year, tracknum, disk, etc.)
+// or raw atom names such as "(c)nam" or "aART". Values follow AtomicParsley: boolean atoms accept "true"
+// and "false", advisory accepts "clean" and "explicit", stik accepts media kind names such as "Audiobook"
+// or "value=N", artwork and lyricsFile take a file path, artwork accepts "REMOVE_ALL", and "remove"
+// (or an empty value) deletes the atom.
+// Options taking several arguments have no key: use raw atoms for sortOrder (e.g. "soar") and
+// MP4SetFreeformTag for rDNSatom and contentRating. Any other key that is not a four-character atom fails.
+// Modifies the file in-place.
+func MP4SetTag(helpers test.Helpers, path, key, value string) {
+	helpers.T().Helper()
 
-	if tags.Composer != "" {
-		MP4SetTag(helpers, path, "composer", tags.Composer)
-	}
+	switch key {
+	case "artwork":
+		if value == "REMOVE_ALL" {
+			MP4RemoveArtwork(helpers, path)
+		} else {
+			MP4SetArtwork(helpers, path, value)
+		}
 
-	// Track number (format: N/T or just N)
-	if tags.Track > 0 {
-		trackStr := strconv.Itoa(tags.Track)
-		if tags.TrackTotal > 0 {
-			trackStr += "/" + strconv.Itoa(tags.TrackTotal)
+		return
+	case "lyricsFile":
+		lyrics, err := os.ReadFile(value) //nolint:gosec // G304: path is caller-provided test fixture.
+		if err != nil {
+			failOnError(helpers, err)
 		}
 
-		MP4SetTag(helpers, path, "tracknum", trackStr)
+		key, value = "lyrics", string(lyrics)
+	default:
 	}
 
-	// Disc number (format: N/T or just N)
-	if tags.Disc > 0 {
-		discStr := strconv.Itoa(tags.Disc)
-		if tags.DiscTotal > 0 {
-			discStr += "/" + strconv.Itoa(tags.DiscTotal)
+	failOnError(helpers, UpdateMP4Metadata(path, func(meta *MP4Metadata) {
+		switch key {
+		case "tracknum":
+			meta.Track, meta.TrackTotal = parsePairValue(value)
+		case "disk":
+			meta.Disc, meta.DiscTotal = parsePairValue(value)
+		default:
+			atom, ok := mp4TagAtoms[key]
+			if !ok {
+				atom = key
+			}
+
+			if value == "" || value == "remove" {
+				delete(meta.Text, atom)
+
+				return
+			}
+
+			meta.Text[atom] = []string{mp4TagValue(atom, value)}
 		}
+	}))
+}
 
-		MP4SetTag(helpers, path, "disk", discStr)
-	}
+// mp4TagValues maps the AtomicParsley keywords accepted by integer atoms to their values.
+//
+//nolint:gochecknoglobals // lookup table
+var mp4TagValues = map[string]map[string]string{
+	"rtng": {"clean": "2", "explicit": "1"},
+	"stik": {
+		"movie": "0", "normal": "1", "audiobook": "2", "whacked bookmark": "5", "music video": "6",
+		"short film": "9", "tv show": "10", "booklet": "11",
+	},
 }
 
-// MP4SetTag sets a tag on an MP4 file using atomicparsley.
-// Standard tags use short names: title, artist, album, albumArtist, genre, year, etc.
-// Modifies the file in-place.
-func MP4SetTag(helpers test.Helpers, path, key, value string) {
-	helpers.T().Helper()
+// mp4TagValue converts an AtomicParsley value for atom to the decimal string MP4Metadata expects for
+// integer atoms. Other values are returned unchanged.
+func mp4TagValue(atom, value string) string {
+	if keywords, ok := mp4TagValues[atom]; ok {
+		if number, ok := keywords[strings.ToLower(value)]; ok {
+			return number
+		}
 
-	ap := lookForOrFail(helpers.T(), atomicParsleyBinary)
-	helpers.Custom(ap, path, "--"+key, value, "--overWrite").Run(&test.Expected{})
+		return strings.TrimPrefix(value, "value=")
+	}
+
+	if _, ok := mp4IntegerAtoms[atom]; ok {
+		switch value {
+		case "true":
+			return "1"
+		case "false":
+			return "0"
+		default:
+		}
+	}
+
+	return value
 }
 
-// MP4SetFreeformTag sets a freeform tag on an MP4 file using atomicparsley.
+// MP4SetFreeformTag sets a freeform tag on an MP4 file, replacing any existing value.
 // Uses the reverse DNS format: domain (e.g., "com.apple.iTunes") and name (e.g., "ARTISTS").
 // Modifies the file in-place.
 func MP4SetFreeformTag(helpers test.Helpers, path, domain, name, value string) {
 	helpers.T().Helper()
 
-	ap := lookForOrFail(helpers.T(), atomicParsleyBinary)
-	// AtomicParsley syntax: --rDNSatom "value" name=NAME domain=DOMAIN
-	helpers.Custom(ap, path,
-		"--rDNSatom", value,
-		"name="+name,
-		"domain="+domain,
-		"--overWrite").Run(&test.Expected{})
+	failOnError(helpers, UpdateMP4Metadata(path, func(meta *MP4Metadata) {
+		meta.SetFreeform(domain, name, value)
+	}))
 }
 
-// MP4SetArtwork sets cover artwork on an MP4 file using atomicparsley.
+// MP4SetArtwork adds cover artwork (JPEG or PNG) to an MP4 file, after any existing artwork.
 // Modifies the file in-place.
 func MP4SetArtwork(helpers test.Helpers, path, artworkPath string) {
 	helpers.T().Helper()
 
-	ap := lookForOrFail(helpers.T(), atomicParsleyBinary)
-	helpers.Custom(ap, path, "--artwork", artworkPath, "--overWrite").Run(&test.Expected{})
+	image, err := os.ReadFile(artworkPath) //nolint:gosec // G304: path is caller-provided test fixture.
+	if err != nil {
+		failOnError(helpers, err)
+	}
+
+	failOnError(helpers, UpdateMP4Metadata(path, func(meta *MP4Metadata) {
+		meta.Artwork = append(meta.Artwork, image)
+	}))
 }
 
-// MP4RemoveAllTags removes all metadata from an MP4 file using atomicparsley.
+// MP4RemoveAllTags removes all metadata from an MP4 file.
 // Modifies the file in-place.
 func MP4RemoveAllTags(helpers test.Helpers, path string) {
 	helpers.T().Helper()
 
-	ap := lookForOrFail(helpers.T(), atomicParsleyBinary)
-	helpers.Custom(ap, path, "--metaEnema", "--overWrite").Run(&test.Expected{})
+	failOnError(helpers, WriteMP4Metadata(path, NewMP4Metadata()))
 }
 
-// MP4RemoveArtwork removes all artwork from an MP4 file using atomicparsley.
+// MP4RemoveArtwork removes all artwork from an MP4 file.
 // Modifies the file in-place.
 func MP4RemoveArtwork(helpers test.Helpers, path string) {
 	helpers.T().Helper()
 
-	ap := lookForOrFail(helpers.T(), atomicParsleyBinary)
-	helpers.Custom(ap, path, "--artwork", "REMOVE_ALL", "--overWrite").Run(&test.Expected{})
+	failOnError(helpers, UpdateMP4Metadata(path, func(meta *MP4Metadata) {
+		meta.Artwork = nil
+	}))
 }
 
 // MP4VerifyTagWithAtomicParsley verifies a tag value using atomicparsley.
@@ -175,101 +283,143 @@ func MP4RemoveArtwork(helpers test.Helpers, path string) {
 func MP4VerifyTagWithAtomicParsley(helpers test.Helpers, path string) {
 	helpers.T().Helper()
 
-	ap := lookForOrFail(helpers.T(), atomicParsleyBinary)
 	// Just verify atomicparsley can read the file without error
-	helpers.Custom(ap, path, "-t").Run(&test.Expected{})
+	runToolOrFail(helpers, "MP4VerifyTagWithAtomicParsley", atomicParsleyBinary, path, "-t")
 }
 
-// TaggedAAC returns path to AAC with standard metadata tags set via atomicparsley.
+// TaggedAAC returns path to AAC with standard metadata tags.
 func TaggedAAC(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	path := FormatAAC256k(data, helpers)
-	MP4SetAllTags(helpers, path, DefaultMP4Tags())
+	return taggedAAC().Path(data, helpers)
+}
 
-	return path
+func taggedAAC() Generator {
+	return formatAAC256k().renamed("TaggedAAC", "tagged-aac.m4a").then(setDefaultMP4Tags())
 }
 
-// TaggedALAC returns path to ALAC with standard metadata tags set via atomicparsley.
+// TaggedALAC returns path to ALAC with standard metadata tags.
 func TaggedALAC(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	path := FormatALAC(data, helpers)
-	MP4SetAllTags(helpers, path, DefaultMP4Tags())
+	return taggedALAC().Path(data, helpers)
+}
 
-	return path
+func taggedALAC() Generator {
+	return formatALAC().renamed("TaggedALAC", "tagged-alac.m4a").then(setDefaultMP4Tags())
 }
 
 // TaggedAACWithUnknown returns path to AAC with standard tags plus an unknown freeform tag.
 func TaggedAACWithUnknown(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	path := TaggedAAC(data, helpers)
+	return taggedAACWithUnknown().Path(data, helpers)
+}
 
+func taggedAACWithUnknown() Generator {
 	// Add an "unknown" freeform tag that's not in our mapping
-	MP4SetFreeformTag(helpers, path, iTunesDomain, "CUSTOM_UNKNOWN_TAG", "CustomValue")
-
-	return path
+	return taggedAAC().renamed("TaggedAACWithUnknown", "tagged-aac-unknown.m4a").then(
+		setMP4Freeform("CUSTOM_UNKNOWN_TAG", "CustomValue"))
 }
 
 // TaggedAACWithMultiArtist returns path to AAC with multiple artist values.
-// Note: AtomicParsley doesn't easily support multiple values for the same tag,
-// so this uses the ARTISTS freeform tag which is designed for multiple artists.
+// This uses the ARTISTS freeform tag, which Picard uses for multiple artists.
 func TaggedAACWithMultiArtist(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	path := TaggedAAC(data, helpers)
+	return taggedAACWithMultiArtist().Path(data, helpers)
+}
 
+func taggedAACWithMultiArtist() Generator {
 	// Add ARTISTS freeform tag (used by Picard for multiple artists)
-	MP4SetFreeformTag(helpers, path, iTunesDomain, "ARTISTS", "Artist One; Artist Two")
-
-	return path
+	return taggedAAC().renamed("TaggedAACWithMultiArtist", "tagged-aac-multi-artist.m4a").then(
+		setMP4Freeform("ARTISTS", "Artist One; Artist Two"))
 }
 
 // TaggedAACWithMusicBrainz returns path to AAC with MusicBrainz identifiers.
 func TaggedAACWithMusicBrainz(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	path := TaggedAAC(data, helpers)
+	return taggedAACWithMusicBrainz().Path(data, helpers)
+}
 
+func taggedAACWithMusicBrainz() Generator {
 	// Add MusicBrainz freeform tags
-	MP4SetFreeformTag(helpers, path, iTunesDomain, "MusicBrainz Track Id", "12345678-1234-1234-1234-123456789012")
-	MP4SetFreeformTag(helpers, path, iTunesDomain, "MusicBrainz Album Id", "abcdefab-abcd-abcd-abcd-abcdefabcdef")
-	MP4SetFreeformTag(
-		helpers,
-		path,
-		iTunesDomain,
-		"MusicBrainz Artist Id",
-		"11111111-2222-3333-4444-555555555555",
-	)
-
-	return path
+	return taggedAAC().renamed("TaggedAACWithMusicBrainz", "tagged-aac-musicbrainz.m4a").then(
+		setMP4Freeform(
+			"MusicBrainz Track Id", "12345678-1234-1234-1234-123456789012",
+			"MusicBrainz Album Id", "abcdefab-abcd-abcd-abcd-abcdefabcdef",
+			"MusicBrainz Artist Id", "11111111-2222-3333-4444-555555555555",
+		))
+}
+
+// setDefaultMP4Tags returns a generator step writing DefaultMP4Tags.
+func setDefaultMP4Tags() generatorStep {
+	return generatorStep{
+		key: []string{"mp4-tags", fmt.Sprintf("%+v", DefaultMP4Tags())},
+		run: func(_ context.Context, _, path string) error {
+			return UpdateMP4Metadata(path, func(meta *MP4Metadata) {
+				applyMP4Tags(meta, DefaultMP4Tags())
+			})
+		},
+	}
+}
+
+// setMP4Freeform returns a generator step setting iTunes freeform tags, given as name and value pairs.
+func setMP4Freeform(pairs ...string) generatorStep {
+	return generatorStep{
+		key: append([]string{"mp4-freeform"}, pairs...),
+		run: func(_ context.Context, _, path string) error {
+			return UpdateMP4Metadata(path, func(meta *MP4Metadata) {
+				for idx := 0; idx+1 < len(pairs); idx += 2 {
+					meta.SetFreeform(iTunesDomain, pairs[idx], pairs[idx+1])
+				}
+			})
+		},
+	}
 }
 
 // TaggedAACWithArtwork returns path to AAC with standard tags and cover artwork.
 func TaggedAACWithArtwork(data test.Data, helpers test.Helpers, artworkPath string) string {
 	helpers.T().Helper()
 
-	path := TaggedAAC(data, helpers)
-	MP4SetArtwork(helpers, path, artworkPath)
+	artwork, err := os.ReadFile(artworkPath) //nolint:gosec // G304: path is caller-provided test fixture.
+	failOnError(helpers, err)
 
-	return path
+	return TaggedAACWithArtworkGenerator(artwork).Path(data, helpers)
+}
+
+// TaggedAACWithArtworkGenerator returns the generator of TaggedAACWithArtwork, given the artwork image
+// (JPEG or PNG) itself.
+func TaggedAACWithArtworkGenerator(artwork []byte) Generator {
+	return taggedAAC().renamed("TaggedAACWithArtwork", "tagged-aac-artwork.m4a").then(generatorStep{
+		key: []string{"mp4-artwork", sha256Hex(artwork)},
+		run: func(_ context.Context, _, path string) error {
+			return UpdateMP4Metadata(path, func(meta *MP4Metadata) {
+				meta.Artwork = append(meta.Artwork, artwork)
+			})
+		},
+	})
 }
 
 // UntaggedAAC returns path to AAC with no metadata (tags stripped).
 func UntaggedAAC(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	// Generate a base AAC file
-	path := generate(helpers, filepath.Join(data.Temp().Dir(), "untagged.m4a"), []string{
+	return untaggedAAC().Path(data, helpers)
+}
+
+func untaggedAAC() Generator {
+	// Generate a base AAC file, then strip all metadata
+	return ffmpegGenerator("UntaggedAAC", "untagged.m4a", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + shortDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
 		"-ar", "44100", "-c:a", "aac", "-b:a", "256k",
+	}).then(generatorStep{
+		key: []string{"mp4-strip"},
+		run: func(_ context.Context, _, path string) error {
+			return WriteMP4Metadata(path, NewMP4Metadata())
+		},
 	})
-
-	// Strip all metadata
-	MP4RemoveAllTags(helpers, path)
-
-	return path
 }
@@ -0,0 +1,129 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+//nolint:paralleltest // the test case runs in parallel.
+func TestMP4SetTagAtomicParsleyValues(t *testing.T) {
+	testCase := &test.Case{
+		Setup: func(_ test.Data, helpers test.Helpers) {
+			path := filepath.Join(helpers.T().TempDir(), "tags.m4a")
+			if err := os.WriteFile(path, mp4Box("moov", mp4Box("mvhd", make([]byte, 100))), 0o600); err != nil {
+				helpers.T().Log(err.Error())
+				helpers.T().FailNow()
+			}
+
+			for key, value := range map[string]string{
+				"advisory": "explicit", "stik": "Audiobook", "gapless": "true", "compilation": "false",
+				"bpm": "120", "title": "Title", "composer": "Composer",
+			} {
+				agar.MP4SetTag(helpers, path, key, value)
+			}
+
+			agar.MP4SetTag(helpers, path, "composer", "remove")
+
+			meta, err := agar.ReadMP4Metadata(path)
+			if err != nil {
+				helpers.T().Log(err.Error())
+				helpers.T().FailNow()
+			}
+
+			expected := map[string][]string{
+				"rtng": {"1"}, "stik": {"2"}, "pgap": {"1"}, "cpil": {"0"}, "tmpo": {"120"}, "(c)nam": {"Title"},
+			}
+			if !maps.EqualFunc(meta.Text, expected, slices.Equal) {
+				helpers.T().Log(fmt.Sprintf("got %q", meta.Text))
+				helpers.T().Fail()
+			}
+		},
+	}
+
+	testCase.Run(t)
+}
+
+//nolint:paralleltest // the test case runs in parallel.
+func TestMP4SetTagAtomicParsleyOptions(t *testing.T) {
+	testCase := &test.Case{
+		Setup: func(_ test.Data, helpers test.Helpers) {
+			dir := helpers.T().TempDir()
+			path := filepath.Join(dir, "tags.m4a")
+			lyrics, cover := filepath.Join(dir, "lyrics.txt"), filepath.Join(dir, "cover.png")
+
+			for file, content := range map[string][]byte{
+				path:   mp4Box("moov", mp4Box("mvhd", make([]byte, 100))),
+				lyrics: []byte("la la la"),
+				cover:  []byte("\x89PNG\r\n\x1a\n"),
+			} {
+				if err := os.WriteFile(file, content, 0o600); err != nil {
+					helpers.T().Log(err.Error())
+					helpers.T().FailNow()
+				}
+			}
+
+			for _, option := range [][2]string{
+				{"encodingTool", "agar"}, {"encodedBy", "Encoder"}, {"storedesc", "Store"}, {"apID", "someone"},
+				{"cnID", "1234"}, {"geID", "5678"}, {"xID", "vendor:isrc:1"}, {"lyricsFile", lyrics},
+				{"artwork", cover}, {"artwork", cover}, {"tracknum", "2/9"}, {"disk", "1/2"},
+			} {
+				agar.MP4SetTag(helpers, path, option[0], option[1])
+			}
+
+			meta, err := agar.ReadMP4Metadata(path)
+			if err != nil {
+				helpers.T().Log(err.Error())
+				helpers.T().FailNow()
+			}
+
+			expected := map[string][]string{
+				"(c)too": {"agar"}, "(c)enc": {"Encoder"}, "sdes": {"Store"}, "apID": {"someone"},
+				"cnID": {"1234"}, "geID": {"5678"}, "xid ": {"vendor:isrc:1"}, "(c)lyr": {"la la la"},
+			}
+			if !maps.EqualFunc(meta.Text, expected, slices.Equal) {
+				helpers.T().Log(fmt.Sprintf("got %q", meta.Text))
+				helpers.T().Fail()
+			}
+
+			if len(meta.Artwork) != 2 || meta.Track != 2 || meta.TrackTotal != 9 ||
+				meta.Disc != 1 || meta.DiscTotal != 2 {
+				helpers.T().Log(fmt.Sprintf("got %d images, track %d/%d, disc %d/%d",
+					len(meta.Artwork), meta.Track, meta.TrackTotal, meta.Disc, meta.DiscTotal))
+				helpers.T().Fail()
+			}
+
+			agar.MP4SetTag(helpers, path, "artwork", "REMOVE_ALL")
+
+			if meta, err = agar.ReadMP4Metadata(path); err != nil || len(meta.Artwork) != 0 {
+				helpers.T().Log(fmt.Sprintf("artwork left after REMOVE_ALL: %v", err))
+				helpers.T().Fail()
+			}
+		},
+	}
+
+	testCase.Run(t)
+}
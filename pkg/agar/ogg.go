@@ -0,0 +1,474 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Ogg page layout.
+const (
+	oggPageHeaderSize  = 27
+	oggSegmentsOffset  = 26
+	oggSerialOffset    = 14
+	oggHeaderTypeIndex = 5
+	oggMaxSegmentSize  = 255
+	oggFlagBOS         = 0x02
+)
+
+// Codec header packet signatures.
+const (
+	vorbisIDHeader      = "\x01vorbis"
+	vorbisCommentHeader = "\x03vorbis"
+	opusIDHeader        = "OpusHead"
+	opusCommentHeader   = "OpusTags"
+)
+
+// metadataBlockPicture is the Vorbis comment holding a base64 FLAC PICTURE block.
+const metadataBlockPicture = "METADATA_BLOCK_PICTURE"
+
+// ErrOggMalformed is returned when an Ogg stream cannot be demuxed.
+var ErrOggMalformed = errors.New("malformed Ogg stream")
+
+// ParseVorbisComment reads the Vorbis comment header of an Ogg Vorbis file natively.
+func ParseVorbisComment(filePath string) (*ParsedTags, error) {
+	return parseOggComments(filePath, vorbisIDHeader, vorbisCommentHeader, ErrOGGNotSupported)
+}
+
+// ParseOpusTags reads the OpusTags header of an Ogg Opus file natively.
+func ParseOpusTags(filePath string) (*ParsedTags, error) {
+	return parseOggComments(filePath, opusIDHeader, opusCommentHeader, ErrOpusNotSupported)
+}
+
+// parseOggComments reads the comment header, the second packet of the first logical stream,
+// after checking that the identification header matches the expected codec.
+func parseOggComments(filePath, idHeader, commentHeader string, errCodec error) (*ParsedTags, error) {
+	content, err := os.ReadFile(filePath) //nolint:gosec // G304: path is caller-provided test fixture.
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filePath, err)
+	}
+
+	packets, err := readOggPackets(content, 2)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	if len(packets) < 2 || !bytes.HasPrefix(packets[0], []byte(idHeader)) {
+		return nil, fmt.Errorf("%w: %s", errCodec, filePath)
+	}
+
+	if !bytes.HasPrefix(packets[1], []byte(commentHeader)) {
+		return nil, fmt.Errorf("%w: %s: second packet is not a comment header", ErrOggMalformed, filePath)
+	}
+
+	tags, err := parseVorbisCommentPacket(packets[1][len(commentHeader):])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	return tags, nil
+}
+
+// readOggPackets returns up to count complete packets of the first logical stream in content,
+// reassembling packets that span several pages.
+func readOggPackets(content []byte, count int) ([][]byte, error) {
+	var (
+		packets [][]byte
+		pending []byte
+		serial  uint32
+		started bool
+	)
+
+	for len(content) > 0 && len(packets) < count {
+		if len(content) < oggPageHeaderSize || string(content[:4]) != "OggS" {
+			return nil, fmt.Errorf("%w: missing capture pattern", ErrOggMalformed)
+		}
+
+		numSegments := int(content[oggSegmentsOffset])
+		headerSize := oggPageHeaderSize + numSegments
+
+		if len(content) < headerSize {
+			return nil, fmt.Errorf("%w: truncated page header", ErrOggMalformed)
+		}
+
+		lacing := content[oggPageHeaderSize:headerSize]
+
+		bodySize := 0
+		for _, size := range lacing {
+			bodySize += int(size)
+		}
+
+		if len(content) < headerSize+bodySize {
+			return nil, fmt.Errorf("%w: truncated page", ErrOggMalformed)
+		}
+
+		headerType := content[oggHeaderTypeIndex]
+		pageSerial := binary.LittleEndian.Uint32(content[oggSerialOffset:])
+		body := content[headerSize : headerSize+bodySize]
+		content = content[headerSize+bodySize:]
+
+		if !started {
+			if headerType&oggFlagBOS == 0 {
+				return nil, fmt.Errorf("%w: first page is not a beginning of stream", ErrOggMalformed)
+			}
+
+			serial, started = pageSerial, true
+		}
+
+		// Pages of other multiplexed streams are skipped.
+		if pageSerial != serial {
+			continue
+		}
+
+		for _, size := range lacing {
+			pending = append(pending, body[:size]...)
+			body = body[size:]
+
+			// A lacing value below 255 terminates the packet.
+			if size < oggMaxSegmentSize {
+				packets = append(packets, pending)
+				pending = nil
+
+				if len(packets) == count {
+					break
+				}
+			}
+		}
+	}
+
+	return packets, nil
+}
+
+// parseVorbisCommentPacket parses a comment header body (after its codec signature):
+// vendor string, then a count of "KEY=value" comments, all length-prefixed little endian.
+func parseVorbisCommentPacket(packet []byte) (*ParsedTags, error) {
+	readString := func() (string, bool) {
+		if len(packet) < 4 {
+			return "", false
+		}
+
+		size := binary.LittleEndian.Uint32(packet)
+		if uint64(size) > uint64(len(packet)-4) {
+			return "", false
+		}
+
+		value := string(packet[4 : 4+size])
+		packet = packet[4+size:]
+
+		return value, true
+	}
+
+	if _, ok := readString(); !ok {
+		return nil, fmt.Errorf("%w: truncated vendor string", ErrOggMalformed)
+	}
+
+	if len(packet) < 4 {
+		return nil, fmt.Errorf("%w: missing comment count", ErrOggMalformed)
+	}
+
+	count := binary.LittleEndian.Uint32(packet)
+	packet = packet[4:]
+
+	tags := NewParsedTags()
+
+	for range count {
+		comment, ok := readString()
+		if !ok {
+			return nil, fmt.Errorf("%w: truncated comment", ErrOggMalformed)
+		}
+
+		key, value, found := strings.Cut(comment, "=")
+		if !found || key == "" {
+			continue
+		}
+
+		if strings.EqualFold(key, metadataBlockPicture) {
+			if _, err := base64.StdEncoding.DecodeString(value); err == nil {
+				tags.PictureCount++
+			}
+
+			continue
+		}
+
+		addVorbisComment(tags, key, value)
+	}
+
+	return tags, nil
+}
+
+// Ogg page fields written by replaceOggPacket.
+const (
+	oggGranuleOffset    = 6
+	oggSequenceOffset   = 18
+	oggChecksumOffset   = 22
+	oggFlagContinued    = 0x01
+	oggCRCPolynomial    = 0x04C11DB7
+	oggCRCTopBit        = 0x80000000
+	oggCRCShift         = 24
+	oggNoGranule        = ^uint64(0)
+	vorbisHeaderPackets = 3
+	opusHeaderPackets   = 2
+)
+
+// oggPage is a parsed Ogg page.
+type oggPage struct {
+	raw      []byte
+	flags    byte
+	serial   uint32
+	sequence uint32
+	lacing   []byte
+	body     []byte
+}
+
+// parseOggPages splits content into pages.
+func parseOggPages(content []byte) ([]oggPage, error) {
+	var pages []oggPage
+
+	for len(content) > 0 {
+		if len(content) < oggPageHeaderSize || string(content[:4]) != "OggS" {
+			return nil, fmt.Errorf("%w: missing capture pattern", ErrOggMalformed)
+		}
+
+		headerSize := oggPageHeaderSize + int(content[oggSegmentsOffset])
+		if len(content) < headerSize {
+			return nil, fmt.Errorf("%w: truncated page header", ErrOggMalformed)
+		}
+
+		lacing := content[oggPageHeaderSize:headerSize]
+
+		bodySize := 0
+		for _, size := range lacing {
+			bodySize += int(size)
+		}
+
+		if len(content) < headerSize+bodySize {
+			return nil, fmt.Errorf("%w: truncated page", ErrOggMalformed)
+		}
+
+		pages = append(pages, oggPage{
+			raw:      content[:headerSize+bodySize],
+			flags:    content[oggHeaderTypeIndex],
+			serial:   binary.LittleEndian.Uint32(content[oggSerialOffset:]),
+			sequence: binary.LittleEndian.Uint32(content[oggSequenceOffset:]),
+			lacing:   lacing,
+			body:     content[headerSize : headerSize+bodySize],
+		})
+
+		content = content[headerSize+bodySize:]
+	}
+
+	return pages, nil
+}
+
+// replaceOggPacket replaces header packet index of the first logical stream, which has headerCount
+// header packets. Header pages after the first are rebuilt (codecs start audio on a fresh page), and
+// later pages of the stream are renumbered. Pages of other multiplexed streams are kept as-is.
+//
+//nolint:cyclop // page bookkeeping.
+func replaceOggPacket(content []byte, headerCount, index int, packet []byte) ([]byte, error) {
+	pages, err := parseOggPages(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pages) == 0 || pages[0].flags&oggFlagBOS == 0 {
+		return nil, fmt.Errorf("%w: first page is not a beginning of stream", ErrOggMalformed)
+	}
+
+	serial := pages[0].serial
+
+	// Collect the header packets and the pages holding them.
+	var (
+		packets  [][]byte
+		pending  []byte
+		lastPage = -1
+	)
+
+	for pageIdx, page := range pages {
+		if page.serial != serial {
+			continue
+		}
+
+		body := page.body
+
+		for _, size := range page.lacing {
+			pending = append(pending, body[:size]...)
+			body = body[size:]
+
+			if size < oggMaxSegmentSize {
+				packets = append(packets, pending)
+				pending = nil
+			}
+		}
+
+		// The identification header must fill the first page on its own.
+		if pageIdx == 0 && (len(packets) != 1 || pending != nil) {
+			return nil, fmt.Errorf("%w: identification header shares its page", ErrOggMalformed)
+		}
+
+		if len(packets) >= headerCount {
+			if len(packets) > headerCount || pending != nil {
+				return nil, fmt.Errorf("%w: audio data shares a page with the headers", ErrOggMalformed)
+			}
+
+			lastPage = pageIdx
+
+			break
+		}
+	}
+
+	if lastPage < 0 || index < 1 || index >= headerCount {
+		return nil, fmt.Errorf("%w: missing header packets", ErrOggMalformed)
+	}
+
+	packets[index] = packet
+	headerPages := buildOggPages(serial, 1, packets[1:])
+
+	oldHeaderPages := 0
+
+	for _, page := range pages[1 : lastPage+1] {
+		if page.serial == serial {
+			oldHeaderPages++
+		}
+	}
+
+	written := false
+
+	shift := uint32(len(headerPages) - oldHeaderPages) //nolint:gosec // G115: wraps as intended for fewer pages.
+	out := make([]byte, 0, len(content)+len(packet))
+
+	for pageIdx, page := range pages {
+		switch {
+		case page.serial != serial || pageIdx == 0:
+			out = append(out, page.raw...)
+		case pageIdx <= lastPage:
+			if !written {
+				for _, header := range headerPages {
+					out = append(out, header...)
+				}
+
+				written = true
+			}
+		default:
+			renumbered := bytes.Clone(page.raw)
+			binary.LittleEndian.PutUint32(renumbered[oggSequenceOffset:], page.sequence+shift)
+			out = append(out, sealOggPage(renumbered)...)
+		}
+	}
+
+	return out, nil
+}
+
+// buildOggPages lays packets out on pages of at most 255 segments, starting at sequence.
+// Pages on which no packet ends carry no granule position; the others carry granule zero, as
+// header pages do.
+func buildOggPages(serial, sequence uint32, packets [][]byte) [][]byte {
+	var (
+		pages     [][]byte
+		lacing    []byte
+		body      []byte
+		ended     bool
+		continued bool
+	)
+
+	flush := func(nextContinued bool) {
+		granule := uint64(0)
+		if !ended {
+			granule = oggNoGranule
+		}
+
+		var flags byte
+		if continued {
+			flags = oggFlagContinued
+		}
+
+		header := make([]byte, oggPageHeaderSize, oggPageHeaderSize+len(lacing))
+		copy(header, "OggS")
+		header[oggHeaderTypeIndex] = flags
+		binary.LittleEndian.PutUint64(header[oggGranuleOffset:], granule)
+		binary.LittleEndian.PutUint32(header[oggSerialOffset:], serial)
+		binary.LittleEndian.PutUint32(header[oggSequenceOffset:], sequence)
+		header[oggSegmentsOffset] = byte(len(lacing))
+
+		pages = append(pages, sealOggPage(slices.Concat(header, lacing, body)))
+		sequence++
+		lacing, body, ended, continued = nil, nil, false, nextContinued
+	}
+
+	for _, packet := range packets {
+		remaining := packet
+
+		for {
+			size := min(len(remaining), oggMaxSegmentSize)
+			lacing = append(lacing, byte(size))
+			body = append(body, remaining[:size]...)
+			remaining = remaining[size:]
+
+			last := size < oggMaxSegmentSize
+			if last {
+				ended = true
+			}
+
+			if len(lacing) == oggMaxSegmentSize {
+				flush(!last)
+			}
+
+			if last {
+				break
+			}
+		}
+	}
+
+	if len(lacing) > 0 {
+		flush(false)
+	}
+
+	return pages
+}
+
+// sealOggPage computes and stores the CRC of a complete page.
+func sealOggPage(page []byte) []byte {
+	binary.LittleEndian.PutUint32(page[oggChecksumOffset:], 0)
+	binary.LittleEndian.PutUint32(page[oggChecksumOffset:], oggCRC(page))
+
+	return page
+}
+
+// oggCRC is the CRC-32 of Ogg pages: polynomial 0x04C11DB7, no reflection, zero initial value.
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+
+	for _, b := range data {
+		crc ^= uint32(b) << oggCRCShift
+		for range bitsPerByte {
+			if crc&oggCRCTopBit != 0 {
+				crc = crc<<1 ^ oggCRCPolynomial
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
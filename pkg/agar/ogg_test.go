@@ -0,0 +1,238 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+const (
+	oggFlagContinued = 0x01
+	oggFlagBOS       = 0x02
+	oggCRCPolynomial = 0x04C11DB7
+	oggCRCOffset     = 22
+	// oggPageHeaderSize excludes the lacing values.
+	oggPageHeaderSize = 27
+)
+
+// oggStream lays out a logical Ogg stream. Each group of packets starts on a fresh page, and pages hold at
+// most perPage lacing segments, so small values spread packets over many pages.
+func oggStream(serial uint32, perPage int, groups ...[][]byte) []byte {
+	var (
+		out      bytes.Buffer
+		sequence uint32
+	)
+
+	for groupIdx, group := range groups {
+		var segments [][]byte
+
+		for _, packet := range group {
+			for len(packet) >= 255 {
+				segments = append(segments, packet[:255])
+				packet = packet[255:]
+			}
+
+			segments = append(segments, packet)
+		}
+
+		for start := 0; start < len(segments); start += perPage {
+			end := min(start+perPage, len(segments))
+
+			flags := byte(0)
+			if groupIdx == 0 && start == 0 {
+				flags |= oggFlagBOS
+			}
+
+			if start > 0 && len(segments[start-1]) == 255 {
+				flags |= oggFlagContinued
+			}
+
+			page := append([]byte("OggS\x00"), flags)
+			page = binary.LittleEndian.AppendUint64(page, 0)
+			page = binary.LittleEndian.AppendUint32(page, serial)
+			page = binary.LittleEndian.AppendUint32(page, sequence)
+			page = append(page, 0, 0, 0, 0, byte(end-start))
+
+			for _, segment := range segments[start:end] {
+				page = append(page, byte(len(segment)))
+			}
+
+			for _, segment := range segments[start:end] {
+				page = append(page, segment...)
+			}
+
+			binary.LittleEndian.PutUint32(page[oggCRCOffset:], oggTestCRC(page))
+			out.Write(page)
+
+			sequence++
+		}
+	}
+
+	return out.Bytes()
+}
+
+// oggTestCRC is the bitwise form of the Ogg page checksum.
+func oggTestCRC(page []byte) uint32 {
+	var crc uint32
+
+	for _, b := range page {
+		crc ^= uint32(b) << 24
+
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ oggCRCPolynomial
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// commentPacket builds a Vorbis comment header behind the given codec signature.
+func commentPacket(signature string, comments ...string) []byte {
+	packet := []byte(signature)
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(len("agar")))
+	packet = append(packet, "agar"...)
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(len(comments))) //nolint:gosec // G115: small.
+
+	for _, comment := range comments {
+		packet = binary.LittleEndian.AppendUint32(packet, uint32(len(comment))) //nolint:gosec // G115: small.
+		packet = append(packet, comment...)
+	}
+
+	if signature == "\x03vorbis" {
+		packet = append(packet, 1) // framing bit
+	}
+
+	return packet
+}
+
+func vorbisStream(comments ...string) []byte {
+	return oggStream(7, 255,
+		[][]byte{append([]byte("\x01vorbis"), make([]byte, 23)...)},
+		[][]byte{commentPacket("\x03vorbis", comments...), []byte("\x05vorbis setup")},
+		[][]byte{{0x00, 0x01, 0x02}},
+	)
+}
+
+func opusStream(comments ...string) []byte {
+	return oggStream(9, 255,
+		[][]byte{append([]byte("OpusHead\x01\x02"), make([]byte, 9)...)},
+		[][]byte{commentPacket("OpusTags", comments...)},
+		[][]byte{{0xFC, 0xFF, 0xFE}},
+	)
+}
+
+func writeTestFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestParseVorbisCommentAcrossPages(t *testing.T) {
+	t.Parallel()
+
+	picture := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1, 2, 3}, 3000))
+	comments := commentPacket("\x03vorbis", "TITLE=Title", "artist=First", "ARTIST=Second",
+		"TRACKNUMBER=3", "TRACKTOTAL=10", "METADATA_BLOCK_PICTURE="+picture, "MUSICBRAINZ_TRACKID=recording")
+
+	// Five segments per page spreads the comment header, and its picture, over many pages.
+	stream := oggStream(7, 5,
+		[][]byte{append([]byte("\x01vorbis"), make([]byte, 23)...)},
+		[][]byte{comments, []byte("\x05vorbis setup")},
+	)
+
+	// Pages of another multiplexed stream are skipped.
+	other := oggStream(8, 255, [][]byte{[]byte("\x01vorbis other")}, [][]byte{[]byte("\x03vorbis other")})
+	firstPage := oggPageHeaderSize + 1 + 30
+	stream = append(append(stream[:firstPage:firstPage], other...), stream[firstPage:]...)
+
+	tags, err := agar.ParseVorbisComment(writeTestFile(t, "split.ogg", stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"title":                   {"Title"},
+		"artist":                  {"First", "Second"},
+		"tracknumber":             {"3"},
+		"tracktotal":              {"10"},
+		"musicbrainz_recordingid": {"recording"},
+	}
+
+	if !reflect.DeepEqual(tags.Text, expected) {
+		t.Errorf("text %v, expected %v", tags.Text, expected)
+	}
+
+	if tags.Track != 3 || tags.TrackTotal != 10 || tags.PictureCount != 1 {
+		t.Errorf("track %d/%d with %d pictures, expected 3/10 with 1", tags.Track, tags.TrackTotal, tags.PictureCount)
+	}
+}
+
+func TestParseOpusTags(t *testing.T) {
+	t.Parallel()
+
+	tags, err := agar.ParseOpusTags(writeTestFile(t, "tags.opus", opusStream("TITLE=Opus", "DISCNUMBER=1/2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tags.Text["title"][0] != "Opus" || tags.Disc != 1 || tags.Text["discnumber"][0] != "1/2" {
+		t.Errorf("parsed %+v, expected the title and disc 1/2", tags)
+	}
+}
+
+func TestParseOggCommentsErrors(t *testing.T) {
+	t.Parallel()
+
+	vorbis, opus := vorbisStream("TITLE=Vorbis"), opusStream("TITLE=Opus")
+	notBOS := bytes.Clone(vorbis)
+	notBOS[5] = 0
+
+	for _, tc := range []struct {
+		name     string
+		content  []byte
+		parse    func(string) (*agar.ParsedTags, error)
+		expected error
+	}{
+		{"opus as vorbis", opus, agar.ParseVorbisComment, agar.ErrOGGNotSupported},
+		{"vorbis as opus", vorbis, agar.ParseOpusTags, agar.ErrOpusNotSupported},
+		{"truncated", opus[:50], agar.ParseOpusTags, agar.ErrOggMalformed},
+		{"no capture pattern", append([]byte("RIFF"), vorbis[4:]...), agar.ParseVorbisComment, agar.ErrOggMalformed},
+		{"missing beginning of stream", notBOS, agar.ParseVorbisComment, agar.ErrOggMalformed},
+	} {
+		if _, err := tc.parse(writeTestFile(t, "stream.ogg", tc.content)); !errors.Is(err, tc.expected) {
+			t.Errorf("%s: got %v, expected %v", tc.name, err, tc.expected)
+		}
+	}
+}
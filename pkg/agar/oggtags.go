@@ -17,7 +17,6 @@
 package agar
 
 import (
-	"path/filepath"
 	"strconv"
 
 	"github.com/containerd/nerdctl/mod/tigron/test"
@@ -66,6 +65,11 @@ func DefaultOggTags() OggTags {
 func OggSetTags(helpers test.Helpers, path string, tags OggTags) {
 	helpers.T().Helper()
 
+	runToolOrFail(helpers, "OggSetTags", vorbiscommentBinary, oggSetTagsArgs(path, tags)...)
+}
+
+// oggSetTagsArgs returns the vorbiscomment arguments replacing the comments of path with tags.
+func oggSetTagsArgs(path string, tags OggTags) []string {
 	args := []string{"-w"} // Write mode (replace all)
 
 	if tags.Title != "" {
@@ -118,10 +122,7 @@ func OggSetTags(helpers test.Helpers, path string, tags OggTags) {
 		args = append(args, vorbisTagFlag, "DISCTOTAL="+strconv.Itoa(tags.DiscTotal))
 	}
 
-	args = append(args, path)
-
-	vc := lookForOrFail(helpers.T(), vorbiscommentBinary)
-	helpers.Custom(vc, args...).Run(&test.Expected{})
+	return append(args, path)
 }
 
 // OggAddTag adds a single tag to an OGG file using vorbiscomment.
@@ -129,43 +130,47 @@ func OggSetTags(helpers test.Helpers, path string, tags OggTags) {
 func OggAddTag(helpers test.Helpers, path, key, value string) {
 	helpers.T().Helper()
 
-	vc := lookForOrFail(helpers.T(), vorbiscommentBinary)
-	helpers.Custom(vc, "-a", vorbisTagFlag, key+"="+value, path).Run(&test.Expected{})
+	runToolOrFail(helpers, "OggAddTag", vorbiscommentBinary, "-a", vorbisTagFlag, key+"="+value, path)
 }
 
 // TaggedOggVorbis returns path to OGG Vorbis with standard metadata tags.
 func TaggedOggVorbis(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	path := generate(helpers, filepath.Join(data.Temp().Dir(), "tagged-ogg-vorbis.ogg"), []string{
-		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
-		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + shortDuration,
-		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
-		"-ar", "44100", "-c:a", "libvorbis", "-q:a", "6",
-	})
-
-	OggSetTags(helpers, path, DefaultOggTags())
+	return taggedOggVorbis().Path(data, helpers)
+}
 
-	return path
+func taggedOggVorbis() Generator {
+	return untaggedOggVorbis().renamed("TaggedOggVorbis", "tagged-ogg-vorbis.ogg").then(
+		toolStep(vorbiscommentBinary, func(path string) []string {
+			return oggSetTagsArgs(path, DefaultOggTags())
+		}))
 }
 
 // TaggedOggVorbisMultiArtist returns path to OGG with multiple artist values.
 func TaggedOggVorbisMultiArtist(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	path := TaggedOggVorbis(data, helpers)
+	return taggedOggVorbisMultiArtist().Path(data, helpers)
+}
 
+func taggedOggVorbisMultiArtist() Generator {
 	// Add second artist using vorbiscomment append mode
-	OggAddTag(helpers, path, "ARTIST", "Artist Two")
-
-	return path
+	return taggedOggVorbis().renamed("TaggedOggVorbisMultiArtist", "tagged-ogg-vorbis-multi-artist.ogg").then(
+		toolStep(vorbiscommentBinary, func(path string) []string {
+			return []string{"-a", vorbisTagFlag, "ARTIST=Artist Two", path}
+		}))
 }
 
 // UntaggedOggVorbis returns path to OGG Vorbis with no tags.
 func UntaggedOggVorbis(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "untagged-ogg-vorbis.ogg"), []string{
+	return untaggedOggVorbis().Path(data, helpers)
+}
+
+func untaggedOggVorbis() Generator {
+	return ffmpegGenerator("UntaggedOggVorbis", "untagged-ogg-vorbis.ogg", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
 		"-f", "lavfi", "-i", "sine=frequency=554:duration=" + shortDuration,
 		"-filter_complex", "[0][1]amerge=inputs=2,volume=-6dB",
@@ -22,7 +22,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -30,9 +29,9 @@ import (
 
 // Sentinel errors for unsupported formats.
 var (
-	ErrMP3NotSupported  = errors.New("MP3/ID3v2 parsing not yet supported")
-	ErrOGGNotSupported  = errors.New("OGG Vorbis parsing not yet supported")
-	ErrOpusNotSupported = errors.New("opus parsing not yet supported")
+	ErrMP3NotSupported  = errors.New("MP3/ID3v2 tag variant not supported")
+	ErrOGGNotSupported  = errors.New("not an Ogg Vorbis stream")
+	ErrOpusNotSupported = errors.New("not an Ogg Opus stream")
 )
 
 // ParsedTags holds metadata parsed from a native tool.
@@ -78,17 +77,14 @@ func ParseAtomicParsley(ctx context.Context, filePath string) (*ParsedTags, erro
 		return nil, fmt.Errorf("%s: %w", atomicParsleyBinary, err)
 	}
 
-	//nolint:gosec // binary resolved by LookFor
-	cmd := exec.CommandContext(ctx, atomicParsley, filePath, "-t")
+	cmd := newToolCommand(ctx, atomicParsley, filePath, "-t")
 
-	var stdout, stderr bytes.Buffer
+	var stdout bytes.Buffer
 
 	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
 
-	err = cmd.Run()
-	if err != nil {
-		return nil, fmt.Errorf("atomicparsley failed: %w\nstderr: %s", err, stderr.String())
+	if err = runTool(ctx, "ParseAtomicParsley", cmd); err != nil {
+		return nil, err
 	}
 
 	output := stdout.Bytes()
@@ -174,17 +170,14 @@ func ParseMetaflac(ctx context.Context, filePath string) (*ParsedTags, error) {
 		return nil, fmt.Errorf("%s: %w", metaflacBinary, err)
 	}
 
-	//nolint:gosec // binary resolved by LookFor
-	cmd := exec.CommandContext(ctx, metaflac, "--export-tags-to=-", filePath)
+	cmd := newToolCommand(ctx, metaflac, "--export-tags-to=-", filePath)
 
-	var stdout, stderr bytes.Buffer
+	var stdout bytes.Buffer
 
 	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
 
-	err = cmd.Run()
-	if err != nil {
-		return nil, fmt.Errorf("metaflac failed: %w\nstderr: %s", err, stderr.String())
+	if err = runTool(ctx, "ParseMetaflac", cmd); err != nil {
+		return nil, err
 	}
 
 	output := stdout.Bytes()
@@ -195,37 +188,7 @@ func ParseMetaflac(ctx context.Context, filePath string) (*ParsedTags, error) {
 	for scanner.Scan() {
 		line := scanner.Text()
 		if idx := strings.Index(line, "="); idx > 0 {
-			key := line[:idx]
-			value := line[idx+1:]
-
-			// Vorbis comment keys are case-insensitive, normalize to uppercase
-			upperKey := strings.ToUpper(key)
-
-			// Handle special tags
-			switch upperKey {
-			case "TRACKNUMBER":
-				tags.Track, _ = parsePairValue(value)
-				// Add to Text in gill's format
-				tags.Text["tracknumber"] = append(tags.Text["tracknumber"], value)
-			case "TRACKTOTAL", "TOTALTRACKS":
-				tags.TrackTotal, _ = strconv.Atoi(value)
-				// Also store as semantic name
-				semanticKey := vorbisToSemanticName(upperKey)
-				tags.Text[semanticKey] = append(tags.Text[semanticKey], value)
-			case "DISCNUMBER":
-				tags.Disc, _ = parsePairValue(value)
-				// Add to Text in gill's format
-				tags.Text["discnumber"] = append(tags.Text["discnumber"], value)
-			case "DISCTOTAL", "TOTALDISCS":
-				tags.DiscTotal, _ = strconv.Atoi(value)
-				// Also store as semantic name
-				semanticKey := vorbisToSemanticName(upperKey)
-				tags.Text[semanticKey] = append(tags.Text[semanticKey], value)
-			default:
-				// Convert to semantic name
-				semanticKey := vorbisToSemanticName(upperKey)
-				tags.Text[semanticKey] = append(tags.Text[semanticKey], value)
-			}
+			addVorbisComment(tags, line[:idx], line[idx+1:])
 		}
 	}
 
@@ -239,6 +202,38 @@ func ParseMetaflac(ctx context.Context, filePath string) (*ParsedTags, error) {
 	return tags, nil
 }
 
+// addVorbisComment stores a Vorbis comment under its semantic name, filling track and disc numbers.
+func addVorbisComment(tags *ParsedTags, key, value string) {
+	// Vorbis comment keys are case-insensitive, normalize to uppercase
+	upperKey := strings.ToUpper(key)
+
+	// Handle special tags
+	switch upperKey {
+	case "TRACKNUMBER":
+		tags.Track, _ = parsePairValue(value)
+		// Add to Text in gill's format
+		tags.Text["tracknumber"] = append(tags.Text["tracknumber"], value)
+	case "TRACKTOTAL", "TOTALTRACKS":
+		tags.TrackTotal, _ = strconv.Atoi(value)
+		// Also store as semantic name
+		semanticKey := vorbisToSemanticName(upperKey)
+		tags.Text[semanticKey] = append(tags.Text[semanticKey], value)
+	case "DISCNUMBER":
+		tags.Disc, _ = parsePairValue(value)
+		// Add to Text in gill's format
+		tags.Text["discnumber"] = append(tags.Text["discnumber"], value)
+	case "DISCTOTAL", "TOTALDISCS":
+		tags.DiscTotal, _ = strconv.Atoi(value)
+		// Also store as semantic name
+		semanticKey := vorbisToSemanticName(upperKey)
+		tags.Text[semanticKey] = append(tags.Text[semanticKey], value)
+	default:
+		// Convert to semantic name
+		semanticKey := vorbisToSemanticName(upperKey)
+		tags.Text[semanticKey] = append(tags.Text[semanticKey], value)
+	}
+}
+
 // countMetaflacPictures counts PICTURE blocks in a FLAC file.
 func countMetaflacPictures(ctx context.Context, filePath string) int {
 	metaflac, err := LookFor(metaflacBinary)
@@ -246,17 +241,19 @@ func countMetaflacPictures(ctx context.Context, filePath string) int {
 		return 0
 	}
 
-	//nolint:gosec // binary resolved by LookFor
-	cmd := exec.CommandContext(ctx, metaflac, "--list", "--block-type=PICTURE", filePath)
+	cmd := newToolCommand(ctx, metaflac, "--list", "--block-type=PICTURE", filePath)
 
-	output, err := cmd.Output()
-	if err != nil {
+	var output bytes.Buffer
+
+	cmd.Stdout = &output
+
+	if runTool(ctx, "countMetaflacPictures", cmd) != nil {
 		return 0
 	}
 
 	// Count "type: 6 (PICTURE)" lines
 	count := 0
-	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner := bufio.NewScanner(&output)
 
 	for scanner.Scan() {
 		if strings.Contains(scanner.Text(), "type: 6 (PICTURE)") {
@@ -267,21 +264,6 @@ func countMetaflacPictures(ctx context.Context, filePath string) int {
 	return count
 }
 
-// ParseID3v2 is a stub that logs a warning - MP3 not yet supported.
-func ParseID3v2(filePath string) (*ParsedTags, error) {
-	return nil, fmt.Errorf("%w: %s", ErrMP3NotSupported, filePath)
-}
-
-// ParseVorbisComment is a stub that logs a warning - OGG Vorbis not yet supported.
-func ParseVorbisComment(filePath string) (*ParsedTags, error) {
-	return nil, fmt.Errorf("%w: %s", ErrOGGNotSupported, filePath)
-}
-
-// ParseOpusTags is a stub that logs a warning - Opus not yet supported.
-func ParseOpusTags(filePath string) (*ParsedTags, error) {
-	return nil, fmt.Errorf("%w: %s", ErrOpusNotSupported, filePath)
-}
-
 // formatPairValue formats a number/total pair as "N/M" string.
 func formatPairValue(num, total int) string {
 	if total > 0 {
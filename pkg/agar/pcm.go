@@ -19,6 +19,9 @@ package agar
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"testing"
@@ -47,20 +50,66 @@ const (
 	lossyLargeDiffPct     = 100
 )
 
-// PCMBytesPerSample returns the number of bytes per sample for a given bit depth.
-func PCMBytesPerSample(bitDepth int) int {
-	switch bitDepth {
-	case BitDepth4, BitDepth8:
-		return 1
-	case BitDepth12, BitDepth16:
-		return 2
-	case BitDepth20, BitDepth24:
-		return 3
-	case BitDepth32:
-		return 4
-	default:
-		return bitDepth / bitsPerByte
+// Float sample sizes in bits.
+const (
+	FloatBits32 = 32
+	FloatBits64 = 64
+)
+
+// ErrUnsupportedFormat is returned when a PCM format cannot be represented by a writer.
+var ErrUnsupportedFormat = errors.New("unsupported PCM format")
+
+// Format describes interleaved little-endian PCM sample data.
+// Integer samples are signed and right-justified in PCMBytesPerSample(BitDepth) bytes,
+// which is the layout produced by GenerateWhiteNoise and FFmpegDecode.
+type Format struct {
+	SampleRate int
+	BitDepth   int
+	Channels   int
+	// Float indicates IEEE 754 samples. BitDepth must then be FloatBits32 or FloatBits64.
+	Float bool
+}
+
+// BytesPerSample returns the container size of a single sample.
+func (f Format) BytesPerSample() int {
+	if f.Float {
+		return f.BitDepth / bitsPerByte
 	}
+
+	return PCMBytesPerSample(f.BitDepth)
+}
+
+// FrameSize returns the size in bytes of one sample across all channels.
+func (f Format) FrameSize() int {
+	return f.BytesPerSample() * f.Channels
+}
+
+// Validate checks that the format is internally consistent.
+func (f Format) Validate() error {
+	if f.SampleRate <= 0 || f.Channels <= 0 {
+		return fmt.Errorf("%w: sample rate %d, channels %d", ErrUnsupportedFormat, f.SampleRate, f.Channels)
+	}
+
+	if f.Float {
+		if f.BitDepth != FloatBits32 && f.BitDepth != FloatBits64 {
+			return fmt.Errorf("%w: %d-bit float", ErrUnsupportedFormat, f.BitDepth)
+		}
+
+		return nil
+	}
+
+	// The WAV, AIFF and FLAC writers all handle 4 to 32-bit integer samples.
+	if f.BitDepth < BitDepth4 || f.BitDepth > BitDepth32 {
+		return fmt.Errorf("%w: %d-bit integer", ErrUnsupportedFormat, f.BitDepth)
+	}
+
+	return nil
+}
+
+// PCMBytesPerSample returns the number of bytes per sample for a given bit depth: the smallest whole
+// number of bytes holding it, e.g. 2 for 10-bit samples.
+func PCMBytesPerSample(bitDepth int) int {
+	return (bitDepth + bitsPerByte - 1) / bitsPerByte
 }
 
 // GenerateWhiteNoise creates deterministic random PCM data at the given format.
@@ -71,12 +120,10 @@ func GenerateWhiteNoise(sampleRate, bitDepth, channels, durationSec int) []byte
 
 	buf := make([]byte, numSamples*bytesPerSample)
 
-	seed := xorshiftSeed
+	rng := newXorshift(xorshiftSeed)
 
 	for sampleIdx := range numSamples {
-		seed ^= seed << xorshiftShiftA
-		seed ^= seed >> xorshiftShiftB
-		seed ^= seed << xorshiftShiftC
+		seed := rng.next()
 
 		offset := sampleIdx * bytesPerSample
 
@@ -133,8 +180,93 @@ func GenerateWhiteNoise(sampleRate, bitDepth, channels, durationSec int) []byte
 	return buf
 }
 
+// pcmSampleAt decodes a signed little-endian integer sample of the given byte width.
+func pcmSampleAt(buf []byte, bytesPerSample int) int32 {
+	switch bytesPerSample {
+	case 1:
+		return int32(int8(buf[0]))
+	case 2:
+		return int32(int16(binary.LittleEndian.Uint16(buf))) //nolint:gosec // G115: reinterpret as signed.
+	case 3:
+		val := int32(buf[0]) | int32(buf[1])<<bitsPerByte | int32(buf[2])<<(2*bitsPerByte)
+
+		return (val << bitsPerByte) >> bitsPerByte // sign-extend from 24 bits
+	default:
+		return int32(binary.LittleEndian.Uint32(buf)) //nolint:gosec // G115: reinterpret as signed.
+	}
+}
+
+// putPCMSample encodes a signed integer sample in little-endian order using the given byte width.
+func putPCMSample(buf []byte, bytesPerSample int, val int32) {
+	switch bytesPerSample {
+	case 1:
+		buf[0] = byte(val)
+	case 2:
+		binary.LittleEndian.PutUint16(buf, uint16(val)) //nolint:gosec // G115: reinterpret cast for LE encoding.
+	case 3:
+		buf[0] = byte(val)
+		buf[1] = byte(val >> bitsPerByte)
+		buf[2] = byte(val >> (2 * bitsPerByte))
+	default:
+		binary.LittleEndian.PutUint32(buf, uint32(val)) //nolint:gosec // G115: reinterpret cast for LE encoding.
+	}
+}
+
+// PCMToFloat converts integer PCM in the given format to IEEE float PCM of floatBits (32 or 64).
+// Samples are scaled so that full scale maps to [-1.0, 1.0).
+func PCMToFloat(pcm []byte, format Format, floatBits int) []byte {
+	bps := PCMBytesPerSample(format.BitDepth)
+	numSamples := len(pcm) / bps
+	scale := math.Ldexp(1, format.BitDepth-1)
+	out := make([]byte, numSamples*(floatBits/bitsPerByte))
+
+	for idx := range numSamples {
+		val := float64(pcmSampleAt(pcm[idx*bps:], bps)) / scale
+
+		if floatBits == FloatBits64 {
+			binary.LittleEndian.PutUint64(out[idx*8:], math.Float64bits(val))
+		} else {
+			binary.LittleEndian.PutUint32(out[idx*4:], math.Float32bits(float32(val)))
+		}
+	}
+
+	return out
+}
+
+// xorshift is Marsaglia's xorshift64 PRNG. It is used instead of math/rand so that
+// generated fixtures are bit-identical across Go versions.
+type xorshift struct {
+	state uint64
+}
+
+// newXorshift returns a PRNG seeded with seed. A zero seed (which would only ever
+// produce zeros) is replaced with xorshiftSeed.
+func newXorshift(seed uint64) *xorshift {
+	if seed == 0 {
+		seed = xorshiftSeed
+	}
+
+	return &xorshift{state: seed}
+}
+
+func (x *xorshift) next() uint64 {
+	x.state ^= x.state << xorshiftShiftA
+	x.state ^= x.state >> xorshiftShiftB
+	x.state ^= x.state << xorshiftShiftC
+
+	return x.state
+}
+
+// float returns a uniformly distributed value in [-1, 1).
+func (x *xorshift) float() float64 {
+	const mantissaBits = 53
+
+	return math.Ldexp(float64(x.next()>>(FloatBits64-mantissaBits)), 1-mantissaBits) - 1
+}
+
 // CompareLosslessSamples requires exact byte match for lossless codecs.
 // The label identifies which comparison is being made (e.g. "saprobe vs ffmpeg").
+// Mismatches are logged as a DiffPCM report; see AssertLosslessPCM for timestamps and WAV dumps.
 func CompareLosslessSamples(t *testing.T, label string, expected, actual []byte, bitDepth, channels int) {
 	t.Helper()
 
@@ -158,7 +290,14 @@ func CompareLosslessSamples(t *testing.T, label string, expected, actual []byte,
 		t.Errorf("%s: PCM mismatch: %d differing bytes (%.2f%%), first diff at byte %d (sample %d)",
 			label, differences, float64(differences)/float64(minLen)*lossyLargeDiffPct, firstDiff, sampleIndex)
 
-		ShowDiffs(t, label, expected, actual, bitDepth, channels, defaultMaxDiffSamples)
+		diff, err := DiffPCM(expected, actual, Format{BitDepth: bitDepth, Channels: channels}, DiffOptions{})
+		if err != nil {
+			ShowDiffs(t, label, expected, actual, bitDepth, channels, defaultMaxDiffSamples)
+
+			return
+		}
+
+		t.Log(label + ": " + diff.String())
 	}
 }
 
@@ -166,6 +305,8 @@ func CompareLosslessSamples(t *testing.T, label string, expected, actual []byte,
 // Different decoders use different floating-point implementations,
 // resulting in +/-1-2 LSB differences per sample.
 // Length differences up to 1 frame (1152 stereo samples) are tolerated.
+// It only supports 16-bit PCM: see ComparePCM and AssertPCMWithin for other formats and codecs.
+// It does not shift streams: see AlignPCM when decoders disagree on encoder delay and priming.
 func CompareLossySamples(t *testing.T, pcmA, pcmB []byte, bitDepth, channels int) {
 	t.Helper()
 
@@ -235,7 +376,7 @@ func CompareLossySamples(t *testing.T, pcmA, pcmB []byte, bitDepth, channels int
 	}
 }
 
-// ShowDiffs prints the first maxDiffs differing frames for debugging.
+// ShowDiffs prints the sample values, per channel, of the first maxDiffs differing frames for debugging.
 func ShowDiffs(t *testing.T, label string, expected, actual []byte, bitDepth, channels, maxDiffs int) {
 	t.Helper()
 
@@ -243,19 +384,30 @@ func ShowDiffs(t *testing.T, label string, expected, actual []byte, bitDepth, ch
 	frameSize := bytesPerSample * channels
 	shown := 0
 
-	for idx := 0; idx < min(len(expected), len(actual))-frameSize && shown < maxDiffs; idx += frameSize {
+	for idx := 0; idx+frameSize <= min(len(expected), len(actual)) && shown < maxDiffs; idx += frameSize {
 		expectedFrame := expected[idx : idx+frameSize]
 		actualFrame := actual[idx : idx+frameSize]
 
 		if !bytes.Equal(expectedFrame, actualFrame) {
 			sampleIdx := idx / frameSize
-			t.Logf("%s: sample %d: expected=%v, actual=%v", label, sampleIdx, expectedFrame, actualFrame)
+			t.Logf("%s: sample %d: expected=%v, actual=%v", label, sampleIdx,
+				frameSamples(expectedFrame, bytesPerSample), frameSamples(actualFrame, bytesPerSample))
 
 			shown++
 		}
 	}
 }
 
+// frameSamples decodes the integer samples of one frame.
+func frameSamples(frame []byte, bytesPerSample int) []int32 {
+	samples := make([]int32, len(frame)/bytesPerSample)
+	for ch := range samples {
+		samples[ch] = pcmSampleAt(frame[ch*bytesPerSample:], bytesPerSample)
+	}
+
+	return samples
+}
+
 // MonoToStereo duplicates mono PCM to stereo by copying each sample to both channels.
 func MonoToStereo(mono []byte, bitDepth int) []byte {
 	bps := PCMBytesPerSample(bitDepth)
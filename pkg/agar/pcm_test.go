@@ -0,0 +1,177 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+func TestPCMBytesPerSample(t *testing.T) {
+	t.Parallel()
+
+	for bitDepth, expected := range map[int]int{
+		4: 1, 6: 1, 8: 1, 10: 2, 12: 2, 16: 2, 18: 3, 20: 3, 24: 3, 28: 4, 32: 4,
+	} {
+		if got := agar.PCMBytesPerSample(bitDepth); got != expected {
+			t.Errorf("PCMBytesPerSample(%d) = %d, expected %d", bitDepth, got, expected)
+		}
+	}
+}
+
+func TestFormatValidateBitDepth(t *testing.T) {
+	t.Parallel()
+
+	for bitDepth := range 40 {
+		err := agar.Format{SampleRate: 48000, BitDepth: bitDepth, Channels: 2}.Validate()
+		if valid := bitDepth >= 4 && bitDepth <= 32; valid != (err == nil) {
+			t.Errorf("%d-bit: got %v", bitDepth, err)
+		}
+
+		if err != nil && !errors.Is(err, agar.ErrUnsupportedFormat) {
+			t.Errorf("%d-bit: %v is not ErrUnsupportedFormat", bitDepth, err)
+		}
+	}
+}
+
+// TestOddBitDepthRoundTrip encodes bit depths that are not a multiple of 8 and reads the samples back.
+func TestOddBitDepthRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, bitDepth := range []int{6, 10, 18, 20} {
+		t.Run(strconv.Itoa(bitDepth)+"-bit", func(t *testing.T) {
+			t.Parallel()
+
+			format := agar.Format{SampleRate: 48000, BitDepth: bitDepth, Channels: 2}
+
+			pcm, err := agar.RenderPCM(format, 50*time.Millisecond,
+				agar.Sine{Frequency: 1000, Level: -1}, agar.Sine{Frequency: 440, Level: -6})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wav, err := agar.EncodeWAV(pcm, format, agar.WAVOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			containerBits := format.BytesPerSample() * 8
+			samples := riffChunk(t, wav[12:], "data", binary.LittleEndian)
+			assertJustifiedSamples(t, "WAV", pcm, bitDepth, samples, containerBits, binary.LittleEndian, bitDepth <= 8)
+
+			aiff, err := agar.EncodeAIFF(pcm, format, agar.AIFFOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			samples = riffChunk(t, aiff[12:], "SSND", binary.BigEndian)[8:]
+			assertJustifiedSamples(t, "AIFF", pcm, bitDepth, samples, containerBits, binary.BigEndian, false)
+
+			if _, err = agar.EncodeFLAC(pcm, format, agar.FLACOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			// FLAC has no native decoder here: ffmpeg left-justifies samples in its output format.
+			if _, err = agar.LookFor("ffmpeg"); err != nil {
+				t.Skip("ffmpeg not found: FLAC samples not decoded")
+			}
+
+			path := filepath.Join(t.TempDir(), "odd.flac")
+			if err = agar.WriteFLAC(path, pcm, format, agar.FLACOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, decodedFormat, err := agar.DecodeFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertJustifiedSamples(t, "FLAC", pcm, bitDepth, decoded, decodedFormat.BitDepth,
+				binary.LittleEndian, false)
+		})
+	}
+}
+
+// riffChunk returns the body of the first chunk with the given ID in an IFF chunk list.
+func riffChunk(t *testing.T, chunks []byte, id string, order binary.ByteOrder) []byte {
+	t.Helper()
+
+	for len(chunks) >= 8 {
+		size := int(order.Uint32(chunks[4:8]))
+		if string(chunks[:4]) == id {
+			return chunks[8 : 8+size]
+		}
+
+		chunks = chunks[8+size+size%2:]
+	}
+
+	t.Fatalf("no %q chunk", id)
+
+	return nil
+}
+
+// assertJustifiedSamples checks that samples holds the samples of pcm, right-justified in
+// PCMBytesPerSample(bitDepth) little-endian bytes, left-justified in containerBits wide containers.
+// Containers are offset binary when offsetBinary is set.
+func assertJustifiedSamples(
+	t *testing.T, label string, pcm []byte, bitDepth int, samples []byte, containerBits int,
+	order binary.ByteOrder, offsetBinary bool,
+) {
+	t.Helper()
+
+	sourceBytes, containerBytes := agar.PCMBytesPerSample(bitDepth), containerBits/8
+	if len(samples)/containerBytes != len(pcm)/sourceBytes {
+		t.Fatalf("%s: %d bytes of samples for %d bytes of PCM", label, len(samples), len(pcm))
+	}
+
+	shift := containerBits - bitDepth
+
+	for idx := range len(pcm) / sourceBytes {
+		expected := readSample(pcm[idx*sourceBytes:], sourceBytes, binary.LittleEndian, false) << shift
+		if got := readSample(samples[idx*containerBytes:], containerBytes, order, offsetBinary); got != expected {
+			t.Fatalf("%s: sample %d is %d, expected %d", label, idx, got, expected)
+		}
+	}
+}
+
+// readSample reads a signed integer sample of width bytes.
+func readSample(buf []byte, width int, order binary.ByteOrder, offsetBinary bool) int64 {
+	var raw [8]byte
+
+	if order == binary.BigEndian {
+		for idx := range width {
+			raw[idx] = buf[width-1-idx]
+		}
+	} else {
+		copy(raw[:], buf[:width])
+	}
+
+	if offsetBinary {
+		raw[width-1] ^= 0x80
+	}
+
+	value := int64(binary.LittleEndian.Uint64(raw[:]))
+	unused := 64 - width*8
+
+	return value << unused >> unused
+}
@@ -0,0 +1,201 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+
+	"github.com/mycophonic/primordium/filesystem"
+)
+
+const (
+	// FourGiB is the largest size representable by the 32-bit RIFF size fields.
+	FourGiB int64 = 1 << 32
+
+	// rf64SizePlaceholder replaces 32-bit sizes whose real value lives in the ds64 chunk.
+	rf64SizePlaceholder = math.MaxUint32
+
+	rf64DS64Size = 28
+
+	// oversizedFrames declares a little over 5 GiB of 16-bit stereo audio.
+	oversizedFrames = 5 * FourGiB / 4 / 4
+)
+
+// ErrDeclaredSizeTooSmall is returned when a declared frame count is smaller than the actual PCM data.
+var ErrDeclaredSizeTooSmall = errors.New("declared frame count smaller than actual data")
+
+// LargeWAVOptions controls RF64 and Wave64 output.
+type LargeWAVOptions struct {
+	WAVOptions
+
+	// DeclaredFrames is the sample frame count written in the headers.
+	// Zero declares the actual frame count of the PCM data. Larger values produce a "virtual"
+	// large file whose headers describe more audio than is written, e.g. sizes beyond FourGiB,
+	// for testing size-overflow handling without giant fixtures.
+	DeclaredFrames int64
+	// Sparse extends the file to the declared size with a hole after the real data, so the file
+	// is self-consistent on disk (the tail reads back as digital silence) while only the real
+	// data occupies disk blocks. Without Sparse the file simply ends after the real data.
+	// Filesystems without sparse file support will allocate the full size.
+	Sparse bool
+}
+
+// WriteRF64 writes pcm to path as an RF64 (EBU Tech 3306) file with a ds64 chunk.
+func WriteRF64(path string, pcm []byte, format Format) error {
+	return WriteRF64WithOptions(path, pcm, format, LargeWAVOptions{})
+}
+
+// WriteRF64WithOptions writes pcm to path as an RF64 file using the given options.
+func WriteRF64WithOptions(path string, pcm []byte, format Format, opts LargeWAVOptions) error {
+	data, dataSize, err := largeWAVData(pcm, format, opts)
+	if err != nil {
+		return err
+	}
+
+	fmtChunk := wavFmtChunk(format, opts.WAVOptions)
+	frames := dataSize / int64(format.FrameSize())
+
+	headerSize := int64(riffHeaderSize + chunkHeaderSize + rf64DS64Size + chunkHeaderSize + len(fmtChunk) +
+		chunkHeaderSize)
+	if format.Float {
+		headerSize += chunkHeaderSize + wavFactSize
+	}
+
+	fileSize := headerSize + dataSize + dataSize%2
+
+	var header bytes.Buffer
+
+	header.WriteString("RF64")
+	writeLE32(&header, rf64SizePlaceholder)
+	header.WriteString("WAVE")
+
+	ds64 := make([]byte, rf64DS64Size)
+	binary.LittleEndian.PutUint64(ds64[0:], uint64(fileSize-chunkHeaderSize)) //nolint:gosec // G115: positive.
+	binary.LittleEndian.PutUint64(ds64[8:], uint64(dataSize))                 //nolint:gosec // G115: positive.
+	binary.LittleEndian.PutUint64(ds64[16:], uint64(frames))                  //nolint:gosec // G115: positive.
+	// ds64 table length (no additional chunk sizes) is left at zero.
+	writeRIFFChunk(&header, "ds64", ds64)
+
+	writeRIFFChunk(&header, "fmt ", fmtChunk)
+
+	if format.Float {
+		fact := make([]byte, wavFactSize)
+		binary.LittleEndian.PutUint32(fact, clampUint32(frames))
+		writeRIFFChunk(&header, "fact", fact)
+	}
+
+	header.WriteString("data")
+	writeLE32(&header, rf64SizePlaceholder)
+
+	return writeLargeFile(path, header.Bytes(), data, fileSize, opts.Sparse, trailingPad(data, dataSize, 2))
+}
+
+// OversizedRF64 returns path to a tiny 16-bit stereo RF64 file whose ds64 chunk declares over 5 GiB of audio.
+// Only one second of white noise is actually present, so readers must cope with a file much shorter than declared.
+func OversizedRF64(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return oversizedRF64().Path(data, helpers)
+}
+
+func oversizedRF64() Generator {
+	format := Format{SampleRate: 44100, BitDepth: BitDepth16, Channels: 2}
+
+	return nativeGenerator("OversizedRF64", "oversized.rf64", func(path string) error {
+		return WriteRF64WithOptions(path, whiteNoisePCM(format, 1), format, LargeWAVOptions{
+			DeclaredFrames: oversizedFrames,
+		})
+	})
+}
+
+// largeWAVData converts pcm to WAVE sample layout and returns it with the declared data size in bytes.
+func largeWAVData(pcm []byte, format Format, opts LargeWAVOptions) ([]byte, int64, error) {
+	data, err := wavSampleData(pcm, format)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	frames := int64(len(pcm) / format.FrameSize())
+
+	if opts.DeclaredFrames != 0 {
+		if opts.DeclaredFrames < frames {
+			return nil, 0, fmt.Errorf("%w: declared %d, actual %d", ErrDeclaredSizeTooSmall, opts.DeclaredFrames, frames)
+		}
+
+		frames = opts.DeclaredFrames
+	}
+
+	return data, frames * int64(format.FrameSize()), nil
+}
+
+// writeLargeFile writes header, data and pad zero bytes to path, then extends the file to fileSize when sparse.
+func writeLargeFile(path string, header, data []byte, fileSize int64, sparse bool, pad int) error {
+	//nolint:gosec // G304: path is caller-provided test output.
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filesystem.FilePermissionsPrivate)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+
+	_, err = file.Write(header)
+	if err == nil {
+		_, err = file.Write(data)
+	}
+
+	if err == nil && pad > 0 {
+		_, err = file.Write(make([]byte, pad))
+	}
+
+	if err == nil && sparse {
+		err = file.Truncate(fileSize)
+	}
+
+	if err != nil {
+		return errors.Join(fmt.Errorf("writing %s: %w", path, err), file.Close())
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// trailingPad returns the number of zero bytes needed to align a complete data chunk to alignment.
+// Virtual (incomplete) data chunks are never padded since the padding would land mid-chunk.
+func trailingPad(data []byte, declaredSize int64, alignment int) int {
+	if int64(len(data)) != declaredSize {
+		return 0
+	}
+
+	return (alignment - len(data)%alignment) % alignment
+}
+
+// clampUint32 returns val, saturated to the 32-bit placeholder when it does not fit.
+func clampUint32(val int64) uint32 {
+	if val >= rf64SizePlaceholder {
+		return rf64SizePlaceholder
+	}
+
+	return uint32(val) //nolint:gosec // G115: bounds checked above.
+}
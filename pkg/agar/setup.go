@@ -17,15 +17,38 @@
 package agar
 
 import (
-	"context"
+	"bytes"
 	"os"
-	"os/exec"
 	"strings"
+	"sync/atomic"
 
 	"github.com/containerd/nerdctl/mod/tigron/test"
 	"github.com/containerd/nerdctl/mod/tigron/tig"
 )
 
+// envWhitelist lists the environment variables passed on to the binary under test. A trailing * matches
+// any suffix.
+//
+//nolint:gochecknoglobals // shared by tigron commands and golden snapshots
+var envWhitelist = []string{
+	"PATH",
+	"HOME",
+	"XDG_*",
+	// Windows
+	"SYSTEMROOT",
+	"SYSTEMDRIVE",
+	"COMSPEC",
+	"TEMP",
+	"TMP",
+	"USERPROFILE",
+	"PATHEXT",
+}
+
+// setupBinary is the binary under test resolved by the last Setup call.
+//
+//nolint:gochecknoglobals // Setup is called once per test binary
+var setupBinary atomic.Pointer[string]
+
 // agarSetup implements test.Testable for hypha CLI testing.
 type agarSetup struct {
 	binary string
@@ -37,19 +60,7 @@ func (hs *agarSetup) CustomCommand(_ *test.Case, _ tig.T) test.CustomizableComma
 	cmd.WithBinary(hs.binary)
 
 	gen := *(cmd.(*test.GenericCommand))
-	gen.WithWhitelist([]string{
-		"PATH",
-		"HOME",
-		"XDG_*",
-		// Windows
-		"SYSTEMROOT",
-		"SYSTEMDRIVE",
-		"COMSPEC",
-		"TEMP",
-		"TMP",
-		"USERPROFILE",
-		"PATHEXT",
-	})
+	gen.WithWhitelist(envWhitelist)
 
 	return &gen
 }
@@ -80,15 +91,19 @@ func requireSoxNG(helper tig.T) {
 		return // already handled by the binary check above
 	}
 
-	//nolint:gosec // soxPath comes from LookFor
-	out, err := exec.CommandContext(
-		context.Background(), soxPath, "--version",
-	).Output()
-	if err != nil {
+	ctx, cancel := ToolContext(helper)
+	defer cancel()
+
+	var out bytes.Buffer
+
+	cmd := newToolCommand(ctx, soxPath, "--version")
+	cmd.Stdout = &out
+
+	if err = runTool(ctx, "requireSoxNG", cmd); err != nil {
 		helper.Skip("sox --version failed: " + err.Error())
 	}
 
-	if !strings.Contains(string(out), "SoX_ng") {
+	if !strings.Contains(out.String(), "SoX_ng") {
 		helper.Skip("sox is not sox_ng (missing DSD support); install with: brew install sox_ng")
 	}
 }
@@ -107,6 +122,7 @@ func Setup(binary string) *test.Case {
 	test.Customize(&agarSetup{
 		binary: path,
 	})
+	setupBinary.Store(&path)
 
 	return &test.Case{
 		Env: map[string]string{},
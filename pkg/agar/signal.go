@@ -0,0 +1,432 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+// Pink and brown noise filter coefficients.
+// Pink noise uses Paul Kellet's refined 7-pole approximation of a -3 dB/octave slope.
+const (
+	pinkNoiseGain  = 0.11
+	brownNoiseStep = 0.02
+	brownNoiseLeak = 1.02
+	brownNoiseGain = 3.5
+	decibelFactor  = 20
+)
+
+// ErrChannelMismatch is returned when the number of signals does not match the channel count.
+var ErrChannelMismatch = errors.New("signal count does not match channel count")
+
+// Signal renders a single channel of audio as float samples, nominally within [-1, 1].
+// Implementations must be deterministic: rendering the same value twice yields identical output.
+type Signal interface {
+	// Render returns numSamples samples at sampleRate.
+	Render(sampleRate, numSamples int) []float64
+}
+
+// Sine is a sine wave.
+type Sine struct {
+	Frequency float64
+	// Level is the peak level in dBFS (0 is full scale).
+	Level float64
+	// Phase is the starting phase in cycles (0.25 starts at the positive peak).
+	Phase float64
+}
+
+// Square is a square wave with a 50% duty cycle.
+type Square struct {
+	Frequency float64
+	Level     float64
+	Phase     float64
+}
+
+// Triangle is a triangle wave.
+type Triangle struct {
+	Frequency float64
+	Level     float64
+	Phase     float64
+}
+
+// Sawtooth is a rising sawtooth wave.
+type Sawtooth struct {
+	Frequency float64
+	Level     float64
+	Phase     float64
+}
+
+// Chirp is a sine sweep from Start to End Hz over the rendered length.
+type Chirp struct {
+	Start float64
+	End   float64
+	Level float64
+	// Logarithmic sweeps at a constant number of octaves per second instead of Hz per second.
+	Logarithmic bool
+}
+
+// WhiteNoise is uniformly distributed noise.
+type WhiteNoise struct {
+	// Seed initializes the PRNG. Zero selects the package default seed.
+	Seed  uint64
+	Level float64
+}
+
+// PinkNoise is noise with a -3 dB/octave spectral slope.
+type PinkNoise struct {
+	Seed  uint64
+	Level float64
+}
+
+// BrownNoise is noise with a -6 dB/octave spectral slope (leaky integrated white noise).
+type BrownNoise struct {
+	Seed  uint64
+	Level float64
+}
+
+// Impulse is a single-sample impulse at Position, optionally repeating every Period samples.
+type Impulse struct {
+	Position int
+	// Period repeats the impulse every Period samples when positive.
+	Period int
+	Level  float64
+}
+
+// Step is zero before Position and a constant Level afterwards.
+type Step struct {
+	Position int
+	Level    float64
+	// Negative makes the step go to the negative rail instead.
+	Negative bool
+}
+
+// Silence is digital silence.
+type Silence struct{}
+
+// Mix sums its signals sample by sample.
+type Mix []Signal
+
+// Segment is a signal played for a fixed duration within a Sequence.
+type Segment struct {
+	Signal   Signal
+	Duration time.Duration
+}
+
+// Sequence plays its segments back to back. Rendering past the end yields silence,
+// rendering less truncates the sequence.
+type Sequence []Segment
+
+// DBToAmplitude converts a level in dBFS to a linear amplitude (0 dBFS = 1.0).
+func DBToAmplitude(db float64) float64 {
+	return math.Pow(10, db/decibelFactor)
+}
+
+// AmplitudeToDB converts a linear amplitude to dBFS. Zero returns negative infinity.
+func AmplitudeToDB(amplitude float64) float64 {
+	return decibelFactor * math.Log10(amplitude)
+}
+
+// SamplesFor returns the number of samples covering duration at sampleRate, rounded down.
+func SamplesFor(duration time.Duration, sampleRate int) int {
+	return int(int64(duration) * int64(sampleRate) / int64(time.Second))
+}
+
+// Render implements Signal.
+func (s Sine) Render(sampleRate, numSamples int) []float64 {
+	return renderPeriodic(sampleRate, numSamples, s.Frequency, s.Phase, s.Level, func(cycle float64) float64 {
+		return math.Sin(2 * math.Pi * cycle)
+	})
+}
+
+// Render implements Signal.
+func (s Square) Render(sampleRate, numSamples int) []float64 {
+	return renderPeriodic(sampleRate, numSamples, s.Frequency, s.Phase, s.Level, func(cycle float64) float64 {
+		if cycle < 0.5 {
+			return 1
+		}
+
+		return -1
+	})
+}
+
+// Render implements Signal.
+func (s Triangle) Render(sampleRate, numSamples int) []float64 {
+	return renderPeriodic(sampleRate, numSamples, s.Frequency, s.Phase, s.Level, func(cycle float64) float64 {
+		// Starts at zero rising, peaks at a quarter cycle, like Sine.
+		return 1 - 4*math.Abs(math.Mod(cycle+0.25, 1)-0.5)
+	})
+}
+
+// Render implements Signal.
+func (s Sawtooth) Render(sampleRate, numSamples int) []float64 {
+	return renderPeriodic(sampleRate, numSamples, s.Frequency, s.Phase, s.Level, func(cycle float64) float64 {
+		return 2*math.Mod(cycle+0.5, 1) - 1
+	})
+}
+
+// Render implements Signal.
+func (s Chirp) Render(sampleRate, numSamples int) []float64 {
+	out := make([]float64, numSamples)
+	amplitude := DBToAmplitude(s.Level)
+	length := float64(numSamples) / float64(sampleRate)
+
+	logarithmic := s.Logarithmic && s.Start > 0 && s.End > 0 && s.Start != s.End
+	ratio := s.End / s.Start
+
+	for idx := range out {
+		elapsed := float64(idx) / float64(sampleRate)
+
+		var cycles float64
+
+		if logarithmic {
+			cycles = s.Start * length / math.Log(ratio) * (math.Pow(ratio, elapsed/length) - 1)
+		} else {
+			cycles = s.Start*elapsed + (s.End-s.Start)*elapsed*elapsed/(2*length)
+		}
+
+		out[idx] = amplitude * math.Sin(2*math.Pi*math.Mod(cycles, 1))
+	}
+
+	return out
+}
+
+// Render implements Signal.
+func (s WhiteNoise) Render(_, numSamples int) []float64 {
+	out := make([]float64, numSamples)
+	amplitude := DBToAmplitude(s.Level)
+	rng := newXorshift(s.Seed)
+
+	for idx := range out {
+		out[idx] = amplitude * rng.float()
+	}
+
+	return out
+}
+
+// Render implements Signal.
+func (s PinkNoise) Render(_, numSamples int) []float64 {
+	out := make([]float64, numSamples)
+	amplitude := DBToAmplitude(s.Level) * pinkNoiseGain
+	rng := newXorshift(s.Seed)
+
+	var b0, b1, b2, b3, b4, b5, b6 float64
+
+	for idx := range out {
+		white := rng.float()
+
+		b0 = 0.99886*b0 + white*0.0555179
+		b1 = 0.99332*b1 + white*0.0750759
+		b2 = 0.96900*b2 + white*0.1538520
+		b3 = 0.86650*b3 + white*0.3104856
+		b4 = 0.55000*b4 + white*0.5329522
+		b5 = -0.7616*b5 - white*0.0168980
+
+		out[idx] = amplitude * (b0 + b1 + b2 + b3 + b4 + b5 + b6 + white*0.5362)
+
+		b6 = white * 0.115926
+	}
+
+	return out
+}
+
+// Render implements Signal.
+func (s BrownNoise) Render(_, numSamples int) []float64 {
+	out := make([]float64, numSamples)
+	amplitude := DBToAmplitude(s.Level) * brownNoiseGain
+	rng := newXorshift(s.Seed)
+
+	var state float64
+
+	for idx := range out {
+		state = (state + brownNoiseStep*rng.float()) / brownNoiseLeak
+		out[idx] = amplitude * state
+	}
+
+	return out
+}
+
+// Render implements Signal.
+func (s Impulse) Render(_, numSamples int) []float64 {
+	out := make([]float64, numSamples)
+	amplitude := DBToAmplitude(s.Level)
+
+	for pos := s.Position; pos >= 0 && pos < numSamples; pos += s.Period {
+		out[pos] = amplitude
+
+		if s.Period <= 0 {
+			break
+		}
+	}
+
+	return out
+}
+
+// Render implements Signal.
+func (s Step) Render(_, numSamples int) []float64 {
+	out := make([]float64, numSamples)
+
+	amplitude := DBToAmplitude(s.Level)
+	if s.Negative {
+		amplitude = -amplitude
+	}
+
+	for idx := max(s.Position, 0); idx < numSamples; idx++ {
+		out[idx] = amplitude
+	}
+
+	return out
+}
+
+// Render implements Signal.
+func (Silence) Render(_, numSamples int) []float64 {
+	return make([]float64, numSamples)
+}
+
+// Render implements Signal.
+func (m Mix) Render(sampleRate, numSamples int) []float64 {
+	out := make([]float64, numSamples)
+
+	for _, signal := range m {
+		for idx, val := range signal.Render(sampleRate, numSamples) {
+			out[idx] += val
+		}
+	}
+
+	return out
+}
+
+// Render implements Signal.
+func (s Sequence) Render(sampleRate, numSamples int) []float64 {
+	out := make([]float64, 0, numSamples)
+
+	for _, segment := range s {
+		length := min(SamplesFor(segment.Duration, sampleRate), numSamples-len(out))
+		out = append(out, segment.Signal.Render(sampleRate, length)...)
+	}
+
+	return append(out, make([]float64, numSamples-len(out))...)
+}
+
+// RenderPCM renders signals to interleaved PCM in format for the given duration.
+// A single signal is copied to every channel, otherwise one signal per channel is required.
+// Integer output is rounded and clipped to the format's range without dither, so identical
+// inputs always produce identical bytes.
+func RenderPCM(format Format, duration time.Duration, signals ...Signal) ([]byte, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(signals) != 1 && len(signals) != format.Channels {
+		return nil, fmt.Errorf("%w: %d signals for %d channels", ErrChannelMismatch, len(signals), format.Channels)
+	}
+
+	numSamples := SamplesFor(duration, format.SampleRate)
+	channels := make([][]float64, len(signals))
+
+	for idx, signal := range signals {
+		channels[idx] = signal.Render(format.SampleRate, numSamples)
+	}
+
+	bps := format.BytesPerSample()
+	out := make([]byte, numSamples*format.FrameSize())
+
+	for sample := range numSamples {
+		for channel := range format.Channels {
+			val := channels[min(channel, len(channels)-1)][sample]
+			putFloatSample(out[(sample*format.Channels+channel)*bps:], format, val)
+		}
+	}
+
+	return out, nil
+}
+
+// SignalWAV returns path to a WAV file named name rendered from signals (see RenderPCM), written without ffmpeg.
+func SignalWAV(
+	data test.Data,
+	helpers test.Helpers,
+	name string,
+	format Format,
+	duration time.Duration,
+	signals ...Signal,
+) string {
+	helpers.T().Helper()
+
+	return SignalWAVGenerator(name, format, duration, signals...).Path(data, helpers)
+}
+
+// SignalWAVGenerator returns the generator of SignalWAV. Signals are deterministic, so the fixture cache
+// keys them by value.
+func SignalWAVGenerator(name string, format Format, duration time.Duration, signals ...Signal) Generator {
+	return nativeGenerator("SignalWAV", name+".wav", func(path string) error {
+		pcm, err := RenderPCM(format, duration, signals...)
+		if err != nil {
+			return err
+		}
+
+		return WriteWAV(path, pcm, format)
+	}, fmt.Sprintf("%+v", format), duration.String(), fmt.Sprintf("%#v", signals))
+}
+
+// QuantizeSample converts a float sample to a signed integer at bitDepth, rounding to nearest and clipping.
+func QuantizeSample(val float64, bitDepth int) int32 {
+	scale := math.Ldexp(1, bitDepth-1)
+	scaled := math.Round(val * scale)
+
+	switch {
+	case scaled >= scale:
+		return int32(scale - 1)
+	case scaled < -scale:
+		return int32(-scale)
+	default:
+		return int32(scaled)
+	}
+}
+
+// putFloatSample writes val to buf in format's sample encoding.
+func putFloatSample(buf []byte, format Format, val float64) {
+	switch {
+	case format.Float && format.BitDepth == FloatBits64:
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(val))
+	case format.Float:
+		binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(val)))
+	default:
+		putPCMSample(buf, format.BytesPerSample(), QuantizeSample(val, format.BitDepth))
+	}
+}
+
+// renderPeriodic renders a periodic waveform; shape maps a position within the cycle ([0, 1)) to [-1, 1].
+func renderPeriodic(
+	sampleRate, numSamples int,
+	frequency, phase, level float64,
+	shape func(cycle float64) float64,
+) []float64 {
+	out := make([]float64, numSamples)
+	amplitude := DBToAmplitude(level)
+
+	for idx := range out {
+		cycles := frequency*float64(idx)/float64(sampleRate) + phase
+		out[idx] = amplitude * shape(cycles-math.Floor(cycles))
+	}
+
+	return out
+}
@@ -0,0 +1,335 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// Spectrum analysis parameters.
+const (
+	// DefaultFFTSize is the FFT size used when SpectrumOptions.Size is zero.
+	DefaultFFTSize = 8192
+
+	// spectrumSmoothingBins is the number of bins on either side averaged by Cutoff.
+	spectrumSmoothingBins = 8
+	// cutoffReferenceLow and cutoffReferenceHigh bound the band Cutoff measures levels against, in Hz.
+	cutoffReferenceLow  = 1000
+	cutoffReferenceHigh = 4000
+	// peakNeighborhood is the number of bins on either side whose median is the floor of a peak.
+	peakNeighborhood = 32
+)
+
+// ErrFFTSize is returned when the FFT size is not a power of two.
+var ErrFFTSize = errors.New("FFT size must be a power of two")
+
+// SpectrumOptions controls PowerSpectrum.
+type SpectrumOptions struct {
+	// Size is the FFT size, a power of two. Zero selects DefaultFFTSize.
+	Size int
+	// Channels selects the channels to average. Empty averages every channel.
+	Channels []int
+}
+
+// Spectrum is an averaged one-sided power spectrum. Power is normalized so that a sine centered on a bin
+// reads its mean square there (-3.01 dB for a full-scale sine).
+type Spectrum struct {
+	SampleRate int
+	// BinWidth is the frequency resolution in Hz.
+	BinWidth float64
+	// Power holds the linear power of bins 0 (DC) to Size/2 (Nyquist).
+	Power []float64
+}
+
+// SpectralPeak is a local maximum of a spectrum.
+type SpectralPeak struct {
+	// Frequency is refined by parabolic interpolation between bins.
+	Frequency float64
+	// Level is the bin level in dB.
+	Level float64
+	// Prominence is the level above the median of the neighboring bins, in dB.
+	Prominence float64
+}
+
+// PowerSpectrum computes the Welch power spectrum of interleaved PCM: Hann-windowed frames of
+// opts.Size samples, overlapping by half, averaged over frames and channels. Audio shorter than one
+// frame is zero-padded.
+func PowerSpectrum(pcm []byte, format Format, opts SpectrumOptions) (*Spectrum, error) {
+	size := opts.Size
+	if size == 0 {
+		size = DefaultFFTSize
+	}
+
+	if size < 2 || bits.OnesCount(uint(size)) != 1 {
+		return nil, fmt.Errorf("%w: %d", ErrFFTSize, size)
+	}
+
+	channels, err := pcmChannels(pcm, format)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := opts.Channels
+	if len(selected) == 0 {
+		for ch := range channels {
+			selected = append(selected, ch)
+		}
+	}
+
+	window := make([]float64, size)
+
+	var windowSum float64
+
+	for idx := range window {
+		window[idx] = hannHalf - hannHalf*math.Cos(2*math.Pi*float64(idx)/float64(size))
+		windowSum += window[idx]
+	}
+
+	spectrum := &Spectrum{
+		SampleRate: format.SampleRate,
+		BinWidth:   float64(format.SampleRate) / float64(size),
+		Power:      make([]float64, size/2+1),
+	}
+
+	reals := make([]float64, size)
+	imags := make([]float64, size)
+	frames := 0
+
+	for _, ch := range selected {
+		if ch < 0 || ch >= len(channels) {
+			return nil, fmt.Errorf("%w: channel %d of %d", ErrUnsupportedFormat, ch, len(channels))
+		}
+
+		samples := channels[ch]
+
+		for start := 0; start == 0 || start+size <= len(samples); start += size / 2 {
+			for idx := range reals {
+				reals[idx], imags[idx] = 0, 0
+				if start+idx < len(samples) {
+					reals[idx] = samples[start+idx] * window[idx]
+				}
+			}
+
+			fft(reals, imags)
+
+			for bin := range spectrum.Power {
+				power := (reals[bin]*reals[bin] + imags[bin]*imags[bin]) / (windowSum * windowSum)
+				if bin > 0 && bin < size/2 {
+					power *= 2
+				}
+
+				spectrum.Power[bin] += power
+			}
+
+			frames++
+		}
+	}
+
+	for bin := range spectrum.Power {
+		spectrum.Power[bin] /= float64(frames)
+	}
+
+	return spectrum, nil
+}
+
+// PowerSpectrumFile decodes an audio file with DecodeFile and computes its spectrum with PowerSpectrum.
+func PowerSpectrumFile(path string, opts SpectrumOptions) (*Spectrum, error) {
+	pcm, format, err := DecodeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return PowerSpectrum(pcm, format, opts)
+}
+
+// Frequency returns the center frequency of a bin.
+func (s *Spectrum) Frequency(bin int) float64 {
+	return float64(bin) * s.BinWidth
+}
+
+// Bin returns the bin nearest to a frequency, clamped to the spectrum.
+func (s *Spectrum) Bin(frequency float64) int {
+	return min(max(0, int(math.Round(frequency/s.BinWidth))), len(s.Power)-1)
+}
+
+// Level returns the level of a bin in dB.
+func (s *Spectrum) Level(bin int) float64 {
+	return powerToDB(s.Power[bin])
+}
+
+// BandEnergy returns the total power of the bins from low to high Hz, inclusive.
+func (s *Spectrum) BandEnergy(low, high float64) float64 {
+	var energy float64
+	for bin := s.Bin(low); bin <= s.Bin(high); bin++ {
+		energy += s.Power[bin]
+	}
+
+	return energy
+}
+
+// BandEnergyRatio returns, in dB, the power per Hz of the band from low to high over that of the
+// reference band, so that white noise reads 0 dB whatever the band widths. It returns +Inf when the
+// reference band holds no energy, such as in digital silence.
+func (s *Spectrum) BandEnergyRatio(low, high, referenceLow, referenceHigh float64) float64 {
+	reference := s.bandDensity(referenceLow, referenceHigh)
+	if reference == 0 {
+		return math.Inf(1)
+	}
+
+	return powerToDB(s.bandDensity(low, high) / reference)
+}
+
+// Cutoff returns the highest frequency whose level, averaged over neighboring bins, is at most dropDB
+// below the average level of the 1-4 kHz band. Band-limited material (lossy transcodes, upsampled or
+// lowpassed audio) falls far below that level past its cutoff, while full-band noise stays within a few
+// tens of dB up to Nyquist. It returns zero when no bin qualifies, or when the 1-4 kHz band holds no
+// energy, such as in digital silence.
+func (s *Spectrum) Cutoff(dropDB float64) float64 {
+	reference := s.bandDensity(cutoffReferenceLow, cutoffReferenceHigh)
+	if reference == 0 {
+		return 0
+	}
+
+	smoothed := s.smoothed()
+	threshold := powerToDB(reference) - dropDB
+
+	for bin := len(smoothed) - 1; bin >= 0; bin-- {
+		if powerToDB(smoothed[bin]) >= threshold {
+			return s.Frequency(bin)
+		}
+	}
+
+	return 0
+}
+
+// Peaks returns up to count local maxima standing at least minProminence dB above the median level of
+// their neighborhood, loudest first. A count of zero returns every peak.
+func (s *Spectrum) Peaks(count int, minProminence float64) []SpectralPeak {
+	var peaks []SpectralPeak
+
+	for bin := 1; bin < len(s.Power)-1; bin++ {
+		if s.Power[bin] <= s.Power[bin-1] || s.Power[bin] < s.Power[bin+1] || s.Power[bin] == 0 {
+			continue
+		}
+
+		neighborhood := slices.Clone(s.Power[max(0, bin-peakNeighborhood):min(len(s.Power), bin+peakNeighborhood+1)])
+		slices.Sort(neighborhood)
+
+		prominence := s.Level(bin) - powerToDB(neighborhood[len(neighborhood)/2])
+		if prominence < minProminence {
+			continue
+		}
+
+		// Parabolic interpolation over the log magnitudes of the peak and its neighbors.
+		left, center, right := s.Level(bin-1), s.Level(bin), s.Level(bin+1)
+		offset := 0.0
+
+		if denominator := left - 2*center + right; denominator != 0 && !math.IsInf(denominator, 0) {
+			offset = (left - right) / (2 * denominator)
+		}
+
+		peaks = append(peaks, SpectralPeak{
+			Frequency:  (float64(bin) + offset) * s.BinWidth,
+			Level:      center,
+			Prominence: prominence,
+		})
+	}
+
+	slices.SortFunc(peaks, func(a, b SpectralPeak) int {
+		return cmp.Compare(b.Level, a.Level)
+	})
+
+	if count > 0 {
+		peaks = peaks[:min(count, len(peaks))]
+	}
+
+	return peaks
+}
+
+// bandDensity returns the mean power of the bins from low to high Hz.
+func (s *Spectrum) bandDensity(low, high float64) float64 {
+	return s.BandEnergy(low, high) / float64(s.Bin(high)-s.Bin(low)+1)
+}
+
+// smoothed returns the power spectrum averaged over spectrumSmoothingBins on either side.
+func (s *Spectrum) smoothed() []float64 {
+	out := make([]float64, len(s.Power))
+
+	for bin := range out {
+		low, high := max(0, bin-spectrumSmoothingBins), min(len(s.Power)-1, bin+spectrumSmoothingBins)
+
+		var sum float64
+		for _, power := range s.Power[low : high+1] {
+			sum += power
+		}
+
+		out[bin] = sum / float64(high-low+1)
+	}
+
+	return out
+}
+
+// powerToDB converts a power ratio to dB.
+func powerToDB(power float64) float64 {
+	return decibelFactor / 2 * math.Log10(power)
+}
+
+// fft computes an in-place iterative radix-2 FFT. The length must be a power of two.
+func fft(reals, imags []float64) {
+	size := len(reals)
+
+	// Bit-reversal permutation.
+	for idx, rev := 1, 0; idx < size; idx++ {
+		bit := size >> 1
+		for ; rev&bit != 0; bit >>= 1 {
+			rev ^= bit
+		}
+
+		rev ^= bit
+
+		if idx < rev {
+			reals[idx], reals[rev] = reals[rev], reals[idx]
+			imags[idx], imags[rev] = imags[rev], imags[idx]
+		}
+	}
+
+	for length := 2; length <= size; length <<= 1 {
+		angle := -2 * math.Pi / float64(length)
+		stepReal, stepImag := math.Cos(angle), math.Sin(angle)
+
+		for start := 0; start < size; start += length {
+			twiddleReal, twiddleImag := 1.0, 0.0
+
+			for offset := range length / 2 {
+				even, odd := start+offset, start+offset+length/2
+				oddReal := reals[odd]*twiddleReal - imags[odd]*twiddleImag
+				oddImag := reals[odd]*twiddleImag + imags[odd]*twiddleReal
+
+				reals[odd], imags[odd] = reals[even]-oddReal, imags[even]-oddImag
+				reals[even], imags[even] = reals[even]+oddReal, imags[even]+oddImag
+
+				twiddleReal, twiddleImag = twiddleReal*stepReal-twiddleImag*stepImag,
+					twiddleReal*stepImag+twiddleImag*stepReal
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+func TestSpectrumSilence(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 48000, BitDepth: 24, Channels: 2}
+
+	pcm, err := agar.RenderPCM(format, time.Second, agar.Silence{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spectrum, err := agar.PowerSpectrum(pcm, format, agar.SpectrumOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cutoff := spectrum.Cutoff(40); cutoff != 0 {
+		t.Errorf("silence has a cutoff at %.0f Hz, expected 0", cutoff)
+	}
+
+	if ratio := spectrum.BandEnergyRatio(14000, 18000, 1000, 10000); !math.IsInf(ratio, 1) {
+		t.Errorf("silence band ratio is %v, expected +Inf", ratio)
+	}
+}
+
+func TestSpectrumBandLimitedNoise(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 48000, BitDepth: 24, Channels: 1}
+
+	// Tones every 25 Hz up to 8 kHz, with scattered phases, stand for noise lowpassed at 8 kHz.
+	var tones agar.Mix
+	for frequency := 100.0; frequency <= 8000; frequency += 25 {
+		tones = append(tones, agar.Sine{Frequency: frequency, Level: -60, Phase: math.Mod(frequency*0.618, 1)})
+	}
+
+	for _, tt := range []struct {
+		name       string
+		signal     agar.Signal
+		cutoffLow  float64
+		cutoffHigh float64
+		ratioLow   float64
+		ratioHigh  float64
+	}{
+		{"white", agar.WhiteNoise{Level: -6}, 23000, 24000, -1, 1},
+		{"lowpassed", tones, 7900, 8100, math.Inf(-1), -60},
+	} {
+		pcm, err := agar.RenderPCM(format, 2*time.Second, tt.signal)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		spectrum, err := agar.PowerSpectrum(pcm, format, agar.SpectrumOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cutoff := spectrum.Cutoff(40); cutoff < tt.cutoffLow || cutoff > tt.cutoffHigh {
+			t.Errorf("%s: cutoff at %.0f Hz, expected %.0f to %.0f Hz", tt.name, cutoff, tt.cutoffLow, tt.cutoffHigh)
+		}
+
+		ratio := spectrum.BandEnergyRatio(14000, 18000, 1000, 7000)
+		if ratio < tt.ratioLow || ratio > tt.ratioHigh {
+			t.Errorf("%s: 14-18 kHz band at %.2f dB, expected %.2f to %.2f dB",
+				tt.name, ratio, tt.ratioLow, tt.ratioHigh)
+		}
+	}
+}
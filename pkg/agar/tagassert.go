@@ -0,0 +1,288 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// TagDiffKind classifies a difference between expected and actual tags.
+type TagDiffKind string
+
+// Tag difference kinds.
+const (
+	// TagMissing is an expected key absent from the actual tags.
+	TagMissing TagDiffKind = "missing"
+	// TagExtra is an actual key that was not expected.
+	TagExtra TagDiffKind = "extra"
+	// TagMismatch is a key whose values differ.
+	TagMismatch TagDiffKind = "mismatch"
+	// TagOrder is a key holding the expected values in another order.
+	TagOrder TagDiffKind = "order"
+)
+
+// Pseudo-keys of the values compared outside Text.
+const (
+	tagPicturesKey = "(pictures)"
+	tagValueSep    = " | "
+	tagAbsent      = "-"
+)
+
+// TagAssertOptions controls how DiffTags and AssertTags compare tags.
+// Keys are always compared case-insensitively, and track and disc pairs by number and total,
+// so that "3/6", "3 of 6" and separate TRACKTOTAL values are equivalent.
+type TagAssertOptions struct {
+	// IgnoreKeys lists semantic keys excluded from the comparison, typically keys a container or tool
+	// adds on its own (e.g. "encoder"). Ignoring "tracktotal" or "tracknumber" (and their disc
+	// counterparts) compares the other half of the pair only.
+	IgnoreKeys []string
+	// IgnoreExtra accepts actual keys that were not expected.
+	IgnoreExtra bool
+	// IgnoreOrder compares the values of a key as a multiset.
+	IgnoreOrder bool
+	// IgnoreCase compares values case-insensitively.
+	IgnoreCase bool
+	// IgnorePictures skips the picture count.
+	IgnorePictures bool
+}
+
+// TagDifference is one difference between expected and actual tags.
+type TagDifference struct {
+	Key      string
+	Kind     TagDiffKind
+	Expected []string
+	Actual   []string
+}
+
+// TagDiff lists the differences between expected and actual tags, sorted by key.
+type TagDiff []TagDifference
+
+// String renders the differences as an aligned table, multiple values separated by " | ".
+func (diff TagDiff) String() string {
+	var builder strings.Builder
+
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0) //nolint:mnd // two spaces between columns.
+	_, _ = writer.Write([]byte("KEY\tDIFF\tEXPECTED\tACTUAL\n"))
+
+	render := func(values []string) string {
+		if values == nil {
+			return tagAbsent
+		}
+
+		return strconv.Quote(strings.Join(values, tagValueSep))
+	}
+
+	for _, difference := range diff {
+		_, _ = writer.Write([]byte(difference.Key + "\t" + string(difference.Kind) + "\t" +
+			render(difference.Expected) + "\t" + render(difference.Actual) + "\n"))
+	}
+
+	_ = writer.Flush()
+
+	return builder.String()
+}
+
+// DiffTags compares actual tags, as read by a parser, against expected metadata.
+func DiffTags(expected Tags, actual *ParsedTags, opts TagAssertOptions) TagDiff {
+	ignored := make(map[string]bool, len(opts.IgnoreKeys))
+	for _, key := range opts.IgnoreKeys {
+		ignored[strings.ToLower(key)] = true
+	}
+
+	want := normalizeTagText(expected.Text)
+	got := normalizeTagText(actual.Text)
+
+	// Track and disc pairs are compared as "N/M", whichever way each side stores them. Ignoring the
+	// number or the total key leaves the other half compared, the total alone under its own key.
+	for _, pair := range []struct {
+		key, totalKey, altTotalKey string
+		number, total              int
+		actualNumber, actualTotal  int
+	}{
+		{
+			"tracknumber", "tracktotal", "totaltracks",
+			expected.Track, expected.TrackTotal, actual.Track, actual.TrackTotal,
+		},
+		{
+			"discnumber", "disctotal", "totaldiscs",
+			expected.Disc, expected.DiscTotal, actual.Disc, actual.DiscTotal,
+		},
+	} {
+		wantNumber, wantTotal := tagPair(want, pair.key, pair.totalKey, pair.altTotalKey, pair.number, pair.total)
+		gotNumber, gotTotal := tagPair(got, pair.key, pair.totalKey, pair.altTotalKey,
+			pair.actualNumber, pair.actualTotal)
+
+		for _, key := range []string{pair.key, pair.totalKey, pair.altTotalKey} {
+			delete(want, key)
+			delete(got, key)
+		}
+
+		if ignored[pair.totalKey] || ignored[pair.altTotalKey] {
+			wantTotal, gotTotal = 0, 0
+		}
+
+		key, format := pair.key, formatPairValue
+		if ignored[pair.key] {
+			key, format = pair.totalKey, func(_, total int) string { return strconv.Itoa(total) }
+			wantNumber, gotNumber = 0, 0
+		}
+
+		if wantNumber != 0 || wantTotal != 0 {
+			want[key] = []string{format(wantNumber, wantTotal)}
+		}
+
+		if gotNumber != 0 || gotTotal != 0 {
+			got[key] = []string{format(gotNumber, gotTotal)}
+		}
+	}
+
+	if !opts.IgnorePictures && (len(expected.Pictures) > 0 || actual.PictureCount > 0) {
+		want[tagPicturesKey] = []string{strconv.Itoa(len(expected.Pictures))}
+		got[tagPicturesKey] = []string{strconv.Itoa(actual.PictureCount)}
+	}
+
+	var diff TagDiff
+
+	keys := slices.Sorted(maps.Keys(want))
+	for key := range got {
+		if _, ok := want[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if ignored[key] {
+			continue
+		}
+
+		wantValues, wantOK := want[key]
+		gotValues, gotOK := got[key]
+
+		switch {
+		case !gotOK:
+			diff = append(diff, TagDifference{Key: key, Kind: TagMissing, Expected: wantValues})
+		case !wantOK:
+			if !opts.IgnoreExtra {
+				diff = append(diff, TagDifference{Key: key, Kind: TagExtra, Actual: gotValues})
+			}
+		default:
+			if kind, ok := compareTagValues(wantValues, gotValues, opts); !ok {
+				diff = append(diff, TagDifference{Key: key, Kind: kind, Expected: wantValues, Actual: gotValues})
+			}
+		}
+	}
+
+	return diff
+}
+
+// AssertTags fails the test, logging a table of the differences, when actual tags differ from expected.
+func AssertTags(t tig.T, expected Tags, actual *ParsedTags, opts TagAssertOptions) {
+	t.Helper()
+
+	if diff := DiffTags(expected, actual, opts); len(diff) > 0 {
+		t.Log("tags differ from expectations:\n" + diff.String())
+		t.FailNow()
+	}
+}
+
+// AssertFileTags reads the tags of path with ReadTags and asserts them with AssertTags.
+func AssertFileTags(t tig.T, path string, expected Tags, opts TagAssertOptions) {
+	t.Helper()
+
+	actual, err := ReadTags(path)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	AssertTags(t, expected, actual, opts)
+}
+
+// normalizeTagText lowercases keys, merging the values of keys differing only by case, and drops
+// keys without values.
+func normalizeTagText(text map[string][]string) map[string][]string {
+	normalized := make(map[string][]string, len(text))
+
+	for _, key := range slices.Sorted(maps.Keys(text)) {
+		if len(text[key]) > 0 {
+			lower := strings.ToLower(key)
+			normalized[lower] = append(normalized[lower], text[key]...)
+		}
+	}
+
+	return normalized
+}
+
+// tagPair returns the number and total of a track or disc pair, taking integer fields first and
+// falling back to text values.
+func tagPair(text map[string][]string, key, totalKey, altTotalKey string, number, total int) (int, int) {
+	if values := text[key]; len(values) > 0 {
+		textNumber, textTotal := parsePairValue(values[0])
+		if number == 0 {
+			number = textNumber
+		}
+
+		if total == 0 {
+			total = textTotal
+		}
+	}
+
+	for _, candidate := range []string{totalKey, altTotalKey} {
+		if values := text[candidate]; total == 0 && len(values) > 0 {
+			total, _ = strconv.Atoi(strings.TrimSpace(values[0]))
+		}
+	}
+
+	return number, total
+}
+
+// compareTagValues reports whether values match, and the kind of difference otherwise.
+func compareTagValues(want, got []string, opts TagAssertOptions) (TagDiffKind, bool) {
+	normalize := func(values []string) []string {
+		out := slices.Clone(values)
+
+		if opts.IgnoreCase {
+			for idx := range out {
+				out[idx] = strings.ToLower(out[idx])
+			}
+		}
+
+		return out
+	}
+
+	wantNorm, gotNorm := normalize(want), normalize(got)
+	if slices.Equal(wantNorm, gotNorm) {
+		return "", true
+	}
+
+	slices.Sort(wantNorm)
+	slices.Sort(gotNorm)
+
+	if !slices.Equal(wantNorm, gotNorm) {
+		return TagMismatch, false
+	}
+
+	return TagOrder, opts.IgnoreOrder
+}
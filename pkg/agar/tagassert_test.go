@@ -0,0 +1,200 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+func TestDiffTags(t *testing.T) {
+	t.Parallel()
+
+	expected := agar.Tags{
+		Text:  map[string][]string{"title": {"Title"}, "artist": {"A", "B"}},
+		Track: 3, TrackTotal: 6, Disc: 1, DiscTotal: 2,
+	}
+
+	for _, tc := range []struct {
+		name     string
+		actual   agar.ParsedTags
+		opts     agar.TagAssertOptions
+		expected agar.TagDiff
+	}{
+		{
+			name: "normalized keys and pairs",
+			actual: agar.ParsedTags{Text: map[string][]string{
+				"TITLE": {"Title"}, "ARTIST": {"A"}, "artist": {"B"}, "tracknumber": {"3 of 6"},
+				"discnumber": {"1"}, "totaldiscs": {"2"}, "empty": {},
+			}},
+		},
+		{
+			name: "integer pairs",
+			actual: agar.ParsedTags{
+				Text:  map[string][]string{"title": {"Title"}, "artist": {"A", "B"}},
+				Track: 3, TrackTotal: 6, Disc: 1, DiscTotal: 2,
+			},
+		},
+		{
+			name: "differences",
+			actual: agar.ParsedTags{
+				Text:  map[string][]string{"artist": {"B", "A"}, "encoder": {"lavf"}},
+				Track: 3, TrackTotal: 7, Disc: 1, DiscTotal: 2, PictureCount: 1,
+			},
+			expected: agar.TagDiff{
+				{Key: "(pictures)", Kind: agar.TagMismatch, Expected: []string{"0"}, Actual: []string{"1"}},
+				{Key: "artist", Kind: agar.TagOrder, Expected: []string{"A", "B"}, Actual: []string{"B", "A"}},
+				{Key: "encoder", Kind: agar.TagExtra, Actual: []string{"lavf"}},
+				{Key: "title", Kind: agar.TagMissing, Expected: []string{"Title"}},
+				{Key: "tracknumber", Kind: agar.TagMismatch, Expected: []string{"3/6"}, Actual: []string{"3/7"}},
+			},
+		},
+		{
+			name: "lenient options",
+			actual: agar.ParsedTags{
+				Text:  map[string][]string{"title": {"TITLE"}, "artist": {"B", "A"}, "encoder": {"lavf"}, "x": {"y"}},
+				Track: 3, TrackTotal: 6, Disc: 1, DiscTotal: 2, PictureCount: 1,
+			},
+			opts: agar.TagAssertOptions{
+				IgnoreKeys: []string{"Encoder"}, IgnoreExtra: true, IgnoreOrder: true, IgnoreCase: true,
+				IgnorePictures: true,
+			},
+		},
+		{
+			name: "ignored totals",
+			actual: agar.ParsedTags{
+				Text:  map[string][]string{"title": {"Title"}, "artist": {"A", "B"}},
+				Track: 4, TrackTotal: 7, Disc: 1, DiscTotal: 3,
+			},
+			opts: agar.TagAssertOptions{IgnoreKeys: []string{"tracktotal", "totaldiscs"}},
+			expected: agar.TagDiff{
+				{Key: "tracknumber", Kind: agar.TagMismatch, Expected: []string{"3"}, Actual: []string{"4"}},
+			},
+		},
+		{
+			name: "ignored numbers",
+			actual: agar.ParsedTags{
+				Text:  map[string][]string{"title": {"Title"}, "artist": {"A", "B"}},
+				Track: 4, TrackTotal: 7, Disc: 2, DiscTotal: 2,
+			},
+			opts: agar.TagAssertOptions{IgnoreKeys: []string{"TrackNumber", "discnumber"}},
+			expected: agar.TagDiff{
+				{Key: "tracktotal", Kind: agar.TagMismatch, Expected: []string{"6"}, Actual: []string{"7"}},
+			},
+		},
+		{
+			name: "ignored pairs",
+			actual: agar.ParsedTags{
+				Text: map[string][]string{"title": {"Title"}, "artist": {"A", "B"}},
+			},
+			opts: agar.TagAssertOptions{IgnoreKeys: []string{"tracknumber", "tracktotal", "discnumber", "disctotal"}},
+		},
+	} {
+		if diff := agar.DiffTags(expected, &tc.actual, tc.opts); !reflect.DeepEqual(diff, tc.expected) {
+			t.Errorf("%s: got\n%s\nexpected\n%s", tc.name, diff, tc.expected)
+		}
+	}
+}
+
+func TestTagDiffString(t *testing.T) {
+	t.Parallel()
+
+	diff := agar.TagDiff{
+		{Key: "artist", Kind: agar.TagMismatch, Expected: []string{"A", "B"}, Actual: []string{"C"}},
+		{Key: "title", Kind: agar.TagMissing, Expected: []string{"Title"}},
+	}
+
+	expected := `KEY     DIFF      EXPECTED  ACTUAL
+artist  mismatch  "A | B"   "C"
+title   missing   "Title"   -
+`
+	if diff.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", diff, expected)
+	}
+}
+
+func TestAssertTags(t *testing.T) {
+	t.Parallel()
+
+	actual := agar.NewParsedTags()
+	actual.Text["title"] = []string{"Title"}
+
+	for _, tc := range []struct {
+		title  string
+		failed bool
+	}{
+		{"Title", false},
+		{"Other", true},
+	} {
+		expected := agar.Tags{Text: map[string][]string{"title": {tc.title}}}
+
+		recorder := recordAssertion(t, func(recorder *recordingT) {
+			agar.AssertTags(recorder, expected, actual, agar.TagAssertOptions{})
+		})
+
+		if recorder.failed != tc.failed {
+			t.Errorf("%s: failed %v, expected %v", tc.title, recorder.failed, tc.failed)
+		}
+
+		if tc.failed && (len(recorder.logs) != 1 || !strings.Contains(recorder.logs[0], `title  mismatch  "Other"`)) {
+			t.Errorf("%s: logged %q, expected the difference table", tc.title, recorder.logs)
+		}
+	}
+}
+
+func TestAssertFileTags(t *testing.T) {
+	t.Parallel()
+
+	format := agar.Format{SampleRate: 8000, BitDepth: agar.BitDepth16, Channels: 1}
+
+	pcm, err := agar.RenderPCM(format, 10*time.Millisecond, agar.Sine{Frequency: 440, Level: -6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tags.wav")
+	if err = agar.WriteWAV(path, pcm, format); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = agar.WriteTags(path, agar.DefaultTags()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path   string
+		tags   agar.Tags
+		failed bool
+	}{
+		{path, agar.DefaultTags(), false},
+		{path, agar.NewTags(), true},
+		{filepath.Join(t.TempDir(), "missing.wav"), agar.DefaultTags(), true},
+	} {
+		recorder := recordAssertion(t, func(recorder *recordingT) {
+			agar.AssertFileTags(recorder, tc.path, tc.tags, agar.TagAssertOptions{})
+		})
+
+		if recorder.failed != tc.failed {
+			t.Errorf("%s %v: failed %v, expected %v", tc.path, tc.tags.Text, recorder.failed, tc.failed)
+		}
+	}
+}
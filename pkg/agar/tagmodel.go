@@ -0,0 +1,486 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+const (
+	mimeJPEG         = "image/jpeg"
+	mimePNG          = "image/png"
+	vorbisFramingBit = 0x01
+)
+
+// ErrTagsUnsupported is returned when WriteTags or ReadTags does not recognize a file's container.
+var ErrTagsUnsupported = errors.New("unsupported container for tags")
+
+// tagPairKeys are the semantic names of track and disc numbers, which Tags carries as integer fields.
+//
+//nolint:gochecknoglobals // lookup table
+var tagPairKeys = map[string]bool{
+	"tracknumber": true, "tracktotal": true, "totaltracks": true,
+	"discnumber": true, "disctotal": true, "totaldiscs": true,
+}
+
+// Picture is an embedded image. MIME is detected from the image signature when empty.
+// Type is the ID3/FLAC picture type (ID3PictureFrontCover for a front cover).
+type Picture struct {
+	MIME        string
+	Type        byte
+	Description string
+	Data        []byte
+}
+
+// Tags is format-independent metadata, keyed by the semantic names parsers produce (title, artist,
+// musicbrainz_recordingid, ...). Keys every container maps natively are written to their native field;
+// other keys go to the container's freeform mechanism (Vorbis comment, TXXX, "----" atom). The keys
+// "comment:<description>" and "lyrics:<description>" become described ID3 COMM and USLT frames.
+//
+// Track and disc numbers live in their integer fields: writers ignore the tracknumber, tracktotal,
+// discnumber and disctotal keys of Text.
+type Tags struct {
+	Text map[string][]string
+
+	Track      int
+	TrackTotal int
+	Disc       int
+	DiscTotal  int
+
+	Pictures []Picture
+}
+
+// NewTags returns empty tags.
+func NewTags() Tags {
+	return Tags{Text: make(map[string][]string)}
+}
+
+// DefaultTags returns the standard test metadata used across gill tests, matching DefaultFLACTags,
+// DefaultMP3Tags, DefaultOggTags and DefaultMP4Tags.
+func DefaultTags() Tags {
+	return Tags{
+		Text: map[string][]string{
+			"title":       {"Test Title"},
+			"artist":      {"Test Artist"},
+			"album":       {"Test Album"},
+			"albumartist": {"Test AlbumArtist"},
+			"date":        {strconv.Itoa(testYear)},
+			"genre":       {"Jazz"},
+			"comment":     {"Test Comment"},
+			"composer":    {"Test Composer"},
+		},
+		Track:      testTrack,
+		TrackTotal: testTrackTotal,
+		Disc:       testDisc,
+	}
+}
+
+// Add appends values to key.
+func (tags *Tags) Add(key string, values ...string) {
+	if tags.Text == nil {
+		tags.Text = make(map[string][]string)
+	}
+
+	tags.Text[key] = append(tags.Text[key], values...)
+}
+
+// Set replaces the values of key.
+func (tags *Tags) Set(key string, values ...string) {
+	if tags.Text == nil {
+		tags.Text = make(map[string][]string)
+	}
+
+	tags.Text[key] = values
+}
+
+// tagContainer identifies the container format of a file.
+type tagContainer int
+
+const (
+	containerUnknown tagContainer = iota
+	containerFLAC
+	containerMP3
+	containerMP4
+	containerVorbis
+	containerOpus
+	containerWAV
+	containerAIFF
+)
+
+// detectContainer identifies the container of content from its signature.
+func detectContainer(content []byte) tagContainer {
+	const (
+		mp4TypeOffset = 4
+		mpegSyncMask  = 0xE0
+	)
+
+	switch {
+	case bytes.HasPrefix(content, []byte("fLaC")):
+		return containerFLAC
+	case bytes.HasPrefix(content, []byte("ID3")),
+		len(content) > 1 && content[0] == 0xFF && content[1]&mpegSyncMask == mpegSyncMask:
+		return containerMP3
+	case len(content) >= mp4TypeOffset+4 && string(content[mp4TypeOffset:mp4TypeOffset+4]) == "ftyp":
+		return containerMP4
+	case bytes.HasPrefix(content, []byte("OggS")):
+		packets, err := readOggPackets(content, 1)
+		if err != nil || len(packets) == 0 {
+			return containerUnknown
+		}
+
+		switch {
+		case bytes.HasPrefix(packets[0], []byte(vorbisIDHeader)):
+			return containerVorbis
+		case bytes.HasPrefix(packets[0], []byte(opusIDHeader)):
+			return containerOpus
+		default:
+			return containerUnknown
+		}
+	case len(content) >= riffHeaderSize && string(content[:4]) == "RIFF" && string(content[8:12]) == "WAVE":
+		return containerWAV
+	case len(content) >= riffHeaderSize && string(content[:4]) == "FORM" &&
+		(string(content[8:12]) == "AIFF" || string(content[8:12]) == "AIFC"):
+		return containerAIFF
+	default:
+		return containerUnknown
+	}
+}
+
+// WriteTags replaces the metadata of the file at path with tags. The container is detected from the
+// file content: FLAC (Vorbis comment and PICTURE blocks), MP3 (ID3v2.4, keeping any ID3v1 tag),
+// MP4 (ilst), Ogg Vorbis and Opus (comment header, with METADATA_BLOCK_PICTURE pictures),
+// RIFF/WAVE (LIST/INFO and id3 chunks) and AIFF (text and ID3 chunks).
+func WriteTags(path string, tags Tags) error {
+	content, err := os.ReadFile(path) //nolint:gosec // G304: path is caller-provided test fixture.
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var rewritten []byte
+
+	switch detectContainer(content) {
+	case containerFLAC:
+		rewritten, err = replaceFLACTags(content, vorbisComments(tags, false), tags.Pictures)
+	case containerMP3:
+		return WriteID3v2(path, tagsToID3(tags), ID3Options{Version: ID3v24})
+	case containerMP4:
+		return WriteMP4Metadata(path, tagsToMP4(tags))
+	case containerVorbis:
+		packet := slices.Concat([]byte(vorbisCommentHeader), encodeVorbisComments(vorbisComments(tags, true)))
+		rewritten, err = replaceOggPacket(content, vorbisHeaderPackets, 1, append(packet, vorbisFramingBit))
+	case containerOpus:
+		packet := slices.Concat([]byte(opusCommentHeader), encodeVorbisComments(vorbisComments(tags, true)))
+		rewritten, err = replaceOggPacket(content, opusHeaderPackets, 1, packet)
+	case containerWAV:
+		rewritten, err = replaceWAVTags(content, tags)
+	case containerAIFF:
+		rewritten, err = replaceAIFFTags(content, tags)
+	default:
+		return fmt.Errorf("%w: %s", ErrTagsUnsupported, path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	if err := os.WriteFile(path, rewritten, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// ReadTags reads the metadata of the file at path natively, for every container WriteTags supports.
+// Files without metadata yield empty tags.
+func ReadTags(path string) (*ParsedTags, error) {
+	content, err := os.ReadFile(path) //nolint:gosec // G304: path is caller-provided test fixture.
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var tags *ParsedTags
+
+	switch detectContainer(content) {
+	case containerFLAC:
+		tags, err = parseFLACTags(content)
+	case containerMP3:
+		tags, err = parseID3(content)
+	case containerMP4:
+		var meta *MP4Metadata
+
+		meta, err = ReadMP4Metadata(path)
+		if err == nil {
+			tags = meta.parsedTags()
+		}
+	case containerVorbis:
+		return ParseVorbisComment(path)
+	case containerOpus:
+		return ParseOpusTags(path)
+	case containerWAV, containerAIFF:
+		tags, err = parseChunkTags(content)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrTagsUnsupported, path)
+	}
+
+	if errors.Is(err, ErrNoID3Tag) {
+		return NewParsedTags(), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return tags, nil
+}
+
+// SetTags writes tags to the file at path with WriteTags, failing the test on error.
+func SetTags(helpers test.Helpers, path string, tags Tags) {
+	helpers.T().Helper()
+
+	if err := WriteTags(path, tags); err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+}
+
+// parseFLACTags reads the Vorbis comment and PICTURE blocks of a FLAC file.
+func parseFLACTags(content []byte) (*ParsedTags, error) {
+	blocks, _, err := parseFLACMetadata(content)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := NewParsedTags()
+	pictures := 0
+
+	for _, block := range blocks {
+		switch block.Type {
+		case FLACBlockVorbisComment:
+			tags, err = parseVorbisCommentPacket(block.Data)
+			if err != nil {
+				return nil, err
+			}
+		case FLACBlockPicture:
+			pictures++
+		default:
+		}
+	}
+
+	tags.PictureCount += pictures
+
+	return tags, nil
+}
+
+// sortedTagKeys returns the keys of tags.Text in order, without track and disc keys.
+func sortedTagKeys(tags Tags) []string {
+	return slices.DeleteFunc(slices.Sorted(maps.Keys(tags.Text)), func(key string) bool {
+		return tagPairKeys[key] || len(tags.Text[key]) == 0
+	})
+}
+
+// semanticToKey returns the native key a mapping table associates with a semantic name.
+// Ties resolve to the lexicographically first key (e.g. TDRC over TYER).
+func semanticToKey(table map[string]string, semantic string) (string, bool) {
+	for _, key := range slices.Sorted(maps.Keys(table)) {
+		if table[key] == semantic {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// freeformName returns the freeform (TXXX, "----") name of a semantic key, following Picard's
+// spelling for known names ("MusicBrainz Album Id", "ASIN") and keeping other keys verbatim.
+func freeformName(semantic string) string {
+	name, ok := semanticToKey(freeformNameToSemantic, semantic)
+	if !ok {
+		return semantic
+	}
+
+	const musicBrainzPrefix = "MUSICBRAINZ "
+
+	if name != "ACOUSTID ID" && !strings.HasPrefix(name, musicBrainzPrefix) {
+		return name
+	}
+
+	words := strings.Fields(strings.ToLower(name))
+	for idx, word := range words {
+		words[idx] = strings.ToUpper(word[:1]) + word[1:]
+	}
+
+	return strings.Replace(strings.Join(words, " "), "Musicbrainz", "MusicBrainz", 1)
+}
+
+// vorbisComments returns the "KEY=value" comments of tags. Pictures are included as base64
+// METADATA_BLOCK_PICTURE comments when withPictures is set, as Ogg streams carry them.
+func vorbisComments(tags Tags, withPictures bool) []string {
+	var comments []string
+
+	for _, key := range sortedTagKeys(tags) {
+		name, ok := semanticToKey(vorbisToSemantic, key)
+		if !ok {
+			name = strings.ToUpper(key)
+		}
+
+		for _, value := range tags.Text[key] {
+			comments = append(comments, name+"="+value)
+		}
+	}
+
+	pairs := []struct {
+		key   string
+		value int
+	}{
+		{"TRACKNUMBER", tags.Track},
+		{"TRACKTOTAL", tags.TrackTotal},
+		{"DISCNUMBER", tags.Disc},
+		{"DISCTOTAL", tags.DiscTotal},
+	}
+
+	for _, pair := range pairs {
+		if pair.value > 0 {
+			comments = append(comments, pair.key+"="+strconv.Itoa(pair.value))
+		}
+	}
+
+	if withPictures {
+		for _, picture := range tags.Pictures {
+			comments = append(comments,
+				metadataBlockPicture+"="+base64.StdEncoding.EncodeToString(encodeFLACPicture(picture)))
+		}
+	}
+
+	return comments
+}
+
+// tagsToID3 maps tags to an ID3v2 tag.
+func tagsToID3(tags Tags) ID3Tag {
+	tag := ID3Tag{Text: make(map[string][]string)}
+	tag.Track, tag.TrackTotal, tag.Disc, tag.DiscTotal = tags.Track, tags.TrackTotal, tags.Disc, tags.DiscTotal
+
+	for _, key := range sortedTagKeys(tags) {
+		values := tags.Text[key]
+		base, description, _ := strings.Cut(key, ":")
+
+		switch {
+		case base == "comment" || base == "lyrics":
+			for _, value := range values {
+				comment := ID3Comment{Description: description, Text: value}
+				if base == "comment" {
+					tag.Comments = append(tag.Comments, comment)
+				} else {
+					tag.Lyrics = append(tag.Lyrics, comment)
+				}
+			}
+		case key == "musicbrainz_recordingid":
+			for _, value := range values {
+				tag.Frames = append(tag.Frames, ID3Frame{ID: "UFID", Data: []byte(musicBrainzUFIDOwn + "\x00" + value)})
+			}
+		default:
+			if frameID, ok := semanticToKey(id3FrameToSemantic, key); ok {
+				tag.Text[frameID] = values
+			} else {
+				tag.UserText = append(tag.UserText, ID3UserText{Description: freeformName(key), Values: values})
+			}
+		}
+	}
+
+	for _, picture := range tags.Pictures {
+		tag.Pictures = append(tag.Pictures, ID3Picture(picture))
+	}
+
+	return tag
+}
+
+// encodeTagsID3 encodes tags as an ID3v2.4 tag, as embedded in RIFF/WAVE and AIFF chunks.
+func encodeTagsID3(tags Tags) ([]byte, error) {
+	return EncodeID3v2(tagsToID3(tags), ID3Options{Version: ID3v24})
+}
+
+// tagsToMP4 maps tags to MP4 ilst metadata.
+func tagsToMP4(tags Tags) *MP4Metadata {
+	meta := NewMP4Metadata()
+	meta.Track, meta.TrackTotal, meta.Disc, meta.DiscTotal = tags.Track, tags.TrackTotal, tags.Disc, tags.DiscTotal
+
+	for _, key := range sortedTagKeys(tags) {
+		if atom, ok := semanticToKey(mp4AtomToSemantic, key); ok && atom != mp4FreeformAtom {
+			meta.Text[atom] = tags.Text[key]
+		} else {
+			meta.SetFreeform(iTunesDomain, freeformName(key), tags.Text[key]...)
+		}
+	}
+
+	for _, picture := range tags.Pictures {
+		meta.Artwork = append(meta.Artwork, picture.Data)
+	}
+
+	return meta
+}
+
+// parsedTags converts MP4 metadata to semantic tags, as ParseAtomicParsley reports them.
+func (meta *MP4Metadata) parsedTags() *ParsedTags {
+	tags := NewParsedTags()
+
+	for atom, values := range meta.Text {
+		key := atomToSemanticName(atom)
+		tags.Text[key] = append(tags.Text[key], values...)
+	}
+
+	for _, freeform := range meta.Freeform {
+		key := freeformToSemanticName(freeform.Name)
+		tags.Text[key] = append(tags.Text[key], freeform.Values...)
+	}
+
+	if meta.Track > 0 {
+		tags.Track, tags.TrackTotal = meta.Track, meta.TrackTotal
+		tags.Text["tracknumber"] = []string{formatPairValue(meta.Track, meta.TrackTotal)}
+	}
+
+	if meta.Disc > 0 {
+		tags.Disc, tags.DiscTotal = meta.Disc, meta.DiscTotal
+		tags.Text["discnumber"] = []string{formatPairValue(meta.Disc, meta.DiscTotal)}
+	}
+
+	tags.PictureCount = len(meta.Artwork)
+
+	return tags
+}
+
+// sniffImageMIME returns the MIME type of a PNG or, by default, JPEG image.
+func sniffImageMIME(data []byte) string {
+	if mp4ImageType(data) == mp4DataTypePNG {
+		return mimePNG
+	}
+
+	return mimeJPEG
+}
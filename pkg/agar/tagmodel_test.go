@@ -0,0 +1,202 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// tagRoundTripFiles returns tool-free files of every container WriteTags supports, with the bytes of
+// their audio payload, which tag rewrites must keep.
+func tagRoundTripFiles(t *testing.T) map[string][2][]byte {
+	t.Helper()
+
+	format := agar.Format{SampleRate: 44100, BitDepth: agar.BitDepth16, Channels: 2}
+
+	pcm, err := agar.RenderPCM(format, 200*time.Millisecond, agar.Sine{Frequency: 440, Level: -6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flac, err := agar.EncodeFLAC(pcm, format, agar.FLACOptions{
+		Padding:  100,
+		Metadata: []agar.FLACMetadataBlock{{Type: agar.FLACBlockSeekTable}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wav, err := agar.EncodeWAV(pcm, format, agar.WAVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sound data chunk ends the file.
+	aiff, err := agar.EncodeAIFF(pcm, format, agar.AIFFOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mp3 := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 100)
+	mdat := []byte("AAAABBBBCCCC")
+	mp4 := slices.Concat(
+		mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42isom")),
+		mp4Box("moov", mp4Box("mvhd", make([]byte, 100)), mp4Box("udta", mp4Box("Xtra", []byte("keep")))),
+		mp4Box("mdat", mdat),
+	)
+
+	// A multi-page setup header makes the rewrite rebuild several header pages.
+	oggAudio := []byte{0xFC, 0xFF, 0xFE, 0x01}
+	vorbis := oggStream(7, 1,
+		[][]byte{append([]byte("\x01vorbis"), make([]byte, 23)...)},
+		[][]byte{commentPacket("\x03vorbis", "OLD=1"), append([]byte("\x05vorbis"), bytes.Repeat([]byte("S"), 700)...)},
+		[][]byte{oggAudio},
+	)
+	opus := oggStream(9, 255,
+		[][]byte{append([]byte("OpusHead\x01\x02"), make([]byte, 9)...)},
+		[][]byte{commentPacket("OpusTags", "OLD=1")},
+		[][]byte{oggAudio},
+	)
+
+	return map[string][2][]byte{
+		"tags.flac": {flac, nil},
+		"tags.wav":  {wav, pcm},
+		"tags.aiff": {aiff, aiff[len(aiff)-len(pcm):]},
+		"tags.mp3":  {mp3, mp3},
+		"tags.m4a":  {mp4, mdat},
+		"tags.ogg":  {vorbis, oggAudio},
+		"tags.opus": {opus, oggAudio},
+	}
+}
+
+func TestWriteTagsRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tags := agar.DefaultTags()
+	tags.Add("artist", "Second Artist")
+	tags.Set("musicbrainz_recordingid", "recording-id")
+	tags.Set("musicbrainz_albumid", "album-id")
+	tags.Set("isrc", "USRC17607839")
+	tags.Set("label", "Label")
+	tags.Set("my_custom", "custom")
+	tags.Pictures = []agar.Picture{
+		{Type: agar.ID3PictureFrontCover, Data: append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100000)...)},
+		{Data: []byte{0xFF, 0xD8, 0xFF}},
+	}
+
+	for name, file := range tagRoundTripFiles(t) {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, file[0], 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		// Writing twice checks that the first tags are replaced rather than kept.
+		for range 2 {
+			if err := agar.WriteTags(path, tags); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		read, err := agar.ReadTags(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if diff := agar.DiffTags(tags, read, agar.TagAssertOptions{}); len(diff) > 0 {
+			t.Errorf("%s:\n%s", name, diff)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if file[1] != nil && !bytes.Contains(content, file[1]) {
+			t.Errorf("%s: audio payload lost", name)
+		}
+
+		switch name {
+		case "tags.ogg", "tags.opus":
+			checkOggPages(t, name, content)
+		case "tags.flac":
+			_, decoded := decodeFLAC(t, content)
+			_, original := decodeFLAC(t, file[0])
+
+			if !bytes.Equal(decoded, original) {
+				t.Errorf("%s: audio changed", name)
+			}
+		}
+	}
+}
+
+// checkOggPages checks the checksum of every page and that page sequence numbers follow each other.
+func checkOggPages(t *testing.T, name string, content []byte) {
+	t.Helper()
+
+	next := map[uint32]uint32{}
+
+	for len(content) > 0 {
+		headerSize := oggPageHeaderSize + int(content[oggPageHeaderSize-1])
+
+		size := headerSize
+		for _, lacing := range content[oggPageHeaderSize:headerSize] {
+			size += int(lacing)
+		}
+
+		page := bytes.Clone(content[:size])
+		checksum := binary.LittleEndian.Uint32(page[oggCRCOffset:])
+		binary.LittleEndian.PutUint32(page[oggCRCOffset:], 0)
+
+		if oggTestCRC(page) != checksum {
+			t.Errorf("%s: bad page checksum", name)
+		}
+
+		serial, sequence := binary.LittleEndian.Uint32(page[14:]), binary.LittleEndian.Uint32(page[18:])
+		if expected, ok := next[serial]; ok && sequence != expected {
+			t.Errorf("%s: page %d follows page %d", name, sequence, expected-1)
+		}
+
+		next[serial] = sequence + 1
+		content = content[size:]
+	}
+}
+
+func TestWriteTagsUnsupported(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "tags.txt")
+	if err := os.WriteFile(path, []byte("plain text"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := agar.WriteTags(path, agar.DefaultTags()); !errors.Is(err, agar.ErrTagsUnsupported) {
+		t.Errorf("WriteTags: got %v, expected ErrTagsUnsupported", err)
+	}
+
+	if _, err := agar.ReadTags(path); !errors.Is(err, agar.ErrTagsUnsupported) {
+		t.Errorf("ReadTags: got %v, expected ErrTagsUnsupported", err)
+	}
+}
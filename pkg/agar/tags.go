@@ -17,7 +17,6 @@
 package agar
 
 import (
-	"path/filepath"
 	"strconv"
 
 	"github.com/containerd/nerdctl/mod/tigron/test"
@@ -62,16 +61,14 @@ func DefaultFLACTags() FLACTags {
 func AddTag(helpers test.Helpers, path, key, value string) {
 	helpers.T().Helper()
 
-	mf := lookForOrFail(helpers.T(), metaflacBinary)
-	helpers.Custom(mf, "--set-tag="+key+"="+value, path).Run(&test.Expected{})
+	runToolOrFail(helpers, "AddTag", metaflacBinary, "--set-tag="+key+"="+value, path)
 }
 
 // RemoveTag removes all instances of a tag from a FLAC file using metaflac.
 func RemoveTag(helpers test.Helpers, path, key string) {
 	helpers.T().Helper()
 
-	mf := lookForOrFail(helpers.T(), metaflacBinary)
-	helpers.Custom(mf, "--remove-tag="+key, path).Run(&test.Expected{})
+	runToolOrFail(helpers, "RemoveTag", metaflacBinary, "--remove-tag="+key, path)
 }
 
 // SetTag sets a tag value, removing any existing values for that key first.
@@ -86,75 +83,71 @@ func SetTag(helpers test.Helpers, path, key, value string) {
 func FLACSetTags(helpers test.Helpers, path string, tags FLACTags) {
 	helpers.T().Helper()
 
-	if tags.Title != "" {
-		SetTag(helpers, path, "TITLE", tags.Title)
-	}
-
-	if tags.Artist != "" {
-		SetTag(helpers, path, "ARTIST", tags.Artist)
-	}
-
-	if tags.Album != "" {
-		SetTag(helpers, path, "ALBUM", tags.Album)
-	}
-
-	if tags.AlbumArtist != "" {
-		SetTag(helpers, path, "ALBUMARTIST", tags.AlbumArtist)
-	}
-
-	if tags.Date != "" {
-		SetTag(helpers, path, "DATE", tags.Date)
-	}
-
-	if tags.Genre != "" {
-		SetTag(helpers, path, "GENRE", tags.Genre)
-	}
-
-	if tags.Comment != "" {
-		SetTag(helpers, path, "COMMENT", tags.Comment)
-	}
-
-	if tags.Composer != "" {
-		SetTag(helpers, path, "COMPOSER", tags.Composer)
-	}
+	runToolOrFail(helpers, "FLACSetTags", metaflacBinary, flacSetTagsArgs(path, tags)...)
+}
 
-	if tags.TrackNumber > 0 {
-		SetTag(helpers, path, "TRACKNUMBER", strconv.Itoa(tags.TrackNumber))
+// flacSetTagsArgs returns the metaflac arguments replacing the values of the non-empty tags of path in a
+// single invocation: metaflac applies operations in order.
+func flacSetTagsArgs(path string, tags FLACTags) []string {
+	var args []string
+
+	for _, tag := range []struct {
+		key   string
+		value string
+	}{
+		{"TITLE", tags.Title},
+		{"ARTIST", tags.Artist},
+		{"ALBUM", tags.Album},
+		{"ALBUMARTIST", tags.AlbumArtist},
+		{"DATE", tags.Date},
+		{"GENRE", tags.Genre},
+		{"COMMENT", tags.Comment},
+		{"COMPOSER", tags.Composer},
+		{"TRACKNUMBER", positiveString(tags.TrackNumber)},
+		{"TRACKTOTAL", positiveString(tags.TrackTotal)},
+		{"DISCNUMBER", positiveString(tags.DiscNumber)},
+		{"DISCTOTAL", positiveString(tags.DiscTotal)},
+	} {
+		if tag.value != "" {
+			args = append(args, "--remove-tag="+tag.key, "--set-tag="+tag.key+"="+tag.value)
+		}
 	}
 
-	if tags.TrackTotal > 0 {
-		SetTag(helpers, path, "TRACKTOTAL", strconv.Itoa(tags.TrackTotal))
-	}
+	return append(args, path)
+}
 
-	if tags.DiscNumber > 0 {
-		SetTag(helpers, path, "DISCNUMBER", strconv.Itoa(tags.DiscNumber))
+// positiveString formats val, or returns an empty string when it is not positive.
+func positiveString(val int) string {
+	if val <= 0 {
+		return ""
 	}
 
-	if tags.DiscTotal > 0 {
-		SetTag(helpers, path, "DISCTOTAL", strconv.Itoa(tags.DiscTotal))
-	}
+	return strconv.Itoa(val)
 }
 
 // TaggedFLAC returns path to FLAC with standard metadata tags.
 func TaggedFLAC(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	path := generate(helpers, filepath.Join(data.Temp().Dir(), "tagged.flac"), []string{
-		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
-		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
-		"-ar", "44100", "-sample_fmt", "s16",
-	})
-
-	FLACSetTags(helpers, path, DefaultFLACTags())
+	return taggedFLAC().Path(data, helpers)
+}
 
-	return path
+func taggedFLAC() Generator {
+	return untaggedFLAC().renamed("TaggedFLAC", "tagged.flac").then(
+		toolStep(metaflacBinary, func(path string) []string {
+			return flacSetTagsArgs(path, DefaultFLACTags())
+		}))
 }
 
 // UntaggedFLAC returns path to FLAC with no tags.
 func UntaggedFLAC(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	return generate(helpers, filepath.Join(data.Temp().Dir(), "untagged.flac"), []string{
+	return untaggedFLAC().Path(data, helpers)
+}
+
+func untaggedFLAC() Generator {
+	return ffmpegGenerator("UntaggedFLAC", "untagged.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
@@ -166,16 +159,18 @@ func UntaggedFLAC(data test.Data, helpers test.Helpers) string {
 func TaggedFLACMultiArtist(data test.Data, helpers test.Helpers) string {
 	helpers.T().Helper()
 
-	path := generate(helpers, filepath.Join(data.Temp().Dir(), "tagged-multi-artist.flac"), []string{
+	return taggedFLACMultiArtist().Path(data, helpers)
+}
+
+func taggedFLACMultiArtist() Generator {
+	return ffmpegGenerator("TaggedFLACMultiArtist", "tagged-multi-artist.flac", []string{
 		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + shortDuration,
 		"-af", "pan=stereo|c0=c0|c1=c0,volume=-6dB",
 		"-ar", "44100", "-sample_fmt", "s16",
 		"-metadata", "artist=Artist One",
 		"-metadata", "album=Collaboration Album",
-	})
-
-	// Add second artist using metaflac
-	AddTag(helpers, path, "ARTIST", "Artist Two")
-
-	return path
+	}).then(toolStep(metaflacBinary, func(path string) []string {
+		// Add second artist using metaflac
+		return []string{"--set-tag=ARTIST=Artist Two", path}
+	}))
 }
@@ -0,0 +1,167 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+	"github.com/containerd/nerdctl/mod/tigron/tig"
+)
+
+// External tool execution parameters.
+const (
+	// DefaultToolTimeout bounds external tool invocations when neither SetToolTimeout nor a test
+	// deadline applies.
+	DefaultToolTimeout = 3 * time.Minute
+
+	// toolDeadlineShare reserves 1/20th of the time left before the test deadline to report a timeout
+	// before the test binary panics.
+	toolDeadlineShare = 20
+	// toolWaitDelay bounds the wait for output pipes once a tool was killed.
+	toolWaitDelay = 5 * time.Second
+)
+
+// Errors returned by external tool invocations.
+var (
+	ErrToolTimeout = errors.New("external tool timed out")
+	ErrToolFailed  = errors.New("external tool failed")
+)
+
+//nolint:gochecknoglobals // set once per test binary, typically from TestMain
+var toolTimeout atomic.Int64
+
+// SetToolTimeout bounds every external tool invocation (ffmpeg, ffprobe, metaflac, atomicparsley,
+// vorbiscomment, sox) by timeout, still within the test deadline. Zero restores the default: the test
+// deadline when there is one, DefaultToolTimeout otherwise.
+func SetToolTimeout(timeout time.Duration) {
+	toolTimeout.Store(int64(timeout))
+}
+
+// ToolContext returns the context bounding the external tools run for t, which may be nil: it expires
+// at the SetToolTimeout timeout, or else shortly before the test deadline (see go test -timeout), or
+// else after DefaultToolTimeout. Tools still running when it expires are killed with their whole process
+// group.
+func ToolContext(t tig.T) (context.Context, context.CancelFunc) {
+	timeout := time.Duration(toolTimeout.Load())
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	if withDeadline, ok := t.(interface{ Deadline() (time.Time, bool) }); ok {
+		if testDeadline, set := withDeadline.Deadline(); set {
+			testDeadline = testDeadline.Add(-time.Until(testDeadline) / toolDeadlineShare)
+			if deadline.IsZero() || testDeadline.Before(deadline) {
+				deadline = testDeadline
+			}
+		}
+	}
+
+	if deadline.IsZero() {
+		deadline = time.Now().Add(DefaultToolTimeout)
+	}
+
+	return context.WithDeadline(context.Background(), deadline)
+}
+
+// newToolCommand returns a command running in its own process group, which is killed as a whole when
+// ctx expires.
+func newToolCommand(ctx context.Context, path string, args ...string) *exec.Cmd {
+	//nolint:gosec // tools are resolved by LookFor, arguments are test-controlled
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.WaitDelay = toolWaitDelay
+	setProcessGroup(cmd)
+
+	return cmd
+}
+
+// runTool runs cmd to completion, capturing its stderr for error reports. The generator names the
+// fixture generator or helper the tool runs for.
+func runTool(ctx context.Context, generator string, cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, &stderr)
+	} else {
+		cmd.Stderr = &stderr
+	}
+
+	started := time.Now()
+
+	if err := cmd.Run(); err != nil {
+		return toolError(ctx, generator, cmd, err, stderr.String(), time.Since(started))
+	}
+
+	return nil
+}
+
+// toolError reports a failed or timed out tool with its stderr.
+func toolError(ctx context.Context, generator string, cmd *exec.Cmd, err error, stderr string,
+	elapsed time.Duration,
+) error {
+	tool := strings.TrimSuffix(filepath.Base(cmd.Path), filepath.Ext(cmd.Path))
+	stderr = strings.TrimSpace(stderr)
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s: %s killed after %v\nstderr: %s",
+			ErrToolTimeout, generator, tool, elapsed.Round(time.Millisecond), stderr)
+	}
+
+	return fmt.Errorf("%w: %s: %s: %w\nstderr: %s", ErrToolFailed, generator, tool, err, stderr)
+}
+
+// runToolOrFail runs binary with args for the generator, within the tool context of the test, and
+// fails the test with the captured stderr when it fails or times out.
+func runToolOrFail(helpers test.Helpers, generator, binary string, args ...string) {
+	helpers.T().Helper()
+
+	ctx, cancel := ToolContext(helpers.T())
+	defer cancel()
+
+	failOnError(helpers, runBinary(ctx, generator, binary, args...))
+}
+
+// failOnError fails the test with err when it is not nil.
+func failOnError(helpers test.Helpers, err error) {
+	helpers.T().Helper()
+
+	if err != nil {
+		helpers.T().Log(err.Error())
+		helpers.T().FailNow()
+	}
+}
+
+// runBinary looks binary up with LookFor and runs it with args through runTool.
+func runBinary(ctx context.Context, generator, binary string, args ...string) error {
+	path, err := LookFor(binary)
+	if err != nil {
+		return fmt.Errorf("%s: %w", binary, err)
+	}
+
+	return runTool(ctx, generator, newToolCommand(ctx, path, args...))
+}
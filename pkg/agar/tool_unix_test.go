@@ -0,0 +1,100 @@
+//go:build unix
+
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+//nolint:paralleltest // replaces PATH of the test binary.
+func TestToolTimeoutKillsProcessGroup(t *testing.T) {
+	// The fake tool forks a sleeping child that inherits its output, records its pid, and waits for it.
+	bin, pidFile := t.TempDir(), filepath.Join(t.TempDir(), "child.pid")
+	script := "#!/bin/sh\nsleep 60 &\necho $! > " + pidFile + "\nwait\n"
+
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+
+	_, err := agar.FFmpegContext(ctx, agar.FFmpegOptions{})
+	if !errors.Is(err, agar.ErrToolTimeout) {
+		t.Fatalf("expected %v, got %v", agar.ErrToolTimeout, err)
+	}
+
+	// Killing only the tool would leave its pipes open until the wait delay expires.
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("timed out tool returned after %v", elapsed)
+	}
+
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The orphaned child is reaped asynchronously once killed.
+	for deadline := time.Now().Add(5 * time.Second); processAlive(pid); {
+		if time.Now().After(deadline) {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+
+			t.Fatalf("child process %d outlived the timed out tool", pid)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// processAlive reports whether pid is a running process, treating zombies awaiting their reaper as gone.
+func processAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		// Without procfs, a signalable process is alive; on Linux it may just have been reaped.
+		return true
+	}
+
+	// The state follows the parenthesized command name.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+
+	return len(fields) == 0 || fields[0] != "Z"
+}
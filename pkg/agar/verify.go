@@ -0,0 +1,185 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeDurationTolerance is the accepted deviation of a probed duration, which includes encoder delay
+// and padding of lossy codecs.
+const probeDurationTolerance = 100 * time.Millisecond
+
+// probeFormatNames maps containers to the ffprobe format name listing them.
+//
+//nolint:gochecknoglobals // lookup table
+var probeFormatNames = map[string]string{
+	ContainerFLAC: "flac",
+	ContainerMP4:  "mp4",
+	ContainerMKV:  "matroska",
+	ContainerMP3:  "mp3",
+	ContainerOgg:  "ogg",
+	ContainerRF64: "wav",
+	ContainerW64:  "w64",
+}
+
+// Verify checks that the file at path is the fixture: it probes the container, codec, audio streams,
+// sample rate, channels, bit depth and duration, then runs the checks of the FixtureProperties
+// documented for the fixture on its decoded audio. All mismatches are reported, as ErrFixtureProperty
+// errors. Malformed fixtures, which probing cannot be trusted with, are compared byte for byte with a
+// fresh generation instead.
+func (f Fixture) Verify(ctx context.Context, path string) error {
+	if f.Malformed {
+		return f.verifyContent(ctx, path)
+	}
+
+	probe, err := FFProbeContext(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	errs := f.verifyProbe(probe)
+
+	properties := slices.DeleteFunc(FixtureProperties(), func(property FixtureProperty) bool {
+		return property.Fixture != f.Name
+	})
+	if len(properties) == 0 {
+		return errors.Join(errs...)
+	}
+
+	pcm, format, err := DecodeFileContext(ctx, path)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for _, property := range properties {
+		if err = property.Check(pcm, format); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", property.Description, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// verifyContent compares the file at path with the fixture generated in a temporary directory.
+func (f Fixture) verifyContent(ctx context.Context, path string) error {
+	dir, err := os.MkdirTemp("", "agar-verify-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+
+	expectedPath, err := f.Generate(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	expected, err := os.ReadFile(expectedPath) //nolint:gosec // G304: generated in our own directory.
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(path) //nolint:gosec // G304: path is the fixture under verification.
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(content, expected) {
+		return fmt.Errorf("%w: content differs from a fresh generation of %s", ErrFixtureProperty, f.File)
+	}
+
+	return nil
+}
+
+// verifyProbe compares the probed metadata of a file with the fixture.
+func (f Fixture) verifyProbe(probe *FFProbeResult) []error {
+	var errs []error
+
+	mismatch := func(what string, got, expected any) {
+		errs = append(errs, fmt.Errorf("%w: %s %v, expected %v", ErrFixtureProperty, what, got, expected))
+	}
+
+	if name, ok := probeFormatNames[f.Container]; ok &&
+		!slices.Contains(strings.Split(probe.Format.FormatName, ","), name) {
+		mismatch("container", probe.Format.FormatName, name)
+	}
+
+	var audio []FFProbeStream
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "audio" {
+			audio = append(audio, stream)
+		}
+	}
+
+	if len(audio) != f.Streams {
+		mismatch("audio streams", len(audio), f.Streams)
+	}
+
+	if len(audio) == 0 || f.Streams == 0 {
+		if len(probe.Streams) > 0 && probe.Streams[0].CodecName != f.Codec {
+			mismatch("codec", probe.Streams[0].CodecName, f.Codec)
+		}
+
+		return errs
+	}
+
+	stream := audio[0]
+
+	if stream.CodecName != f.Codec {
+		mismatch("codec", stream.CodecName, f.Codec)
+	}
+
+	if rate := stream.SampleRateInt(); rate != f.SampleRate {
+		mismatch("sample rate", rate, f.SampleRate)
+	}
+
+	if stream.Channels != f.Channels {
+		mismatch("channels", stream.Channels, f.Channels)
+	}
+
+	if f.BitDepth != 0 && stream.BitDepth() != f.BitDepth {
+		mismatch("bit depth", stream.BitDepth(), f.BitDepth)
+	}
+
+	// The headers of oversized fixtures declare far more audio than they hold.
+	if !f.Oversized {
+		seconds := stream.DurationFloat()
+		if stream.Duration == "" {
+			// Matroska only has a container duration.
+			seconds, _ = strconv.ParseFloat(probe.Format.Duration, float64Bits)
+		}
+
+		duration := time.Duration(math.Round(seconds * float64(time.Second)))
+
+		if (duration - f.Duration).Abs() > probeDurationTolerance {
+			mismatch("duration", duration, f.Duration)
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,120 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+)
+
+const (
+	// w64ChunkHeaderSize is a 16-byte GUID followed by a 64-bit size that includes the header itself.
+	w64ChunkHeaderSize = 24
+	// w64Alignment is the chunk alignment of Sony Wave64 files.
+	w64Alignment = 8
+	w64GUIDSize  = 16
+)
+
+// Sony Wave64 chunk GUIDs. "riff" uses its own suffix, every other chunk shares w64GUIDTail.
+//
+//nolint:gochecknoglobals // constant byte sequences
+var (
+	w64GUIDRiff = []byte{
+		'r', 'i', 'f', 'f', 0x2E, 0x91, 0xCF, 0x11, 0xA5, 0xD6, 0x28, 0xDB, 0x04, 0xC1, 0x00, 0x00,
+	}
+	w64GUIDTail = []byte{0xF3, 0xAC, 0xD3, 0x11, 0x8C, 0xD1, 0x00, 0xC0, 0x4F, 0x8E, 0xDB, 0x8A}
+)
+
+// WriteW64 writes pcm to path as a Sony Wave64 file.
+func WriteW64(path string, pcm []byte, format Format) error {
+	return WriteW64WithOptions(path, pcm, format, LargeWAVOptions{})
+}
+
+// WriteW64WithOptions writes pcm to path as a Sony Wave64 file using the given options.
+func WriteW64WithOptions(path string, pcm []byte, format Format, opts LargeWAVOptions) error {
+	data, dataSize, err := largeWAVData(pcm, format, opts)
+	if err != nil {
+		return err
+	}
+
+	var header bytes.Buffer
+
+	header.Write(w64GUIDRiff)
+	writeLE64(&header, 0) // patched below
+	header.Write(w64GUID("wave"))
+
+	writeW64Chunk(&header, "fmt ", wavFmtChunk(format, opts.WAVOptions))
+
+	if format.Float {
+		fact := make([]byte, w64Alignment)
+		binary.LittleEndian.PutUint64(fact, uint64(dataSize/int64(format.FrameSize()))) //nolint:gosec // G115: positive.
+		writeW64Chunk(&header, "fact", fact)
+	}
+
+	header.Write(w64GUID("data"))
+	writeLE64(&header, uint64(w64ChunkHeaderSize+dataSize)) //nolint:gosec // G115: positive.
+
+	out := header.Bytes()
+	fileSize := int64(len(out)) + alignUp(dataSize, w64Alignment)
+	binary.LittleEndian.PutUint64(out[w64GUIDSize:], uint64(fileSize)) //nolint:gosec // G115: positive.
+
+	return writeLargeFile(path, out, data, fileSize, opts.Sparse, trailingPad(data, dataSize, w64Alignment))
+}
+
+// OversizedW64 returns path to a tiny 16-bit stereo Wave64 file whose headers declare over 5 GiB of audio.
+// Only one second of white noise is actually present, so readers must cope with a file much shorter than declared.
+func OversizedW64(data test.Data, helpers test.Helpers) string {
+	helpers.T().Helper()
+
+	return oversizedW64().Path(data, helpers)
+}
+
+func oversizedW64() Generator {
+	format := Format{SampleRate: 44100, BitDepth: BitDepth16, Channels: 2}
+
+	return nativeGenerator("OversizedW64", "oversized.w64", func(path string) error {
+		return WriteW64WithOptions(path, whiteNoisePCM(format, 1), format, LargeWAVOptions{
+			DeclaredFrames: oversizedFrames,
+		})
+	})
+}
+
+// w64GUID returns the Wave64 GUID for a four-character chunk name.
+func w64GUID(name string) []byte {
+	return append([]byte(name), w64GUIDTail...)
+}
+
+// writeW64Chunk appends a Wave64 chunk, padded to the 8-byte alignment.
+func writeW64Chunk(buf *bytes.Buffer, name string, body []byte) {
+	buf.Write(w64GUID(name))
+	writeLE64(buf, uint64(w64ChunkHeaderSize+len(body))) //nolint:gosec // G115: positive.
+	buf.Write(body)
+	buf.Write(make([]byte, alignUp(int64(len(body)), w64Alignment)-int64(len(body))))
+}
+
+func alignUp(size, alignment int64) int64 {
+	return (size + alignment - 1) / alignment * alignment
+}
+
+func writeLE64(buf *bytes.Buffer, val uint64) {
+	var tmp [8]byte
+
+	binary.LittleEndian.PutUint64(tmp[:], val)
+	buf.Write(tmp[:])
+}
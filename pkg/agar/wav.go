@@ -0,0 +1,311 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/containerd/nerdctl/mod/tigron/test"
+
+	"github.com/mycophonic/primordium/filesystem"
+)
+
+// WAVE format tags (wFormatTag).
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatIEEEFloat  = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+// Chunk layout sizes.
+const (
+	wavFmtSize           = 16
+	wavFmtExtensibleSize = 40
+	wavExtensibleCbSize  = 22
+	wavFactSize          = 4
+	riffHeaderSize       = 12
+	chunkHeaderSize      = 8
+)
+
+// Speaker position bits for the WAVE_FORMAT_EXTENSIBLE dwChannelMask field.
+const (
+	SpeakerFrontLeft          uint32 = 0x1
+	SpeakerFrontRight         uint32 = 0x2
+	SpeakerFrontCenter        uint32 = 0x4
+	SpeakerLowFrequency       uint32 = 0x8
+	SpeakerBackLeft           uint32 = 0x10
+	SpeakerBackRight          uint32 = 0x20
+	SpeakerFrontLeftOfCenter  uint32 = 0x40
+	SpeakerFrontRightOfCenter uint32 = 0x80
+	SpeakerBackCenter         uint32 = 0x100
+	SpeakerSideLeft           uint32 = 0x200
+	SpeakerSideRight          uint32 = 0x400
+)
+
+// Common channel layouts, matching the masks ffmpeg writes by default.
+const (
+	ChannelMaskMono     = SpeakerFrontCenter
+	ChannelMaskStereo   = SpeakerFrontLeft | SpeakerFrontRight
+	ChannelMaskSurround = ChannelMaskStereo | SpeakerFrontCenter
+	ChannelMaskQuad     = ChannelMaskStereo | SpeakerBackLeft | SpeakerBackRight
+	ChannelMask5Point0  = ChannelMaskQuad | SpeakerFrontCenter
+	ChannelMask5Point1  = ChannelMask5Point0 | SpeakerLowFrequency
+	ChannelMask6Point1  = ChannelMaskSurround | SpeakerLowFrequency | SpeakerBackCenter | SpeakerSideLeft |
+		SpeakerSideRight
+	ChannelMask7Point1 = ChannelMask5Point1 | SpeakerSideLeft | SpeakerSideRight
+)
+
+// ksDataFormatSubtypeTail is the common suffix of the KSDATAFORMAT_SUBTYPE_* GUIDs.
+// The first two bytes of the GUID carry the format tag.
+//
+//nolint:gochecknoglobals // constant byte sequence
+var ksDataFormatSubtypeTail = []byte{
+	0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71,
+}
+
+// WAVOptions controls the header layout written by WriteWAVWithOptions.
+type WAVOptions struct {
+	// Extensible forces a WAVE_FORMAT_EXTENSIBLE header.
+	// It is always used for more than two channels or when BitDepth is not byte-aligned.
+	Extensible bool
+	// ChannelMask sets dwChannelMask in extensible headers. Zero selects DefaultChannelMask.
+	ChannelMask uint32
+}
+
+// DefaultChannelMask returns the conventional speaker mask for a channel count,
+// or zero (unassigned) when there is no common layout.
+func DefaultChannelMask(channels int) uint32 {
+	switch channels {
+	case 1:
+		return ChannelMaskMono
+	case 2:
+		return ChannelMaskStereo
+	case 3:
+		return ChannelMaskSurround
+	case 4:
+		return ChannelMaskQuad
+	case 5:
+		return ChannelMask5Point0
+	case 6:
+		return ChannelMask5Point1
+	case 7:
+		return ChannelMask6Point1
+	case 8:
+		return ChannelMask7Point1
+	default:
+		return 0
+	}
+}
+
+// WriteWAV writes pcm to path as a RIFF/WAVE file.
+// Integer formats produce WAVE_FORMAT_PCM (8-bit data is converted to unsigned as the format requires),
+// float formats produce WAVE_FORMAT_IEEE_FLOAT with a fact chunk.
+func WriteWAV(path string, pcm []byte, format Format) error {
+	return WriteWAVWithOptions(path, pcm, format, WAVOptions{})
+}
+
+// WriteWAVExtensible writes pcm to path with a WAVE_FORMAT_EXTENSIBLE header and the given channel mask.
+func WriteWAVExtensible(path string, pcm []byte, format Format, channelMask uint32) error {
+	return WriteWAVWithOptions(path, pcm, format, WAVOptions{Extensible: true, ChannelMask: channelMask})
+}
+
+// WriteWAVWithOptions writes pcm to path as a RIFF/WAVE file using the given header options.
+func WriteWAVWithOptions(path string, pcm []byte, format Format, opts WAVOptions) error {
+	encoded, err := EncodeWAV(pcm, format, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, encoded, filesystem.FilePermissionsPrivate); err != nil {
+		return fmt.Errorf("writing WAV file: %w", err)
+	}
+
+	return nil
+}
+
+// EncodeWAV returns a complete RIFF/WAVE file for pcm.
+func EncodeWAV(pcm []byte, format Format, opts WAVOptions) ([]byte, error) {
+	data, err := wavSampleData(pcm, format)
+	if err != nil {
+		return nil, err
+	}
+
+	fmtChunk := wavFmtChunk(format, opts)
+
+	var buf bytes.Buffer
+
+	buf.Grow(riffHeaderSize + len(fmtChunk) + chunkHeaderSize*2 + wavFactSize + len(data) + 1)
+
+	buf.WriteString("RIFF")
+	writeLE32(&buf, 0) // patched below
+	buf.WriteString("WAVE")
+
+	writeRIFFChunk(&buf, "fmt ", fmtChunk)
+
+	if format.Float {
+		fact := make([]byte, wavFactSize)
+		binary.LittleEndian.PutUint32(fact, uint32(len(pcm)/format.FrameSize())) //nolint:gosec // G115: bounded.
+		writeRIFFChunk(&buf, "fact", fact)
+	}
+
+	writeRIFFChunk(&buf, "data", data)
+
+	out := buf.Bytes()
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-chunkHeaderSize)) //nolint:gosec // G115: RIFF is 32-bit.
+
+	return out, nil
+}
+
+// WhiteNoiseWAV returns path to a deterministic white noise WAV in the given format, written without ffmpeg.
+func WhiteNoiseWAV(data test.Data, helpers test.Helpers, format Format, durationSec int) string {
+	helpers.T().Helper()
+
+	return WhiteNoiseWAVGenerator(format, durationSec).Path(data, helpers)
+}
+
+// WhiteNoiseWAVGenerator returns the generator of WhiteNoiseWAV.
+func WhiteNoiseWAVGenerator(format Format, durationSec int) Generator {
+	return nativeGenerator("WhiteNoiseWAV", whiteNoiseName(format)+".wav", func(path string) error {
+		return WriteWAV(path, whiteNoisePCM(format, durationSec), format)
+	}, strconv.Itoa(durationSec))
+}
+
+// whiteNoisePCM returns GenerateWhiteNoise output for format, converting 24-bit noise for float formats.
+func whiteNoisePCM(format Format, durationSec int) []byte {
+	if !format.Float {
+		return GenerateWhiteNoise(format.SampleRate, format.BitDepth, format.Channels, durationSec)
+	}
+
+	intFormat := Format{SampleRate: format.SampleRate, BitDepth: BitDepth24, Channels: format.Channels}
+
+	return PCMToFloat(
+		GenerateWhiteNoise(format.SampleRate, BitDepth24, format.Channels, durationSec), intFormat, format.BitDepth)
+}
+
+// whiteNoiseName returns a file name stem describing a white noise fixture in format.
+func whiteNoiseName(format Format) string {
+	name := fmt.Sprintf("white-noise-%d-%d-%d", format.SampleRate, format.BitDepth, format.Channels)
+	if format.Float {
+		name += "-float"
+	}
+
+	return name
+}
+
+// wavNeedsExtensible reports whether the format cannot be described by a plain fmt chunk.
+func wavNeedsExtensible(format Format, opts WAVOptions) bool {
+	return opts.Extensible || format.Channels > 2 || format.BitDepth != format.BytesPerSample()*bitsPerByte
+}
+
+// wavFmtChunk builds the body of a "fmt " chunk.
+func wavFmtChunk(format Format, opts WAVOptions) []byte {
+	tag := uint16(wavFormatPCM)
+	if format.Float {
+		tag = wavFormatIEEEFloat
+	}
+
+	containerBits := format.BytesPerSample() * bitsPerByte
+	extensible := wavNeedsExtensible(format, opts)
+
+	size := wavFmtSize
+	if extensible {
+		size = wavFmtExtensibleSize
+	}
+
+	body := make([]byte, size)
+
+	binary.LittleEndian.PutUint16(body[0:], tag)
+	binary.LittleEndian.PutUint16(body[2:], uint16(format.Channels))                      //nolint:gosec // G115: validated.
+	binary.LittleEndian.PutUint32(body[4:], uint32(format.SampleRate))                    //nolint:gosec // G115: validated.
+	binary.LittleEndian.PutUint32(body[8:], uint32(format.SampleRate*format.FrameSize())) //nolint:gosec // G115: validated.
+	binary.LittleEndian.PutUint16(body[12:], uint16(format.FrameSize()))                  //nolint:gosec // G115: validated.
+	binary.LittleEndian.PutUint16(body[14:], uint16(containerBits))                       //nolint:gosec // G115: validated.
+
+	if !extensible {
+		return body
+	}
+
+	mask := opts.ChannelMask
+	if mask == 0 {
+		mask = DefaultChannelMask(format.Channels)
+	}
+
+	binary.LittleEndian.PutUint16(body[0:], wavFormatExtensible)
+	binary.LittleEndian.PutUint16(body[16:], wavExtensibleCbSize)
+	binary.LittleEndian.PutUint16(body[18:], uint16(format.BitDepth)) //nolint:gosec // G115: validated.
+	binary.LittleEndian.PutUint32(body[20:], mask)
+	binary.LittleEndian.PutUint16(body[24:], tag)
+	copy(body[26:], ksDataFormatSubtypeTail)
+
+	return body
+}
+
+// wavSampleData validates pcm against format and converts it to WAVE sample layout:
+// integer samples are left-justified in their container and 8-bit containers are unsigned.
+func wavSampleData(pcm []byte, format Format) ([]byte, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+
+	frameSize := format.FrameSize()
+	if len(pcm)%frameSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes is not a multiple of frame size %d",
+			ErrUnsupportedFormat, len(pcm), frameSize)
+	}
+
+	bps := format.BytesPerSample()
+	shift := bps*bitsPerByte - format.BitDepth
+
+	if format.Float || (shift == 0 && bps > 1) {
+		return pcm, nil
+	}
+
+	out := make([]byte, len(pcm))
+
+	for offset := 0; offset < len(pcm); offset += bps {
+		val := pcmSampleAt(pcm[offset:], bps) << shift
+		putPCMSample(out[offset:], bps, val)
+
+		if bps == 1 {
+			out[offset] ^= 0x80
+		}
+	}
+
+	return out, nil
+}
+
+// writeRIFFChunk appends a chunk with a little-endian size and a pad byte for odd lengths.
+func writeRIFFChunk(buf *bytes.Buffer, id string, body []byte) {
+	buf.WriteString(id)
+	writeLE32(buf, uint32(len(body))) //nolint:gosec // G115: RIFF chunk sizes are 32-bit.
+	buf.Write(body)
+
+	if len(body)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func writeLE32(buf *bytes.Buffer, val uint32) {
+	var tmp [4]byte
+
+	binary.LittleEndian.PutUint32(tmp[:], val)
+	buf.Write(tmp[:])
+}
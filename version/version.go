@@ -0,0 +1,94 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package version holds the build information the Makefile sets at link time with -X. Binaries built
+// without it, such as with go install, report the module version and VCS stamps of the Go build info.
+package version
+
+import "runtime/debug"
+
+// Values of the link time variables when the Makefile did not set them.
+const (
+	unstamped = "dev"
+	unknown   = "unknown"
+)
+
+// Set by hack/common.mk with -X.
+//
+//nolint:gochecknoglobals // link time variables
+var (
+	name    = "agar"
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// Name returns the binary name.
+func Name() string {
+	return name
+}
+
+// Version returns the release version, or the module version when it was not set at link time.
+func Version() string {
+	if version != unstamped {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	return version
+}
+
+// Commit returns the commit the binary was built from, or the VCS revision when it was not set at link time.
+func Commit() string {
+	if commit != unknown {
+		return commit
+	}
+
+	return buildSetting("vcs.revision", commit)
+}
+
+// Date returns the build date, or the commit date when it was not set at link time.
+func Date() string {
+	if date != unknown {
+		return date
+	}
+
+	return buildSetting("vcs.time", date)
+}
+
+// String returns the name, version, commit and build date on one line.
+func String() string {
+	return Name() + " " + Version() + " (" + Commit() + ", " + Date() + ")"
+}
+
+// buildSetting returns the value of key in the Go build info, or fallback when it is missing.
+func buildSetting(key, fallback string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fallback
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == key && setting.Value != "" {
+			return setting.Value
+		}
+	}
+
+	return fallback
+}